@@ -0,0 +1,219 @@
+package idp
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+	"github.com/rgynn/klottr/pkg/config"
+	"github.com/rgynn/klottr/pkg/keys"
+)
+
+// Server is a stub OpenID Connect provider for the integration test, it signs in whoever is
+// named by the login_hint of the authorization request without asking
+type Server struct {
+	provider *config.OIDCProvider
+	keys     *keys.Set
+	mu       sync.Mutex
+	codes    map[string]*grant
+}
+
+type grant struct {
+	subject   string
+	redirect  string
+	nonce     string
+	challenge string
+}
+
+// NewServer for provider, signing id tokens with a new RS256 key
+func NewServer(provider *config.OIDCProvider) (*Server, error) {
+
+	if provider == nil {
+		return nil, errors.New("no provider *config.OIDCProvider provided")
+	}
+
+	private, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		return nil, err
+	}
+
+	der, err := x509.MarshalPKCS8PrivateKey(private)
+	if err != nil {
+		return nil, err
+	}
+
+	dir, err := ioutil.TempDir("", "klottr-idp")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+
+	file := filepath.Join(dir, "stub.pem")
+	if err := ioutil.WriteFile(file, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), 0600); err != nil {
+		return nil, err
+	}
+
+	set, err := keys.Load(file, "", "")
+	if err != nil {
+		return nil, err
+	}
+
+	return &Server{
+		provider: provider,
+		keys:     set,
+		codes:    map[string]*grant{},
+	}, nil
+}
+
+// Start listening on the host of the issuer
+func (srv *Server) Start() (*http.Server, error) {
+
+	u, err := url.Parse(srv.provider.Issuer)
+	if err != nil {
+		return nil, err
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc(u.Path+"/.well-known/openid-configuration", srv.discovery)
+	mux.HandleFunc(u.Path+"/authorize", srv.authorize)
+	mux.HandleFunc(u.Path+"/token", srv.token)
+	mux.HandleFunc(u.Path+"/jwks", srv.jwks)
+
+	listener, err := net.Listen("tcp", u.Host)
+	if err != nil {
+		return nil, err
+	}
+
+	server := &http.Server{Handler: mux}
+
+	go server.Serve(listener) //nolint:errcheck
+
+	return server, nil
+}
+
+func (srv *Server) discovery(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"issuer":                 srv.provider.Issuer,
+		"authorization_endpoint": srv.provider.Issuer + "/authorize",
+		"token_endpoint":         srv.provider.Issuer + "/token",
+		"jwks_uri":               srv.provider.Issuer + "/jwks",
+	})
+}
+
+func (srv *Server) jwks(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, srv.keys.JWKS())
+}
+
+func (srv *Server) authorize(w http.ResponseWriter, r *http.Request) {
+
+	q := r.URL.Query()
+
+	if q.Get("response_type") != "code" || q.Get("client_id") != srv.provider.ClientID || q.Get("code_challenge_method") != "S256" {
+		http.Error(w, "invalid authorization request", http.StatusBadRequest)
+		return
+	}
+
+	redirect, err := url.Parse(q.Get("redirect_uri"))
+	if err != nil || q.Get("login_hint") == "" {
+		http.Error(w, "invalid authorization request", http.StatusBadRequest)
+		return
+	}
+
+	code := randomString()
+
+	srv.mu.Lock()
+	srv.codes[code] = &grant{
+		subject:   q.Get("login_hint"),
+		redirect:  q.Get("redirect_uri"),
+		nonce:     q.Get("nonce"),
+		challenge: q.Get("code_challenge"),
+	}
+	srv.mu.Unlock()
+
+	params := redirect.Query()
+	params.Set("code", code)
+	params.Set("state", q.Get("state"))
+	redirect.RawQuery = params.Encode()
+
+	http.Redirect(w, r, redirect.String(), http.StatusFound)
+}
+
+func (srv *Server) token(w http.ResponseWriter, r *http.Request) {
+
+	if err := r.ParseForm(); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_request"})
+		return
+	}
+
+	clientID, clientSecret, ok := r.BasicAuth()
+	if !ok {
+		clientID = url.QueryEscape(r.PostForm.Get("client_id"))
+	}
+
+	if clientID != url.QueryEscape(srv.provider.ClientID) || clientSecret != url.QueryEscape(srv.provider.ClientSecret) {
+		writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "invalid_client"})
+		return
+	}
+
+	srv.mu.Lock()
+	g, ok := srv.codes[r.PostForm.Get("code")]
+	delete(srv.codes, r.PostForm.Get("code"))
+	srv.mu.Unlock()
+
+	sum := sha256.Sum256([]byte(r.PostForm.Get("code_verifier")))
+
+	if !ok || r.PostForm.Get("grant_type") != "authorization_code" || r.PostForm.Get("redirect_uri") != g.redirect || base64.RawURLEncoding.EncodeToString(sum[:]) != g.challenge {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_grant"})
+		return
+	}
+
+	now := time.Now()
+
+	idToken, err := srv.keys.Sign(jwt.MapClaims{
+		"iss":                srv.provider.Issuer,
+		"sub":                g.subject,
+		"aud":                srv.provider.ClientID,
+		"exp":                now.Add(time.Minute).Unix(),
+		"iat":                now.Unix(),
+		"nonce":              g.nonce,
+		"email":              g.subject + "@stub.example.com",
+		"email_verified":     true,
+		"preferred_username": g.subject,
+	})
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "server_error"})
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"access_token": randomString(),
+		"token_type":   "Bearer",
+		"id_token":     idToken,
+	})
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v) //nolint:errcheck
+}
+
+func randomString() string {
+	b := make([]byte, 16)
+	rand.Read(b) //nolint:errcheck
+	return base64.RawURLEncoding.EncodeToString(b)
+}
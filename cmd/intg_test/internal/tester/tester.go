@@ -5,11 +5,13 @@ import (
 	"time"
 
 	"github.com/rgynn/klottr/pkg/config"
+	"github.com/rgynn/klottr/pkg/keys"
 	"github.com/sirupsen/logrus"
 )
 
 type Tester struct {
 	cfg        *config.Config
+	keys       *keys.Set
 	logger     *logrus.Logger
 	client     *http.Client
 	username   string
@@ -18,15 +20,21 @@ type Tester struct {
 }
 
 func NewTester(cfg *config.Config, logger *logrus.Logger) (*Tester, error) {
+
+	set, err := keys.Load(cfg.JWTKeys, cfg.JWTSigningKeyID, cfg.JWTSecret)
+	if err != nil {
+		return nil, err
+	}
+
 	return &Tester{
 		cfg:    cfg,
+		keys:   set,
 		logger: logger,
 		client: &http.Client{
 			Timeout: time.Second * 5,
 		},
-		username:   "testuser",
-		password:   "testpsswd",
-		categories: []string{"misc"},
+		username: "testuser",
+		password: "testpsswd",
 	}, nil
 }
 
@@ -49,6 +57,70 @@ func (tester *Tester) Run() error {
 		return err
 	}
 
+	if err := tester.validateJWKS(token); err != nil {
+		return err
+	}
+
+	// Test rate limits
+
+	if err := tester.validateRateLimit(token); err != nil {
+		return err
+	}
+
+	// Test refresh and signout
+
+	if err := tester.validateSessions(); err != nil {
+		return err
+	}
+
+	// Test email verification
+
+	if err := tester.validateEmailVerification(); err != nil {
+		return err
+	}
+
+	// Test password reset
+
+	if err := tester.validatePasswordReset(); err != nil {
+		return err
+	}
+
+	// Test two factor authentication
+
+	if err := tester.validateTwoFactor(); err != nil {
+		return err
+	}
+
+	// Test sign in lockout
+
+	if err := tester.validateLockout(); err != nil {
+		return err
+	}
+
+	// Test single sign on
+
+	if err := tester.validateOIDC(); err != nil {
+		return err
+	}
+
+	// Test admin routes
+
+	if err := tester.validateAdminRoutes(token); err != nil {
+		return err
+	}
+
+	// Test categories
+
+	if err := tester.listCategories(token); err != nil {
+		return err
+	}
+
+	// Test personal access tokens
+
+	if err := tester.validatePersonalTokens(tester.categories[0]); err != nil {
+		return err
+	}
+
 	// Test threads
 
 	for _, category := range tester.categories {
@@ -58,19 +130,45 @@ func (tester *Tester) Run() error {
 			return err
 		}
 
-		if err := tester.listThreads(token, category); err != nil {
+		for _, sort := range []string{"new", "top", "hot", "rising", "controversial"} {
+			if err := tester.listThreads(token, category, sort); err != nil {
+				return err
+			}
+		}
+		if _, err := tester.pageThreads(token, category, "new"); err != nil {
+			return err
+		}
+		if err := tester.validateRankedPaging(token, category); err != nil {
 			return err
 		}
 		if err := tester.getThread(token, category, thrd.SlugID, thrd.SlugTitle); err != nil {
 			return err
 		}
-		if err := tester.upvoteThread(token, category, thrd.SlugID, thrd.SlugTitle); err != nil {
+		if err := tester.editThread(token, category, thrd.SlugID, thrd.SlugTitle); err != nil {
+			return err
+		}
+		if err := tester.validateThreadRevisions(token, category, thrd.SlugID, thrd.SlugTitle, 1); err != nil {
+			return err
+		}
+		// votes are idempotent, a second upvote does nothing and a downvote replaces the upvote
+		for i := 0; i < 2; i++ {
+			if err := tester.upvoteThread(token, category, thrd.SlugID, thrd.SlugTitle); err != nil {
+				return err
+			}
+		}
+		if err := tester.validateVotes(token, category, thrd.SlugID, thrd.SlugTitle, 1); err != nil {
 			return err
 		}
 		if err := tester.downvoteThread(token, category, thrd.SlugID, thrd.SlugTitle); err != nil {
 			return err
 		}
-		if err := tester.validateVotes(token, category, thrd.SlugID, thrd.SlugTitle); err != nil {
+		if err := tester.validateVotes(token, category, thrd.SlugID, thrd.SlugTitle, -1); err != nil {
+			return err
+		}
+		if err := tester.retractVoteThread(token, category, thrd.SlugID, thrd.SlugTitle); err != nil {
+			return err
+		}
+		if err := tester.validateVotes(token, category, thrd.SlugID, thrd.SlugTitle, 0); err != nil {
 			return err
 		}
 
@@ -79,23 +177,88 @@ func (tester *Tester) Run() error {
 		if err != nil {
 			return err
 		}
-		if err := tester.upvoteComment(token, category, thrd.SlugID, thrd.SlugTitle, cmnt.SlugID); err != nil {
+		for i := 0; i < 2; i++ {
+			if err := tester.upvoteComment(token, category, thrd.SlugID, thrd.SlugTitle, cmnt.SlugID); err != nil {
+				return err
+			}
+		}
+		if err := tester.validateCommentVotes(token, category, thrd.SlugID, thrd.SlugTitle, cmnt.SlugID, 1); err != nil {
 			return err
 		}
 		if err := tester.downvoteComment(token, category, thrd.SlugID, thrd.SlugTitle, cmnt.SlugID); err != nil {
 			return err
 		}
-		if err := tester.validateCommentVotes(token, category, thrd.SlugID, thrd.SlugTitle, cmnt.SlugID); err != nil {
+		if err := tester.validateCommentVotes(token, category, thrd.SlugID, thrd.SlugTitle, cmnt.SlugID, -1); err != nil {
+			return err
+		}
+		if err := tester.retractVoteComment(token, category, thrd.SlugID, thrd.SlugTitle, cmnt.SlugID); err != nil {
+			return err
+		}
+		if err := tester.validateCommentVotes(token, category, thrd.SlugID, thrd.SlugTitle, cmnt.SlugID, 0); err != nil {
+			return err
+		}
+		if err := tester.validateCommentScope(token, category, cmnt.SlugID); err != nil {
+			return err
+		}
+		if err := tester.listComments(token, category, thrd.SlugID, thrd.SlugTitle); err != nil {
+			return err
+		}
+		reply, err := tester.createReply(token, category, thrd.SlugID, thrd.SlugTitle, cmnt.SlugID)
+		if err != nil {
+			return err
+		}
+		if err := tester.validateCommentTree(token, category, thrd.SlugID, thrd.SlugTitle, cmnt.SlugID, reply.SlugID); err != nil {
+			return err
+		}
+		if err := tester.getThreadWithComments(token, category, thrd.SlugID, thrd.SlugTitle, cmnt.SlugID); err != nil {
+			return err
+		}
+		if err := tester.editComment(token, category, thrd.SlugID, thrd.SlugTitle, cmnt.SlugID); err != nil {
+			return err
+		}
+		if err := tester.validateCommentRevisions(token, category, thrd.SlugID, thrd.SlugTitle, cmnt.SlugID, 1); err != nil {
+			return err
+		}
+		// deleted comments stay in the tree as tombstones above their replies
+		if err := tester.deleteComment(token, category, thrd.SlugID, thrd.SlugTitle, cmnt.SlugID); err != nil {
+			return err
+		}
+		if err := tester.validateDeletedComment(token, category, thrd.SlugID, thrd.SlugTitle, cmnt.SlugID, reply.SlugID); err != nil {
+			return err
+		}
+		if err := tester.deleteComment(token, category, thrd.SlugID, thrd.SlugTitle, reply.SlugID); err != nil {
+			return err
+		}
+
+		if err := tester.deleteThread(token, category, thrd.SlugID, thrd.SlugTitle); err != nil {
 			return err
 		}
 	}
 
-	// Test deactivate user
+	// every thread and comment was deleted again
+
+	if err := tester.validateCounters(0, 0); err != nil {
+		return err
+	}
+
+	// Test deactivate user, which signs out every session of the user
+
+	other, err := tester.signinTestUser(http.StatusOK)
+	if err != nil {
+		return err
+	}
+
+	// access tokens are denied when issued before the second of the deactivation
+	time.Sleep(time.Second)
 
 	if err := tester.deactivateTestUser(token); err != nil {
 		return err
 	}
 
+	if _, err := tester.postAuth("signout", other, nil, http.StatusUnauthorized); err != nil {
+		return err
+	}
+
 	if _, err = tester.signinTestUser(http.StatusUnauthorized); err != nil {
 		return err
 	}
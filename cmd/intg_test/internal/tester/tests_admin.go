@@ -0,0 +1,72 @@
+package tester
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+)
+
+// adminRoutes called by validateAdminRoutes, with the method of each, the moderation routes
+// of a category are refused to users the same way
+var adminRoutes = [][2]string{
+	{http.MethodPost, "/admin/c"},
+	{http.MethodPost, "/admin/c/misc/archive"},
+	{http.MethodGet, "/admin/users"},
+	{http.MethodGet, "/admin/admins"},
+	{http.MethodPost, "/admin/admins"},
+	{http.MethodGet, "/admin/admins/testuser"},
+	{http.MethodDelete, "/admin/admins/testuser"},
+	{http.MethodGet, "/admin/moderators"},
+	{http.MethodPut, "/admin/moderators/testuser"},
+	{http.MethodDelete, "/admin/moderators/testuser"},
+	{http.MethodGet, "/admin/bans"},
+	{http.MethodPost, "/admin/bans"},
+	{http.MethodDelete, "/admin/bans/testuser"},
+	{http.MethodPost, "/c/misc/t/slugid/slugtitle/lock"},
+	{http.MethodPost, "/c/misc/t/slugid/slugtitle/pin"},
+	{http.MethodGet, "/c/misc/bans"},
+	{http.MethodPost, "/c/misc/bans"},
+	{http.MethodDelete, "/c/misc/bans/testuser"},
+	{http.MethodGet, "/reports"},
+	{http.MethodPost, "/c/misc/reports/000000000000000000000000"},
+}
+
+// validateAdminRoutes checks that the admin routes refuse requests without a jwt and with the jwt of a user
+func (tester *Tester) validateAdminRoutes(token *string) error {
+
+	for _, route := range adminRoutes {
+
+		url := fmt.Sprintf("http://%s/api/1.0%s", tester.cfg.Addr, route[1])
+
+		for _, expected := range []int{http.StatusUnauthorized, http.StatusForbidden} {
+
+			req, err := http.NewRequest(route[0], url, nil)
+			if err != nil {
+				return err
+			}
+
+			if expected == http.StatusForbidden {
+				req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", *token))
+			}
+
+			resp, err := tester.client.Do(req)
+			if err != nil {
+				return err
+			}
+
+			body, err := ioutil.ReadAll(resp.Body)
+			if err != nil {
+				return err
+			}
+			resp.Body.Close()
+
+			if resp.StatusCode != expected {
+				return fmt.Errorf("expected status %d in %s %s response, got: %d, response body: %s", expected, route[0], route[1], resp.StatusCode, string(body))
+			}
+		}
+	}
+
+	tester.logger.Infof("OK: Admin and moderation routes refused for users")
+
+	return nil
+}
@@ -6,9 +6,16 @@ import (
 	"fmt"
 	"io/ioutil"
 	"net/http"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"time"
 
 	"github.com/golang-jwt/jwt"
 	"github.com/rgynn/klottr/pkg/api"
+	"github.com/rgynn/klottr/pkg/keys"
+	"github.com/rgynn/klottr/pkg/token"
+	"github.com/rgynn/klottr/pkg/totp"
 	"github.com/rgynn/klottr/pkg/user"
 	"github.com/rgynn/ptrconv"
 )
@@ -109,9 +116,7 @@ func (tester *Tester) validateJWT(token *string) error {
 
 	claims := new(api.JWTClaims)
 
-	_, err := jwt.ParseWithClaims(*token, claims, func(token *jwt.Token) (interface{}, error) {
-		return []byte(tester.cfg.JWTSecret), nil
-	})
+	_, err := jwt.ParseWithClaims(*token, claims, tester.keys.Keyfunc)
 	if err != nil {
 		return err
 	}
@@ -121,6 +126,52 @@ func (tester *Tester) validateJWT(token *string) error {
 	return nil
 }
 
+// validateJWKS checks that the key of token is listed by the jwks endpoint
+func (tester *Tester) validateJWKS(token *string) error {
+
+	t, _, err := new(jwt.Parser).ParseUnverified(*token, new(api.JWTClaims))
+	if err != nil {
+		return err
+	}
+
+	url := fmt.Sprintf("http://%s/.well-known/jwks.json", tester.cfg.Addr)
+
+	resp, err := tester.client.Get(url)
+	if err != nil {
+		return err
+	}
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("expected status %d in jwks response, got: %d, response body: %s", http.StatusOK, resp.StatusCode, string(body))
+	}
+
+	result := new(keys.JWKS)
+	if err := json.Unmarshal(body, result); err != nil {
+		return err
+	}
+
+	kid, _ := t.Header["kid"].(string)
+	if kid == "" {
+		tester.logger.Infof("OK: JWT signed with the shared secret, %d keys listed", len(result.Keys))
+		return nil
+	}
+
+	for _, key := range result.Keys {
+		if key.ID == kid && key.Alg == t.Method.Alg() {
+			tester.logger.Infof("OK: JWT key %s listed in jwks", kid)
+			return nil
+		}
+	}
+
+	return fmt.Errorf("expected jwks to list key %s", kid)
+}
+
 func (tester *Tester) deactivateTestUser(token *string) error {
 
 	url := fmt.Sprintf("http://%s/api/1.0/auth/deactivate", tester.cfg.Addr)
@@ -154,3 +205,489 @@ func (tester *Tester) deactivateTestUser(token *string) error {
 
 	return nil
 }
+
+func (tester *Tester) validateCounters(threads, comments uint32) error {
+
+	token, err := tester.signinTestUser(http.StatusOK)
+	if err != nil {
+		return err
+	}
+
+	claims := new(api.JWTClaims)
+
+	if _, err := jwt.ParseWithClaims(*token, claims, tester.keys.Keyfunc); err != nil {
+		return err
+	}
+
+	if claims.Counters.Num.Threads != threads || claims.Counters.Num.Comments != comments {
+		return fmt.Errorf("expected user counters num threads: %d, num comments: %d, got: %d, %d", threads, comments, claims.Counters.Num.Threads, claims.Counters.Num.Comments)
+	}
+
+	tester.logger.Infof("OK: User counters validated, num threads: %d, num comments: %d", threads, comments)
+
+	return nil
+}
+
+// validateSessions checks that refresh tokens rotate, that a reused refresh token revokes its
+// session, and that signing out revokes the access and refresh token
+func (tester *Tester) validateSessions() error {
+
+	signin, err := tester.postAuth("signin", nil, &api.LoginInput{
+		Username: ptrconv.StringPtr(tester.username),
+		Password: ptrconv.StringPtr(tester.password),
+	}, http.StatusOK)
+	if err != nil {
+		return err
+	}
+
+	refreshed, err := tester.postAuth("refresh", nil, &api.RefreshInput{RefreshToken: &signin.RefreshToken}, http.StatusOK)
+	if err != nil {
+		return err
+	}
+
+	if refreshed.RefreshToken == signin.RefreshToken {
+		return fmt.Errorf("expected refresh to rotate the refresh token")
+	}
+
+	if err := tester.validateJWT(&refreshed.Token); err != nil {
+		return err
+	}
+
+	if _, err := tester.postAuth("refresh", nil, &api.RefreshInput{RefreshToken: &signin.RefreshToken}, http.StatusUnauthorized); err != nil {
+		return err
+	}
+
+	if _, err := tester.postAuth("refresh", nil, &api.RefreshInput{RefreshToken: &refreshed.RefreshToken}, http.StatusUnauthorized); err != nil {
+		return err
+	}
+
+	tester.logger.Infof("OK: Refresh token rotated, reuse revoked the session")
+
+	signin, err = tester.postAuth("signin", nil, &api.LoginInput{
+		Username: ptrconv.StringPtr(tester.username),
+		Password: ptrconv.StringPtr(tester.password),
+	}, http.StatusOK)
+	if err != nil {
+		return err
+	}
+
+	if _, err := tester.postAuth("signout", &signin.Token, nil, http.StatusAccepted); err != nil {
+		return err
+	}
+
+	if _, err := tester.postAuth("signout", &signin.Token, nil, http.StatusUnauthorized); err != nil {
+		return err
+	}
+
+	if _, err := tester.postAuth("refresh", nil, &api.RefreshInput{RefreshToken: &signin.RefreshToken}, http.StatusUnauthorized); err != nil {
+		return err
+	}
+
+	tester.logger.Infof("OK: Signed out access and refresh token revoked")
+
+	return nil
+}
+
+// postAuth posts input to an /auth route and returns the tokens in a 200 response
+func (tester *Tester) postAuth(route string, token *string, input interface{}, expectedStatusCode int) (*api.TokenResponse, error) {
+
+	result := new(api.TokenResponse)
+
+	ok, err := tester.postAuthResult(route, token, input, expectedStatusCode, result)
+	if err != nil || !ok {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+// postAuthResult posts input to an /auth route and decodes a 200 or 202 response with a body
+// into result, reporting whether it did
+func (tester *Tester) postAuthResult(route string, token *string, input interface{}, expectedStatusCode int, result interface{}) (bool, error) {
+
+	url := fmt.Sprintf("http://%s/api/1.0/auth/%s", tester.cfg.Addr, route)
+
+	reqbody, err := json.Marshal(input)
+	if err != nil {
+		return false, err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(reqbody))
+	if err != nil {
+		return false, err
+	}
+
+	if token != nil {
+		req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", *token))
+	}
+
+	resp, err := tester.client.Do(req)
+	if err != nil {
+		return false, err
+	}
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != expectedStatusCode {
+		return false, fmt.Errorf("expected status %d in %s response, got: %d, response body: %s", expectedStatusCode, route, resp.StatusCode, string(body))
+	}
+
+	if (resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusAccepted) || len(body) == 0 {
+		return false, nil
+	}
+
+	if err := json.Unmarshal(body, result); err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+// validateEmailVerification signs up a user with an email address and validates it with the
+// link mailed to it, it needs MAILER=file to read the mail
+func (tester *Tester) validateEmailVerification() error {
+
+	if tester.cfg.Mailer != "file" {
+		tester.logger.Infof("SKIP: Email verification needs MAILER=file, got: %s", tester.cfg.Mailer)
+		return nil
+	}
+
+	username, password, err := tester.signupWithEmail("verify")
+	if err != nil {
+		return err
+	}
+
+	link, err := tester.findMailedLink(username+"@example.com", tester.cfg.PublicURL+"/api/1.0/auth/verify")
+	if err != nil {
+		return err
+	}
+
+	signin, err := tester.postAuth("signin", nil, &api.LoginInput{Username: &username, Password: &password}, http.StatusOK)
+	if err != nil {
+		return err
+	}
+
+	for _, expectedStatusCode := range []int{http.StatusOK, http.StatusNotFound} {
+
+		resp, err := tester.client.Get(fmt.Sprintf("http://%s/api/1.0/auth/verify?%s", tester.cfg.Addr, link.RawQuery))
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+
+		if resp.StatusCode != expectedStatusCode {
+			return fmt.Errorf("expected status %d in verify response, got: %d", expectedStatusCode, resp.StatusCode)
+		}
+	}
+
+	refreshed, err := tester.postAuth("refresh", nil, &api.RefreshInput{RefreshToken: &signin.RefreshToken}, http.StatusOK)
+	if err != nil {
+		return err
+	}
+
+	claims := new(api.JWTClaims)
+	if _, err := jwt.ParseWithClaims(refreshed.Token, claims, tester.keys.Keyfunc); err != nil {
+		return err
+	}
+
+	if !claims.Validated {
+		return fmt.Errorf("expected user %s to be validated after verifying", username)
+	}
+
+	tester.logger.Infof("OK: Email address verified for username: %s", username)
+
+	return nil
+}
+
+// validatePasswordReset resets the password of a user with the link mailed to it, it needs
+// MAILER=file to read the mail
+func (tester *Tester) validatePasswordReset() error {
+
+	if tester.cfg.Mailer != "file" {
+		tester.logger.Infof("SKIP: Password reset needs MAILER=file, got: %s", tester.cfg.Mailer)
+		return nil
+	}
+
+	username, password, err := tester.signupWithEmail("reset")
+	if err != nil {
+		return err
+	}
+
+	signin, err := tester.postAuth("signin", nil, &api.LoginInput{Username: &username, Password: &password}, http.StatusOK)
+	if err != nil {
+		return err
+	}
+
+	tokens := fmt.Sprintf("http://%s/api/1.0/auth/tokens", tester.cfg.Addr)
+
+	pat := new(api.PersonalTokenResponse)
+	if err := tester.request(http.MethodPost, tokens, &signin.Token, &api.PersonalTokenInput{
+		Name:   ptrconv.StringPtr("reset"),
+		Scopes: []string{token.ScopeRead},
+	}, http.StatusCreated, pat); err != nil {
+		return err
+	}
+
+	// the access token issued before the reset must be at least a second older than it
+	time.Sleep(time.Second)
+
+	// every request gets the same response, only the matching one sends a mail
+	for _, input := range []*api.ForgotPasswordInput{
+		{Username: ptrconv.StringPtr(username + "nobody"), Email: ptrconv.StringPtr(username + "@example.com")},
+		{Username: &username, Email: ptrconv.StringPtr("nobody@example.com")},
+		{Username: &username, Email: ptrconv.StringPtr(username + "@example.com")},
+	} {
+		if _, err := tester.postAuth("password/forgot", nil, input, http.StatusAccepted); err != nil {
+			return err
+		}
+	}
+
+	var link *url.URL
+	for i := 0; i < 20 && link == nil; i++ {
+		time.Sleep(100 * time.Millisecond)
+		link, _ = tester.findMailedLink(username+"@example.com", tester.cfg.ResetURL)
+	}
+
+	if link == nil {
+		return fmt.Errorf("no password reset mail sent to %s@example.com", username)
+	}
+
+	newPassword := "resetpsswd2"
+	input := &api.ResetPasswordInput{
+		Token:    ptrconv.StringPtr(link.Query().Get("token")),
+		Password: &newPassword,
+	}
+
+	if _, err := tester.postAuth("password/reset", nil, input, http.StatusAccepted); err != nil {
+		return err
+	}
+
+	if _, err := tester.postAuth("password/reset", nil, input, http.StatusNotFound); err != nil {
+		return err
+	}
+
+	if _, err := tester.postAuth("refresh", nil, &api.RefreshInput{RefreshToken: &signin.RefreshToken}, http.StatusUnauthorized); err != nil {
+		return err
+	}
+
+	// access tokens and personal access tokens issued before the reset are revoked
+	if err := tester.request(http.MethodGet, tokens, &signin.Token, nil, http.StatusUnauthorized, nil); err != nil {
+		return err
+	}
+
+	if err := tester.request(http.MethodGet, tokens, &pat.Token, nil, http.StatusUnauthorized, nil); err != nil {
+		return err
+	}
+
+	if _, err := tester.postAuth("signin", nil, &api.LoginInput{Username: &username, Password: &password}, http.StatusUnauthorized); err != nil {
+		return err
+	}
+
+	signin, err = tester.postAuth("signin", nil, &api.LoginInput{Username: &username, Password: &newPassword}, http.StatusOK)
+	if err != nil {
+		return err
+	}
+
+	if err := tester.request(http.MethodGet, tokens, &signin.Token, nil, http.StatusOK, nil); err != nil {
+		return err
+	}
+
+	tester.logger.Infof("OK: Password reset for username: %s", username)
+
+	return nil
+}
+
+// validateTwoFactor enables two factor authentication for a new user and signs in with a totp
+// code and with a recovery code, it needs EMAIL_KEY to encrypt the totp secret
+func (tester *Tester) validateTwoFactor() error {
+
+	if tester.cfg.EmailKey == nil {
+		tester.logger.Infof("SKIP: Two factor authentication needs EMAIL_KEY")
+		return nil
+	}
+
+	username, password, err := tester.signupWithEmail("totp")
+	if err != nil {
+		return err
+	}
+
+	login := &api.LoginInput{Username: &username, Password: &password}
+
+	signin, err := tester.postAuth("signin", nil, login, http.StatusOK)
+	if err != nil {
+		return err
+	}
+
+	enrollment := new(api.TOTPEnrollment)
+	if _, err := tester.postAuthResult("totp/enroll", &signin.Token, nil, http.StatusOK, enrollment); err != nil {
+		return err
+	}
+
+	if !strings.HasPrefix(enrollment.URI, "otpauth://totp/") {
+		return fmt.Errorf("expected an otpauth uri in enroll response, got: %s", enrollment.URI)
+	}
+
+	if _, err := tester.postAuth("totp/confirm", &signin.Token, &api.TOTPInput{Code: ptrconv.StringPtr("000000")}, http.StatusUnauthorized); err != nil {
+		return err
+	}
+
+	code, err := totp.Code(enrollment.Secret, time.Now())
+	if err != nil {
+		return err
+	}
+
+	enabled := new(api.TOTPEnabledResponse)
+	if _, err := tester.postAuthResult("totp/confirm", &signin.Token, &api.TOTPInput{Code: &code}, http.StatusOK, enabled); err != nil {
+		return err
+	}
+
+	if len(enabled.RecoveryCodes) == 0 || enabled.TokenResponse == nil {
+		return fmt.Errorf("expected recovery codes and tokens in confirm response")
+	}
+
+	// the session signed in without a second factor is signed out
+	if _, err := tester.postAuth("refresh", nil, &api.RefreshInput{RefreshToken: &signin.RefreshToken}, http.StatusUnauthorized); err != nil {
+		return err
+	}
+
+	challenge := new(api.ChallengeResponse)
+	if _, err := tester.postAuthResult("signin", nil, login, http.StatusAccepted, challenge); err != nil {
+		return err
+	}
+
+	// a wrong code uses up the challenge
+	if _, err := tester.postAuth("totp/verify", nil, &api.TOTPInput{ChallengeToken: &challenge.ChallengeToken, Code: ptrconv.StringPtr("000000")}, http.StatusUnauthorized); err != nil {
+		return err
+	}
+
+	if _, err := tester.postAuth("totp/verify", nil, &api.TOTPInput{ChallengeToken: &challenge.ChallengeToken, Code: &code}, http.StatusUnauthorized); err != nil {
+		return err
+	}
+
+	if _, err := tester.postAuthResult("signin", nil, login, http.StatusAccepted, challenge); err != nil {
+		return err
+	}
+
+	// the code confirming two factor authentication cannot be used again
+	if _, err := tester.postAuth("totp/verify", nil, &api.TOTPInput{ChallengeToken: &challenge.ChallengeToken, Code: &code}, http.StatusUnauthorized); err != nil {
+		return err
+	}
+
+	// the code of the next period is accepted early within the skew
+	next, err := totp.Code(enrollment.Secret, time.Now().Add(totp.Period))
+	if err != nil {
+		return err
+	}
+
+	if _, err := tester.postAuthResult("signin", nil, login, http.StatusAccepted, challenge); err != nil {
+		return err
+	}
+
+	verified, err := tester.postAuth("totp/verify", nil, &api.TOTPInput{ChallengeToken: &challenge.ChallengeToken, Code: &next}, http.StatusOK)
+	if err != nil {
+		return err
+	}
+
+	if _, err := tester.postAuthResult("signin", nil, login, http.StatusAccepted, challenge); err != nil {
+		return err
+	}
+
+	if _, err := tester.postAuth("totp/verify", nil, &api.TOTPInput{ChallengeToken: &challenge.ChallengeToken, Code: &next}, http.StatusUnauthorized); err != nil {
+		return err
+	}
+
+	claims := new(api.JWTClaims)
+	if _, err := jwt.ParseWithClaims(verified.Token, claims, tester.keys.Keyfunc); err != nil {
+		return err
+	}
+
+	if !claims.TOTP {
+		return fmt.Errorf("expected totp claim after signing in with a code")
+	}
+
+	recovery := &api.TOTPInput{RecoveryCode: &enabled.RecoveryCodes[0]}
+
+	for _, expectedStatusCode := range []int{http.StatusOK, http.StatusUnauthorized} {
+		if _, err := tester.postAuthResult("signin", nil, login, http.StatusAccepted, challenge); err != nil {
+			return err
+		}
+		recovery.ChallengeToken = &challenge.ChallengeToken
+		if _, err := tester.postAuth("totp/verify", nil, recovery, expectedStatusCode); err != nil {
+			return err
+		}
+	}
+
+	if _, err := tester.postAuth("totp/disable", &verified.Token, &api.TOTPInput{RecoveryCode: &enabled.RecoveryCodes[1]}, http.StatusAccepted); err != nil {
+		return err
+	}
+
+	if _, err := tester.postAuth("signin", nil, login, http.StatusOK); err != nil {
+		return err
+	}
+
+	tester.logger.Infof("OK: Two factor authentication enabled and disabled for username: %s", username)
+
+	return nil
+}
+
+// signupWithEmail signs up a new user with an address at example.com and returns its username
+// and password
+func (tester *Tester) signupWithEmail(prefix string) (string, string, error) {
+
+	username := fmt.Sprintf("%s%d", prefix, time.Now().UnixNano())
+	password := prefix + "psswd"
+
+	reqbody, err := json.Marshal(&user.Model{
+		Username: &username,
+		Password: &password,
+		Email:    ptrconv.StringPtr(username + "@Example.com"),
+	})
+	if err != nil {
+		return "", "", err
+	}
+
+	resp, err := tester.client.Post(fmt.Sprintf("http://%s/api/1.0/auth/signup", tester.cfg.Addr), "application/json", bytes.NewReader(reqbody))
+	if err != nil {
+		return "", "", err
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusCreated {
+		return "", "", fmt.Errorf("expected status %d in signup response, got: %d", http.StatusCreated, resp.StatusCode)
+	}
+
+	return username, password, nil
+}
+
+// findMailedLink returns the link starting with target in the mail sent to email in MAIL_DIR
+func (tester *Tester) findMailedLink(email, target string) (*url.URL, error) {
+
+	files, err := filepath.Glob(filepath.Join(tester.cfg.MailDir, "*.eml"))
+	if err != nil {
+		return nil, err
+	}
+
+	for _, file := range files {
+
+		b, err := ioutil.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+
+		if !strings.Contains(string(b), "To: "+email+"\r\n") {
+			continue
+		}
+
+		for _, line := range strings.Split(string(b), "\n") {
+			if strings.HasPrefix(line, target+"?") {
+				return url.Parse(strings.TrimSpace(line))
+			}
+		}
+	}
+
+	return nil, fmt.Errorf("no mail with a link sent to %s in %s", email, tester.cfg.MailDir)
+}
@@ -0,0 +1,58 @@
+package tester
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+
+	"github.com/rgynn/klottr/pkg/category"
+)
+
+func (tester *Tester) listCategories(token *string) error {
+
+	url := fmt.Sprintf("http://%s/api/1.0/c", tester.cfg.Addr)
+
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", *token))
+
+	resp, err := tester.client.Do(req)
+	if err != nil {
+		return err
+	}
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+		break
+	default:
+		return fmt.Errorf("expected status %d in list categories response, got: %d, response body: %s", http.StatusOK, resp.StatusCode, string(body))
+	}
+
+	var response []*category.Model
+	if err := json.Unmarshal(body, &response); err != nil {
+		return err
+	}
+
+	if len(response) == 0 {
+		return fmt.Errorf("expected at least one category in list categories response")
+	}
+
+	tester.categories = []string{}
+	for _, m := range response {
+		tester.categories = append(tester.categories, *m.Name)
+	}
+
+	tester.logger.Infof("OK: List categories: %v", tester.categories)
+
+	return nil
+}
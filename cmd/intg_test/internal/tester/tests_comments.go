@@ -8,6 +8,7 @@ import (
 	"net/http"
 
 	"github.com/rgynn/klottr/pkg/comment"
+	"github.com/rgynn/klottr/pkg/revision"
 	"github.com/rgynn/klottr/pkg/user"
 	"github.com/rgynn/ptrconv"
 )
@@ -144,7 +145,50 @@ func (tester *Tester) downvoteComment(token *string, category string, slugID, sl
 	return nil
 }
 
-func (tester *Tester) validateCommentVotes(token *string, category string, slugID, slugTitle, cmntSlugID *string) error {
+func (tester *Tester) retractVoteComment(token *string, category string, slugID, slugTitle, cmntSlugID *string) error {
+
+	url := fmt.Sprintf("http://%s/api/1.0/c/%s/t/%s/%s/comments/%s/vote", tester.cfg.Addr, category, *slugID, *slugTitle, *cmntSlugID)
+
+	reqbody, err := json.Marshal(&user.Vote{
+		SlugType: ptrconv.StringPtr("comments"),
+		SlugID:   cmntSlugID,
+		Value:    ptrconv.Int8Ptr(0),
+	})
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(reqbody))
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", *token))
+
+	resp, err := tester.client.Do(req)
+	if err != nil {
+		return err
+	}
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusAccepted:
+		break
+	default:
+		return fmt.Errorf("expected status %d in comment downvote response, got: %d, response body: %s", http.StatusAccepted, resp.StatusCode, string(body))
+	}
+
+	tester.logger.Infof("OK: Comment vote retracted")
+
+	return nil
+}
+
+func (tester *Tester) validateCommentVotes(token *string, category string, slugID, slugTitle, cmntSlugID *string, expected int64) error {
 
 	url := fmt.Sprintf("http://%s/api/1.0/c/%s/t/%s/%s/comments/%s", tester.cfg.Addr, category, *slugID, *slugTitle, *cmntSlugID)
 
@@ -178,7 +222,395 @@ func (tester *Tester) validateCommentVotes(token *string, category string, slugI
 		return err
 	}
 
-	tester.logger.Infof("OK: Comment votes validated")
+	if response.Votes != expected {
+		return fmt.Errorf("expected comment votes to be %d, got: %d, body: %s", expected, response.Votes, string(body))
+	}
+
+	tester.logger.Infof("OK: Comment votes validated: %d", expected)
+
+	return nil
+}
+
+// validateCommentScope checks that a comment cannot be read or voted on through a thread it
+// was not posted in
+func (tester *Tester) validateCommentScope(token *string, category string, cmntSlugID *string) error {
+
+	other, err := tester.createThread(token, category)
+	if err != nil {
+		return err
+	}
+
+	url := fmt.Sprintf("http://%s/api/1.0/c/%s/t/%s/%s/comments/%s", tester.cfg.Addr, category, *other.SlugID, *other.SlugTitle, *cmntSlugID)
+
+	reqbody, err := json.Marshal(&user.Vote{
+		SlugType: ptrconv.StringPtr("comments"),
+		SlugID:   cmntSlugID,
+		Value:    ptrconv.Int8Ptr(1),
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, r := range []struct {
+		method string
+		url    string
+		body   []byte
+	}{
+		{http.MethodGet, url, nil},
+		{http.MethodPost, url + "/vote", reqbody},
+	} {
+
+		req, err := http.NewRequest(r.method, r.url, bytes.NewReader(r.body))
+		if err != nil {
+			return err
+		}
+
+		req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", *token))
+
+		resp, err := tester.client.Do(req)
+		if err != nil {
+			return err
+		}
+
+		body, err := ioutil.ReadAll(resp.Body)
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+
+		if resp.StatusCode != http.StatusNotFound {
+			return fmt.Errorf("expected status %d in %s %s response for a comment of another thread, got: %d, response body: %s", http.StatusNotFound, r.method, r.url, resp.StatusCode, string(body))
+		}
+	}
+
+	tester.logger.Infof("OK: Comment not found through another thread")
+
+	return tester.deleteThread(token, category, other.SlugID, other.SlugTitle)
+}
+
+func (tester *Tester) listComments(token *string, category string, slugID, slugTitle *string) error {
+
+	url := fmt.Sprintf("http://%s/api/1.0/c/%s/t/%s/%s/comments", tester.cfg.Addr, category, *slugID, *slugTitle)
+
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", *token))
+
+	resp, err := tester.client.Do(req)
+	if err != nil {
+		return err
+	}
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+		break
+	default:
+		return fmt.Errorf("expected status %d in list comments response, got: %d, response body: %s", http.StatusOK, resp.StatusCode, string(body))
+	}
+
+	var response struct {
+		Results []*comment.Model `json:"results"`
+	}
+	if err := json.Unmarshal(body, &response); err != nil {
+		return err
+	}
+
+	if len(response.Results) == 0 {
+		return fmt.Errorf("expected at least one comment in list comments response")
+	}
+
+	tester.logger.Infof("OK: List comments, count: %d", len(response.Results))
+
+	return nil
+}
+
+func (tester *Tester) createReply(token *string, category string, slugID, slugTitle, cmntSlugID *string) (*comment.Model, error) {
+
+	url := fmt.Sprintf("http://%s/api/1.0/c/%s/t/%s/%s/comments/%s/replies", tester.cfg.Addr, category, *slugID, *slugTitle, *cmntSlugID)
+
+	reqbody, err := json.Marshal(&comment.Model{
+		Content: `test reply`,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(reqbody))
+	if err != nil {
+		return nil, err
+	}
+
+	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", *token))
+
+	resp, err := tester.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusCreated:
+		break
+	default:
+		return nil, fmt.Errorf("expected status %d in create reply response, got: %d, response body: %s", http.StatusCreated, resp.StatusCode, string(body))
+	}
+
+	var result *comment.Model
+	if err := json.Unmarshal(body, &result); err != nil {
+		return nil, err
+	}
+
+	if result.Depth != 1 || result.ReplyToID == nil {
+		return nil, fmt.Errorf("expected reply at depth 1 with reply_to_id, response body: %s", string(body))
+	}
+
+	tester.logger.Infof("OK: Reply created")
+
+	return result, nil
+}
+
+func (tester *Tester) validateCommentTree(token *string, category string, slugID, slugTitle, cmntSlugID, replySlugID *string) error {
+
+	url := fmt.Sprintf("http://%s/api/1.0/c/%s/t/%s/%s/comments?sort=new&depth=2", tester.cfg.Addr, category, *slugID, *slugTitle)
+
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", *token))
+
+	resp, err := tester.client.Do(req)
+	if err != nil {
+		return err
+	}
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+		break
+	default:
+		return fmt.Errorf("expected status %d in comment tree response, got: %d, response body: %s", http.StatusOK, resp.StatusCode, string(body))
+	}
+
+	var response struct {
+		Results []*comment.Node `json:"results"`
+	}
+	if err := json.Unmarshal(body, &response); err != nil {
+		return err
+	}
+
+	for _, n := range response.Results {
+		if n.SlugID == nil || *n.SlugID != *cmntSlugID {
+			continue
+		}
+		if n.NumReplies != 1 || len(n.Replies) != 1 || n.Replies[0].SlugID == nil || *n.Replies[0].SlugID != *replySlugID {
+			return fmt.Errorf("expected reply %s nested under comment %s, response body: %s", *replySlugID, *cmntSlugID, string(body))
+		}
+		tester.logger.Infof("OK: Comment tree validated")
+		return nil
+	}
+
+	return fmt.Errorf("expected comment %s at the top level of the comment tree, response body: %s", *cmntSlugID, string(body))
+}
+
+func (tester *Tester) validateDeletedComment(token *string, category string, slugID, slugTitle, cmntSlugID, replySlugID *string) error {
+
+	url := fmt.Sprintf("http://%s/api/1.0/c/%s/t/%s/%s/comments?sort=new&depth=2", tester.cfg.Addr, category, *slugID, *slugTitle)
+
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", *token))
+
+	resp, err := tester.client.Do(req)
+	if err != nil {
+		return err
+	}
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+		break
+	default:
+		return fmt.Errorf("expected status %d in comment tree response, got: %d, response body: %s", http.StatusOK, resp.StatusCode, string(body))
+	}
+
+	var response struct {
+		Results []*comment.Node `json:"results"`
+	}
+	if err := json.Unmarshal(body, &response); err != nil {
+		return err
+	}
+
+	for _, n := range response.Results {
+		if n.SlugID == nil || *n.SlugID != *cmntSlugID {
+			continue
+		}
+		if n.Deleted == nil || n.Content != comment.Tombstone || n.Username != nil {
+			return fmt.Errorf("expected comment %s to be shown as deleted, response body: %s", *cmntSlugID, string(body))
+		}
+		if len(n.Replies) != 1 || n.Replies[0].SlugID == nil || *n.Replies[0].SlugID != *replySlugID {
+			return fmt.Errorf("expected reply %s to stay nested under deleted comment %s, response body: %s", *replySlugID, *cmntSlugID, string(body))
+		}
+		tester.logger.Infof("OK: Deleted comment validated")
+		return nil
+	}
+
+	return fmt.Errorf("expected deleted comment %s to stay in the comment tree, response body: %s", *cmntSlugID, string(body))
+}
+
+func (tester *Tester) editComment(token *string, category string, slugID, slugTitle, cmntSlugID *string) error {
+
+	url := fmt.Sprintf("http://%s/api/1.0/c/%s/t/%s/%s/comments/%s", tester.cfg.Addr, category, *slugID, *slugTitle, *cmntSlugID)
+
+	reqbody, err := json.Marshal(&comment.Model{
+		Content: `edited test comment`,
+	})
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest(http.MethodPatch, url, bytes.NewReader(reqbody))
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", *token))
+
+	resp, err := tester.client.Do(req)
+	if err != nil {
+		return err
+	}
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+		break
+	default:
+		return fmt.Errorf("expected status %d in edit comment response, got: %d, response body: %s", http.StatusOK, resp.StatusCode, string(body))
+	}
+
+	var response *comment.Model
+	if err := json.Unmarshal(body, &response); err != nil {
+		return err
+	}
+
+	if !response.Edited || response.Content != `edited test comment` {
+		return fmt.Errorf("expected edited comment content, response body: %s", string(body))
+	}
+
+	tester.logger.Infof("OK: Comment edited")
+
+	return nil
+}
+
+func (tester *Tester) validateCommentRevisions(token *string, category string, slugID, slugTitle, cmntSlugID *string, expected int) error {
+
+	url := fmt.Sprintf("http://%s/api/1.0/c/%s/t/%s/%s/comments/%s/revisions", tester.cfg.Addr, category, *slugID, *slugTitle, *cmntSlugID)
+
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", *token))
+
+	resp, err := tester.client.Do(req)
+	if err != nil {
+		return err
+	}
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+		break
+	default:
+		return fmt.Errorf("expected status %d in list comment revisions response, got: %d, response body: %s", http.StatusOK, resp.StatusCode, string(body))
+	}
+
+	var response struct {
+		Results []*revision.Model `json:"results"`
+	}
+	if err := json.Unmarshal(body, &response); err != nil {
+		return err
+	}
+
+	if len(response.Results) != expected {
+		return fmt.Errorf("expected %d comment revisions, got: %d, response body: %s", expected, len(response.Results), string(body))
+	}
+
+	tester.logger.Infof("OK: Comment revisions validated: %d", expected)
+
+	return nil
+}
+
+func (tester *Tester) deleteComment(token *string, category string, slugID, slugTitle, cmntSlugID *string) error {
+
+	url := fmt.Sprintf("http://%s/api/1.0/c/%s/t/%s/%s/comments/%s", tester.cfg.Addr, category, *slugID, *slugTitle, *cmntSlugID)
+
+	req, err := http.NewRequest(http.MethodDelete, url, nil)
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", *token))
+
+	resp, err := tester.client.Do(req)
+	if err != nil {
+		return err
+	}
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusAccepted:
+		break
+	default:
+		return fmt.Errorf("expected status %d in delete comment response, got: %d, response body: %s", http.StatusAccepted, resp.StatusCode, string(body))
+	}
+
+	tester.logger.Infof("OK: Comment deleted")
 
 	return nil
 }
@@ -0,0 +1,152 @@
+package tester
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rgynn/klottr/pkg/api"
+	"github.com/rgynn/klottr/pkg/totp"
+	"github.com/rgynn/ptrconv"
+)
+
+func (tester *Tester) validateLockout() error {
+
+	if tester.cfg.LockoutStore == "none" || tester.cfg.LockoutUserThreshold <= 0 {
+		tester.logger.Infof("SKIP: Sign in lockout needs LOCKOUT_STORE and LOCKOUT_USER_THRESHOLD")
+		return nil
+	}
+
+	username, password, err := tester.signupWithEmail("lockout")
+	if err != nil {
+		return err
+	}
+
+	wrong := "wrong" + password
+
+	for i := 0; i < tester.cfg.LockoutUserThreshold; i++ {
+		if _, err := tester.signinAttempt(username, wrong, http.StatusUnauthorized); err != nil {
+			return err
+		}
+	}
+
+	// the right password is refused too while the username is locked out
+	resp, err := tester.signinAttempt(username, password, http.StatusTooManyRequests)
+	if err != nil {
+		return err
+	}
+
+	retryAfter, err := strconv.Atoi(resp.Header.Get("Retry-After"))
+	if err != nil || retryAfter < 1 {
+		return fmt.Errorf("expected Retry-After header in seconds, got: %s", resp.Header.Get("Retry-After"))
+	}
+
+	time.Sleep(time.Duration(retryAfter) * time.Second)
+
+	if _, err := tester.signinAttempt(username, password, http.StatusOK); err != nil {
+		return err
+	}
+
+	metrics, err := tester.client.Get(fmt.Sprintf("http://%s/api/1.0/metrics", tester.cfg.Addr))
+	if err != nil {
+		return err
+	}
+	defer metrics.Body.Close()
+
+	body, err := ioutil.ReadAll(metrics.Body)
+	if err != nil {
+		return err
+	}
+
+	if !strings.Contains(string(body), `signin_blocked_total{key="username"}`) {
+		return fmt.Errorf("expected signin_blocked_total metric for username lockouts")
+	}
+
+	tester.logger.Infof("OK: Sign in locked out for %ds after %d failed attempts for username: %s", retryAfter, tester.cfg.LockoutUserThreshold, username)
+
+	return tester.validateTwoFactorLockout()
+}
+
+// validateTwoFactorLockout fails the second factor of sign ins with the right password until
+// the username is locked out
+func (tester *Tester) validateTwoFactorLockout() error {
+
+	if tester.cfg.EmailKey == nil {
+		tester.logger.Infof("SKIP: Two factor lockout needs EMAIL_KEY")
+		return nil
+	}
+
+	username, password, err := tester.signupWithEmail("totplockout")
+	if err != nil {
+		return err
+	}
+
+	login := &api.LoginInput{Username: &username, Password: &password}
+
+	signin, err := tester.postAuth("signin", nil, login, http.StatusOK)
+	if err != nil {
+		return err
+	}
+
+	enrollment := new(api.TOTPEnrollment)
+	if _, err := tester.postAuthResult("totp/enroll", &signin.Token, nil, http.StatusOK, enrollment); err != nil {
+		return err
+	}
+
+	code, err := totp.Code(enrollment.Secret, time.Now())
+	if err != nil {
+		return err
+	}
+
+	if _, err := tester.postAuth("totp/confirm", &signin.Token, &api.TOTPInput{Code: &code}, http.StatusOK); err != nil {
+		return err
+	}
+
+	for i := 0; i < tester.cfg.LockoutUserThreshold; i++ {
+		challenge := new(api.ChallengeResponse)
+		if _, err := tester.postAuthResult("signin", nil, login, http.StatusAccepted, challenge); err != nil {
+			return err
+		}
+		if _, err := tester.postAuth("totp/verify", nil, &api.TOTPInput{ChallengeToken: &challenge.ChallengeToken, Code: ptrconv.StringPtr("000000")}, http.StatusUnauthorized); err != nil {
+			return err
+		}
+	}
+
+	if _, err := tester.signinAttempt(username, password, http.StatusTooManyRequests); err != nil {
+		return err
+	}
+
+	tester.logger.Infof("OK: Sign in locked out after %d failed second factors for username: %s", tester.cfg.LockoutUserThreshold, username)
+
+	return nil
+}
+
+func (tester *Tester) signinAttempt(username, password string, expectedStatusCode int) (*http.Response, error) {
+
+	reqbody, err := json.Marshal(&api.LoginInput{Username: &username, Password: &password})
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := tester.client.Post(fmt.Sprintf("http://%s/api/1.0/auth/signin", tester.cfg.Addr), "application/json", bytes.NewReader(reqbody))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode != expectedStatusCode {
+		return nil, fmt.Errorf("expected status %d in signin response, got: %d, response body: %s", expectedStatusCode, resp.StatusCode, string(body))
+	}
+
+	return resp, nil
+}
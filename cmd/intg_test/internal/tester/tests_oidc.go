@@ -0,0 +1,314 @@
+package tester
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/cookiejar"
+	"net/url"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+	"github.com/rgynn/klottr/cmd/intg_test/internal/idp"
+	"github.com/rgynn/klottr/pkg/api"
+	"github.com/rgynn/klottr/pkg/config"
+	"github.com/rgynn/klottr/pkg/user"
+)
+
+// stubProvider is the name of the identity provider the stub server stands in for
+const stubProvider = "stub"
+
+func (tester *Tester) validateOIDC() error {
+
+	var provider *config.OIDCProvider
+	for _, p := range tester.cfg.OIDCProviders {
+		if p.Name == stubProvider {
+			provider = p
+		}
+	}
+
+	if provider == nil {
+		tester.logger.Infof("SKIP: Single sign on needs OIDC_PROVIDERS=%s", stubProvider)
+		return nil
+	}
+
+	srv, err := idp.NewServer(provider)
+	if err != nil {
+		return err
+	}
+
+	server, err := srv.Start()
+	if err != nil {
+		return err
+	}
+	defer server.Close()
+
+	providers := struct {
+		Providers []string `json:"providers"`
+	}{}
+
+	if err := tester.getOIDC(tester.client, fmt.Sprintf("http://%s/api/1.0/auth/oidc", tester.cfg.Addr), nil, http.StatusOK, &providers); err != nil {
+		return err
+	}
+
+	if len(providers.Providers) == 0 || providers.Providers[0] != stubProvider {
+		return fmt.Errorf("expected %s in providers response, got: %v", stubProvider, providers.Providers)
+	}
+
+	if err := tester.getOIDC(tester.client, fmt.Sprintf("http://%s/api/1.0/auth/oidc/unknown", tester.cfg.Addr), nil, http.StatusNotFound, nil); err != nil {
+		return err
+	}
+
+	subject := fmt.Sprintf("oidc%d", time.Now().UnixNano())
+
+	signInURL := fmt.Sprintf("http://%s/api/1.0/auth/oidc/%s", tester.cfg.Addr, stubProvider)
+
+	// the first sign in provisions a user for the identity
+
+	browser, err := tester.browser()
+	if err != nil {
+		return err
+	}
+
+	authURL, err := tester.redirectOIDC(browser, signInURL, "")
+	if err != nil {
+		return err
+	}
+
+	callback, err := tester.redirectOIDC(browser, authURL, subject)
+	if err != nil {
+		return err
+	}
+
+	// the callback is refused in a browser that did not start the sign in
+	other, err := tester.browser()
+	if err != nil {
+		return err
+	}
+
+	if err := tester.getOIDC(other, callback, nil, http.StatusUnauthorized, nil); err != nil {
+		return err
+	}
+
+	first := new(api.TokenResponse)
+	if err := tester.getOIDC(browser, callback, nil, http.StatusOK, first); err != nil {
+		return err
+	}
+
+	claims := new(api.JWTClaims)
+	if _, err := jwt.ParseWithClaims(first.Token, claims, tester.keys.Keyfunc); err != nil {
+		return err
+	}
+
+	if claims.Username == nil || *claims.Username != subject {
+		return fmt.Errorf("expected provisioned username %s, got: %v", subject, claims.Username)
+	}
+
+	// the state of a sign in is used up by the callback, which removes its cookie
+
+	if err := tester.getOIDC(browser, callback, nil, http.StatusUnauthorized, nil); err != nil {
+		return err
+	}
+
+	// signing in again with the same identity signs in the same user
+
+	if authURL, err = tester.redirectOIDC(browser, signInURL, ""); err != nil {
+		return err
+	}
+
+	if callback, err = tester.redirectOIDC(browser, authURL, subject); err != nil {
+		return err
+	}
+
+	second := new(api.TokenResponse)
+	if err := tester.getOIDC(browser, callback, nil, http.StatusOK, second); err != nil {
+		return err
+	}
+
+	if _, err := jwt.ParseWithClaims(second.Token, claims, tester.keys.Keyfunc); err != nil {
+		return err
+	}
+
+	if claims.Username == nil || *claims.Username != subject {
+		return fmt.Errorf("expected username %s signing in again, got: %v", subject, claims.Username)
+	}
+
+	// a signed in user links another identity and signs in with it
+
+	username, password, err := tester.signupWithEmail("oidclink")
+	if err != nil {
+		return err
+	}
+
+	signin, err := tester.postAuth("signin", nil, &api.LoginInput{Username: &username, Password: &password}, http.StatusOK)
+	if err != nil {
+		return err
+	}
+
+	// link starts a link in a new browser and finishes it with bearer
+	link := func(subject string, bearer *string, expectedStatusCode int) error {
+
+		browser, err := tester.browser()
+		if err != nil {
+			return err
+		}
+
+		req, err := http.NewRequest(http.MethodPost, fmt.Sprintf("http://%s/api/1.0/auth/oidc/%s/link", tester.cfg.Addr, stubProvider), nil)
+		if err != nil {
+			return err
+		}
+
+		req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", signin.Token))
+
+		resp, err := browser.Do(req)
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+
+		if resp.StatusCode != http.StatusOK {
+			return fmt.Errorf("expected status %d in link response, got: %d", http.StatusOK, resp.StatusCode)
+		}
+
+		result := struct {
+			AuthURL string `json:"auth_url"`
+		}{}
+
+		if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+			return err
+		}
+
+		callback, err := tester.redirectOIDC(browser, result.AuthURL, subject)
+		if err != nil {
+			return err
+		}
+
+		return tester.getOIDC(browser, callback, bearer, expectedStatusCode, &user.Identity{})
+	}
+
+	// the auth url of a link cannot be finished without the session of the user that started it
+	if err := link(username, nil, http.StatusUnauthorized); err != nil {
+		return err
+	}
+
+	if err := link(username, &first.Token, http.StatusUnauthorized); err != nil {
+		return err
+	}
+
+	if err := link(username, &signin.Token, http.StatusOK); err != nil {
+		return err
+	}
+
+	// an identity linked to another user is not moved over
+	if err := link(subject, &signin.Token, http.StatusConflict); err != nil {
+		return err
+	}
+
+	if authURL, err = tester.redirectOIDC(browser, signInURL, ""); err != nil {
+		return err
+	}
+
+	if callback, err = tester.redirectOIDC(browser, authURL, username); err != nil {
+		return err
+	}
+
+	linked := new(api.TokenResponse)
+	if err := tester.getOIDC(browser, callback, nil, http.StatusOK, linked); err != nil {
+		return err
+	}
+
+	if _, err := jwt.ParseWithClaims(linked.Token, claims, tester.keys.Keyfunc); err != nil {
+		return err
+	}
+
+	if claims.Username == nil || *claims.Username != username {
+		return fmt.Errorf("expected linked username %s, got: %v", username, claims.Username)
+	}
+
+	tester.logger.Infof("OK: Single sign on provisioned username: %s and linked username: %s", subject, username)
+
+	return nil
+}
+
+// browser returns a client keeping cookies without following redirects, like a browser the
+// redirects of a sign in are followed through
+func (tester *Tester) browser() (*http.Client, error) {
+
+	jar, err := cookiejar.New(nil)
+	if err != nil {
+		return nil, err
+	}
+
+	return &http.Client{
+		Timeout: tester.client.Timeout,
+		Jar:     jar,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}, nil
+}
+
+// redirectOIDC requests target with client and returns where the redirect it gets points to, a
+// subject is passed to the stub provider as the login_hint of whom to sign in
+func (tester *Tester) redirectOIDC(client *http.Client, target, subject string) (string, error) {
+
+	u, err := url.Parse(target)
+	if err != nil {
+		return "", err
+	}
+
+	if subject != "" {
+		q := u.Query()
+		q.Set("login_hint", subject)
+		u.RawQuery = q.Encode()
+	}
+
+	resp, err := client.Get(u.String())
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusFound {
+		body, _ := ioutil.ReadAll(resp.Body)
+		return "", fmt.Errorf("expected status %d from %s, got: %d, response body: %s", http.StatusFound, u.Path, resp.StatusCode, string(body))
+	}
+
+	return resp.Header.Get("Location"), nil
+}
+
+// getOIDC requests target with client and the bearer token, when given, and decodes the
+// response into result
+func (tester *Tester) getOIDC(client *http.Client, target string, bearer *string, expectedStatusCode int, result interface{}) error {
+
+	req, err := http.NewRequest(http.MethodGet, target, nil)
+	if err != nil {
+		return err
+	}
+
+	if bearer != nil {
+		req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", *bearer))
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+
+	if resp.StatusCode != expectedStatusCode {
+		return fmt.Errorf("expected status %d from %s, got: %d, response body: %s", expectedStatusCode, target, resp.StatusCode, string(body))
+	}
+
+	if resp.StatusCode != http.StatusOK || result == nil {
+		return nil
+	}
+
+	return json.Unmarshal(body, result)
+}
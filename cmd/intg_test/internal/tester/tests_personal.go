@@ -0,0 +1,170 @@
+package tester
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+
+	"github.com/rgynn/klottr/pkg/api"
+	"github.com/rgynn/klottr/pkg/thread"
+	"github.com/rgynn/klottr/pkg/token"
+	"github.com/rgynn/ptrconv"
+)
+
+func (tester *Tester) validatePersonalTokens(category string) error {
+
+	username, password, err := tester.signupWithEmail("pat")
+	if err != nil {
+		return err
+	}
+
+	signin, err := tester.postAuth("signin", nil, &api.LoginInput{Username: &username, Password: &password}, http.StatusOK)
+	if err != nil {
+		return err
+	}
+
+	tokens := fmt.Sprintf("http://%s/api/1.0/auth/tokens", tester.cfg.Addr)
+
+	if err := tester.request(http.MethodPost, tokens, &signin.Token, &api.PersonalTokenInput{
+		Name:   ptrconv.StringPtr("admin bot"),
+		Scopes: []string{token.ScopeAdmin},
+	}, http.StatusForbidden, nil); err != nil {
+		return err
+	}
+
+	reader := new(api.PersonalTokenResponse)
+	if err := tester.request(http.MethodPost, tokens, &signin.Token, &api.PersonalTokenInput{
+		Name:   ptrconv.StringPtr("reader"),
+		Scopes: []string{token.ScopeRead},
+	}, http.StatusCreated, reader); err != nil {
+		return err
+	}
+
+	if !strings.HasPrefix(reader.Token, token.PersonalPrefix) {
+		return fmt.Errorf("expected personal access token with prefix %s, got: %s", token.PersonalPrefix, reader.Token)
+	}
+
+	poster := new(api.PersonalTokenResponse)
+	if err := tester.request(http.MethodPost, tokens, &signin.Token, &api.PersonalTokenInput{
+		Name:      ptrconv.StringPtr("poster"),
+		Scopes:    []string{token.ScopeRead, token.ScopePost},
+		ExpiresIn: ptrconv.Int64Ptr(3600),
+	}, http.StatusCreated, poster); err != nil {
+		return err
+	}
+
+	if poster.Expires == nil {
+		return fmt.Errorf("expected expires on personal access token created with expires_in")
+	}
+
+	threads := fmt.Sprintf("http://%s/api/1.0/c/%s", tester.cfg.Addr, category)
+	thrd := &thread.Model{
+		Title:   ptrconv.StringPtr("personal access token"),
+		URL:     ptrconv.StringPtr("https://klottr.com"),
+		Content: "posted with a personal access token",
+	}
+
+	// the scopes of a token limit the routes it is accepted on
+
+	if err := tester.request(http.MethodGet, threads, &reader.Token, nil, http.StatusOK, nil); err != nil {
+		return err
+	}
+
+	if err := tester.request(http.MethodPost, threads, &reader.Token, thrd, http.StatusForbidden, nil); err != nil {
+		return err
+	}
+
+	created := new(thread.Model)
+	if err := tester.request(http.MethodPost, threads, &poster.Token, thrd, http.StatusCreated, created); err != nil {
+		return err
+	}
+
+	if err := tester.request(http.MethodDelete, fmt.Sprintf("%s/t/%s/%s", threads, *created.SlugID, *created.SlugTitle), &poster.Token, nil, http.StatusAccepted, nil); err != nil {
+		return err
+	}
+
+	// personal access tokens cannot manage tokens or sessions
+	if err := tester.request(http.MethodGet, tokens, &poster.Token, nil, http.StatusForbidden, nil); err != nil {
+		return err
+	}
+
+	list := []*token.Personal{}
+	if err := tester.request(http.MethodGet, tokens, &signin.Token, nil, http.StatusOK, &list); err != nil {
+		return err
+	}
+
+	if len(list) != 2 {
+		return fmt.Errorf("expected 2 personal access tokens, got: %d", len(list))
+	}
+
+	for _, pat := range list {
+		if pat.LastUsed == nil {
+			return fmt.Errorf("expected last_used on personal access token: %s", pat.Name)
+		}
+	}
+
+	revoke := fmt.Sprintf("%s/%s", tokens, reader.ID)
+
+	if err := tester.request(http.MethodDelete, revoke, &signin.Token, nil, http.StatusAccepted, nil); err != nil {
+		return err
+	}
+
+	if err := tester.request(http.MethodDelete, revoke, &signin.Token, nil, http.StatusNotFound, nil); err != nil {
+		return err
+	}
+
+	if err := tester.request(http.MethodGet, threads, &reader.Token, nil, http.StatusUnauthorized, nil); err != nil {
+		return err
+	}
+
+	tester.logger.Infof("OK: Personal access tokens created, scoped and revoked for username: %s", username)
+
+	return nil
+}
+
+// request sends input as json to url with the bearer token and decodes the response into result
+func (tester *Tester) request(method, url string, bearer *string, input interface{}, expectedStatusCode int, result interface{}) error {
+
+	var reqbody io.Reader
+	if input != nil {
+		b, err := json.Marshal(input)
+		if err != nil {
+			return err
+		}
+		reqbody = bytes.NewReader(b)
+	}
+
+	req, err := http.NewRequest(method, url, reqbody)
+	if err != nil {
+		return err
+	}
+
+	if bearer != nil {
+		req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", *bearer))
+	}
+
+	resp, err := tester.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+
+	if resp.StatusCode != expectedStatusCode {
+		return fmt.Errorf("expected status %d in %s %s response, got: %d, response body: %s", expectedStatusCode, method, url, resp.StatusCode, string(body))
+	}
+
+	if result == nil || len(body) == 0 {
+		return nil
+	}
+
+	return json.Unmarshal(body, result)
+}
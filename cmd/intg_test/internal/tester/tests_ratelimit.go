@@ -0,0 +1,79 @@
+package tester
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+)
+
+// rateLimitedRoute is limited by the RATELIMITS of the integration test to validate rate limits
+const rateLimitedRoute = "/api/1.0/version"
+
+func (tester *Tester) validateRateLimit(token *string) error {
+
+	burst := 0
+	for _, limit := range tester.cfg.RateLimits {
+		if limit.Method == http.MethodGet && limit.Path == rateLimitedRoute {
+			burst = limit.Burst
+		}
+	}
+
+	if tester.cfg.RateLimitStore == "none" || burst == 0 {
+		tester.logger.Infof("SKIP: Rate limits need RATELIMITS=GET %s=<burst>/<period>", rateLimitedRoute)
+		return nil
+	}
+
+	url := fmt.Sprintf("http://%s%s", tester.cfg.Addr, rateLimitedRoute)
+
+	get := func(token *string, expectedStatusCode int) (*http.Response, error) {
+
+		req, err := http.NewRequest(http.MethodGet, url, nil)
+		if err != nil {
+			return nil, err
+		}
+
+		if token != nil {
+			req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", *token))
+		}
+
+		resp, err := tester.client.Do(req)
+		if err != nil {
+			return nil, err
+		}
+		resp.Body.Close()
+
+		if resp.StatusCode != expectedStatusCode {
+			return nil, fmt.Errorf("expected status %d in version response, got: %d", expectedStatusCode, resp.StatusCode)
+		}
+
+		return resp, nil
+	}
+
+	for i := 1; i <= burst; i++ {
+		resp, err := get(nil, http.StatusOK)
+		if err != nil {
+			return err
+		}
+		if remaining := resp.Header.Get("RateLimit-Remaining"); remaining != strconv.Itoa(burst-i) {
+			return fmt.Errorf("expected RateLimit-Remaining %d, got: %s", burst-i, remaining)
+		}
+	}
+
+	resp, err := get(nil, http.StatusTooManyRequests)
+	if err != nil {
+		return err
+	}
+
+	if resp.Header.Get("Retry-After") == "" || resp.Header.Get("RateLimit-Limit") != strconv.Itoa(burst) {
+		return fmt.Errorf("expected Retry-After and RateLimit-Limit headers in rate limited response")
+	}
+
+	// a signed in user has a bucket of its own
+	if _, err := get(token, http.StatusOK); err != nil {
+		return err
+	}
+
+	tester.logger.Infof("OK: Rate limited %s after %d requests", rateLimitedRoute, burst)
+
+	return nil
+}
@@ -7,6 +7,8 @@ import (
 	"io/ioutil"
 	"net/http"
 
+	"github.com/rgynn/klottr/pkg/comment"
+	"github.com/rgynn/klottr/pkg/revision"
 	"github.com/rgynn/klottr/pkg/thread"
 	"github.com/rgynn/klottr/pkg/user"
 	"github.com/rgynn/ptrconv"
@@ -61,9 +63,9 @@ func (tester *Tester) createThread(token *string, category string) (*thread.Mode
 	return result, nil
 }
 
-func (tester *Tester) listThreads(token *string, category string) error {
+func (tester *Tester) listThreads(token *string, category, sort string) error {
 
-	url := fmt.Sprintf("http://%s/api/1.0/c/%s", tester.cfg.Addr, category)
+	url := fmt.Sprintf("http://%s/api/1.0/c/%s?sort=%s", tester.cfg.Addr, category, sort)
 
 	req, err := http.NewRequest(http.MethodGet, url, nil)
 	if err != nil {
@@ -90,16 +92,167 @@ func (tester *Tester) listThreads(token *string, category string) error {
 		return fmt.Errorf("expected status %d in list threads response, got: %d, response body: %s", http.StatusAccepted, resp.StatusCode, string(body))
 	}
 
-	var response []*thread.Model
+	var response threadsResponse
 	if err := json.Unmarshal(body, &response); err != nil {
 		return err
 	}
 
-	tester.logger.Infof("OK: List threads in category: %s", category)
+	tester.logger.Infof("OK: List %s threads in category: %s", sort, category)
 
 	return nil
 }
 
+type threadsResponse struct {
+	Results    []*thread.Model `json:"results"`
+	NextCursor string          `json:"next_cursor"`
+}
+
+// pageThreads walks all threads in category in order sort one at a time following next_cursor
+// and returns the number of pages
+func (tester *Tester) pageThreads(token *string, category, sort string) (int, error) {
+
+	seen := map[string]bool{}
+	next := ""
+	pages := 0
+
+	for {
+
+		url := fmt.Sprintf("http://%s/api/1.0/c/%s?sort=%s&size=1&cursor=%s", tester.cfg.Addr, category, sort, next)
+
+		req, err := http.NewRequest(http.MethodGet, url, nil)
+		if err != nil {
+			return 0, err
+		}
+
+		req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", *token))
+
+		resp, err := tester.client.Do(req)
+		if err != nil {
+			return 0, err
+		}
+
+		body, err := ioutil.ReadAll(resp.Body)
+		if err != nil {
+			return 0, err
+		}
+		defer resp.Body.Close()
+
+		switch resp.StatusCode {
+		case http.StatusOK:
+			break
+		default:
+			return 0, fmt.Errorf("expected status %d in page threads response, got: %d, response body: %s", http.StatusOK, resp.StatusCode, string(body))
+		}
+
+		var response threadsResponse
+		if err := json.Unmarshal(body, &response); err != nil {
+			return 0, err
+		}
+
+		pages++
+
+		for _, m := range response.Results {
+			if seen[*m.SlugID] {
+				return 0, fmt.Errorf("thread %s returned twice while paging %s threads in category: %s", *m.SlugID, sort, category)
+			}
+			seen[*m.SlugID] = true
+		}
+
+		if response.NextCursor == "" {
+			break
+		}
+
+		next = response.NextCursor
+	}
+
+	if len(seen) == 0 {
+		return 0, fmt.Errorf("expected at least one thread while paging %s threads in category: %s", sort, category)
+	}
+
+	tester.logger.Infof("OK: Paged %d %s threads in category: %s", len(seen), sort, category)
+
+	return pages, nil
+}
+
+// validateRankedPaging pages through the ranked orders of category, which are scored at the
+// time of the first page, with a second thread so they take more than one page
+func (tester *Tester) validateRankedPaging(token *string, category string) error {
+
+	thrd, err := tester.createThread(token, category)
+	if err != nil {
+		return err
+	}
+
+	for _, sort := range []string{"hot", "rising", "controversial"} {
+		pages, err := tester.pageThreads(token, category, sort)
+		if err != nil {
+			return err
+		}
+		if pages < 2 {
+			return fmt.Errorf("expected at least 2 pages of %s threads in category: %s, got: %d", sort, category, pages)
+		}
+	}
+
+	// a cursor only continues the order it was returned for
+	url := fmt.Sprintf("http://%s/api/1.0/c/%s?sort=hot&size=1", tester.cfg.Addr, category)
+
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", *token))
+
+	resp, err := tester.client.Do(req)
+	if err != nil {
+		return err
+	}
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("expected status %d in list hot threads response, got: %d, response body: %s", http.StatusOK, resp.StatusCode, string(body))
+	}
+
+	var first threadsResponse
+	if err := json.Unmarshal(body, &first); err != nil {
+		return err
+	}
+
+	if first.NextCursor == "" {
+		return fmt.Errorf("expected next_cursor on first page of hot threads in category: %s", category)
+	}
+
+	url = fmt.Sprintf("http://%s/api/1.0/c/%s?sort=new&size=1&cursor=%s", tester.cfg.Addr, category, first.NextCursor)
+
+	if req, err = http.NewRequest(http.MethodGet, url, nil); err != nil {
+		return err
+	}
+
+	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", *token))
+
+	if resp, err = tester.client.Do(req); err != nil {
+		return err
+	}
+
+	if body, err = ioutil.ReadAll(resp.Body); err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusBadRequest {
+		return fmt.Errorf("expected status %d for a hot cursor in list new threads response, got: %d, response body: %s", http.StatusBadRequest, resp.StatusCode, string(body))
+	}
+
+	tester.logger.Infof("OK: Paged ranked threads in category: %s", category)
+
+	return tester.deleteThread(token, category, thrd.SlugID, thrd.SlugTitle)
+}
+
 func (tester *Tester) getThread(token *string, category string, slugID, slugTitle *string) error {
 
 	url := fmt.Sprintf("http://%s/api/1.0/c/%s/t/%s/%s", tester.cfg.Addr, category, *slugID, *slugTitle)
@@ -147,6 +300,58 @@ func (tester *Tester) getThread(token *string, category string, slugID, slugTitl
 	return nil
 }
 
+func (tester *Tester) getThreadWithComments(token *string, category string, slugID, slugTitle, cmntSlugID *string) error {
+
+	url := fmt.Sprintf("http://%s/api/1.0/c/%s/t/%s/%s?include=comments&sort=new&depth=1", tester.cfg.Addr, category, *slugID, *slugTitle)
+
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", *token))
+
+	resp, err := tester.client.Do(req)
+	if err != nil {
+		return err
+	}
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+		break
+	default:
+		return fmt.Errorf("expected status %d in get thread with comments response, got: %d, response body: %s", http.StatusOK, resp.StatusCode, string(body))
+	}
+
+	var response struct {
+		*thread.Model
+		Comments *struct {
+			Results []*comment.Node `json:"results"`
+		} `json:"comments"`
+	}
+	if err := json.Unmarshal(body, &response); err != nil {
+		return err
+	}
+
+	if response.Model == nil || response.SlugID == nil || *response.SlugID != *slugID {
+		return fmt.Errorf("expected thread with slug_id: %s, response body: %s", *slugID, string(body))
+	}
+
+	if response.Comments == nil || len(response.Comments.Results) == 0 || *response.Comments.Results[0].SlugID != *cmntSlugID {
+		return fmt.Errorf("expected comment %s included in thread, response body: %s", *cmntSlugID, string(body))
+	}
+
+	tester.logger.Infof("OK: Got thread with comments, count: %d", len(response.Comments.Results))
+
+	return nil
+}
+
 func (tester *Tester) upvoteThread(token *string, category string, slugID, slugTitle *string) error {
 
 	url := fmt.Sprintf("http://%s/api/1.0/c/%s/t/%s/%s/vote", tester.cfg.Addr, category, *slugID, *slugTitle)
@@ -225,7 +430,7 @@ func (tester *Tester) downvoteThread(token *string, category string, slugID, slu
 	case http.StatusAccepted:
 		break
 	default:
-		return fmt.Errorf("expected status %d in upvote response, got: %d, body: %s", http.StatusAccepted, resp.StatusCode, string(body))
+		return fmt.Errorf("expected status %d in downvote response, got: %d, body: %s", http.StatusAccepted, resp.StatusCode, string(body))
 	}
 
 	tester.logger.Infof("OK: Thread downvoted")
@@ -233,7 +438,50 @@ func (tester *Tester) downvoteThread(token *string, category string, slugID, slu
 	return nil
 }
 
-func (tester *Tester) validateVotes(token *string, category string, slugID, slugTitle *string) error {
+func (tester *Tester) retractVoteThread(token *string, category string, slugID, slugTitle *string) error {
+
+	url := fmt.Sprintf("http://%s/api/1.0/c/%s/t/%s/%s/vote", tester.cfg.Addr, category, *slugID, *slugTitle)
+
+	reqbody, err := json.Marshal(&user.Vote{
+		SlugType: ptrconv.StringPtr("threads"),
+		SlugID:   slugID,
+		Value:    ptrconv.Int8Ptr(0),
+	})
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(reqbody))
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", *token))
+
+	resp, err := tester.client.Do(req)
+	if err != nil {
+		return err
+	}
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusAccepted:
+		break
+	default:
+		return fmt.Errorf("expected status %d in retract vote response, got: %d, body: %s", http.StatusAccepted, resp.StatusCode, string(body))
+	}
+
+	tester.logger.Infof("OK: Thread vote retracted")
+
+	return nil
+}
+
+func (tester *Tester) validateVotes(token *string, category string, slugID, slugTitle *string, expected int64) error {
 
 	url := fmt.Sprintf("http://%s/api/1.0/c/%s/t/%s/%s", tester.cfg.Addr, category, *slugID, *slugTitle)
 
@@ -267,11 +515,169 @@ func (tester *Tester) validateVotes(token *string, category string, slugID, slug
 		return err
 	}
 
-	if response.Counters.Votes != 0 {
-		return fmt.Errorf("expected num votes to be 0, got: %d, body: %s", response.Counters.Votes, string(body))
+	if response.Counters.Votes != expected {
+		return fmt.Errorf("expected num votes to be %d, got: %d, body: %s", expected, response.Counters.Votes, string(body))
+	}
+
+	tester.logger.Infof("OK: Num votes validated: %d", expected)
+
+	return nil
+}
+
+func (tester *Tester) editThread(token *string, category string, slugID, slugTitle *string) error {
+
+	url := fmt.Sprintf("http://%s/api/1.0/c/%s/t/%s/%s", tester.cfg.Addr, category, *slugID, *slugTitle)
+
+	reqbody, err := json.Marshal(&thread.Model{
+		Content: `edited test thread`,
+	})
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest(http.MethodPatch, url, bytes.NewReader(reqbody))
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", *token))
+
+	resp, err := tester.client.Do(req)
+	if err != nil {
+		return err
+	}
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+		break
+	default:
+		return fmt.Errorf("expected status %d in edit thread response, got: %d, response body: %s", http.StatusOK, resp.StatusCode, string(body))
+	}
+
+	var response *thread.Model
+	if err := json.Unmarshal(body, &response); err != nil {
+		return err
+	}
+
+	if !response.Edited || response.Content != `edited test thread` {
+		return fmt.Errorf("expected edited thread content, response body: %s", string(body))
+	}
+
+	tester.logger.Infof("OK: Thread edited")
+
+	return nil
+}
+
+func (tester *Tester) validateThreadRevisions(token *string, category string, slugID, slugTitle *string, expected int) error {
+
+	url := fmt.Sprintf("http://%s/api/1.0/c/%s/t/%s/%s/revisions", tester.cfg.Addr, category, *slugID, *slugTitle)
+
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", *token))
+
+	resp, err := tester.client.Do(req)
+	if err != nil {
+		return err
+	}
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+		break
+	default:
+		return fmt.Errorf("expected status %d in list thread revisions response, got: %d, response body: %s", http.StatusOK, resp.StatusCode, string(body))
+	}
+
+	var response struct {
+		Results []*revision.Model `json:"results"`
+	}
+	if err := json.Unmarshal(body, &response); err != nil {
+		return err
+	}
+
+	if len(response.Results) != expected {
+		return fmt.Errorf("expected %d thread revisions, got: %d, response body: %s", expected, len(response.Results), string(body))
+	}
+
+	tester.logger.Infof("OK: Thread revisions validated: %d", expected)
+
+	return nil
+}
+
+func (tester *Tester) deleteThread(token *string, category string, slugID, slugTitle *string) error {
+
+	url := fmt.Sprintf("http://%s/api/1.0/c/%s/t/%s/%s", tester.cfg.Addr, category, *slugID, *slugTitle)
+
+	req, err := http.NewRequest(http.MethodDelete, url, nil)
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", *token))
+
+	resp, err := tester.client.Do(req)
+	if err != nil {
+		return err
+	}
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusAccepted:
+		break
+	default:
+		return fmt.Errorf("expected status %d in delete thread response, got: %d, response body: %s", http.StatusAccepted, resp.StatusCode, string(body))
+	}
+
+	req, err = http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+
+	resp, err = tester.client.Do(req)
+	if err != nil {
+		return err
+	}
+
+	body, err = ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("expected status %d in get deleted thread response, got: %d, response body: %s", http.StatusOK, resp.StatusCode, string(body))
+	}
+
+	result := new(thread.Model)
+	if err := json.Unmarshal(body, result); err != nil {
+		return err
+	}
+
+	if result.Deleted == nil || result.Content != thread.Tombstone || result.Username != nil {
+		return fmt.Errorf("expected thread to be shown as deleted, response body: %s", string(body))
 	}
 
-	tester.logger.Infof("OK: Num votes validated")
+	tester.logger.Infof("OK: Thread deleted")
 
 	return nil
 }
@@ -10,7 +10,12 @@ func main() {
 
 	logger := logrus.New()
 
-	cfg, err := config.NewFromEnv("../../.env")
+	flags, err := config.GetFlags()
+	if err != nil {
+		logger.Fatal(err)
+	}
+
+	cfg, err := config.NewFromEnv(flags.EnvFiles...)
 	if err != nil {
 		logger.Fatal(err)
 	}
@@ -4,16 +4,20 @@ import (
 	"context"
 	"fmt"
 
+	"github.com/rgynn/klottr/pkg/category"
 	"github.com/rgynn/klottr/pkg/config"
+	"github.com/rgynn/klottr/pkg/sqldb"
 	"github.com/sirupsen/logrus"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
+
+	mongocategory "github.com/rgynn/klottr/pkg/category/mongo"
+	mongothread "github.com/rgynn/klottr/pkg/thread/mongo"
 )
 
 var logger = logrus.New()
-var threadCategories = []string{"misc"}
 
 func main() {
 
@@ -27,41 +31,165 @@ func main() {
 		logger.Fatal(err)
 	}
 
+	switch cfg.DatabaseDriver {
+	case "mongo":
+		if err := seedMongoDB(cfg); err != nil {
+			logger.Fatal(err)
+		}
+	case sqldb.DriverSQLite, sqldb.DriverPostgres:
+		if err := seedSQL(cfg); err != nil {
+			logger.Fatal(err)
+		}
+	default:
+		logger.Infof("Nothing to seed for database driver: %s", cfg.DatabaseDriver)
+	}
+}
+
+func seedMongoDB(cfg *config.Config) error {
+
 	client, err := openDB(cfg)
 	if err != nil {
-		logger.Fatal(err)
+		return err
+	}
+
+	if err := dropThreadsCollections(cfg, client); err != nil {
+		return err
+	}
+
+	if err := createCategoriesCollection(cfg, client); err != nil {
+		return err
 	}
 
 	if err := createThreadsCollections(cfg, client); err != nil {
-		logger.Fatal(err)
+		return err
 	}
 
 	if err := createCommentsCollection(cfg, client); err != nil {
-		logger.Fatal(err)
+		return err
+	}
+
+	if err := createBansCollection(cfg, client); err != nil {
+		return err
+	}
+
+	if err := createReportsCollection(cfg, client); err != nil {
+		return err
+	}
+
+	if err := createRevisionsCollection(cfg, client); err != nil {
+		return err
+	}
+
+	if err := createTokensCollections(cfg, client); err != nil {
+		return err
 	}
 
 	if err := createUsersCollection(cfg, client); err != nil {
-		logger.Fatal(err)
+		return err
 	}
 
-	if err := closeDB(cfg, client); err != nil {
-		logger.Fatal(err)
+	return closeDB(cfg, client)
+}
+
+func seedSQL(cfg *config.Config) error {
+
+	db, err := sqldb.Open(cfg)
+	if err != nil {
+		return err
 	}
+	defer db.Close()
+
+	logger.Infof("INFO: Connected to %s database\n", cfg.DatabaseDriver)
+
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.RequestTimeout)
+	defer cancel()
+
+	return db.Reset(ctx)
 }
 
-func createThreadsCollections(cfg *config.Config, client *mongo.Client) error {
+// dropThreadsCollections drops the threads collection of every stored category
+func dropThreadsCollections(cfg *config.Config, client *mongo.Client) error {
 
 	ctx, cancel := context.WithTimeout(context.Background(), cfg.RequestTimeout)
 	defer cancel()
 
-	for _, category := range threadCategories {
+	names, err := client.Database(cfg.DatabaseName).Collection("categories").Distinct(ctx, "name", bson.M{})
+	if err != nil {
+		return err
+	}
+
+	for _, category := range names {
 
-		name := fmt.Sprintf("threads_%s", category)
+		name := fmt.Sprintf("threads_%v", category)
 
 		logger.Infof("Dropping collection: %s in database: %s", name, cfg.DatabaseName)
 		if err := client.Database(cfg.DatabaseName).Collection(name).Drop(ctx); err != nil {
 			logger.Warn(err)
 		}
+	}
+
+	return nil
+}
+
+func createCategoriesCollection(cfg *config.Config, client *mongo.Client) error {
+
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.RequestTimeout)
+	defer cancel()
+
+	name := "categories"
+
+	logger.Infof("Dropping collection: %s in database: %s", name, cfg.DatabaseName)
+	if err := client.Database(cfg.DatabaseName).Collection(name).Drop(ctx); err != nil {
+		logger.Warn(err)
+	}
+
+	logger.Infof("Creating collection: %s in database: %s", name, cfg.DatabaseName)
+	if err := client.Database(cfg.DatabaseName).CreateCollection(ctx, name); err != nil {
+		return err
+	}
+
+	logger.Infof("Creating indexes for collection: %s in database: %s", name, cfg.DatabaseName)
+	indexes, err := client.Database(cfg.DatabaseName).Collection(name).Indexes().CreateMany(ctx,
+		[]mongo.IndexModel{
+			{
+				Keys: bson.D{
+					primitive.E{Key: "name", Value: 1},
+				},
+				Options: options.Index().SetUnique(true),
+			},
+		},
+	)
+	if err != nil {
+		return err
+	}
+
+	for _, idx := range indexes {
+		logger.Infof("Created index: %s for collection: %s", idx, name)
+	}
+
+	return nil
+}
+
+// createThreadsCollections creates the default categories and the threads collections and
+// indexes for them, including the indexes used by the ranked thread listings
+func createThreadsCollections(cfg *config.Config, client *mongo.Client) error {
+
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.RequestTimeout)
+	defer cancel()
+
+	categories, err := mongocategory.NewRepository(cfg, client)
+	if err != nil {
+		return err
+	}
+
+	for _, m := range category.Defaults() {
+
+		logger.Infof("Creating category: %s", *m.Name)
+		if err := categories.Create(ctx, m); err != nil {
+			return err
+		}
+
+		name := fmt.Sprintf("threads_%s", *m.Name)
 
 		logger.Infof("Creating collection: %s in database: %s", name, cfg.DatabaseName)
 		if err := client.Database(cfg.DatabaseName).CreateCollection(ctx, name); err != nil {
@@ -69,39 +197,7 @@ func createThreadsCollections(cfg *config.Config, client *mongo.Client) error {
 		}
 
 		logger.Infof("Creating indexes for collection: %s in database: %s", name, cfg.DatabaseName)
-		indexes, err := client.Database(cfg.DatabaseName).Collection(name).Indexes().CreateMany(ctx,
-			[]mongo.IndexModel{
-				{
-					Keys: bson.D{
-						primitive.E{Key: "_id", Value: 1},
-					},
-				},
-				{
-					Keys: bson.D{
-						primitive.E{Key: "slug_id", Value: 1},
-					},
-					Options: options.Index().SetUnique(true),
-				},
-				{
-					Keys: bson.D{
-						primitive.E{Key: "category", Value: 1},
-						primitive.E{Key: "slug_id", Value: 1},
-						primitive.E{Key: "slug_title", Value: 1},
-					},
-				},
-				{
-					Keys: bson.D{
-						primitive.E{Key: "username", Value: 1},
-					},
-				},
-				{
-					Keys: bson.D{
-						primitive.E{Key: "created", Value: 1},
-					},
-					Options: options.Index().SetExpireAfterSeconds(cfg.PostTTLSeconds),
-				},
-			},
-		)
+		indexes, err := mongothread.CreateIndexes(ctx, cfg, client, *m.Name, m.TTL(cfg.PostTTLSeconds))
 		if err != nil {
 			return err
 		}
@@ -151,6 +247,19 @@ func createCommentsCollection(cfg *config.Config, client *mongo.Client) error {
 					primitive.E{Key: "username", Value: 1},
 				},
 			},
+			{
+				Keys: bson.D{
+					primitive.E{Key: "thread_id", Value: 1},
+					primitive.E{Key: "created", Value: 1},
+					primitive.E{Key: "_id", Value: 1},
+				},
+			},
+			{
+				Keys: bson.D{
+					primitive.E{Key: "deleted", Value: 1},
+				},
+				Options: options.Index().SetSparse(true),
+			},
 			{
 				Keys: bson.D{
 					primitive.E{Key: "created", Value: 1},
@@ -170,6 +279,198 @@ func createCommentsCollection(cfg *config.Config, client *mongo.Client) error {
 	return nil
 }
 
+func createRevisionsCollection(cfg *config.Config, client *mongo.Client) error {
+
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.RequestTimeout)
+	defer cancel()
+
+	name := "revisions"
+
+	logger.Infof("Dropping collection: %s in database: %s", name, cfg.DatabaseName)
+	if err := client.Database(cfg.DatabaseName).Collection(name).Drop(ctx); err != nil {
+		logger.Warn(err)
+	}
+
+	logger.Infof("Creating collection: %s in database: %s", name, cfg.DatabaseName)
+	if err := client.Database(cfg.DatabaseName).CreateCollection(ctx, name); err != nil {
+		return err
+	}
+
+	logger.Infof("Creating indexes for collection: %s in database: %s", name, cfg.DatabaseName)
+	indexes, err := client.Database(cfg.DatabaseName).Collection(name).Indexes().CreateMany(ctx,
+		[]mongo.IndexModel{
+			{
+				Keys: bson.D{
+					primitive.E{Key: "parent_id", Value: 1},
+					primitive.E{Key: "replaced", Value: -1},
+					primitive.E{Key: "_id", Value: -1},
+				},
+			},
+			{
+				Keys: bson.D{
+					primitive.E{Key: "created", Value: 1},
+				},
+				Options: options.Index().SetExpireAfterSeconds(cfg.PostTTLSeconds),
+			},
+		},
+	)
+	if err != nil {
+		return err
+	}
+
+	for _, idx := range indexes {
+		logger.Infof("Created index: %s for collection: %s", idx, name)
+	}
+
+	return nil
+}
+
+func createBansCollection(cfg *config.Config, client *mongo.Client) error {
+
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.RequestTimeout)
+	defer cancel()
+
+	name := "bans"
+
+	logger.Infof("Dropping collection: %s in database: %s", name, cfg.DatabaseName)
+	if err := client.Database(cfg.DatabaseName).Collection(name).Drop(ctx); err != nil {
+		logger.Warn(err)
+	}
+
+	logger.Infof("Creating collection: %s in database: %s", name, cfg.DatabaseName)
+	if err := client.Database(cfg.DatabaseName).CreateCollection(ctx, name); err != nil {
+		return err
+	}
+
+	logger.Infof("Creating indexes for collection: %s in database: %s", name, cfg.DatabaseName)
+	indexes, err := client.Database(cfg.DatabaseName).Collection(name).Indexes().CreateMany(ctx,
+		[]mongo.IndexModel{
+			{
+				Keys: bson.D{
+					primitive.E{Key: "username", Value: 1},
+					primitive.E{Key: "category", Value: 1},
+				},
+			},
+			{
+				Keys: bson.D{
+					primitive.E{Key: "category", Value: 1},
+					primitive.E{Key: "created", Value: -1},
+					primitive.E{Key: "_id", Value: -1},
+				},
+			},
+		},
+	)
+	if err != nil {
+		return err
+	}
+
+	for _, idx := range indexes {
+		logger.Infof("Created index: %s for collection: %s", idx, name)
+	}
+
+	return nil
+}
+
+func createReportsCollection(cfg *config.Config, client *mongo.Client) error {
+
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.RequestTimeout)
+	defer cancel()
+
+	name := "reports"
+
+	logger.Infof("Dropping collection: %s in database: %s", name, cfg.DatabaseName)
+	if err := client.Database(cfg.DatabaseName).Collection(name).Drop(ctx); err != nil {
+		logger.Warn(err)
+	}
+
+	logger.Infof("Creating collection: %s in database: %s", name, cfg.DatabaseName)
+	if err := client.Database(cfg.DatabaseName).CreateCollection(ctx, name); err != nil {
+		return err
+	}
+
+	logger.Infof("Creating indexes for collection: %s in database: %s", name, cfg.DatabaseName)
+	indexes, err := client.Database(cfg.DatabaseName).Collection(name).Indexes().CreateMany(ctx,
+		[]mongo.IndexModel{
+			{
+				Keys: bson.D{
+					primitive.E{Key: "parent_id", Value: 1},
+					primitive.E{Key: "username", Value: 1},
+				},
+				Options: options.Index().SetUnique(true),
+			},
+			{
+				Keys: bson.D{
+					primitive.E{Key: "category", Value: 1},
+					primitive.E{Key: "parent_id", Value: 1},
+				},
+			},
+		},
+	)
+	if err != nil {
+		return err
+	}
+
+	for _, idx := range indexes {
+		logger.Infof("Created index: %s for collection: %s", idx, name)
+	}
+
+	return nil
+}
+
+// createTokensCollections creates the refresh_tokens, denied_tokens, tickets and personal_tokens
+// collections, each expiring its documents once the token expires
+func createTokensCollections(cfg *config.Config, client *mongo.Client) error {
+
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.RequestTimeout)
+	defer cancel()
+
+	for name, keys := range map[string][]string{
+		"refresh_tokens":  {"family", "username"},
+		"denied_tokens":   {},
+		"tickets":         {"username"},
+		"personal_tokens": {"id", "username"},
+	} {
+
+		logger.Infof("Dropping collection: %s in database: %s", name, cfg.DatabaseName)
+		if err := client.Database(cfg.DatabaseName).Collection(name).Drop(ctx); err != nil {
+			logger.Warn(err)
+		}
+
+		logger.Infof("Creating collection: %s in database: %s", name, cfg.DatabaseName)
+		if err := client.Database(cfg.DatabaseName).CreateCollection(ctx, name); err != nil {
+			return err
+		}
+
+		models := []mongo.IndexModel{
+			{
+				Keys: bson.D{
+					primitive.E{Key: "expires", Value: 1},
+				},
+				Options: options.Index().SetExpireAfterSeconds(0),
+			},
+		}
+		for _, key := range keys {
+			models = append(models, mongo.IndexModel{
+				Keys: bson.D{
+					primitive.E{Key: key, Value: 1},
+				},
+			})
+		}
+
+		logger.Infof("Creating indexes for collection: %s in database: %s", name, cfg.DatabaseName)
+		indexes, err := client.Database(cfg.DatabaseName).Collection(name).Indexes().CreateMany(ctx, models)
+		if err != nil {
+			return err
+		}
+
+		for _, idx := range indexes {
+			logger.Infof("Created index: %s for collection: %s", idx, name)
+		}
+	}
+
+	return nil
+}
+
 func createUsersCollection(cfg *config.Config, client *mongo.Client) error {
 
 	ctx, cancel := context.WithTimeout(context.Background(), cfg.RequestTimeout)
@@ -207,6 +508,15 @@ func createUsersCollection(cfg *config.Config, client *mongo.Client) error {
 					primitive.E{Key: "role", Value: 1},
 				},
 			},
+			{
+				Keys: bson.D{
+					primitive.E{Key: "identities.issuer", Value: 1},
+					primitive.E{Key: "identities.subject", Value: 1},
+				},
+				Options: options.Index().SetUnique(true).SetPartialFilterExpression(bson.D{
+					primitive.E{Key: "identities", Value: bson.D{primitive.E{Key: "$exists", Value: true}}},
+				}),
+			},
 		},
 	)
 	if err != nil {
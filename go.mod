@@ -15,6 +15,11 @@ require (
 	golang.org/x/crypto v0.0.0-20210711020723-a769d52b0f97
 )
 
+require (
+	github.com/lib/pq v1.10.9
+	github.com/mattn/go-sqlite3 v1.14.16
+)
+
 require (
 	github.com/beorn7/perks v1.0.1 // indirect
 	github.com/cespare/xxhash/v2 v2.1.1 // indirect
@@ -39,8 +39,13 @@ func main() {
 		api.RequestIDMiddleware,
 		api.ContextLoggerMiddleware,
 		api.JWTMiddleware,
+		api.BanMiddleware,
+		api.RateLimitMiddleware,
 	)
 
+	// Public keys verifying the jwts of the service
+	r.HandleFunc("/.well-known/jwks.json", api.JWKSHandler).Methods(http.MethodGet)
+
 	v1 := r.PathPrefix("/api/1.0").Subrouter()
 
 	// Version
@@ -51,19 +56,54 @@ func main() {
 	// Auth
 	v1.HandleFunc("/auth/signin", api.SignInHandler).Methods(http.MethodPost)
 	v1.HandleFunc("/auth/signup", api.SignUpHandler).Methods(http.MethodPost)
+	v1.HandleFunc("/auth/refresh", api.RefreshHandler).Methods(http.MethodPost)
+	v1.HandleFunc("/auth/signout", api.SignOutHandler).Methods(http.MethodPost)
+	v1.HandleFunc("/auth/verify", api.VerifyHandler).Methods(http.MethodGet)
+	v1.HandleFunc("/auth/verify/resend", api.ResendVerificationHandler).Methods(http.MethodPost)
+	v1.HandleFunc("/auth/password/forgot", api.ForgotPasswordHandler).Methods(http.MethodPost)
+	v1.HandleFunc("/auth/password/reset", api.ResetPasswordHandler).Methods(http.MethodPost)
+	v1.HandleFunc("/auth/oidc", api.OIDCProvidersHandler).Methods(http.MethodGet)
+	v1.HandleFunc("/auth/oidc/{provider}", api.OIDCSignInHandler).Methods(http.MethodGet)
+	v1.HandleFunc("/auth/oidc/{provider}/link", api.OIDCLinkHandler).Methods(http.MethodPost)
+	v1.HandleFunc("/auth/oidc/{provider}/callback", api.OIDCCallbackHandler).Methods(http.MethodGet)
+	v1.HandleFunc("/auth/totp/enroll", api.TOTPEnrollHandler).Methods(http.MethodPost)
+	v1.HandleFunc("/auth/totp/confirm", api.TOTPConfirmHandler).Methods(http.MethodPost)
+	v1.HandleFunc("/auth/totp/verify", api.TOTPVerifyHandler).Methods(http.MethodPost)
+	v1.HandleFunc("/auth/totp/disable", api.TOTPDisableHandler).Methods(http.MethodPost)
 	v1.HandleFunc("/auth/deactivate", api.DeactivateHandler).Methods(http.MethodPost)
+	v1.HandleFunc("/auth/tokens", api.ListPersonalTokensHandler).Methods(http.MethodGet)
+	v1.HandleFunc("/auth/tokens", api.CreatePersonalTokenHandler).Methods(http.MethodPost)
+	v1.HandleFunc("/auth/tokens/{id}", api.RevokePersonalTokenHandler).Methods(http.MethodDelete)
+
+	// Categories
+	v1.HandleFunc("/c", api.ListCategoriesHandler).Methods(http.MethodGet)
+
+	// Admin, every route below /admin is guarded by the roles in its permission table
+	api.HandleRoutes(v1.PathPrefix("/admin").Subrouter(), api.AdminRoutes())
+
+	// Moderation, guarded by the roles in its permission table and the categories of moderators
+	api.HandleRoutes(v1.NewRoute().Subrouter(), api.ModeratorRoutes())
 
 	// Threads
 	v1.HandleFunc("/c/{category}", api.CreateThreadHandler).Methods(http.MethodPost)
 	v1.HandleFunc("/c/{category}", api.ListThreadsHandler).Methods(http.MethodGet)
 	v1.HandleFunc("/c/{category}/t/{slug_id}/{slug_title}", api.GetThreadHandler).Methods(http.MethodGet)
+	v1.HandleFunc("/c/{category}/t/{slug_id}/{slug_title}", api.EditThreadHandler).Methods(http.MethodPatch)
+	v1.HandleFunc("/c/{category}/t/{slug_id}/{slug_title}", api.DeleteThreadHandler).Methods(http.MethodDelete)
+	v1.HandleFunc("/c/{category}/t/{slug_id}/{slug_title}/revisions", api.ListThreadRevisionsHandler).Methods(http.MethodGet)
 	v1.HandleFunc("/c/{category}/t/{slug_id}/{slug_title}/vote", api.VoteThreadHandler).Methods(http.MethodPost)
+	v1.HandleFunc("/c/{category}/t/{slug_id}/{slug_title}/report", api.ReportThreadHandler).Methods(http.MethodPost)
 
 	// Comments
 	v1.HandleFunc("/c/{category}/t/{slug_id}/{slug_title}/comments", api.CreateCommentHandler).Methods(http.MethodPost)
+	v1.HandleFunc("/c/{category}/t/{slug_id}/{slug_title}/comments", api.ListCommentsHandler).Methods(http.MethodGet)
 	v1.HandleFunc("/c/{category}/t/{slug_id}/{slug_title}/comments/{comment_slug_id}", api.GetCommentHandler).Methods(http.MethodGet)
+	v1.HandleFunc("/c/{category}/t/{slug_id}/{slug_title}/comments/{comment_slug_id}", api.EditCommentHandler).Methods(http.MethodPatch)
 	v1.HandleFunc("/c/{category}/t/{slug_id}/{slug_title}/comments/{comment_slug_id}", api.DeleteCommentHandler).Methods(http.MethodDelete)
+	v1.HandleFunc("/c/{category}/t/{slug_id}/{slug_title}/comments/{comment_slug_id}/revisions", api.ListCommentRevisionsHandler).Methods(http.MethodGet)
 	v1.HandleFunc("/c/{category}/t/{slug_id}/{slug_title}/comments/{comment_slug_id}/vote", api.VoteCommentHandler).Methods(http.MethodPost)
+	v1.HandleFunc("/c/{category}/t/{slug_id}/{slug_title}/comments/{comment_slug_id}/report", api.ReportCommentHandler).Methods(http.MethodPost)
+	v1.HandleFunc("/c/{category}/t/{slug_id}/{slug_title}/comments/{comment_slug_id}/replies", api.CreateCommentHandler).Methods(http.MethodPost)
 
 	srv := &http.Server{
 		IdleTimeout:  cfg.IdleTimeout,
@@ -5,20 +5,24 @@ import (
 	"encoding/json"
 	"fmt"
 	"net/http"
+	"sync"
+	"time"
 
 	"github.com/labstack/echo/v4"
+	"github.com/rgynn/klottr/pkg/ban"
+	"github.com/rgynn/klottr/pkg/category"
+	"github.com/rgynn/klottr/pkg/comment"
 	"github.com/rgynn/klottr/pkg/config"
-	"go.mongodb.org/mongo-driver/mongo"
-	"go.mongodb.org/mongo-driver/mongo/options"
-
-	"github.com/rgynn/klottr/pkg/thread"
-	mongothread "github.com/rgynn/klottr/pkg/thread/mongo"
-
+	"github.com/rgynn/klottr/pkg/keys"
+	"github.com/rgynn/klottr/pkg/lockout"
+	"github.com/rgynn/klottr/pkg/mail"
+	"github.com/rgynn/klottr/pkg/oidc"
+	"github.com/rgynn/klottr/pkg/ratelimit"
+	"github.com/rgynn/klottr/pkg/report"
+	"github.com/rgynn/klottr/pkg/revision"
+	"github.com/rgynn/klottr/pkg/token"
+	"github.com/rgynn/klottr/pkg/tx"
 	"github.com/rgynn/klottr/pkg/user"
-	mongouser "github.com/rgynn/klottr/pkg/user/mongo"
-
-	"github.com/rgynn/klottr/pkg/comment"
-	mongocomments "github.com/rgynn/klottr/pkg/comment/mongo"
 )
 
 // BodyDumpFunc used to dump request and response bodies through logger
@@ -31,50 +35,95 @@ func (svc *Service) BodyDumpFunc(c echo.Context, reqBody, resBody []byte) {
 
 // Service for api
 type Service struct {
-	cfg      *config.Config
-	mongodb  *mongo.Client
-	users    user.Repository
-	misc     thread.Repository
-	comments comment.Repository
+	cfg        *config.Config
+	db         Database
+	users      user.Repository
+	categories category.Repository
+	comments   comment.Repository
+	revisions  revision.Repository
+	bans       ban.Repository
+	reports    report.Repository
+	tokens     token.Repository
+	mailer     mail.Mailer
+	oidc       map[string]oidc.Provider
+	lockout    lockout.Store
+	limiter    ratelimit.Store
+	rateLimits map[string]*config.RateLimit
+	tx         tx.Transactor
+	keysMu     sync.RWMutex
+	keys       *keys.Set
+	deniedMu   sync.RWMutex
+	denied     map[string]time.Time
+	// deniedUsers maps usernames to the time their access tokens issued before are denied
+	deniedUsers map[string]time.Time
+	registryMu  sync.RWMutex
+	registry    map[string]*threadCategory
+	stop        chan struct{}
+	jobs        sync.WaitGroup
 }
 
 func NewAPIFromConfig(cfg *config.Config) (*Service, error) {
 
 	setupMetrics()
 
-	mongodb, err := setupMongoDBConnection(cfg)
-	if err != nil {
-		return nil, fmt.Errorf("failed to setup connection to mongodb: %w", err)
+	svc := &Service{
+		cfg:         cfg,
+		registry:    map[string]*threadCategory{},
+		denied:      map[string]time.Time{},
+		deniedUsers: map[string]time.Time{},
+		stop:        make(chan struct{}),
+	}
+
+	if err := svc.loadKeys(context.Background()); err != nil {
+		return nil, fmt.Errorf("failed to load jwt keys: %w", err)
+	}
+
+	if err := svc.setupMailer(); err != nil {
+		return nil, err
+	}
+
+	if err := svc.setupOIDC(); err != nil {
+		return nil, err
+	}
+
+	if err := svc.setupLockout(); err != nil {
+		return nil, err
+	}
+
+	if err := svc.setupRateLimit(); err != nil {
+		return nil, err
 	}
 
-	users, err := mongouser.NewRepository(cfg, mongodb)
-	if err != nil {
-		return nil, fmt.Errorf("failed to initialize users repository: %w", err)
+	if err := svc.setupDatabase(); err != nil {
+		return nil, err
 	}
 
-	misc, err := mongothread.NewRepository(cfg, mongodb, "misc")
-	if err != nil {
-		return nil, fmt.Errorf("failed to initialize misc threads repository: %w", err)
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.RequestTimeout)
+	defer cancel()
+
+	if err := svc.loadCategories(ctx); err != nil {
+		return nil, fmt.Errorf("failed to load categories: %w", err)
 	}
 
-	comments, err := mongocomments.NewRepository(cfg, mongodb)
-	if err != nil {
-		return nil, fmt.Errorf("failed to initialize comments repository: %w", err)
+	if err := svc.loadDenied(ctx); err != nil {
+		return nil, fmt.Errorf("failed to load denied tokens: %w", err)
 	}
 
-	return &Service{
-		mongodb:  mongodb,
-		cfg:      cfg,
-		users:    users,
-		misc:     misc,
-		comments: comments,
-	}, nil
+	svc.startJob("load categories", time.Minute, svc.loadCategories)
+	svc.startJob("load jwt keys", time.Minute, svc.loadKeys)
+	svc.startJob("load denied tokens", 30*time.Second, svc.loadDenied)
+	svc.startJob("delete expired tokens", time.Hour, svc.deleteExpiredTokens)
+	svc.startJob("purge deleted", time.Hour, svc.purgeDeleted)
+
+	return svc, nil
 }
 
 func (svc *Service) Close() error {
+	close(svc.stop)
+	svc.jobs.Wait()
 	ctx, cancel := context.WithTimeout(context.Background(), svc.cfg.RequestTimeout)
 	defer cancel()
-	return svc.mongodb.Disconnect(ctx)
+	return svc.db.Close(ctx)
 }
 
 func (svc *Service) UnmarshalJSONRequest(w http.ResponseWriter, r *http.Request, v interface{}) error {
@@ -103,20 +152,3 @@ func (svc *Service) NoContentResponse(w http.ResponseWriter, status int) error {
 	w.WriteHeader(status)
 	return nil
 }
-
-func setupMongoDBConnection(cfg *config.Config) (*mongo.Client, error) {
-
-	ctx, cancel := context.WithTimeout(context.Background(), cfg.RequestTimeout)
-	defer cancel()
-
-	mongodb, err := mongo.Connect(ctx, options.Client().ApplyURI(cfg.DatabaseURL))
-	if err != nil {
-		return nil, err
-	}
-
-	if err := mongodb.Ping(ctx, nil); err != nil {
-		return nil, err
-	}
-
-	return mongodb, nil
-}
@@ -0,0 +1,80 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/rgynn/klottr/pkg/ban"
+	"github.com/rgynn/klottr/pkg/user"
+	"github.com/rgynn/ptrconv"
+)
+
+// checkBanned returns ban.ErrBanned when username has an active ban in category or site wide
+func (svc *Service) checkBanned(ctx context.Context, username *string, category string) error {
+
+	list, err := svc.bans.ListActive(ctx, username, time.Now().UTC())
+	if err != nil {
+		return err
+	}
+
+	for _, m := range list {
+		if m.Applies(category) {
+			return ban.ErrBanned
+		}
+	}
+
+	return nil
+}
+
+// siteBan returns the active site wide ban of username, or nil when there is none
+func (svc *Service) siteBan(ctx context.Context, username *string) (*ban.Model, error) {
+
+	list, err := svc.bans.ListActive(ctx, username, time.Now().UTC())
+	if err != nil {
+		return nil, err
+	}
+
+	for _, m := range list {
+		if m.Category == nil {
+			return m, nil
+		}
+	}
+
+	return nil, nil
+}
+
+// bannable checks that username exists, is not an admin or a moderator of category and is not
+// banned from it already, returning the status to respond with when it cannot be banned, a nil
+// category checks for a site wide ban, which only admins are safe from
+func (svc *Service) bannable(ctx context.Context, username, category *string) (int, error) {
+
+	u, err := svc.users.GetByUsername(ctx, username)
+	if err != nil {
+		switch err {
+		case user.ErrNotFound:
+			return http.StatusNotFound, err
+		default:
+			return http.StatusInternalServerError, err
+		}
+	}
+
+	target := &JWTClaims{Role: u.Role, Moderates: u.Moderates}
+	if target.CanModerate(ptrconv.StringPtrString(category)) {
+		return http.StatusForbidden, errors.New("cannot ban an admin, or a moderator from its category")
+	}
+
+	active, err := svc.bans.ListActive(ctx, username, time.Now().UTC())
+	if err != nil {
+		return http.StatusInternalServerError, err
+	}
+
+	for _, m := range active {
+		if (m.Category == nil) == (category == nil) && ptrconv.StringPtrString(m.Category) == ptrconv.StringPtrString(category) {
+			return http.StatusConflict, ban.ErrAlreadyBanned
+		}
+	}
+
+	return http.StatusOK, nil
+}
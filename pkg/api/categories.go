@@ -0,0 +1,130 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/rgynn/klottr/pkg/category"
+	"github.com/rgynn/klottr/pkg/thread"
+	"github.com/sirupsen/logrus"
+)
+
+// categoryTTL is how long a registered category is used before it is read again, so a category
+// archived by another instance of the service is seen by this one within it
+const categoryTTL = 10 * time.Second
+
+// threadCategory is a registered category and the thread repository backing it, loaded is when
+// the category was last read from the database
+type threadCategory struct {
+	model   *category.Model
+	threads thread.Repository
+	loaded  time.Time
+}
+
+// loadCategories registers every stored category, creating the default ones when there are none
+func (svc *Service) loadCategories(ctx context.Context) error {
+
+	list, err := svc.categories.List(ctx, true)
+	if err != nil {
+		return err
+	}
+
+	if len(list) == 0 {
+		for _, m := range category.Defaults() {
+			err := svc.createCategory(ctx, m)
+			if err == category.ErrAlreadyExists {
+				continue
+			}
+			if err != nil {
+				return fmt.Errorf("failed to create default category %s: %w", *m.Name, err)
+			}
+			logrus.Infof("Created default category: %s", *m.Name)
+		}
+		return nil
+	}
+
+	for _, m := range list {
+		if _, err := svc.registerCategory(ctx, m); err != nil {
+			return fmt.Errorf("failed to register category %s: %w", *m.Name, err)
+		}
+	}
+
+	return nil
+}
+
+// createCategory stores m and registers it
+func (svc *Service) createCategory(ctx context.Context, m *category.Model) error {
+
+	if err := svc.categories.Create(ctx, m); err != nil {
+		return err
+	}
+
+	_, err := svc.registerCategory(ctx, m)
+	return err
+}
+
+// registerCategory adds or refreshes m in the registry, reusing an existing thread repository,
+// the storage for threads of categories new to the registry is set up first so indexes added
+// by later versions of the service are created for existing categories as well
+func (svc *Service) registerCategory(ctx context.Context, m *category.Model) (*threadCategory, error) {
+
+	svc.registryMu.Lock()
+	defer svc.registryMu.Unlock()
+
+	now := time.Now()
+
+	if existing, ok := svc.registry[*m.Name]; ok {
+		entry := &threadCategory{model: m, threads: existing.threads, loaded: now}
+		svc.registry[*m.Name] = entry
+		return entry, nil
+	}
+
+	if err := svc.db.SetupThreads(ctx, m); err != nil {
+		return nil, err
+	}
+
+	threads, err := svc.db.Threads(m)
+	if err != nil {
+		return nil, err
+	}
+
+	entry := &threadCategory{model: m, threads: threads, loaded: now}
+	svc.registry[*m.Name] = entry
+
+	return entry, nil
+}
+
+// threadCategory returns the category called name and its thread repository, categories created
+// by other instances of the service and the ones registered longer than categoryTTL ago are
+// looked up in the database
+func (svc *Service) threadCategory(ctx context.Context, name string) (*category.Model, thread.Repository, error) {
+
+	svc.registryMu.RLock()
+	entry, ok := svc.registry[name]
+	svc.registryMu.RUnlock()
+
+	if ok && time.Since(entry.loaded) < categoryTTL {
+		return entry.model, entry.threads, nil
+	}
+
+	m, err := svc.categories.Get(ctx, &name)
+	if err != nil {
+		switch err {
+		case category.ErrNotFound:
+			svc.registryMu.Lock()
+			delete(svc.registry, name)
+			svc.registryMu.Unlock()
+			return nil, nil, thread.ErrCategoryNotFound
+		default:
+			return nil, nil, err
+		}
+	}
+
+	entry, err = svc.registerCategory(ctx, m)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return entry.model, entry.threads, nil
+}
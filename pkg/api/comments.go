@@ -0,0 +1,136 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/rgynn/klottr/pkg/comment"
+	"github.com/rgynn/klottr/pkg/cursor"
+	"github.com/rgynn/klottr/pkg/thread"
+	"github.com/rgynn/klottr/pkg/user"
+	"github.com/rgynn/ptrconv"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// CommentsQuery of a comment listing, Tree is nil for the flat view
+type CommentsQuery struct {
+	Page *Pagination
+	Tree *comment.TreeOptions
+}
+
+// CommentsQueryFromRequest reads the view, sort, depth, replies and pagination query parameters
+func (svc *Service) CommentsQueryFromRequest(w http.ResponseWriter, r *http.Request) (*CommentsQuery, error) {
+
+	query := r.URL.Query()
+
+	page, err := svc.PaginationFromRequest(w, r)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &CommentsQuery{Page: page}
+
+	switch query.Get("view") {
+	case "", "tree":
+		if result.Tree, err = comment.NewTreeOptions(query.Get("sort"), query.Get("depth"), query.Get("replies"), nil, page.After, page.Size); err != nil {
+			return nil, err
+		}
+	case "flat":
+		break
+	default:
+		return nil, errors.New("invalid view, must be one of: tree, flat")
+	}
+
+	return result, nil
+}
+
+// listComments returns the page of comments of thrd described by cq
+func (svc *Service) listComments(ctx context.Context, thrd *thread.Model, cq *CommentsQuery) (*ListResponse, error) {
+
+	if cq.Tree == nil {
+
+		list, err := svc.comments.ListByThreadID(ctx, thrd.ID, cq.Page.After, cq.Page.From, cq.Page.Size)
+		if err != nil {
+			return nil, err
+		}
+
+		redact(list)
+
+		return svc.NewListResponse(list, comment.NextCursor(list, cq.Page.Size))
+	}
+
+	list, err := svc.comments.ListByThreadID(ctx, thrd.ID, nil, 0, comment.MaxTreeComments)
+	if err != nil {
+		return nil, err
+	}
+
+	redact(list)
+
+	tree, next := comment.Tree(list, cq.Tree)
+
+	if err := svc.signReplies(tree); err != nil {
+		return nil, err
+	}
+
+	return svc.NewListResponse(tree, next)
+}
+
+// signReplies signs the cursors continuing the replies of every comment in tree
+func (svc *Service) signReplies(tree []*comment.Node) error {
+
+	for _, n := range tree {
+
+		if n.More != nil {
+			token, err := cursor.Encode([]byte(svc.cfg.CursorSecret), n.More)
+			if err != nil {
+				return err
+			}
+			n.NextCursor = token
+		}
+
+		if err := svc.signReplies(n.Replies); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// redact replaces the deleted comments in list with their tombstones
+func redact(list []*comment.Model) {
+	for i, m := range list {
+		list[i] = m.Redacted()
+	}
+}
+
+// removeComment marks cmnt of thrd as deleted by username, removes its revisions and takes it
+// off the counters of the thread and its author
+func (svc *Service) removeComment(ctx context.Context, category string, threads thread.Repository, thrd *thread.Model, cmnt *comment.Model, username *string) error {
+
+	now := time.Now().UTC()
+	m := &comment.Model{Deleted: &now, DeletedBy: username}
+
+	return svc.tx.Do(ctx, func(ctx context.Context) error {
+
+		if err := svc.comments.SoftDelete(ctx, cmnt.SlugID, m); err != nil {
+			return fmt.Errorf("failed to delete user comment: %w", err)
+		}
+
+		if err := svc.revisions.DeleteByParentIDs(ctx, []primitive.ObjectID{*cmnt.ID}); err != nil {
+			return fmt.Errorf("failed to delete comment revisions: %w", err)
+		}
+
+		if err := threads.IncCounter(ctx, thrd.SlugID, thrd.SlugTitle, ptrconv.StringPtr("counters.comments"), -1); err != nil {
+			return fmt.Errorf("failed to decrement %s thread num comments: %w", category, err)
+		}
+
+		if err := svc.users.IncCounter(ctx, cmnt.Username, ptrconv.StringPtr("counters.num.comments"), -1); err != nil && err != user.ErrNotFound {
+			return fmt.Errorf("failed to decrement user comment count: %w", err)
+		}
+
+		return nil
+	})
+}
@@ -0,0 +1,294 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/rgynn/klottr/pkg/category"
+	"github.com/rgynn/klottr/pkg/config"
+	"github.com/rgynn/klottr/pkg/sqldb"
+	"github.com/rgynn/klottr/pkg/thread"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	memorythread "github.com/rgynn/klottr/pkg/thread/memory"
+	mongothread "github.com/rgynn/klottr/pkg/thread/mongo"
+	sqlthread "github.com/rgynn/klottr/pkg/thread/sql"
+
+	memoryuser "github.com/rgynn/klottr/pkg/user/memory"
+	mongouser "github.com/rgynn/klottr/pkg/user/mongo"
+	sqluser "github.com/rgynn/klottr/pkg/user/sql"
+
+	memorycomments "github.com/rgynn/klottr/pkg/comment/memory"
+	mongocomments "github.com/rgynn/klottr/pkg/comment/mongo"
+	sqlcomments "github.com/rgynn/klottr/pkg/comment/sql"
+
+	memorycategory "github.com/rgynn/klottr/pkg/category/memory"
+	mongocategory "github.com/rgynn/klottr/pkg/category/mongo"
+	sqlcategory "github.com/rgynn/klottr/pkg/category/sql"
+
+	memoryrevision "github.com/rgynn/klottr/pkg/revision/memory"
+	mongorevision "github.com/rgynn/klottr/pkg/revision/mongo"
+	sqlrevision "github.com/rgynn/klottr/pkg/revision/sql"
+
+	memoryban "github.com/rgynn/klottr/pkg/ban/memory"
+	mongoban "github.com/rgynn/klottr/pkg/ban/mongo"
+	sqlban "github.com/rgynn/klottr/pkg/ban/sql"
+
+	memoryreport "github.com/rgynn/klottr/pkg/report/memory"
+	mongoreport "github.com/rgynn/klottr/pkg/report/mongo"
+	sqlreport "github.com/rgynn/klottr/pkg/report/sql"
+
+	memorytoken "github.com/rgynn/klottr/pkg/token/memory"
+	mongotoken "github.com/rgynn/klottr/pkg/token/mongo"
+	sqltoken "github.com/rgynn/klottr/pkg/token/sql"
+
+	memorytx "github.com/rgynn/klottr/pkg/tx/memory"
+	mongotx "github.com/rgynn/klottr/pkg/tx/mongo"
+	sqltx "github.com/rgynn/klottr/pkg/tx/sql"
+)
+
+// Database backing the repositories of the service
+type Database interface {
+	Ping(ctx context.Context) error
+	Close(ctx context.Context) error
+	// SetupThreads prepares the storage and indexes for threads in a category, it is called again on every startup
+	SetupThreads(ctx context.Context, m *category.Model) error
+	// Threads returns the thread repository for a category
+	Threads(m *category.Model) (thread.Repository, error)
+}
+
+type mongoDatabase struct {
+	cfg    *config.Config
+	client *mongo.Client
+}
+
+func (db *mongoDatabase) Ping(ctx context.Context) error {
+	return db.client.Ping(ctx, nil)
+}
+
+func (db *mongoDatabase) Close(ctx context.Context) error {
+	return db.client.Disconnect(ctx)
+}
+
+func (db *mongoDatabase) SetupThreads(ctx context.Context, m *category.Model) error {
+	_, err := mongothread.CreateIndexes(ctx, db.cfg, db.client, *m.Name, m.TTL(db.cfg.PostTTLSeconds))
+	return err
+}
+
+func (db *mongoDatabase) Threads(m *category.Model) (thread.Repository, error) {
+	return mongothread.NewRepository(db.cfg, db.client, *m.Name)
+}
+
+type sqlDatabase struct {
+	cfg *config.Config
+	db  *sqldb.DB
+}
+
+func (db *sqlDatabase) Ping(ctx context.Context) error {
+	return db.db.Ping(ctx)
+}
+
+func (db *sqlDatabase) Close(ctx context.Context) error {
+	return db.db.Close()
+}
+
+func (db *sqlDatabase) SetupThreads(ctx context.Context, m *category.Model) error {
+	return nil
+}
+
+func (db *sqlDatabase) Threads(m *category.Model) (thread.Repository, error) {
+	db.db.ExpireAfter("threads", time.Duration(m.TTL(db.cfg.PostTTLSeconds))*time.Second, "category = ?", *m.Name)
+	return sqlthread.NewRepository(db.cfg, db.db, *m.Name)
+}
+
+type memoryDatabase struct {
+	cfg *config.Config
+}
+
+func (db *memoryDatabase) Ping(ctx context.Context) error {
+	return nil
+}
+
+func (db *memoryDatabase) Close(ctx context.Context) error {
+	return nil
+}
+
+func (db *memoryDatabase) SetupThreads(ctx context.Context, m *category.Model) error {
+	return nil
+}
+
+func (db *memoryDatabase) Threads(m *category.Model) (thread.Repository, error) {
+	return memorythread.NewRepository(db.cfg, *m.Name, m.TTL(db.cfg.PostTTLSeconds))
+}
+
+func (svc *Service) setupDatabase() error {
+	switch svc.cfg.DatabaseDriver {
+	case "mongo":
+		return svc.setupMongoDB()
+	case sqldb.DriverSQLite, sqldb.DriverPostgres:
+		return svc.setupSQL()
+	case "memory":
+		return svc.setupMemory()
+	default:
+		return fmt.Errorf("unsupported database driver: %s", svc.cfg.DatabaseDriver)
+	}
+}
+
+func (svc *Service) setupMongoDB() error {
+
+	mongodb, err := setupMongoDBConnection(svc.cfg)
+	if err != nil {
+		return fmt.Errorf("failed to setup connection to mongodb: %w", err)
+	}
+
+	svc.db = &mongoDatabase{cfg: svc.cfg, client: mongodb}
+
+	if svc.users, err = mongouser.NewRepository(svc.cfg, mongodb); err != nil {
+		return fmt.Errorf("failed to initialize users repository: %w", err)
+	}
+
+	if svc.categories, err = mongocategory.NewRepository(svc.cfg, mongodb); err != nil {
+		return fmt.Errorf("failed to initialize categories repository: %w", err)
+	}
+
+	if svc.comments, err = mongocomments.NewRepository(svc.cfg, mongodb); err != nil {
+		return fmt.Errorf("failed to initialize comments repository: %w", err)
+	}
+
+	if svc.revisions, err = mongorevision.NewRepository(svc.cfg, mongodb); err != nil {
+		return fmt.Errorf("failed to initialize revisions repository: %w", err)
+	}
+
+	if svc.bans, err = mongoban.NewRepository(svc.cfg, mongodb); err != nil {
+		return fmt.Errorf("failed to initialize bans repository: %w", err)
+	}
+
+	if svc.reports, err = mongoreport.NewRepository(svc.cfg, mongodb); err != nil {
+		return fmt.Errorf("failed to initialize reports repository: %w", err)
+	}
+
+	if svc.tokens, err = mongotoken.NewRepository(svc.cfg, mongodb); err != nil {
+		return fmt.Errorf("failed to initialize tokens repository: %w", err)
+	}
+
+	if svc.tx, err = mongotx.NewTransactor(svc.cfg, mongodb); err != nil {
+		return fmt.Errorf("failed to initialize transactor: %w", err)
+	}
+
+	return nil
+}
+
+func (svc *Service) setupSQL() error {
+
+	db, err := sqldb.Open(svc.cfg)
+	if err != nil {
+		return fmt.Errorf("failed to setup connection to %s: %w", svc.cfg.DatabaseDriver, err)
+	}
+
+	svc.db = &sqlDatabase{cfg: svc.cfg, db: db}
+
+	ctx, cancel := context.WithTimeout(context.Background(), svc.cfg.RequestTimeout)
+	defer cancel()
+
+	if err := db.Migrate(ctx); err != nil {
+		return fmt.Errorf("failed to migrate %s database: %w", svc.cfg.DatabaseDriver, err)
+	}
+
+	db.ExpireAfter("comments", time.Duration(svc.cfg.PostTTLSeconds)*time.Second, "")
+	db.ExpireAfter("revisions", time.Duration(svc.cfg.PostTTLSeconds)*time.Second, "")
+	db.StartExpiry(time.Minute)
+
+	if svc.users, err = sqluser.NewRepository(svc.cfg, db); err != nil {
+		return fmt.Errorf("failed to initialize users repository: %w", err)
+	}
+
+	if svc.categories, err = sqlcategory.NewRepository(svc.cfg, db); err != nil {
+		return fmt.Errorf("failed to initialize categories repository: %w", err)
+	}
+
+	if svc.comments, err = sqlcomments.NewRepository(svc.cfg, db); err != nil {
+		return fmt.Errorf("failed to initialize comments repository: %w", err)
+	}
+
+	if svc.revisions, err = sqlrevision.NewRepository(svc.cfg, db); err != nil {
+		return fmt.Errorf("failed to initialize revisions repository: %w", err)
+	}
+
+	if svc.bans, err = sqlban.NewRepository(svc.cfg, db); err != nil {
+		return fmt.Errorf("failed to initialize bans repository: %w", err)
+	}
+
+	if svc.reports, err = sqlreport.NewRepository(svc.cfg, db); err != nil {
+		return fmt.Errorf("failed to initialize reports repository: %w", err)
+	}
+
+	if svc.tokens, err = sqltoken.NewRepository(svc.cfg, db); err != nil {
+		return fmt.Errorf("failed to initialize tokens repository: %w", err)
+	}
+
+	if svc.tx, err = sqltx.NewTransactor(svc.cfg, db); err != nil {
+		return fmt.Errorf("failed to initialize transactor: %w", err)
+	}
+
+	return nil
+}
+
+func (svc *Service) setupMemory() error {
+
+	var err error
+
+	svc.db = &memoryDatabase{cfg: svc.cfg}
+
+	if svc.users, err = memoryuser.NewRepository(svc.cfg); err != nil {
+		return fmt.Errorf("failed to initialize users repository: %w", err)
+	}
+
+	if svc.categories, err = memorycategory.NewRepository(svc.cfg); err != nil {
+		return fmt.Errorf("failed to initialize categories repository: %w", err)
+	}
+
+	if svc.comments, err = memorycomments.NewRepository(svc.cfg); err != nil {
+		return fmt.Errorf("failed to initialize comments repository: %w", err)
+	}
+
+	if svc.revisions, err = memoryrevision.NewRepository(svc.cfg); err != nil {
+		return fmt.Errorf("failed to initialize revisions repository: %w", err)
+	}
+
+	if svc.bans, err = memoryban.NewRepository(svc.cfg); err != nil {
+		return fmt.Errorf("failed to initialize bans repository: %w", err)
+	}
+
+	if svc.reports, err = memoryreport.NewRepository(svc.cfg); err != nil {
+		return fmt.Errorf("failed to initialize reports repository: %w", err)
+	}
+
+	if svc.tokens, err = memorytoken.NewRepository(svc.cfg); err != nil {
+		return fmt.Errorf("failed to initialize tokens repository: %w", err)
+	}
+
+	if svc.tx, err = memorytx.NewTransactor(svc.cfg); err != nil {
+		return fmt.Errorf("failed to initialize transactor: %w", err)
+	}
+
+	return nil
+}
+
+func setupMongoDBConnection(cfg *config.Config) (*mongo.Client, error) {
+
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.RequestTimeout)
+	defer cancel()
+
+	mongodb, err := mongo.Connect(ctx, options.Client().ApplyURI(cfg.DatabaseURL))
+	if err != nil {
+		return nil, err
+	}
+
+	if err := mongodb.Ping(ctx, nil); err != nil {
+		return nil, err
+	}
+
+	return mongodb, nil
+}
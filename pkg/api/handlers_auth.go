@@ -1,11 +1,15 @@
 package api
 
 import (
+	"context"
 	"errors"
+	"fmt"
 	"net/http"
+	"sync"
 	"time"
 
-	"github.com/golang-jwt/jwt"
+	"github.com/rgynn/klottr/pkg/helper"
+	"github.com/rgynn/klottr/pkg/token"
 	"github.com/rgynn/klottr/pkg/user"
 	"github.com/rgynn/ptrconv"
 )
@@ -32,6 +36,8 @@ func (input *LoginInput) Valid() error {
 	return nil
 }
 
+// SignInHandler responds with the tokens of a new session, or with a challenge token when the
+// user has two factor authentication enabled
 func (svc *Service) SignInHandler(w http.ResponseWriter, r *http.Request) {
 
 	m := new(LoginInput)
@@ -47,40 +53,209 @@ func (svc *Service) SignInHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	u, err := svc.users.GetByUsername(ctx, m.Username)
+	keys := svc.signInKeys(r, *m.Username)
+
+	// the attempt counts as failed until the password matches
+	locked, err := svc.attemptLockout(ctx, keys)
 	if err != nil {
 		NewErrorResponse(w, r, http.StatusInternalServerError, err)
 		return
 	}
 
+	if locked > 0 {
+		w.Header().Set("Retry-After", ceilSeconds(locked))
+		NewErrorResponse(w, r, http.StatusTooManyRequests, ErrLockedOut)
+		return
+	}
+
+	u, err := svc.users.GetByUsername(ctx, m.Username)
+	if err != nil {
+		switch err {
+		case user.ErrNotFound:
+			// unknown usernames count against the client ip like wrong passwords do
+			if err := svc.failLockout(ctx, keys); err != nil {
+				NewErrorResponse(w, r, http.StatusInternalServerError, err)
+				return
+			}
+			NewErrorResponse(w, r, http.StatusUnauthorized, err)
+		default:
+			NewErrorResponse(w, r, http.StatusInternalServerError, err)
+		}
+		return
+	}
+
 	if u.IsDeactivated() {
 		NewErrorResponse(w, r, http.StatusUnauthorized, user.ErrDeactivated)
 		return
 	}
 
 	if err := u.ValidPassword(m.Password); err != nil {
+		if err := svc.failLockout(ctx, keys); err != nil {
+			NewErrorResponse(w, r, http.StatusInternalServerError, err)
+			return
+		}
 		NewErrorResponse(w, r, http.StatusUnauthorized, err)
 		return
 	}
 
-	claims := &JWTClaims{
-		Username:  u.Username,
-		UserID:    ptrconv.StringPtr(u.ID.String()),
-		Validated: u.Validated,
-		Counters:  u.Counters,
-		Role:      u.Role,
-		StandardClaims: jwt.StandardClaims{
-			ExpiresAt: time.Now().Add(time.Hour * 72).Unix(),
-		},
+	// with two factor authentication the sign in only succeeds once the code is verified, the
+	// failed attempts of the username are forgotten then
+	if u.TOTPEnabled != nil {
+		err = svc.releaseLockout(ctx, keys)
+	} else {
+		err = svc.resetLockout(ctx, keys)
+	}
+	if err != nil {
+		NewErrorResponse(w, r, http.StatusInternalServerError, err)
+		return
+	}
+
+	svc.signIn(w, r, u)
+}
+
+// signIn responds with the tokens of a new session for u, or with a challenge token when u has
+// two factor authentication enabled, users banned site wide are refused
+func (svc *Service) signIn(w http.ResponseWriter, r *http.Request, u *user.Model) {
+
+	ctx := r.Context()
+
+	bn, err := svc.siteBan(ctx, u.Username)
+	if err != nil {
+		NewErrorResponse(w, r, http.StatusInternalServerError, err)
+		return
+	}
+
+	if bn != nil {
+		NewErrorResponse(w, r, http.StatusForbidden, bn.Err())
+		return
+	}
+
+	if u.TOTPEnabled != nil {
+		challenge, err := svc.challenge(ctx, u)
+		if err != nil {
+			NewErrorResponse(w, r, http.StatusInternalServerError, err)
+			return
+		}
+		if err := svc.MarshalJSONResponse(w, http.StatusAccepted, challenge); err != nil {
+			NewErrorResponse(w, r, http.StatusInternalServerError, err)
+			return
+		}
+		return
+	}
+
+	tokens, err := svc.issueTokens(ctx, u, "")
+	if err != nil {
+		NewErrorResponse(w, r, http.StatusInternalServerError, err)
+		return
 	}
 
-	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte(svc.cfg.JWTSecret))
+	if err := svc.MarshalJSONResponse(w, http.StatusOK, tokens); err != nil {
+		NewErrorResponse(w, r, http.StatusInternalServerError, err)
+		return
+	}
+}
+
+type RefreshInput struct {
+	RefreshToken *string `json:"refresh_token,omitempty"`
+}
+
+// RefreshHandler exchanges a refresh token for a new access and refresh token, each refresh
+// token is good for one exchange and presenting it again revokes every token of its session
+func (svc *Service) RefreshHandler(w http.ResponseWriter, r *http.Request) {
+
+	m := new(RefreshInput)
+	ctx := r.Context()
+
+	if err := svc.UnmarshalJSONRequest(w, r, &m); err != nil {
+		NewErrorResponse(w, r, http.StatusBadRequest, err)
+		return
+	}
+
+	if m.RefreshToken == nil || *m.RefreshToken == "" {
+		NewErrorResponse(w, r, http.StatusBadRequest, errors.New("no refresh_token provided"))
+		return
+	}
+
+	now := time.Now().UTC()
+
+	rt, err := svc.tokens.UseRefresh(ctx, token.Hash(*m.RefreshToken), now)
 	if err != nil {
+		switch err {
+		case token.ErrNotFound:
+			NewErrorResponse(w, r, http.StatusUnauthorized, err)
+		case token.ErrReused:
+			if err := svc.tokens.RevokeFamily(ctx, rt.Family, now); err != nil {
+				NewErrorResponse(w, r, http.StatusInternalServerError, err)
+				return
+			}
+			NewErrorResponse(w, r, http.StatusUnauthorized, err)
+		default:
+			NewErrorResponse(w, r, http.StatusInternalServerError, err)
+		}
+		return
+	}
+
+	if err := rt.Valid(now); err != nil {
 		NewErrorResponse(w, r, http.StatusUnauthorized, err)
 		return
 	}
 
-	if err := svc.MarshalJSONResponse(w, http.StatusOK, map[string]string{"token": token}); err != nil {
+	u, err := svc.users.GetByUsername(ctx, rt.Username)
+	if err != nil {
+		switch err {
+		case user.ErrNotFound:
+			NewErrorResponse(w, r, http.StatusUnauthorized, err)
+		default:
+			NewErrorResponse(w, r, http.StatusInternalServerError, err)
+		}
+		return
+	}
+
+	if u.IsDeactivated() {
+		NewErrorResponse(w, r, http.StatusUnauthorized, user.ErrDeactivated)
+		return
+	}
+
+	bn, err := svc.siteBan(ctx, u.Username)
+	if err != nil {
+		NewErrorResponse(w, r, http.StatusInternalServerError, err)
+		return
+	}
+
+	if bn != nil {
+		NewErrorResponse(w, r, http.StatusForbidden, bn.Err())
+		return
+	}
+
+	tokens, err := svc.issueTokens(ctx, u, rt.Family)
+	if err != nil {
+		NewErrorResponse(w, r, http.StatusInternalServerError, err)
+		return
+	}
+
+	if err := svc.MarshalJSONResponse(w, http.StatusOK, tokens); err != nil {
+		NewErrorResponse(w, r, http.StatusInternalServerError, err)
+		return
+	}
+}
+
+// SignOutHandler revokes the access token of the request and the refresh tokens of its session
+func (svc *Service) SignOutHandler(w http.ResponseWriter, r *http.Request) {
+
+	ctx := r.Context()
+
+	claims, err := ClaimsFromContext(ctx)
+	if err != nil {
+		NewErrorResponse(w, r, http.StatusUnauthorized, err)
+		return
+	}
+
+	if err := svc.revokeSession(ctx, claims); err != nil {
+		NewErrorResponse(w, r, http.StatusInternalServerError, err)
+		return
+	}
+
+	if err := svc.NoContentResponse(w, http.StatusAccepted); err != nil {
 		NewErrorResponse(w, r, http.StatusInternalServerError, err)
 		return
 	}
@@ -91,12 +266,20 @@ func (svc *Service) SignUpHandler(w http.ResponseWriter, r *http.Request) {
 	m := new(user.Model)
 	ctx := r.Context()
 
+	logger, err := LoggerFromContext(ctx)
+	if err != nil {
+		NewErrorResponse(w, r, http.StatusInternalServerError, err)
+		return
+	}
+
 	if err := svc.UnmarshalJSONRequest(w, r, &m); err != nil {
 		NewErrorResponse(w, r, http.StatusBadRequest, err)
 		return
 	}
 
 	m.Role = ptrconv.StringPtr("user")
+	m.Validated = false
+	m.Identities = nil
 	m.Created = ptrconv.TimePtr(time.Now().UTC())
 
 	if err := m.HashPassword(); err != nil {
@@ -104,8 +287,8 @@ func (svc *Service) SignUpHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if err := m.HashEmail(); err != nil {
-		NewErrorResponse(w, r, http.StatusBadRequest, err)
+	if status, err := svc.storeEmail(m); err != nil {
+		NewErrorResponse(w, r, status, err)
 		return
 	}
 
@@ -124,6 +307,11 @@ func (svc *Service) SignUpHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// the account exists either way, a failed mail can be sent again with /auth/verify/resend
+	if err := svc.sendVerification(ctx, m); err != nil {
+		logger.Errorf("failed to send verification mail: %s", err.Error())
+	}
+
 	if err := svc.NoContentResponse(w, http.StatusCreated); err != nil {
 		NewErrorResponse(w, r, http.StatusInternalServerError, err)
 		return
@@ -140,7 +328,109 @@ func (svc *Service) DeactivateHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if err := svc.users.Deactivate(ctx, claims.Username, claims.Role); err != nil {
+	now := time.Now().UTC()
+
+	err = svc.tx.Do(ctx, func(ctx context.Context) error {
+
+		if err := svc.users.Deactivate(ctx, claims.Username, claims.Role); err != nil {
+			return fmt.Errorf("failed to deactivate user: %w", err)
+		}
+
+		if err := svc.tokens.RevokeUser(ctx, claims.Username, now); err != nil {
+			return fmt.Errorf("failed to revoke refresh tokens: %w", err)
+		}
+
+		if err := svc.tokens.DeleteUserPersonal(ctx, claims.Username); err != nil {
+			return fmt.Errorf("failed to delete personal access tokens: %w", err)
+		}
+
+		return nil
+	})
+	if err != nil {
+		NewErrorResponse(w, r, http.StatusInternalServerError, err)
+		return
+	}
+
+	if err := svc.denyUser(ctx, claims.Username, now); err != nil {
+		NewErrorResponse(w, r, http.StatusInternalServerError, err)
+		return
+	}
+
+	if err := svc.NoContentResponse(w, http.StatusAccepted); err != nil {
+		NewErrorResponse(w, r, http.StatusInternalServerError, err)
+		return
+	}
+}
+
+// VerifyHandler validates the email address of the user the verification link was mailed to
+func (svc *Service) VerifyHandler(w http.ResponseWriter, r *http.Request) {
+
+	ctx := r.Context()
+
+	s := r.URL.Query().Get("token")
+	if s == "" {
+		NewErrorResponse(w, r, http.StatusBadRequest, errors.New("no token provided"))
+		return
+	}
+
+	t, err := svc.tokens.UseTicket(ctx, token.Hash(s), token.PurposeVerify, time.Now().UTC())
+	if err != nil {
+		switch err {
+		case token.ErrNotFound:
+			NewErrorResponse(w, r, http.StatusNotFound, errors.New("verification link invalid, used or expired"))
+		default:
+			NewErrorResponse(w, r, http.StatusInternalServerError, err)
+		}
+		return
+	}
+
+	if err := svc.users.SetValidated(ctx, t.Username); err != nil {
+		switch err {
+		case user.ErrNotFound:
+			NewErrorResponse(w, r, http.StatusNotFound, err)
+		default:
+			NewErrorResponse(w, r, http.StatusInternalServerError, err)
+		}
+		return
+	}
+
+	if err := svc.MarshalJSONResponse(w, http.StatusOK, map[string]interface{}{
+		"username":  t.Username,
+		"validated": true,
+	}); err != nil {
+		NewErrorResponse(w, r, http.StatusInternalServerError, err)
+		return
+	}
+}
+
+// ResendVerificationHandler mails the signed in user a new verification link
+func (svc *Service) ResendVerificationHandler(w http.ResponseWriter, r *http.Request) {
+
+	ctx := r.Context()
+
+	claims, err := ClaimsFromContext(ctx)
+	if err != nil {
+		NewErrorResponse(w, r, http.StatusUnauthorized, err)
+		return
+	}
+
+	u, err := svc.users.GetByUsername(ctx, claims.Username)
+	if err != nil {
+		NewErrorResponse(w, r, http.StatusInternalServerError, err)
+		return
+	}
+
+	if u.Validated {
+		NewErrorResponse(w, r, http.StatusConflict, errors.New("email address already validated"))
+		return
+	}
+
+	if svc.mailer == nil || u.EmailEncrypted == nil {
+		NewErrorResponse(w, r, http.StatusBadRequest, errors.New("no email address to send a verification link to"))
+		return
+	}
+
+	if err := svc.sendVerification(ctx, u); err != nil {
 		NewErrorResponse(w, r, http.StatusInternalServerError, err)
 		return
 	}
@@ -150,3 +440,183 @@ func (svc *Service) DeactivateHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 }
+
+type ForgotPasswordInput struct {
+	Username *string `json:"username,omitempty"`
+	Email    *string `json:"email,omitempty"`
+}
+
+var (
+	dummyOnce sync.Once
+	dummyUser *user.Model
+)
+
+// dummy returns a user whose email hash never matches, compared against when there is no
+// user to compare with so every forgot password request costs the same bcrypt comparison
+func dummy() *user.Model {
+	dummyOnce.Do(func() {
+		u := &user.Model{Email: ptrconv.StringPtr(helper.RandomString(32))}
+		if err := u.HashEmail(); err != nil {
+			panic(err)
+		}
+		dummyUser = u
+	})
+	return dummyUser
+}
+
+// ForgotPasswordHandler mails a password reset link to the user when username and email match,
+// the response is the same whether they do or not so it cannot be used to find accounts
+func (svc *Service) ForgotPasswordHandler(w http.ResponseWriter, r *http.Request) {
+
+	m := new(ForgotPasswordInput)
+	ctx := r.Context()
+
+	if err := svc.UnmarshalJSONRequest(w, r, &m); err != nil {
+		NewErrorResponse(w, r, http.StatusBadRequest, err)
+		return
+	}
+
+	if m.Username == nil || m.Email == nil {
+		NewErrorResponse(w, r, http.StatusBadRequest, errors.New("no username and email provided"))
+		return
+	}
+
+	email := user.CleanEmail(*m.Email)
+
+	u, err := svc.users.GetByUsername(ctx, m.Username)
+	if err != nil && err != user.ErrNotFound {
+		NewErrorResponse(w, r, http.StatusInternalServerError, err)
+		return
+	}
+
+	match := u != nil && u.EmailHash != nil && !u.IsDeactivated()
+	if !match {
+		u = dummy()
+	}
+
+	if err := u.ValidEmail(&email); err != nil {
+		match = false
+	}
+
+	if match {
+		svc.startTask("send password reset", func(ctx context.Context) error {
+			return svc.sendReset(ctx, u)
+		})
+	}
+
+	if err := svc.NoContentResponse(w, http.StatusAccepted); err != nil {
+		NewErrorResponse(w, r, http.StatusInternalServerError, err)
+		return
+	}
+}
+
+type ResetPasswordInput struct {
+	Token    *string `json:"token,omitempty"`
+	Password *string `json:"password,omitempty"`
+}
+
+// ResetPasswordHandler sets a new password with the token of a mailed reset link, signing the
+// user out of every session and revoking their access tokens and personal access tokens
+func (svc *Service) ResetPasswordHandler(w http.ResponseWriter, r *http.Request) {
+
+	m := new(ResetPasswordInput)
+	ctx := r.Context()
+
+	if err := svc.UnmarshalJSONRequest(w, r, &m); err != nil {
+		NewErrorResponse(w, r, http.StatusBadRequest, err)
+		return
+	}
+
+	if m.Token == nil || *m.Token == "" {
+		NewErrorResponse(w, r, http.StatusBadRequest, errors.New("no token provided"))
+		return
+	}
+
+	if m.Password == nil || *m.Password == "" {
+		NewErrorResponse(w, r, http.StatusBadRequest, errors.New("no password provided"))
+		return
+	}
+
+	// hashed up front so the transaction is not held open for the bcrypt rounds
+	pw := &user.Model{Password: m.Password}
+	if err := pw.HashPassword(); err != nil {
+		NewErrorResponse(w, r, http.StatusBadRequest, err)
+		return
+	}
+
+	now := time.Now().UTC()
+
+	var username *string
+
+	err := svc.tx.Do(ctx, func(ctx context.Context) error {
+
+		t, err := svc.tokens.UseTicket(ctx, token.Hash(*m.Token), token.PurposeReset, now)
+		if err != nil {
+			return err
+		}
+
+		u, err := svc.users.GetByUsername(ctx, t.Username)
+		if err != nil {
+			return err
+		}
+
+		if u.IsDeactivated() {
+			return user.ErrDeactivated
+		}
+
+		if err := svc.users.SetPassword(ctx, u.Username, pw.PasswordHash); err != nil {
+			return fmt.Errorf("failed to set password: %w", err)
+		}
+
+		if err := svc.tokens.RevokeUser(ctx, u.Username, now); err != nil {
+			return fmt.Errorf("failed to revoke refresh tokens: %w", err)
+		}
+
+		if err := svc.tokens.DeleteUserPersonal(ctx, u.Username); err != nil {
+			return fmt.Errorf("failed to delete personal access tokens: %w", err)
+		}
+
+		if err := svc.tokens.RevokeTickets(ctx, u.Username, token.PurposeReset, now); err != nil {
+			return fmt.Errorf("failed to revoke reset tickets: %w", err)
+		}
+
+		username = u.Username
+
+		return nil
+	})
+	if err != nil {
+		switch err {
+		case token.ErrNotFound:
+			NewErrorResponse(w, r, http.StatusNotFound, errors.New("reset link invalid, used or expired"))
+		case user.ErrNotFound:
+			NewErrorResponse(w, r, http.StatusNotFound, err)
+		case user.ErrDeactivated:
+			NewErrorResponse(w, r, http.StatusUnauthorized, err)
+		default:
+			NewErrorResponse(w, r, http.StatusInternalServerError, err)
+		}
+		return
+	}
+
+	if err := svc.denyUser(ctx, username, now); err != nil {
+		NewErrorResponse(w, r, http.StatusInternalServerError, err)
+		return
+	}
+
+	if err := svc.NoContentResponse(w, http.StatusAccepted); err != nil {
+		NewErrorResponse(w, r, http.StatusInternalServerError, err)
+		return
+	}
+}
+
+// JWKSHandler lists the public keys verifying the jwts signed by the service, so other
+// services can verify them without the secret
+func (svc *Service) JWKSHandler(w http.ResponseWriter, r *http.Request) {
+
+	w.Header().Set("Cache-Control", "public, max-age=300")
+
+	if err := svc.MarshalJSONResponse(w, http.StatusOK, svc.signingKeys().JWKS()); err != nil {
+		NewErrorResponse(w, r, http.StatusInternalServerError, err)
+		return
+	}
+}
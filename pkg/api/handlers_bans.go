@@ -0,0 +1,159 @@
+package api
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/rgynn/klottr/pkg/ban"
+	"github.com/rgynn/klottr/pkg/thread"
+)
+
+func (svc *Service) ListBansHandler(w http.ResponseWriter, r *http.Request) {
+
+	category := mux.Vars(r)["category"]
+
+	if _, _, err := svc.threadCategory(r.Context(), category); err != nil {
+		switch err {
+		case thread.ErrCategoryNotFound:
+			NewErrorResponse(w, r, http.StatusNotFound, err)
+		default:
+			NewErrorResponse(w, r, http.StatusInternalServerError, err)
+		}
+		return
+	}
+
+	svc.listBans(w, r, &category)
+}
+
+// CreateBanHandler bans a user from writing in a category, admins and moderators of the
+// category cannot be banned from it
+func (svc *Service) CreateBanHandler(w http.ResponseWriter, r *http.Request) {
+
+	category := mux.Vars(r)["category"]
+
+	if _, _, err := svc.threadCategory(r.Context(), category); err != nil {
+		switch err {
+		case thread.ErrCategoryNotFound:
+			NewErrorResponse(w, r, http.StatusNotFound, err)
+		default:
+			NewErrorResponse(w, r, http.StatusInternalServerError, err)
+		}
+		return
+	}
+
+	svc.createBan(w, r, &category)
+}
+
+func (svc *Service) DeleteBanHandler(w http.ResponseWriter, r *http.Request) {
+	category := mux.Vars(r)["category"]
+	svc.deleteBan(w, r, &category)
+}
+
+func (svc *Service) ListSiteBansHandler(w http.ResponseWriter, r *http.Request) {
+	svc.listBans(w, r, nil)
+}
+
+// CreateSiteBanHandler bans a user from signing in and from every write until the ban expires
+// or is lifted, jwts issued before the ban stop working for writes right away
+func (svc *Service) CreateSiteBanHandler(w http.ResponseWriter, r *http.Request) {
+	svc.createBan(w, r, nil)
+}
+
+func (svc *Service) DeleteSiteBanHandler(w http.ResponseWriter, r *http.Request) {
+	svc.deleteBan(w, r, nil)
+}
+
+// listBans responds with the active bans in category, or the site wide bans when category is nil
+func (svc *Service) listBans(w http.ResponseWriter, r *http.Request, category *string) {
+
+	ctx := r.Context()
+
+	page, err := svc.PaginationFromRequest(w, r)
+	if err != nil {
+		NewErrorResponse(w, r, http.StatusBadRequest, err)
+		return
+	}
+
+	list, err := svc.bans.List(ctx, category, time.Now().UTC(), page.After, page.From, page.Size)
+	if err != nil {
+		NewErrorResponse(w, r, http.StatusInternalServerError, err)
+		return
+	}
+
+	result, err := svc.NewListResponse(list, ban.NextCursor(list, page.Size))
+	if err != nil {
+		NewErrorResponse(w, r, http.StatusInternalServerError, err)
+		return
+	}
+
+	if err := svc.MarshalJSONResponse(w, http.StatusOK, result); err != nil {
+		NewErrorResponse(w, r, http.StatusInternalServerError, err)
+		return
+	}
+}
+
+// createBan bans the user of the request in category, or site wide when category is nil
+func (svc *Service) createBan(w http.ResponseWriter, r *http.Request, category *string) {
+
+	ctx := r.Context()
+
+	m := new(ban.Model)
+
+	claims, err := ClaimsFromContext(ctx)
+	if err != nil {
+		NewErrorResponse(w, r, http.StatusUnauthorized, err)
+		return
+	}
+
+	if err := svc.UnmarshalJSONRequest(w, r, &m); err != nil {
+		NewErrorResponse(w, r, http.StatusBadRequest, err)
+		return
+	}
+
+	m.ID = nil
+	m.Category = category
+	m.CreatedBy = claims.Username
+	m.Created = time.Now().UTC()
+
+	if err := m.ValidForSave(); err != nil {
+		NewErrorResponse(w, r, http.StatusBadRequest, err)
+		return
+	}
+
+	if status, err := svc.bannable(ctx, m.Username, category); err != nil {
+		NewErrorResponse(w, r, status, err)
+		return
+	}
+
+	if err := svc.bans.Create(ctx, m); err != nil {
+		NewErrorResponse(w, r, http.StatusInternalServerError, err)
+		return
+	}
+
+	if err := svc.MarshalJSONResponse(w, http.StatusCreated, m); err != nil {
+		NewErrorResponse(w, r, http.StatusInternalServerError, err)
+		return
+	}
+}
+
+// deleteBan lifts the bans of the user of the route in category, or site wide when category is nil
+func (svc *Service) deleteBan(w http.ResponseWriter, r *http.Request, category *string) {
+
+	username := mux.Vars(r)["username"]
+
+	if err := svc.bans.Delete(r.Context(), &username, category); err != nil {
+		switch err {
+		case ban.ErrNotFound:
+			NewErrorResponse(w, r, http.StatusNotFound, err)
+		default:
+			NewErrorResponse(w, r, http.StatusInternalServerError, err)
+		}
+		return
+	}
+
+	if err := svc.NoContentResponse(w, http.StatusAccepted); err != nil {
+		NewErrorResponse(w, r, http.StatusInternalServerError, err)
+		return
+	}
+}
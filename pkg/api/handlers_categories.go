@@ -0,0 +1,104 @@
+package api
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/rgynn/klottr/pkg/category"
+	"github.com/rgynn/ptrconv"
+)
+
+func (svc *Service) ListCategoriesHandler(w http.ResponseWriter, r *http.Request) {
+
+	ctx := r.Context()
+
+	result, err := svc.categories.List(ctx, false)
+	if err != nil {
+		NewErrorResponse(w, r, http.StatusInternalServerError, err)
+		return
+	}
+
+	if err := svc.MarshalJSONResponse(w, http.StatusOK, result); err != nil {
+		NewErrorResponse(w, r, http.StatusInternalServerError, err)
+		return
+	}
+}
+
+func (svc *Service) CreateCategoryHandler(w http.ResponseWriter, r *http.Request) {
+
+	m := new(category.Model)
+	ctx := r.Context()
+
+	logger, err := LoggerFromContext(ctx)
+	if err != nil {
+		NewErrorResponse(w, r, http.StatusInternalServerError, err)
+		return
+	}
+
+	if err := svc.UnmarshalJSONRequest(w, r, &m); err != nil {
+		NewErrorResponse(w, r, http.StatusBadRequest, err)
+		return
+	}
+
+	m.Created = ptrconv.TimePtr(time.Now().UTC())
+
+	if err := m.ValidForSave(); err != nil {
+		NewErrorResponse(w, r, http.StatusBadRequest, err)
+		return
+	}
+
+	if err := svc.createCategory(ctx, m); err != nil {
+		switch err {
+		case category.ErrAlreadyExists:
+			NewErrorResponse(w, r, http.StatusConflict, err)
+		default:
+			logger.Errorf("Failed to create category %s: %s", *m.Name, err.Error())
+			NewErrorResponse(w, r, http.StatusInternalServerError, err)
+		}
+		return
+	}
+
+	result, err := svc.categories.Get(ctx, m.Name)
+	if err != nil {
+		NewErrorResponse(w, r, http.StatusInternalServerError, err)
+		return
+	}
+
+	if err := svc.MarshalJSONResponse(w, http.StatusCreated, result); err != nil {
+		NewErrorResponse(w, r, http.StatusInternalServerError, err)
+		return
+	}
+}
+
+func (svc *Service) ArchiveCategoryHandler(w http.ResponseWriter, r *http.Request) {
+
+	name := mux.Vars(r)["category"]
+	ctx := r.Context()
+
+	if err := svc.categories.Archive(ctx, &name); err != nil {
+		switch err {
+		case category.ErrNotFound:
+			NewErrorResponse(w, r, http.StatusNotFound, err)
+		default:
+			NewErrorResponse(w, r, http.StatusInternalServerError, err)
+		}
+		return
+	}
+
+	m, err := svc.categories.Get(ctx, &name)
+	if err != nil {
+		NewErrorResponse(w, r, http.StatusInternalServerError, err)
+		return
+	}
+
+	if _, err := svc.registerCategory(ctx, m); err != nil {
+		NewErrorResponse(w, r, http.StatusInternalServerError, err)
+		return
+	}
+
+	if err := svc.NoContentResponse(w, http.StatusAccepted); err != nil {
+		NewErrorResponse(w, r, http.StatusInternalServerError, err)
+		return
+	}
+}
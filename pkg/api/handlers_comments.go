@@ -1,16 +1,24 @@
 package api
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"net/http"
 	"time"
 
 	"github.com/gorilla/mux"
+	"github.com/rgynn/klottr/pkg/ban"
+	categories "github.com/rgynn/klottr/pkg/category"
 	"github.com/rgynn/klottr/pkg/comment"
+	"github.com/rgynn/klottr/pkg/revision"
 	"github.com/rgynn/klottr/pkg/thread"
 	"github.com/rgynn/klottr/pkg/user"
 	"github.com/rgynn/ptrconv"
 )
 
+// CreateCommentHandler creates a top level comment, or a reply to the comment given by
+// comment_slug_id when routed below it
 func (svc *Service) CreateCommentHandler(w http.ResponseWriter, r *http.Request) {
 
 	m := new(comment.Model)
@@ -26,26 +34,59 @@ func (svc *Service) CreateCommentHandler(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
+	if err := svc.checkValidated(claims); err != nil {
+		NewErrorResponse(w, r, http.StatusForbidden, err)
+		return
+	}
+
 	logger, err := LoggerFromContext(ctx)
 	if err != nil {
 		NewErrorResponse(w, r, http.StatusInternalServerError, err)
 		return
 	}
 
-	var thrd *thread.Model
+	cat, threads, err := svc.threadCategory(ctx, category)
+	if err != nil {
+		switch err {
+		case thread.ErrCategoryNotFound:
+			NewErrorResponse(w, r, http.StatusNotFound, err)
+		default:
+			NewErrorResponse(w, r, http.StatusInternalServerError, err)
+		}
+		return
+	}
 
-	switch category {
-	case "misc":
-		thrd, err = svc.misc.Get(ctx, &slugID, &slugTitle)
-	default:
-		NewErrorResponse(w, r, http.StatusInternalServerError, thread.ErrCategoryNotFound)
+	if cat.IsArchived() {
+		NewErrorResponse(w, r, http.StatusForbidden, categories.ErrArchived)
 		return
 	}
+
+	if err := svc.checkBanned(ctx, claims.Username, category); err != nil {
+		switch err {
+		case ban.ErrBanned:
+			NewErrorResponse(w, r, http.StatusForbidden, err)
+		default:
+			NewErrorResponse(w, r, http.StatusInternalServerError, err)
+		}
+		return
+	}
+
+	thrd, err := threads.Get(ctx, &slugID, &slugTitle)
 	if err != nil {
 		NewErrorResponse(w, r, http.StatusInternalServerError, err)
 		return
 	}
 
+	if thrd.IsDeleted() {
+		NewErrorResponse(w, r, http.StatusGone, thread.ErrDeleted)
+		return
+	}
+
+	if thrd.Locked {
+		NewErrorResponse(w, r, http.StatusForbidden, thread.ErrLocked)
+		return
+	}
+
 	if err := svc.UnmarshalJSONRequest(w, r, &m); err != nil {
 		NewErrorResponse(w, r, http.StatusBadRequest, err)
 		return
@@ -54,6 +95,40 @@ func (svc *Service) CreateCommentHandler(w http.ResponseWriter, r *http.Request)
 	m.ThreadID = thrd.ID
 	m.Username = claims.Username
 	m.Created = *ptrconv.TimePtr(time.Now().UTC())
+	m.ReplyToID = nil
+	m.Depth = 0
+
+	if commentSlugID, ok := vars["comment_slug_id"]; ok {
+
+		parent, err := svc.comments.Get(ctx, &commentSlugID)
+		if err != nil {
+			switch err {
+			case comment.ErrNotFound:
+				NewErrorResponse(w, r, http.StatusNotFound, err)
+			default:
+				NewErrorResponse(w, r, http.StatusInternalServerError, err)
+			}
+			return
+		}
+
+		if parent.ThreadID == nil || *parent.ThreadID != *thrd.ID {
+			NewErrorResponse(w, r, http.StatusNotFound, comment.ErrNotFound)
+			return
+		}
+
+		if parent.IsDeleted() {
+			NewErrorResponse(w, r, http.StatusGone, comment.ErrDeleted)
+			return
+		}
+
+		if parent.Depth >= comment.MaxDepth {
+			NewErrorResponse(w, r, http.StatusBadRequest, comment.ErrMaxDepth)
+			return
+		}
+
+		m.ReplyToID = parent.ID
+		m.Depth = parent.Depth + 1
+	}
 
 	if err := m.GenerateSlugs(); err != nil {
 		NewErrorResponse(w, r, http.StatusInternalServerError, err)
@@ -65,35 +140,102 @@ func (svc *Service) CreateCommentHandler(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	if err := svc.comments.Create(ctx, m); err != nil {
-		logger.Warnf("failed to create user comment: %s", err.Error())
+	err = svc.tx.Do(ctx, func(ctx context.Context) error {
+
+		if err := svc.comments.Create(ctx, m); err != nil {
+			return fmt.Errorf("failed to create user comment: %w", err)
+		}
+
+		if err := threads.IncCounter(ctx, &slugID, &slugTitle, ptrconv.StringPtr("counters.comments"), 1); err != nil {
+			return fmt.Errorf("failed to increment %s thread num comments: %w", category, err)
+		}
+
+		if err := svc.users.IncCounter(ctx, claims.Username, ptrconv.StringPtr("counters.num.comments"), 1); err != nil {
+			return fmt.Errorf("failed to increment user num comments: %w", err)
+		}
+
+		return nil
+	})
+	if err != nil {
+		logger.Warn(err)
 		NewErrorResponse(w, r, http.StatusInternalServerError, err)
 		return
 	}
 
-	switch category {
-	case "misc":
-		err = svc.misc.IncCounter(ctx, &slugID, &slugTitle, ptrconv.StringPtr("counters.comments"), 1)
-	}
+	result, err := svc.comments.Get(ctx, m.SlugID)
 	if err != nil {
-		logger.Warnf("failed to increment %s thread num comments: %s", category, err.Error())
 		NewErrorResponse(w, r, http.StatusInternalServerError, err)
 		return
 	}
 
-	if err := svc.users.IncCounter(ctx, claims.Username, ptrconv.StringPtr("counters.num.comments"), 1); err != nil {
-		logger.Warnf("failed to increment user num comments: %s", err.Error())
+	if err := svc.MarshalJSONResponse(w, http.StatusCreated, result); err != nil {
 		NewErrorResponse(w, r, http.StatusInternalServerError, err)
 		return
 	}
+}
 
-	result, err := svc.comments.Get(ctx, m.SlugID)
+// ListCommentsHandler lists the comments of a thread as a tree sorted per level, or with
+// view=flat as a list oldest first with the parent id and depth of each comment
+func (svc *Service) ListCommentsHandler(w http.ResponseWriter, r *http.Request) {
+
+	vars := mux.Vars(r)
+	category := vars["category"]
+	slugID := vars["slug_id"]
+	slugTitle := vars["slug_title"]
+	query := r.URL.Query()
+	ctx := r.Context()
+
+	cq, err := svc.CommentsQueryFromRequest(w, r)
+	if err != nil {
+		NewErrorResponse(w, r, http.StatusBadRequest, err)
+		return
+	}
+
+	_, threads, err := svc.threadCategory(ctx, category)
+	if err != nil {
+		switch err {
+		case thread.ErrCategoryNotFound:
+			NewErrorResponse(w, r, http.StatusNotFound, err)
+		default:
+			NewErrorResponse(w, r, http.StatusInternalServerError, err)
+		}
+		return
+	}
+
+	thrd, err := threads.Get(ctx, &slugID, &slugTitle)
+	if err != nil {
+		NewErrorResponse(w, r, http.StatusNotFound, err)
+		return
+	}
+
+	if parentSlugID := query.Get("parent"); parentSlugID != "" && cq.Tree != nil {
+
+		parent, err := svc.comments.Get(ctx, &parentSlugID)
+		if err != nil {
+			switch err {
+			case comment.ErrNotFound:
+				NewErrorResponse(w, r, http.StatusNotFound, err)
+			default:
+				NewErrorResponse(w, r, http.StatusInternalServerError, err)
+			}
+			return
+		}
+
+		if parent.ThreadID == nil || *parent.ThreadID != *thrd.ID {
+			NewErrorResponse(w, r, http.StatusNotFound, comment.ErrNotFound)
+			return
+		}
+
+		cq.Tree.Parent = parent.ID
+	}
+
+	result, err := svc.listComments(ctx, thrd, cq)
 	if err != nil {
 		NewErrorResponse(w, r, http.StatusInternalServerError, err)
 		return
 	}
 
-	if err := svc.MarshalJSONResponse(w, http.StatusCreated, result); err != nil {
+	if err := svc.MarshalJSONResponse(w, http.StatusOK, result); err != nil {
 		NewErrorResponse(w, r, http.StatusInternalServerError, err)
 		return
 	}
@@ -108,20 +250,204 @@ func (svc *Service) GetCommentHandler(w http.ResponseWriter, r *http.Request) {
 	commentSlugID := vars["comment_slug_id"]
 	ctx := r.Context()
 
-	var err error
+	_, threads, err := svc.threadCategory(ctx, category)
+	if err != nil {
+		switch err {
+		case thread.ErrCategoryNotFound:
+			NewErrorResponse(w, r, http.StatusNotFound, err)
+		default:
+			NewErrorResponse(w, r, http.StatusInternalServerError, err)
+		}
+		return
+	}
+
+	thrd, err := threads.Get(ctx, &slugID, &slugTitle)
+	if err != nil {
+		switch err {
+		case thread.ErrNotFound:
+			NewErrorResponse(w, r, http.StatusNotFound, err)
+		default:
+			NewErrorResponse(w, r, http.StatusInternalServerError, err)
+		}
+		return
+	}
+
+	result, err := svc.comments.Get(ctx, &commentSlugID)
+	if err != nil {
+		switch err {
+		case comment.ErrNotFound:
+			NewErrorResponse(w, r, http.StatusNotFound, err)
+		default:
+			NewErrorResponse(w, r, http.StatusInternalServerError, err)
+		}
+		return
+	}
+
+	if result.ThreadID == nil || *result.ThreadID != *thrd.ID {
+		NewErrorResponse(w, r, http.StatusNotFound, comment.ErrNotFound)
+		return
+	}
+
+	if err := svc.MarshalJSONResponse(w, http.StatusOK, result.Redacted()); err != nil {
+		NewErrorResponse(w, r, http.StatusInternalServerError, err)
+		return
+	}
+}
+
+// EditCommentHandler replaces the content of a comment for its author within the edit
+// window, the previous version is kept as a revision
+func (svc *Service) EditCommentHandler(w http.ResponseWriter, r *http.Request) {
+
+	vars := mux.Vars(r)
+	category := vars["category"]
+	slugID := vars["slug_id"]
+	slugTitle := vars["slug_title"]
+	commentSlugID := vars["comment_slug_id"]
+	ctx := r.Context()
+
+	edit := new(comment.Model)
 
-	switch category {
-	case "misc":
-		_, err = svc.misc.Get(ctx, &slugID, &slugTitle)
-	default:
-		NewErrorResponse(w, r, http.StatusInternalServerError, thread.ErrCategoryNotFound)
+	claims, err := ClaimsFromContext(ctx)
+	if err != nil {
+		NewErrorResponse(w, r, http.StatusUnauthorized, err)
 		return
 	}
+
+	logger, err := LoggerFromContext(ctx)
 	if err != nil {
 		NewErrorResponse(w, r, http.StatusInternalServerError, err)
 		return
 	}
 
+	cat, threads, err := svc.threadCategory(ctx, category)
+	if err != nil {
+		switch err {
+		case thread.ErrCategoryNotFound:
+			NewErrorResponse(w, r, http.StatusNotFound, err)
+		default:
+			NewErrorResponse(w, r, http.StatusInternalServerError, err)
+		}
+		return
+	}
+
+	if cat.IsArchived() {
+		NewErrorResponse(w, r, http.StatusForbidden, categories.ErrArchived)
+		return
+	}
+
+	if err := svc.checkBanned(ctx, claims.Username, category); err != nil {
+		switch err {
+		case ban.ErrBanned:
+			NewErrorResponse(w, r, http.StatusForbidden, err)
+		default:
+			NewErrorResponse(w, r, http.StatusInternalServerError, err)
+		}
+		return
+	}
+
+	thrd, err := threads.Get(ctx, &slugID, &slugTitle)
+	if err != nil {
+		NewErrorResponse(w, r, http.StatusNotFound, err)
+		return
+	}
+
+	cmnt, err := svc.comments.Get(ctx, &commentSlugID)
+	if err != nil {
+		switch err {
+		case comment.ErrNotFound:
+			NewErrorResponse(w, r, http.StatusNotFound, err)
+		default:
+			NewErrorResponse(w, r, http.StatusInternalServerError, err)
+		}
+		return
+	}
+
+	if cmnt.ThreadID == nil || *cmnt.ThreadID != *thrd.ID {
+		NewErrorResponse(w, r, http.StatusNotFound, comment.ErrNotFound)
+		return
+	}
+
+	if thrd.IsDeleted() {
+		NewErrorResponse(w, r, http.StatusGone, thread.ErrDeleted)
+		return
+	}
+
+	if cmnt.IsDeleted() {
+		NewErrorResponse(w, r, http.StatusGone, comment.ErrDeleted)
+		return
+	}
+
+	if thrd.Locked {
+		NewErrorResponse(w, r, http.StatusForbidden, thread.ErrLocked)
+		return
+	}
+
+	if ptrconv.StringPtrString(cmnt.Username) != ptrconv.StringPtrString(claims.Username) {
+		NewErrorResponse(w, r, http.StatusForbidden, errors.New("only the author can edit a comment"))
+		return
+	}
+
+	now := time.Now().UTC()
+
+	if !revision.Editable(cmnt.Created, now, svc.cfg.EditWindow) {
+		NewErrorResponse(w, r, http.StatusForbidden, revision.ErrEditWindow)
+		return
+	}
+
+	if err := svc.UnmarshalJSONRequest(w, r, &edit); err != nil {
+		NewErrorResponse(w, r, http.StatusBadRequest, err)
+		return
+	}
+
+	m := *cmnt
+	m.Content = edit.Content
+	m.Updated = &now
+
+	if err := m.ValidForEdit(); err != nil {
+		NewErrorResponse(w, r, http.StatusBadRequest, err)
+		return
+	}
+
+	err = svc.tx.Do(ctx, func(ctx context.Context) error {
+
+		current, err := svc.comments.Get(ctx, &commentSlugID)
+		if err != nil {
+			return fmt.Errorf("failed to get comment: %w", err)
+		}
+
+		rev := &revision.Model{
+			ParentID: current.ID,
+			SlugType: ptrconv.StringPtr(revision.SlugTypeComments),
+			SlugID:   current.SlugID,
+			Content:  current.Content,
+			Created:  current.Created,
+			Replaced: now,
+		}
+
+		if current.Updated != nil {
+			rev.Created = *current.Updated
+		}
+
+		if err := rev.ValidForSave(); err != nil {
+			return err
+		}
+
+		if err := svc.revisions.Create(ctx, rev); err != nil {
+			return fmt.Errorf("failed to create comment revision: %w", err)
+		}
+
+		if err := svc.comments.Edit(ctx, &commentSlugID, &m); err != nil {
+			return fmt.Errorf("failed to edit comment: %w", err)
+		}
+
+		return nil
+	})
+	if err != nil {
+		logger.Warn(err)
+		NewErrorResponse(w, r, http.StatusInternalServerError, err)
+		return
+	}
+
 	result, err := svc.comments.Get(ctx, &commentSlugID)
 	if err != nil {
 		NewErrorResponse(w, r, http.StatusInternalServerError, err)
@@ -134,6 +460,8 @@ func (svc *Service) GetCommentHandler(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// DeleteCommentHandler marks a comment as deleted for its author or a moderator, it is shown as
+// a tombstone in the tree of the thread until it is purged
 func (svc *Service) DeleteCommentHandler(w http.ResponseWriter, r *http.Request) {
 
 	vars := mux.Vars(r)
@@ -155,37 +483,56 @@ func (svc *Service) DeleteCommentHandler(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	switch category {
-	case "misc":
-		_, err = svc.misc.Get(ctx, &slugID, &slugTitle)
-	default:
-		NewErrorResponse(w, r, http.StatusNotFound, thread.ErrCategoryNotFound)
+	cat, threads, err := svc.threadCategory(ctx, category)
+	if err != nil {
+		switch err {
+		case thread.ErrCategoryNotFound:
+			NewErrorResponse(w, r, http.StatusNotFound, err)
+		default:
+			NewErrorResponse(w, r, http.StatusInternalServerError, err)
+		}
+		return
+	}
+
+	if cat.IsArchived() {
+		NewErrorResponse(w, r, http.StatusForbidden, categories.ErrArchived)
 		return
 	}
+
+	thrd, err := threads.Get(ctx, &slugID, &slugTitle)
 	if err != nil {
-		NewErrorResponse(w, r, http.StatusInternalServerError, err)
+		NewErrorResponse(w, r, http.StatusNotFound, err)
 		return
 	}
 
 	cmnt, err := svc.comments.Get(ctx, &commentSlugID)
 	if err != nil {
-		NewErrorResponse(w, r, http.StatusInternalServerError, err)
+		switch err {
+		case comment.ErrNotFound:
+			NewErrorResponse(w, r, http.StatusNotFound, err)
+		default:
+			NewErrorResponse(w, r, http.StatusInternalServerError, err)
+		}
 		return
 	}
 
-	if cmnt.Username != nil || *cmnt.Username != *claims.Username {
-		NewErrorResponse(w, r, http.StatusUnauthorized, err)
+	if cmnt.ThreadID == nil || *cmnt.ThreadID != *thrd.ID {
+		NewErrorResponse(w, r, http.StatusNotFound, comment.ErrNotFound)
 		return
 	}
 
-	if err := svc.comments.Delete(ctx, &commentSlugID); err != nil {
-		logger.Warnf("failed to delete user comment: %s", err.Error())
-		NewErrorResponse(w, r, http.StatusInternalServerError, err)
+	if cmnt.IsDeleted() {
+		NewErrorResponse(w, r, http.StatusGone, comment.ErrDeleted)
+		return
+	}
+
+	if !claims.CanModerate(category) && ptrconv.StringPtrString(cmnt.Username) != ptrconv.StringPtrString(claims.Username) {
+		NewErrorResponse(w, r, http.StatusForbidden, errors.New("only the author or a moderator of the category can delete a comment"))
 		return
 	}
 
-	if err := svc.users.IncCounter(ctx, claims.UserID, ptrconv.StringPtr("counters.num.comments"), -1); err != nil {
-		logger.Warnf("failed to decrement user comment count: %s", err.Error())
+	if err := svc.removeComment(ctx, category, threads, thrd, cmnt, claims.Username); err != nil {
+		logger.Warn(err)
 		NewErrorResponse(w, r, http.StatusInternalServerError, err)
 		return
 	}
@@ -219,22 +566,66 @@ func (svc *Service) VoteCommentHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	switch category {
-	case "misc":
-		_, err = svc.misc.Get(ctx, &slugID, &slugTitle)
-	default:
-		NewErrorResponse(w, r, http.StatusNotFound, thread.ErrCategoryNotFound)
+	cat, threads, err := svc.threadCategory(ctx, category)
+	if err != nil {
+		switch err {
+		case thread.ErrCategoryNotFound:
+			NewErrorResponse(w, r, http.StatusNotFound, err)
+		default:
+			NewErrorResponse(w, r, http.StatusInternalServerError, err)
+		}
+		return
+	}
+
+	if cat.IsArchived() {
+		NewErrorResponse(w, r, http.StatusForbidden, categories.ErrArchived)
 		return
 	}
+
+	if err := svc.checkBanned(ctx, claims.Username, category); err != nil {
+		switch err {
+		case ban.ErrBanned:
+			NewErrorResponse(w, r, http.StatusForbidden, err)
+		default:
+			NewErrorResponse(w, r, http.StatusInternalServerError, err)
+		}
+		return
+	}
+
+	thrd, err := threads.Get(ctx, &slugID, &slugTitle)
 	if err != nil {
-		NewErrorResponse(w, r, http.StatusInternalServerError, err)
+		switch err {
+		case thread.ErrNotFound:
+			NewErrorResponse(w, r, http.StatusNotFound, err)
+		default:
+			NewErrorResponse(w, r, http.StatusInternalServerError, err)
+		}
+		return
+	}
+
+	if thrd.IsDeleted() {
+		NewErrorResponse(w, r, http.StatusGone, thread.ErrDeleted)
 		return
 	}
 
 	cmnt, err := svc.comments.Get(ctx, &commentSlugID)
 	if err != nil {
+		switch err {
+		case comment.ErrNotFound:
+			NewErrorResponse(w, r, http.StatusNotFound, err)
+		default:
+			NewErrorResponse(w, r, http.StatusInternalServerError, err)
+		}
+		return
+	}
+
+	if cmnt.ThreadID == nil || *cmnt.ThreadID != *thrd.ID {
+		NewErrorResponse(w, r, http.StatusNotFound, comment.ErrNotFound)
+		return
+	}
 
-		NewErrorResponse(w, r, http.StatusNotFound, thread.ErrCategoryNotFound)
+	if cmnt.IsDeleted() {
+		NewErrorResponse(w, r, http.StatusGone, comment.ErrDeleted)
 		return
 	}
 
@@ -243,25 +634,36 @@ func (svc *Service) VoteCommentHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	m.SlugType = ptrconv.StringPtr("comments")
+	m.SlugID = cmnt.SlugID
+
 	if err := m.ValidForSave(); err != nil {
 		NewErrorResponse(w, r, http.StatusBadRequest, err)
 		return
 	}
 
-	if err := svc.comments.IncVotes(ctx, &commentSlugID, *m.Value); err != nil {
-		logger.Warnf("failed to increment comment votes thread: %s", err.Error())
-		NewErrorResponse(w, r, http.StatusInternalServerError, err)
-		return
-	}
+	err = svc.tx.Do(ctx, func(ctx context.Context) error {
 
-	if err := svc.users.UpsertVote(ctx, claims.Username, m); err != nil {
-		logger.Warnf("failed to upsert user vote: %s", err.Error())
-		NewErrorResponse(w, r, http.StatusInternalServerError, err)
-		return
-	}
+		old, err := svc.users.SwapVote(ctx, claims.Username, m)
+		if err != nil {
+			return fmt.Errorf("failed to swap user vote: %w", err)
+		}
+
+		if delta := *m.Value - old; delta != 0 {
 
-	if err := svc.users.IncCounter(ctx, cmnt.Username, ptrconv.StringPtr("counters.votes.comments"), *m.Value); err != nil {
-		logger.Warnf("failed to increment user comment votes: %s", err.Error())
+			if err := svc.comments.IncVotes(ctx, &commentSlugID, delta); err != nil {
+				return fmt.Errorf("failed to increment comment votes: %w", err)
+			}
+
+			if err := svc.users.IncCounter(ctx, cmnt.Username, ptrconv.StringPtr("counters.votes.comments"), int64(delta)); err != nil && err != user.ErrNotFound {
+				return fmt.Errorf("failed to increment user comment votes: %w", err)
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		logger.Warn(err)
 		NewErrorResponse(w, r, http.StatusInternalServerError, err)
 		return
 	}
@@ -32,8 +32,8 @@ func (svc *Service) HealthHandler(w http.ResponseWriter, r *http.Request) {
 	ctx, cancel := context.WithTimeout(ctx, svc.cfg.RequestTimeout)
 	defer cancel()
 
-	if err := svc.mongodb.Ping(ctx, nil); err != nil {
-		logger.Errorf("failed to ping mongodb through api client: %s", err.Error())
+	if err := svc.db.Ping(ctx); err != nil {
+		logger.Errorf("failed to ping %s database through api client: %s", svc.cfg.DatabaseDriver, err.Error())
 		NewErrorResponse(w, r, http.StatusInternalServerError, err)
 		return
 	}
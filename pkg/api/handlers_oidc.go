@@ -0,0 +1,204 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/rgynn/klottr/pkg/oidc"
+	"github.com/rgynn/klottr/pkg/token"
+	"github.com/rgynn/klottr/pkg/user"
+)
+
+// oidcProvider returns the provider of the route, ErrUnknownProvider when it is not configured
+func (svc *Service) oidcProvider(r *http.Request) (oidc.Provider, error) {
+
+	provider, ok := svc.oidc[mux.Vars(r)["provider"]]
+	if !ok {
+		return nil, oidc.ErrUnknownProvider
+	}
+
+	return provider, nil
+}
+
+// OIDCProvidersHandler lists the names of the identity providers users can sign in with
+func (svc *Service) OIDCProvidersHandler(w http.ResponseWriter, r *http.Request) {
+
+	names := []string{}
+	for name := range svc.oidc {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	if err := svc.MarshalJSONResponse(w, http.StatusOK, map[string]interface{}{
+		"providers": names,
+	}); err != nil {
+		NewErrorResponse(w, r, http.StatusInternalServerError, err)
+		return
+	}
+}
+
+// OIDCSignInHandler redirects the user to the identity provider of the route to sign in
+func (svc *Service) OIDCSignInHandler(w http.ResponseWriter, r *http.Request) {
+
+	provider, err := svc.oidcProvider(r)
+	if err != nil {
+		NewErrorResponse(w, r, http.StatusNotFound, err)
+		return
+	}
+
+	target, err := svc.startOIDC(r.Context(), w, provider, nil)
+	if err != nil {
+		NewErrorResponse(w, r, http.StatusBadGateway, err)
+		return
+	}
+
+	http.Redirect(w, r, target, http.StatusFound)
+}
+
+// OIDCLinkHandler responds with the url of the identity provider of the route, the identity
+// the user signs in with there is linked to the signed in user when the callback is reached
+// with the access token of the same user
+func (svc *Service) OIDCLinkHandler(w http.ResponseWriter, r *http.Request) {
+
+	ctx := r.Context()
+
+	claims, err := ClaimsFromContext(ctx)
+	if err != nil {
+		NewErrorResponse(w, r, http.StatusUnauthorized, err)
+		return
+	}
+
+	provider, err := svc.oidcProvider(r)
+	if err != nil {
+		NewErrorResponse(w, r, http.StatusNotFound, err)
+		return
+	}
+
+	target, err := svc.startOIDC(ctx, w, provider, claims.Username)
+	if err != nil {
+		NewErrorResponse(w, r, http.StatusBadGateway, err)
+		return
+	}
+
+	if err := svc.MarshalJSONResponse(w, http.StatusOK, map[string]interface{}{
+		"auth_url": target,
+	}); err != nil {
+		NewErrorResponse(w, r, http.StatusInternalServerError, err)
+		return
+	}
+}
+
+// OIDCCallbackHandler is where the identity provider sends the user back to with a code, it
+// signs in the user linked to the identity, creating one on the first sign in, or links the
+// identity to the user that started a link. The state has to be started by the same browser
+func (svc *Service) OIDCCallbackHandler(w http.ResponseWriter, r *http.Request) {
+
+	ctx := r.Context()
+	query := r.URL.Query()
+
+	provider, err := svc.oidcProvider(r)
+	if err != nil {
+		NewErrorResponse(w, r, http.StatusNotFound, err)
+		return
+	}
+
+	if e := query.Get("error"); e != "" {
+		NewErrorResponse(w, r, http.StatusUnauthorized, fmt.Errorf("sign in with %s failed: %s %s", provider.Name(), e, query.Get("error_description")))
+		return
+	}
+
+	if query.Get("state") == "" || query.Get("code") == "" {
+		NewErrorResponse(w, r, http.StatusBadRequest, errors.New("no state and code provided"))
+		return
+	}
+
+	if !validOIDCCookie(r, query.Get("state")) {
+		NewErrorResponse(w, r, http.StatusUnauthorized, errors.New("sign in state not started by this browser"))
+		return
+	}
+
+	svc.setOIDCCookie(w, "", -1)
+
+	t, err := svc.tokens.UseTicket(ctx, token.Hash(query.Get("state")), token.PurposeOIDC, time.Now().UTC())
+	if err != nil {
+		switch err {
+		case token.ErrNotFound:
+			NewErrorResponse(w, r, http.StatusUnauthorized, errors.New("sign in state invalid, used or expired"))
+		default:
+			NewErrorResponse(w, r, http.StatusInternalServerError, err)
+		}
+		return
+	}
+
+	state := new(oidcState)
+	if err := json.Unmarshal([]byte(t.Data), state); err != nil {
+		NewErrorResponse(w, r, http.StatusInternalServerError, err)
+		return
+	}
+
+	if state.Provider != provider.Name() {
+		NewErrorResponse(w, r, http.StatusUnauthorized, errors.New("sign in state is for another provider"))
+		return
+	}
+
+	// a link is finished by the user that started it, so its auth url cannot link the identity
+	// of whoever else opens it
+	if t.Username != nil && *t.Username != "" {
+		claims, err := ClaimsFromContext(ctx)
+		if err != nil || claims.Username == nil || *claims.Username != *t.Username {
+			NewErrorResponse(w, r, http.StatusUnauthorized, errors.New("linking an identity needs the access token of the user that started it"))
+			return
+		}
+	}
+
+	identity, err := provider.Exchange(ctx, query.Get("code"), state.Verifier, state.Nonce)
+	if err != nil {
+		NewErrorResponse(w, r, http.StatusUnauthorized, err)
+		return
+	}
+
+	if t.Username != nil && *t.Username != "" {
+
+		linked := &user.Identity{Issuer: identity.Issuer, Subject: identity.Subject}
+
+		if err := svc.users.LinkIdentity(ctx, t.Username, linked); err != nil {
+			switch err {
+			case user.ErrAlreadyExists:
+				NewErrorResponse(w, r, http.StatusConflict, errors.New("identity already linked to another user"))
+			case user.ErrNotFound:
+				NewErrorResponse(w, r, http.StatusNotFound, err)
+			default:
+				NewErrorResponse(w, r, http.StatusInternalServerError, err)
+			}
+			return
+		}
+
+		if err := svc.MarshalJSONResponse(w, http.StatusOK, linked); err != nil {
+			NewErrorResponse(w, r, http.StatusInternalServerError, err)
+			return
+		}
+		return
+	}
+
+	u, err := svc.users.GetByIdentity(ctx, identity.Issuer, identity.Subject)
+	if err == user.ErrNotFound {
+		u, err = svc.provisionUser(ctx, identity)
+	}
+	if err != nil {
+		NewErrorResponse(w, r, http.StatusInternalServerError, err)
+		return
+	}
+
+	if u.IsDeactivated() {
+		NewErrorResponse(w, r, http.StatusUnauthorized, user.ErrDeactivated)
+		return
+	}
+
+	svc.signIn(w, r, u)
+}
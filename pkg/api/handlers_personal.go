@@ -0,0 +1,274 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/gorilla/mux"
+	"github.com/rgynn/klottr/pkg/token"
+	"github.com/rgynn/klottr/pkg/user"
+	"github.com/rgynn/ptrconv"
+)
+
+// maxPersonalTokens a user can have at the same time
+const maxPersonalTokens = 50
+
+// touchInterval is how often the last use of a personal access token is written
+const touchInterval = time.Minute
+
+var errPersonalRoute = errors.New("personal access tokens are not accepted on auth routes")
+
+// PersonalTokenInput names a new personal access token and the scopes it is given, it never
+// expires without expires_in seconds
+type PersonalTokenInput struct {
+	Name      *string  `json:"name,omitempty"`
+	Scopes    []string `json:"scopes,omitempty"`
+	ExpiresIn *int64   `json:"expires_in,omitempty"`
+}
+
+// PersonalTokenResponse returned when a personal access token is created, the token is shown
+// once and only its hash is stored
+type PersonalTokenResponse struct {
+	Token string `json:"token"`
+	*token.Personal
+}
+
+// personalScope returns the scope a personal access token needs on the matched route of r,
+// empty for the auth routes it is not accepted on. The admin and moderation routes need the
+// admin scope on top, which RoleMiddleware checks
+func personalScope(r *http.Request) string {
+
+	if route := mux.CurrentRoute(r); route != nil {
+		if template, err := route.GetPathTemplate(); err == nil {
+			switch {
+			case strings.HasPrefix(template, "/api/1.0/auth/"):
+				return ""
+			case strings.HasSuffix(template, "/vote"):
+				return token.ScopeVote
+			}
+		}
+	}
+
+	switch r.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return token.ScopeRead
+	default:
+		return token.ScopePost
+	}
+}
+
+// personalClaims returns the claims of the user of a personal access token, limited to the
+// scopes of the token, when it is accepted on the route of r
+func (svc *Service) personalClaims(r *http.Request, tokenString string) (*JWTClaims, int, error) {
+
+	ctx := r.Context()
+	now := time.Now().UTC()
+
+	pat, err := svc.tokens.GetPersonal(ctx, token.Hash(tokenString), now)
+	if err != nil {
+		switch err {
+		case token.ErrPersonalNotFound:
+			return nil, http.StatusUnauthorized, err
+		default:
+			return nil, http.StatusInternalServerError, err
+		}
+	}
+
+	scope := personalScope(r)
+	if scope == "" {
+		return nil, http.StatusForbidden, errPersonalRoute
+	}
+
+	if !contains(pat.Scopes, scope) {
+		return nil, http.StatusForbidden, fmt.Errorf("personal access token not given the %s scope", scope)
+	}
+
+	u, err := svc.users.GetByUsername(ctx, pat.Username)
+	if err != nil {
+		switch err {
+		case user.ErrNotFound:
+			return nil, http.StatusUnauthorized, token.ErrPersonalNotFound
+		default:
+			return nil, http.StatusInternalServerError, err
+		}
+	}
+
+	if u.IsDeactivated() {
+		return nil, http.StatusUnauthorized, user.ErrDeactivated
+	}
+
+	if pat.LastUsed == nil || now.Sub(*pat.LastUsed) >= touchInterval {
+		if err := svc.tokens.TouchPersonal(ctx, pat.Hash, now); err != nil {
+			return nil, http.StatusInternalServerError, err
+		}
+	}
+
+	claims := &JWTClaims{
+		Username:  u.Username,
+		UserID:    ptrconv.StringPtr(u.ID.String()),
+		Validated: u.Validated,
+		Counters:  u.Counters,
+		Role:      u.Role,
+		Moderates: u.Moderates,
+		TOTP:      u.TOTPEnabled != nil,
+		Scopes:    pat.Scopes,
+	}
+
+	claims.TOTPRequired = svc.cfg.RequireAdminTOTP && ptrconv.StringPtrString(claims.Role) == user.RoleAdmin && !claims.TOTP
+
+	return claims, http.StatusOK, nil
+}
+
+// CreatePersonalTokenHandler creates a personal access token for the signed in user
+func (svc *Service) CreatePersonalTokenHandler(w http.ResponseWriter, r *http.Request) {
+
+	m := new(PersonalTokenInput)
+	ctx := r.Context()
+
+	claims, err := ClaimsFromContext(ctx)
+	if err != nil {
+		NewErrorResponse(w, r, http.StatusUnauthorized, err)
+		return
+	}
+
+	if err := svc.UnmarshalJSONRequest(w, r, &m); err != nil {
+		NewErrorResponse(w, r, http.StatusBadRequest, err)
+		return
+	}
+
+	if m.Name == nil || strings.TrimSpace(*m.Name) == "" {
+		NewErrorResponse(w, r, http.StatusBadRequest, errors.New("no name provided"))
+		return
+	}
+
+	if utf8.RuneCountInString(*m.Name) > 100 {
+		NewErrorResponse(w, r, http.StatusBadRequest, errors.New("name too long"))
+		return
+	}
+
+	if len(m.Scopes) == 0 {
+		NewErrorResponse(w, r, http.StatusBadRequest, errors.New("no scopes provided"))
+		return
+	}
+
+	scopes := []string{}
+	for _, scope := range m.Scopes {
+		if !contains(token.Scopes, scope) {
+			NewErrorResponse(w, r, http.StatusBadRequest, fmt.Errorf("unknown scope %s, expected one of: %s", scope, strings.Join(token.Scopes, ", ")))
+			return
+		}
+		if !contains(scopes, scope) {
+			scopes = append(scopes, scope)
+		}
+	}
+
+	if contains(scopes, token.ScopeAdmin) && !claims.IsAdmin() && !claims.IsModerator() {
+		NewErrorResponse(w, r, http.StatusForbidden, errors.New("only admins and moderators can give the admin scope"))
+		return
+	}
+
+	if m.ExpiresIn != nil && *m.ExpiresIn <= 0 {
+		NewErrorResponse(w, r, http.StatusBadRequest, errors.New("expires_in must be positive"))
+		return
+	}
+
+	existing, err := svc.tokens.ListPersonal(ctx, claims.Username)
+	if err != nil {
+		NewErrorResponse(w, r, http.StatusInternalServerError, err)
+		return
+	}
+
+	if len(existing) >= maxPersonalTokens {
+		NewErrorResponse(w, r, http.StatusConflict, fmt.Errorf("no more than %d personal access tokens allowed", maxPersonalTokens))
+		return
+	}
+
+	s, hash, err := token.NewPersonal()
+	if err != nil {
+		NewErrorResponse(w, r, http.StatusInternalServerError, err)
+		return
+	}
+
+	now := time.Now().UTC()
+
+	pat := &token.Personal{
+		Hash:     hash,
+		ID:       token.NewID(),
+		Username: claims.Username,
+		Name:     strings.TrimSpace(*m.Name),
+		Scopes:   scopes,
+		Created:  now,
+	}
+
+	if m.ExpiresIn != nil {
+		pat.Expires = ptrconv.TimePtr(now.Add(time.Duration(*m.ExpiresIn) * time.Second))
+	}
+
+	if err := svc.tokens.CreatePersonal(ctx, pat); err != nil {
+		NewErrorResponse(w, r, http.StatusInternalServerError, err)
+		return
+	}
+
+	if err := svc.MarshalJSONResponse(w, http.StatusCreated, &PersonalTokenResponse{
+		Token:    s,
+		Personal: pat,
+	}); err != nil {
+		NewErrorResponse(w, r, http.StatusInternalServerError, err)
+		return
+	}
+}
+
+// ListPersonalTokensHandler lists the personal access tokens of the signed in user, without
+// the tokens themselves
+func (svc *Service) ListPersonalTokensHandler(w http.ResponseWriter, r *http.Request) {
+
+	ctx := r.Context()
+
+	claims, err := ClaimsFromContext(ctx)
+	if err != nil {
+		NewErrorResponse(w, r, http.StatusUnauthorized, err)
+		return
+	}
+
+	result, err := svc.tokens.ListPersonal(ctx, claims.Username)
+	if err != nil {
+		NewErrorResponse(w, r, http.StatusInternalServerError, err)
+		return
+	}
+
+	if err := svc.MarshalJSONResponse(w, http.StatusOK, result); err != nil {
+		NewErrorResponse(w, r, http.StatusInternalServerError, err)
+		return
+	}
+}
+
+// RevokePersonalTokenHandler revokes a personal access token of the signed in user by its id
+func (svc *Service) RevokePersonalTokenHandler(w http.ResponseWriter, r *http.Request) {
+
+	ctx := r.Context()
+
+	claims, err := ClaimsFromContext(ctx)
+	if err != nil {
+		NewErrorResponse(w, r, http.StatusUnauthorized, err)
+		return
+	}
+
+	if err := svc.tokens.DeletePersonal(ctx, claims.Username, mux.Vars(r)["id"]); err != nil {
+		switch err {
+		case token.ErrPersonalNotFound:
+			NewErrorResponse(w, r, http.StatusNotFound, err)
+		default:
+			NewErrorResponse(w, r, http.StatusInternalServerError, err)
+		}
+		return
+	}
+
+	if err := svc.NoContentResponse(w, http.StatusAccepted); err != nil {
+		NewErrorResponse(w, r, http.StatusInternalServerError, err)
+		return
+	}
+}
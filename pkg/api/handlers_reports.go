@@ -0,0 +1,417 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/rgynn/klottr/pkg/ban"
+	"github.com/rgynn/klottr/pkg/comment"
+	"github.com/rgynn/klottr/pkg/report"
+	"github.com/rgynn/klottr/pkg/thread"
+	"github.com/rgynn/klottr/pkg/user"
+	"github.com/rgynn/ptrconv"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// ResolveRequest is the action a moderator takes on the reports of a thread or comment, Remove
+// also deletes the content when warning or banning its author, Reason and Expires are those of the ban
+type ResolveRequest struct {
+	Action  *string    `json:"action"`
+	Remove  bool       `json:"remove"`
+	Reason  string     `json:"reason"`
+	Expires *time.Time `json:"expires"`
+}
+
+func (svc *Service) ReportThreadHandler(w http.ResponseWriter, r *http.Request) {
+
+	vars := mux.Vars(r)
+	category := vars["category"]
+	slugID := vars["slug_id"]
+	slugTitle := vars["slug_title"]
+	ctx := r.Context()
+
+	m := new(report.Model)
+
+	claims, err := ClaimsFromContext(ctx)
+	if err != nil {
+		NewErrorResponse(w, r, http.StatusUnauthorized, err)
+		return
+	}
+
+	if err := svc.UnmarshalJSONRequest(w, r, &m); err != nil {
+		NewErrorResponse(w, r, http.StatusBadRequest, err)
+		return
+	}
+
+	_, threads, err := svc.threadCategory(ctx, category)
+	if err != nil {
+		switch err {
+		case thread.ErrCategoryNotFound:
+			NewErrorResponse(w, r, http.StatusNotFound, err)
+		default:
+			NewErrorResponse(w, r, http.StatusInternalServerError, err)
+		}
+		return
+	}
+
+	thrd, err := threads.Get(ctx, &slugID, &slugTitle)
+	if err != nil {
+		NewErrorResponse(w, r, http.StatusNotFound, err)
+		return
+	}
+
+	if thrd.IsDeleted() {
+		NewErrorResponse(w, r, http.StatusGone, thread.ErrDeleted)
+		return
+	}
+
+	if ptrconv.StringPtrString(thrd.Username) == ptrconv.StringPtrString(claims.Username) {
+		NewErrorResponse(w, r, http.StatusBadRequest, errors.New("cannot report your own thread"))
+		return
+	}
+
+	m.ParentID = thrd.ID
+	m.SlugType = ptrconv.StringPtr(report.SlugTypeThreads)
+	m.SlugID = thrd.SlugID
+	m.ThreadSlugID = thrd.SlugID
+	m.Author = thrd.Username
+
+	svc.createReport(w, r, category, claims, m)
+}
+
+func (svc *Service) ReportCommentHandler(w http.ResponseWriter, r *http.Request) {
+
+	vars := mux.Vars(r)
+	category := vars["category"]
+	slugID := vars["slug_id"]
+	slugTitle := vars["slug_title"]
+	commentSlugID := vars["comment_slug_id"]
+	ctx := r.Context()
+
+	m := new(report.Model)
+
+	claims, err := ClaimsFromContext(ctx)
+	if err != nil {
+		NewErrorResponse(w, r, http.StatusUnauthorized, err)
+		return
+	}
+
+	if err := svc.UnmarshalJSONRequest(w, r, &m); err != nil {
+		NewErrorResponse(w, r, http.StatusBadRequest, err)
+		return
+	}
+
+	_, threads, err := svc.threadCategory(ctx, category)
+	if err != nil {
+		switch err {
+		case thread.ErrCategoryNotFound:
+			NewErrorResponse(w, r, http.StatusNotFound, err)
+		default:
+			NewErrorResponse(w, r, http.StatusInternalServerError, err)
+		}
+		return
+	}
+
+	thrd, err := threads.Get(ctx, &slugID, &slugTitle)
+	if err != nil {
+		NewErrorResponse(w, r, http.StatusNotFound, err)
+		return
+	}
+
+	cmnt, err := svc.comments.Get(ctx, &commentSlugID)
+	if err != nil {
+		switch err {
+		case comment.ErrNotFound:
+			NewErrorResponse(w, r, http.StatusNotFound, err)
+		default:
+			NewErrorResponse(w, r, http.StatusInternalServerError, err)
+		}
+		return
+	}
+
+	if cmnt.ThreadID == nil || *cmnt.ThreadID != *thrd.ID {
+		NewErrorResponse(w, r, http.StatusNotFound, comment.ErrNotFound)
+		return
+	}
+
+	if cmnt.IsDeleted() {
+		NewErrorResponse(w, r, http.StatusGone, comment.ErrDeleted)
+		return
+	}
+
+	if ptrconv.StringPtrString(cmnt.Username) == ptrconv.StringPtrString(claims.Username) {
+		NewErrorResponse(w, r, http.StatusBadRequest, errors.New("cannot report your own comment"))
+		return
+	}
+
+	m.ParentID = cmnt.ID
+	m.SlugType = ptrconv.StringPtr(report.SlugTypeComments)
+	m.SlugID = cmnt.SlugID
+	m.ThreadSlugID = thrd.SlugID
+	m.Author = cmnt.Username
+
+	svc.createReport(w, r, category, claims, m)
+}
+
+// createReport stores the report m of a thread or comment in category for the user of claims
+func (svc *Service) createReport(w http.ResponseWriter, r *http.Request, category string, claims *JWTClaims, m *report.Model) {
+
+	m.ID = nil
+	m.Category = &category
+	m.Username = claims.Username
+	m.Created = time.Now().UTC()
+	m.Resolution = nil
+
+	if err := m.ValidForSave(); err != nil {
+		NewErrorResponse(w, r, http.StatusBadRequest, err)
+		return
+	}
+
+	if err := svc.reports.Create(r.Context(), m); err != nil {
+		switch err {
+		case report.ErrAlreadyReported:
+			NewErrorResponse(w, r, http.StatusConflict, err)
+		default:
+			NewErrorResponse(w, r, http.StatusInternalServerError, err)
+		}
+		return
+	}
+
+	if err := svc.NoContentResponse(w, http.StatusCreated); err != nil {
+		NewErrorResponse(w, r, http.StatusInternalServerError, err)
+		return
+	}
+}
+
+// ListReportsHandler lists the moderation queue, the threads and comments with open reports
+// most reported first, of every category for admins and of their own categories for moderators
+func (svc *Service) ListReportsHandler(w http.ResponseWriter, r *http.Request) {
+
+	ctx := r.Context()
+
+	claims, err := ClaimsFromContext(ctx)
+	if err != nil {
+		NewErrorResponse(w, r, http.StatusUnauthorized, err)
+		return
+	}
+
+	page, err := svc.PaginationFromRequest(w, r)
+	if err != nil {
+		NewErrorResponse(w, r, http.StatusBadRequest, err)
+		return
+	}
+
+	opts := &report.QueueOptions{
+		After: page.After,
+		From:  page.From,
+		Size:  page.Size,
+	}
+
+	switch category := r.URL.Query().Get("category"); {
+	case category != "":
+		if !claims.CanModerate(category) {
+			NewErrorResponse(w, r, http.StatusForbidden, ErrNotModerator)
+			return
+		}
+		opts.Categories = []string{category}
+	case !claims.IsAdmin():
+		opts.Categories = append([]string{}, claims.Moderates...)
+	}
+
+	list, err := svc.reports.ListQueue(ctx, opts)
+	if err != nil {
+		NewErrorResponse(w, r, http.StatusInternalServerError, err)
+		return
+	}
+
+	result, err := svc.NewListResponse(list, report.NextCursor(list, page.Size))
+	if err != nil {
+		NewErrorResponse(w, r, http.StatusInternalServerError, err)
+		return
+	}
+
+	if err := svc.MarshalJSONResponse(w, http.StatusOK, result); err != nil {
+		NewErrorResponse(w, r, http.StatusInternalServerError, err)
+		return
+	}
+}
+
+// ResolveReportsHandler closes the open reports of a thread or comment with the action of the
+// request, recorded on every report together with the username of the moderator
+func (svc *Service) ResolveReportsHandler(w http.ResponseWriter, r *http.Request) {
+
+	vars := mux.Vars(r)
+	category := vars["category"]
+	ctx := r.Context()
+
+	req := new(ResolveRequest)
+
+	claims, err := ClaimsFromContext(ctx)
+	if err != nil {
+		NewErrorResponse(w, r, http.StatusUnauthorized, err)
+		return
+	}
+
+	logger, err := LoggerFromContext(ctx)
+	if err != nil {
+		NewErrorResponse(w, r, http.StatusInternalServerError, err)
+		return
+	}
+
+	parentID, err := primitive.ObjectIDFromHex(vars["parent_id"])
+	if err != nil {
+		NewErrorResponse(w, r, http.StatusBadRequest, fmt.Errorf("invalid parent_id: %w", err))
+		return
+	}
+
+	if err := svc.UnmarshalJSONRequest(w, r, &req); err != nil {
+		NewErrorResponse(w, r, http.StatusBadRequest, err)
+		return
+	}
+
+	now := time.Now().UTC()
+
+	resolution := &report.Resolution{
+		Action:   req.Action,
+		Username: claims.Username,
+		Created:  now,
+	}
+
+	if err := resolution.ValidForSave(); err != nil {
+		NewErrorResponse(w, r, http.StatusBadRequest, err)
+		return
+	}
+
+	_, threads, err := svc.threadCategory(ctx, category)
+	if err != nil {
+		switch err {
+		case thread.ErrCategoryNotFound:
+			NewErrorResponse(w, r, http.StatusNotFound, err)
+		default:
+			NewErrorResponse(w, r, http.StatusInternalServerError, err)
+		}
+		return
+	}
+
+	items, err := svc.reports.ListQueue(ctx, &report.QueueOptions{Categories: []string{category}, ParentID: &parentID, Size: 1})
+	if err != nil {
+		NewErrorResponse(w, r, http.StatusInternalServerError, err)
+		return
+	}
+
+	if len(items) == 0 {
+		NewErrorResponse(w, r, http.StatusNotFound, report.ErrNotFound)
+		return
+	}
+
+	item := items[0]
+
+	if item.Author == nil && (*req.Action == report.ActionWarn || *req.Action == report.ActionBan) {
+		NewErrorResponse(w, r, http.StatusNotFound, user.ErrNotFound)
+		return
+	}
+
+	var bn *ban.Model
+
+	switch *req.Action {
+	case report.ActionRemove:
+		req.Remove = true
+	case report.ActionWarn:
+		if _, err := svc.users.GetByUsername(ctx, item.Author); err != nil {
+			switch err {
+			case user.ErrNotFound:
+				NewErrorResponse(w, r, http.StatusNotFound, err)
+			default:
+				NewErrorResponse(w, r, http.StatusInternalServerError, err)
+			}
+			return
+		}
+	case report.ActionBan:
+		bn = &ban.Model{
+			Username:  item.Author,
+			Category:  &category,
+			Reason:    req.Reason,
+			CreatedBy: claims.Username,
+			Created:   now,
+			Expires:   req.Expires,
+		}
+		if err := bn.ValidForSave(); err != nil {
+			NewErrorResponse(w, r, http.StatusBadRequest, err)
+			return
+		}
+		if status, err := svc.bannable(ctx, item.Author, &category); err != nil {
+			NewErrorResponse(w, r, status, err)
+			return
+		}
+	case report.ActionDismiss:
+		req.Remove = false
+	}
+
+	err = svc.tx.Do(ctx, func(ctx context.Context) error {
+
+		if req.Remove {
+			if err := svc.removeReported(ctx, category, threads, item, claims.Username); err != nil {
+				return err
+			}
+		}
+
+		switch *req.Action {
+		case report.ActionWarn:
+			if err := svc.users.IncCounter(ctx, item.Author, ptrconv.StringPtr("counters.warnings"), 1); err != nil {
+				return fmt.Errorf("failed to increment user warnings: %w", err)
+			}
+		case report.ActionBan:
+			if err := svc.bans.Create(ctx, bn); err != nil {
+				return fmt.Errorf("failed to create ban: %w", err)
+			}
+		}
+
+		if err := svc.reports.Resolve(ctx, item.ParentID, resolution); err != nil {
+			return fmt.Errorf("failed to resolve reports: %w", err)
+		}
+
+		return nil
+	})
+	if err != nil {
+		logger.Error(err)
+		NewErrorResponse(w, r, http.StatusInternalServerError, err)
+		return
+	}
+
+	if err := svc.NoContentResponse(w, http.StatusAccepted); err != nil {
+		NewErrorResponse(w, r, http.StatusInternalServerError, err)
+		return
+	}
+}
+
+// removeReported deletes the reported thread or comment of item, content that was deleted
+// since it was reported is left as it is
+func (svc *Service) removeReported(ctx context.Context, category string, threads thread.Repository, item *report.Item, username *string) error {
+
+	thrd, err := threads.Get(ctx, item.ThreadSlugID, nil)
+	if err != nil {
+		return fmt.Errorf("failed to get %s thread: %w", category, err)
+	}
+
+	if *item.SlugType == report.SlugTypeThreads {
+		if thrd.IsDeleted() {
+			return nil
+		}
+		return svc.removeThread(ctx, category, threads, thrd, username)
+	}
+
+	cmnt, err := svc.comments.Get(ctx, item.SlugID)
+	if err != nil {
+		return fmt.Errorf("failed to get comment: %w", err)
+	}
+
+	if cmnt.IsDeleted() {
+		return nil
+	}
+
+	return svc.removeComment(ctx, category, threads, thrd, cmnt, username)
+}
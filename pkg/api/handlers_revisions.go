@@ -0,0 +1,125 @@
+package api
+
+import (
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"github.com/rgynn/klottr/pkg/comment"
+	"github.com/rgynn/klottr/pkg/revision"
+	"github.com/rgynn/klottr/pkg/thread"
+)
+
+func (svc *Service) ListThreadRevisionsHandler(w http.ResponseWriter, r *http.Request) {
+
+	vars := mux.Vars(r)
+	category := vars["category"]
+	slugID := vars["slug_id"]
+	slugTitle := vars["slug_title"]
+	ctx := r.Context()
+
+	page, err := svc.PaginationFromRequest(w, r)
+	if err != nil {
+		NewErrorResponse(w, r, http.StatusBadRequest, err)
+		return
+	}
+
+	_, threads, err := svc.threadCategory(ctx, category)
+	if err != nil {
+		switch err {
+		case thread.ErrCategoryNotFound:
+			NewErrorResponse(w, r, http.StatusNotFound, err)
+		default:
+			NewErrorResponse(w, r, http.StatusInternalServerError, err)
+		}
+		return
+	}
+
+	thrd, err := threads.Get(ctx, &slugID, &slugTitle)
+	if err != nil {
+		NewErrorResponse(w, r, http.StatusNotFound, err)
+		return
+	}
+
+	list, err := svc.revisions.ListByParentID(ctx, thrd.ID, page.After, page.From, page.Size)
+	if err != nil {
+		NewErrorResponse(w, r, http.StatusInternalServerError, err)
+		return
+	}
+
+	result, err := svc.NewListResponse(list, revision.NextCursor(list, page.Size))
+	if err != nil {
+		NewErrorResponse(w, r, http.StatusInternalServerError, err)
+		return
+	}
+
+	if err := svc.MarshalJSONResponse(w, http.StatusOK, result); err != nil {
+		NewErrorResponse(w, r, http.StatusInternalServerError, err)
+		return
+	}
+}
+
+func (svc *Service) ListCommentRevisionsHandler(w http.ResponseWriter, r *http.Request) {
+
+	vars := mux.Vars(r)
+	category := vars["category"]
+	slugID := vars["slug_id"]
+	slugTitle := vars["slug_title"]
+	commentSlugID := vars["comment_slug_id"]
+	ctx := r.Context()
+
+	page, err := svc.PaginationFromRequest(w, r)
+	if err != nil {
+		NewErrorResponse(w, r, http.StatusBadRequest, err)
+		return
+	}
+
+	_, threads, err := svc.threadCategory(ctx, category)
+	if err != nil {
+		switch err {
+		case thread.ErrCategoryNotFound:
+			NewErrorResponse(w, r, http.StatusNotFound, err)
+		default:
+			NewErrorResponse(w, r, http.StatusInternalServerError, err)
+		}
+		return
+	}
+
+	thrd, err := threads.Get(ctx, &slugID, &slugTitle)
+	if err != nil {
+		NewErrorResponse(w, r, http.StatusNotFound, err)
+		return
+	}
+
+	cmnt, err := svc.comments.Get(ctx, &commentSlugID)
+	if err != nil {
+		switch err {
+		case comment.ErrNotFound:
+			NewErrorResponse(w, r, http.StatusNotFound, err)
+		default:
+			NewErrorResponse(w, r, http.StatusInternalServerError, err)
+		}
+		return
+	}
+
+	if cmnt.ThreadID == nil || *cmnt.ThreadID != *thrd.ID {
+		NewErrorResponse(w, r, http.StatusNotFound, comment.ErrNotFound)
+		return
+	}
+
+	list, err := svc.revisions.ListByParentID(ctx, cmnt.ID, page.After, page.From, page.Size)
+	if err != nil {
+		NewErrorResponse(w, r, http.StatusInternalServerError, err)
+		return
+	}
+
+	result, err := svc.NewListResponse(list, revision.NextCursor(list, page.Size))
+	if err != nil {
+		NewErrorResponse(w, r, http.StatusInternalServerError, err)
+		return
+	}
+
+	if err := svc.MarshalJSONResponse(w, http.StatusOK, result); err != nil {
+		NewErrorResponse(w, r, http.StatusInternalServerError, err)
+		return
+	}
+}
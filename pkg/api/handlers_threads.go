@@ -1,11 +1,16 @@
 package api
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"net/http"
-	"strconv"
 	"time"
 
 	"github.com/gorilla/mux"
+	"github.com/rgynn/klottr/pkg/ban"
+	categories "github.com/rgynn/klottr/pkg/category"
+	"github.com/rgynn/klottr/pkg/revision"
 	"github.com/rgynn/klottr/pkg/thread"
 	"github.com/rgynn/klottr/pkg/user"
 	"github.com/rgynn/ptrconv"
@@ -24,6 +29,11 @@ func (svc *Service) CreateThreadHandler(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
+	if err := svc.checkValidated(claims); err != nil {
+		NewErrorResponse(w, r, http.StatusForbidden, err)
+		return
+	}
+
 	logger, err := LoggerFromContext(ctx)
 	if err != nil {
 		NewErrorResponse(w, r, http.StatusInternalServerError, err)
@@ -48,35 +58,57 @@ func (svc *Service) CreateThreadHandler(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	var result *thread.Model
-
-	switch category {
-	case "misc":
-		err = svc.misc.Create(ctx, m)
-	default:
-		NewErrorResponse(w, r, http.StatusNotFound, thread.ErrCategoryNotFound)
+	cat, threads, err := svc.threadCategory(ctx, category)
+	if err != nil {
+		switch err {
+		case thread.ErrCategoryNotFound:
+			NewErrorResponse(w, r, http.StatusNotFound, err)
+		default:
+			NewErrorResponse(w, r, http.StatusInternalServerError, err)
+		}
 		return
 	}
-	if err != nil {
-		logger.Errorf("Failed to create %s thread: %s", category, err.Error())
-		NewErrorResponse(w, r, http.StatusInternalServerError, err)
+
+	if err := svc.checkBanned(ctx, claims.Username, category); err != nil {
+		switch err {
+		case ban.ErrBanned:
+			NewErrorResponse(w, r, http.StatusForbidden, err)
+		default:
+			NewErrorResponse(w, r, http.StatusInternalServerError, err)
+		}
 		return
 	}
 
-	switch category {
-	case "misc":
-		result, err = svc.misc.Get(ctx, m.SlugID, m.SlugTitle)
-	default:
-		NewErrorResponse(w, r, http.StatusNotFound, thread.ErrCategoryNotFound)
+	if err := cat.ValidForPost(claims.IsAdmin(), m.URL, m.Content); err != nil {
+		switch err {
+		case categories.ErrArchived:
+			NewErrorResponse(w, r, http.StatusForbidden, err)
+		default:
+			NewErrorResponse(w, r, http.StatusBadRequest, err)
+		}
 		return
 	}
+
+	err = svc.tx.Do(ctx, func(ctx context.Context) error {
+
+		if err := threads.Create(ctx, m); err != nil {
+			return fmt.Errorf("failed to create %s thread: %w", category, err)
+		}
+
+		if err := svc.users.IncCounter(ctx, claims.Username, ptrconv.StringPtr("counters.num.threads"), 1); err != nil {
+			return fmt.Errorf("failed to increment user num threads: %w", err)
+		}
+
+		return nil
+	})
 	if err != nil {
+		logger.Error(err)
 		NewErrorResponse(w, r, http.StatusInternalServerError, err)
 		return
 	}
 
-	if err := svc.users.IncCounter(ctx, claims.Username, ptrconv.StringPtr("counters.num.threads"), 1); err != nil {
-		logger.Errorf("Failed to increment user num threads: %s", err.Error())
+	result, err := threads.Get(ctx, m.SlugID, m.SlugTitle)
+	if err != nil {
 		NewErrorResponse(w, r, http.StatusInternalServerError, err)
 		return
 	}
@@ -92,36 +124,56 @@ func (svc *Service) ListThreadsHandler(w http.ResponseWriter, r *http.Request) {
 	category := mux.Vars(r)["category"]
 	ctx := r.Context()
 
-	from, err := strconv.ParseInt(r.URL.Query().Get("from"), 10, 64)
+	page, err := svc.PaginationFromRequest(w, r)
 	if err != nil {
-		from = 0
+		NewErrorResponse(w, r, http.StatusBadRequest, err)
+		return
 	}
 
-	size, err := strconv.ParseInt(r.URL.Query().Get("size"), 10, 64)
-	if err != nil || size < 1 {
-		size = 100
+	opts, err := thread.NewListOptions(r.URL.Query().Get("sort"), r.URL.Query().Get("t"), page.After, page.From, page.Size)
+	if err != nil {
+		NewErrorResponse(w, r, http.StatusBadRequest, err)
+		return
 	}
 
-	result := []*thread.Model{}
+	_, threads, err := svc.threadCategory(ctx, category)
+	if err != nil {
+		switch err {
+		case thread.ErrCategoryNotFound:
+			NewErrorResponse(w, r, http.StatusNotFound, err)
+		default:
+			NewErrorResponse(w, r, http.StatusInternalServerError, err)
+		}
+		return
+	}
 
-	switch category {
-	case "misc":
-		result, err = svc.misc.List(ctx, from, size)
-	default:
-		NewErrorResponse(w, r, http.StatusNotFound, thread.ErrCategoryNotFound)
+	list, err := threads.List(ctx, opts)
+	if err != nil {
+		NewErrorResponse(w, r, http.StatusInternalServerError, err)
 		return
 	}
+
+	next := thread.NextCursor(opts, list)
+
+	if list, err = svc.withPinned(ctx, threads, opts, list); err != nil {
+		NewErrorResponse(w, r, http.StatusInternalServerError, err)
+		return
+	}
+
+	result, err := svc.NewListResponse(list, next)
 	if err != nil {
 		NewErrorResponse(w, r, http.StatusInternalServerError, err)
 		return
 	}
 
-	if err := svc.MarshalJSONResponse(w, http.StatusOK, &result); err != nil {
+	if err := svc.MarshalJSONResponse(w, http.StatusOK, result); err != nil {
 		NewErrorResponse(w, r, http.StatusInternalServerError, err)
 		return
 	}
 }
 
+// GetThreadHandler returns a thread, include=comments adds its first page of comments read
+// with the same query parameters as ListCommentsHandler
 func (svc *Service) GetThreadHandler(w http.ResponseWriter, r *http.Request) {
 
 	vars := mux.Vars(r)
@@ -130,28 +182,60 @@ func (svc *Service) GetThreadHandler(w http.ResponseWriter, r *http.Request) {
 	slugTitle := vars["slug_title"]
 	ctx := r.Context()
 
-	var result *thread.Model
-	var err error
+	includes, err := ThreadIncludesFromRequest(r)
+	if err != nil {
+		NewErrorResponse(w, r, http.StatusBadRequest, err)
+		return
+	}
+
+	var cq *CommentsQuery
+	if includes["comments"] {
+		if cq, err = svc.CommentsQueryFromRequest(w, r); err != nil {
+			NewErrorResponse(w, r, http.StatusBadRequest, err)
+			return
+		}
+	}
 
-	switch category {
-	case "misc":
-		result, err = svc.misc.Get(ctx, &slugID, &slugTitle)
-	default:
-		NewErrorResponse(w, r, http.StatusNotFound, thread.ErrCategoryNotFound)
+	_, threads, err := svc.threadCategory(ctx, category)
+	if err != nil {
+		switch err {
+		case thread.ErrCategoryNotFound:
+			NewErrorResponse(w, r, http.StatusNotFound, err)
+		default:
+			NewErrorResponse(w, r, http.StatusInternalServerError, err)
+		}
 		return
 	}
+
+	thrd, err := threads.Get(ctx, &slugID, &slugTitle)
 	if err != nil {
-		NewErrorResponse(w, r, http.StatusInternalServerError, err)
+		switch err {
+		case thread.ErrNotFound:
+			NewErrorResponse(w, r, http.StatusNotFound, err)
+		default:
+			NewErrorResponse(w, r, http.StatusInternalServerError, err)
+		}
 		return
 	}
 
-	if err := svc.MarshalJSONResponse(w, http.StatusOK, &result); err != nil {
+	result := &ThreadResponse{Model: thrd.Redacted()}
+
+	if includes["comments"] {
+		if result.Comments, err = svc.listComments(ctx, thrd, cq); err != nil {
+			NewErrorResponse(w, r, http.StatusInternalServerError, err)
+			return
+		}
+	}
+
+	if err := svc.MarshalJSONResponse(w, http.StatusOK, result); err != nil {
 		NewErrorResponse(w, r, http.StatusInternalServerError, err)
 		return
 	}
 }
 
-func (svc *Service) VoteThreadHandler(w http.ResponseWriter, r *http.Request) {
+// EditThreadHandler replaces the title and content of a thread for its author within the
+// edit window, the previous version is kept as a revision
+func (svc *Service) EditThreadHandler(w http.ResponseWriter, r *http.Request) {
 
 	vars := mux.Vars(r)
 	category := vars["category"]
@@ -159,7 +243,7 @@ func (svc *Service) VoteThreadHandler(w http.ResponseWriter, r *http.Request) {
 	slugTitle := vars["slug_title"]
 	ctx := r.Context()
 
-	m := new(user.Vote)
+	edit := new(thread.Model)
 
 	claims, err := ClaimsFromContext(ctx)
 	if err != nil {
@@ -173,51 +257,375 @@ func (svc *Service) VoteThreadHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	var thrd *thread.Model
+	cat, threads, err := svc.threadCategory(ctx, category)
+	if err != nil {
+		switch err {
+		case thread.ErrCategoryNotFound:
+			NewErrorResponse(w, r, http.StatusNotFound, err)
+		default:
+			NewErrorResponse(w, r, http.StatusInternalServerError, err)
+		}
+		return
+	}
 
-	switch category {
-	case "misc":
-		thrd, err = svc.misc.Get(ctx, &slugID, &slugTitle)
-	default:
-		NewErrorResponse(w, r, http.StatusNotFound, thread.ErrCategoryNotFound)
+	if err := svc.checkBanned(ctx, claims.Username, category); err != nil {
+		switch err {
+		case ban.ErrBanned:
+			NewErrorResponse(w, r, http.StatusForbidden, err)
+		default:
+			NewErrorResponse(w, r, http.StatusInternalServerError, err)
+		}
 		return
 	}
+
+	thrd, err := threads.Get(ctx, &slugID, &slugTitle)
 	if err != nil {
 		NewErrorResponse(w, r, http.StatusNotFound, err)
 		return
 	}
 
-	if err := svc.UnmarshalJSONRequest(w, r, &m); err != nil {
+	if thrd.IsDeleted() {
+		NewErrorResponse(w, r, http.StatusGone, thread.ErrDeleted)
+		return
+	}
+
+	if thrd.Locked {
+		NewErrorResponse(w, r, http.StatusForbidden, thread.ErrLocked)
+		return
+	}
+
+	if ptrconv.StringPtrString(thrd.Username) != ptrconv.StringPtrString(claims.Username) {
+		NewErrorResponse(w, r, http.StatusForbidden, errors.New("only the author can edit a thread"))
+		return
+	}
+
+	now := time.Now().UTC()
+
+	if thrd.Created == nil || !revision.Editable(*thrd.Created, now, svc.cfg.EditWindow) {
+		NewErrorResponse(w, r, http.StatusForbidden, revision.ErrEditWindow)
+		return
+	}
+
+	if err := svc.UnmarshalJSONRequest(w, r, &edit); err != nil {
 		NewErrorResponse(w, r, http.StatusBadRequest, err)
 		return
 	}
 
-	if err := m.ValidForSave(); err != nil {
+	if edit.Title == nil && edit.Content == "" {
+		NewErrorResponse(w, r, http.StatusBadRequest, errors.New("no title or content provided"))
+		return
+	}
+
+	m := *thrd
+	m.Updated = &now
+
+	if edit.Title != nil {
+		m.Title = edit.Title
+	}
+
+	if edit.Content != "" {
+		m.Content = edit.Content
+	}
+
+	if err := m.ValidForEdit(); err != nil {
 		NewErrorResponse(w, r, http.StatusBadRequest, err)
 		return
 	}
 
-	switch category {
-	case "misc":
-		err = svc.misc.IncCounter(ctx, &slugID, &slugTitle, ptrconv.StringPtr("counters.votes"), *m.Value)
-	default:
-		NewErrorResponse(w, r, http.StatusNotFound, thread.ErrCategoryNotFound)
+	if err := cat.ValidForPost(claims.IsAdmin(), m.URL, m.Content); err != nil {
+		switch err {
+		case categories.ErrArchived:
+			NewErrorResponse(w, r, http.StatusForbidden, err)
+		default:
+			NewErrorResponse(w, r, http.StatusBadRequest, err)
+		}
 		return
 	}
+
+	err = svc.tx.Do(ctx, func(ctx context.Context) error {
+
+		current, err := threads.Get(ctx, &slugID, &slugTitle)
+		if err != nil {
+			return fmt.Errorf("failed to get %s thread: %w", category, err)
+		}
+
+		rev := &revision.Model{
+			ParentID: current.ID,
+			SlugType: ptrconv.StringPtr(revision.SlugTypeThreads),
+			SlugID:   current.SlugID,
+			Title:    current.Title,
+			Content:  current.Content,
+			Created:  *current.Created,
+			Replaced: now,
+		}
+
+		if current.Updated != nil {
+			rev.Created = *current.Updated
+		}
+
+		if err := rev.ValidForSave(); err != nil {
+			return err
+		}
+
+		if err := svc.revisions.Create(ctx, rev); err != nil {
+			return fmt.Errorf("failed to create %s thread revision: %w", category, err)
+		}
+
+		if err := threads.Edit(ctx, &slugID, &slugTitle, &m); err != nil {
+			return fmt.Errorf("failed to edit %s thread: %w", category, err)
+		}
+
+		return nil
+	})
+	if err != nil {
+		logger.Error(err)
+		NewErrorResponse(w, r, http.StatusInternalServerError, err)
+		return
+	}
+
+	result, err := threads.Get(ctx, &slugID, &slugTitle)
 	if err != nil {
-		logger.Errorf("Failed to increment %s thread votes: %s", category, err.Error())
 		NewErrorResponse(w, r, http.StatusInternalServerError, err)
 		return
 	}
 
-	if err := svc.users.IncCounter(ctx, thrd.Username, ptrconv.StringPtr("counters.votes.threads"), *m.Value); err != nil {
-		logger.Errorf("Failed to increment user thread votes: %s", err.Error())
+	if err := svc.MarshalJSONResponse(w, http.StatusOK, result); err != nil {
 		NewErrorResponse(w, r, http.StatusInternalServerError, err)
 		return
 	}
+}
+
+// DeleteThreadHandler marks a thread as deleted for its author or a moderator, its comments stay
+// in place below the tombstone until the thread is purged together with them
+func (svc *Service) DeleteThreadHandler(w http.ResponseWriter, r *http.Request) {
+
+	vars := mux.Vars(r)
+	category := vars["category"]
+	slugID := vars["slug_id"]
+	slugTitle := vars["slug_title"]
+	ctx := r.Context()
+
+	claims, err := ClaimsFromContext(ctx)
+	if err != nil {
+		NewErrorResponse(w, r, http.StatusUnauthorized, err)
+		return
+	}
 
-	if err := svc.users.UpsertVote(ctx, claims.Username, m); err != nil {
-		logger.Errorf("Failed to upsert user vote: %s", err.Error())
+	logger, err := LoggerFromContext(ctx)
+	if err != nil {
+		NewErrorResponse(w, r, http.StatusInternalServerError, err)
+		return
+	}
+
+	_, threads, err := svc.threadCategory(ctx, category)
+	if err != nil {
+		switch err {
+		case thread.ErrCategoryNotFound:
+			NewErrorResponse(w, r, http.StatusNotFound, err)
+		default:
+			NewErrorResponse(w, r, http.StatusInternalServerError, err)
+		}
+		return
+	}
+
+	thrd, err := threads.Get(ctx, &slugID, &slugTitle)
+	if err != nil {
+		NewErrorResponse(w, r, http.StatusNotFound, err)
+		return
+	}
+
+	if thrd.IsDeleted() {
+		NewErrorResponse(w, r, http.StatusGone, thread.ErrDeleted)
+		return
+	}
+
+	if !claims.CanModerate(category) && ptrconv.StringPtrString(thrd.Username) != ptrconv.StringPtrString(claims.Username) {
+		NewErrorResponse(w, r, http.StatusForbidden, errors.New("only the author or a moderator of the category can delete a thread"))
+		return
+	}
+
+	if err := svc.removeThread(ctx, category, threads, thrd, claims.Username); err != nil {
+		logger.Error(err)
+		NewErrorResponse(w, r, http.StatusInternalServerError, err)
+		return
+	}
+
+	if err := svc.NoContentResponse(w, http.StatusAccepted); err != nil {
+		NewErrorResponse(w, r, http.StatusInternalServerError, err)
+		return
+	}
+}
+
+// ModerateThreadHandler locks, unlocks, pins or unpins a thread, it is only routed to admins and
+// moderators of the category
+func (svc *Service) ModerateThreadHandler(w http.ResponseWriter, r *http.Request) {
+
+	vars := mux.Vars(r)
+	category := vars["category"]
+	slugID := vars["slug_id"]
+	slugTitle := vars["slug_title"]
+	action := vars["action"]
+	ctx := r.Context()
+
+	_, threads, err := svc.threadCategory(ctx, category)
+	if err != nil {
+		switch err {
+		case thread.ErrCategoryNotFound:
+			NewErrorResponse(w, r, http.StatusNotFound, err)
+		default:
+			NewErrorResponse(w, r, http.StatusInternalServerError, err)
+		}
+		return
+	}
+
+	thrd, err := threads.Get(ctx, &slugID, &slugTitle)
+	if err != nil {
+		NewErrorResponse(w, r, http.StatusNotFound, err)
+		return
+	}
+
+	if thrd.IsDeleted() {
+		NewErrorResponse(w, r, http.StatusGone, thread.ErrDeleted)
+		return
+	}
+
+	switch action {
+	case "lock":
+		thrd.Locked = true
+	case "unlock":
+		thrd.Locked = false
+	case "pin":
+		if !thrd.Pinned {
+			pinned, err := threads.ListPinned(ctx)
+			if err != nil {
+				NewErrorResponse(w, r, http.StatusInternalServerError, err)
+				return
+			}
+			if len(pinned) >= thread.MaxPinned {
+				NewErrorResponse(w, r, http.StatusConflict, thread.ErrMaxPinned)
+				return
+			}
+		}
+		thrd.Pinned = true
+	case "unpin":
+		thrd.Pinned = false
+	default:
+		NewErrorResponse(w, r, http.StatusBadRequest, fmt.Errorf("invalid action: %s, must be one of: lock, unlock, pin, unpin", action))
+		return
+	}
+
+	if err := threads.Moderate(ctx, &slugID, &slugTitle, thrd); err != nil {
+		NewErrorResponse(w, r, http.StatusInternalServerError, err)
+		return
+	}
+
+	if err := svc.MarshalJSONResponse(w, http.StatusOK, thrd); err != nil {
+		NewErrorResponse(w, r, http.StatusInternalServerError, err)
+		return
+	}
+}
+
+func (svc *Service) VoteThreadHandler(w http.ResponseWriter, r *http.Request) {
+
+	vars := mux.Vars(r)
+	category := vars["category"]
+	slugID := vars["slug_id"]
+	slugTitle := vars["slug_title"]
+	ctx := r.Context()
+
+	m := new(user.Vote)
+
+	claims, err := ClaimsFromContext(ctx)
+	if err != nil {
+		NewErrorResponse(w, r, http.StatusUnauthorized, err)
+		return
+	}
+
+	logger, err := LoggerFromContext(ctx)
+	if err != nil {
+		NewErrorResponse(w, r, http.StatusInternalServerError, err)
+		return
+	}
+
+	cat, threads, err := svc.threadCategory(ctx, category)
+	if err != nil {
+		switch err {
+		case thread.ErrCategoryNotFound:
+			NewErrorResponse(w, r, http.StatusNotFound, err)
+		default:
+			NewErrorResponse(w, r, http.StatusInternalServerError, err)
+		}
+		return
+	}
+
+	if err := svc.checkBanned(ctx, claims.Username, category); err != nil {
+		switch err {
+		case ban.ErrBanned:
+			NewErrorResponse(w, r, http.StatusForbidden, err)
+		default:
+			NewErrorResponse(w, r, http.StatusInternalServerError, err)
+		}
+		return
+	}
+
+	if cat.IsArchived() {
+		NewErrorResponse(w, r, http.StatusForbidden, categories.ErrArchived)
+		return
+	}
+
+	thrd, err := threads.Get(ctx, &slugID, &slugTitle)
+	if err != nil {
+		NewErrorResponse(w, r, http.StatusNotFound, err)
+		return
+	}
+
+	if thrd.IsDeleted() {
+		NewErrorResponse(w, r, http.StatusGone, thread.ErrDeleted)
+		return
+	}
+
+	if err := svc.UnmarshalJSONRequest(w, r, &m); err != nil {
+		NewErrorResponse(w, r, http.StatusBadRequest, err)
+		return
+	}
+
+	m.SlugType = ptrconv.StringPtr("threads")
+	m.SlugID = thrd.SlugID
+
+	if err := m.ValidForSave(); err != nil {
+		NewErrorResponse(w, r, http.StatusBadRequest, err)
+		return
+	}
+
+	err = svc.tx.Do(ctx, func(ctx context.Context) error {
+
+		old, err := svc.users.SwapVote(ctx, claims.Username, m)
+		if err != nil {
+			return fmt.Errorf("failed to swap user vote: %w", err)
+		}
+
+		if delta := *m.Value - old; delta != 0 {
+
+			if err := threads.IncCounter(ctx, &slugID, &slugTitle, ptrconv.StringPtr("counters.votes"), delta); err != nil {
+				return fmt.Errorf("failed to increment %s thread votes: %w", category, err)
+			}
+
+			if err := svc.users.IncCounter(ctx, thrd.Username, ptrconv.StringPtr("counters.votes.threads"), int64(delta)); err != nil && err != user.ErrNotFound {
+				return fmt.Errorf("failed to increment user thread votes: %w", err)
+			}
+		}
+
+		for field, value := range thread.VoteCounters(old, *m.Value) {
+			field := field
+			if err := threads.IncCounter(ctx, &slugID, &slugTitle, &field, value); err != nil {
+				return fmt.Errorf("failed to increment %s thread %s: %w", category, field, err)
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		logger.Error(err)
 		NewErrorResponse(w, r, http.StatusInternalServerError, err)
 		return
 	}
@@ -0,0 +1,408 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/rgynn/klottr/pkg/token"
+	"github.com/rgynn/klottr/pkg/totp"
+	"github.com/rgynn/klottr/pkg/user"
+	"github.com/rgynn/ptrconv"
+)
+
+// numRecoveryCodes handed out when two factor authentication is enabled
+const numRecoveryCodes = 10
+
+// ChallengeResponse returned by sign in for users with two factor authentication, the challenge
+// token is posted with a code to /auth/totp/verify once before it expires to get the tokens
+type ChallengeResponse struct {
+	ChallengeToken string `json:"challenge_token"`
+	ExpiresIn      int64  `json:"expires_in"`
+}
+
+// TOTPEnrollment returned when enrolling, secret is added to an authenticator app by hand or
+// by showing uri as a qr code
+type TOTPEnrollment struct {
+	Secret string `json:"secret"`
+	URI    string `json:"uri"`
+}
+
+// TOTPEnabledResponse returned when two factor authentication is confirmed, the recovery codes
+// are shown once and the tokens replace the ones of the sessions that were signed out
+type TOTPEnabledResponse struct {
+	RecoveryCodes []string `json:"recovery_codes"`
+	*TokenResponse
+}
+
+// TOTPInput is a code from the authenticator app, or one of the recovery codes in its place
+type TOTPInput struct {
+	ChallengeToken *string `json:"challenge_token,omitempty"`
+	Code           *string `json:"code,omitempty"`
+	RecoveryCode   *string `json:"recovery_code,omitempty"`
+}
+
+// challenge stores a single use ticket finishing the sign in of u with a second factor
+func (svc *Service) challenge(ctx context.Context, u *user.Model) (*ChallengeResponse, error) {
+
+	s, hash, err := token.New()
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now().UTC()
+
+	if err := svc.tokens.CreateTicket(ctx, &token.Ticket{
+		Hash:     hash,
+		Purpose:  token.PurposeChallenge,
+		Username: u.Username,
+		Created:  now,
+		Expires:  now.Add(svc.cfg.ChallengeTokenTTL),
+	}); err != nil {
+		return nil, err
+	}
+
+	return &ChallengeResponse{
+		ChallengeToken: s,
+		ExpiresIn:      int64(svc.cfg.ChallengeTokenTTL / time.Second),
+	}, nil
+}
+
+// secondFactor checks the code or recovery code of input for u, either is used up so it cannot
+// be used again
+func (svc *Service) secondFactor(ctx context.Context, u *user.Model, input *TOTPInput) (int, error) {
+
+	var err error
+
+	switch {
+	case input.Code != nil:
+		var step int64
+		if step, err = u.ValidTOTP(svc.cfg.EmailKey, *input.Code, time.Now()); err == nil {
+			err = svc.users.UseTOTPStep(ctx, u.Username, step)
+		}
+	case input.RecoveryCode != nil:
+		err = svc.users.UseRecoveryCode(ctx, u.Username, totp.HashRecoveryCode(*input.RecoveryCode))
+	default:
+		return http.StatusBadRequest, errors.New("no code or recovery_code provided")
+	}
+
+	if err != nil {
+		switch err {
+		case user.ErrInvalidCode:
+			return http.StatusUnauthorized, err
+		default:
+			return http.StatusInternalServerError, err
+		}
+	}
+
+	return http.StatusOK, nil
+}
+
+// TOTPEnrollHandler creates a new totp secret for the signed in user, it is not used for sign
+// in until a code for it is confirmed with TOTPConfirmHandler
+func (svc *Service) TOTPEnrollHandler(w http.ResponseWriter, r *http.Request) {
+
+	ctx := r.Context()
+
+	claims, err := ClaimsFromContext(ctx)
+	if err != nil {
+		NewErrorResponse(w, r, http.StatusUnauthorized, err)
+		return
+	}
+
+	if svc.cfg.EmailKey == nil {
+		NewErrorResponse(w, r, http.StatusNotImplemented, errors.New("two factor authentication needs EMAIL_KEY to be set"))
+		return
+	}
+
+	u, err := svc.users.GetByUsername(ctx, claims.Username)
+	if err != nil {
+		NewErrorResponse(w, r, http.StatusInternalServerError, err)
+		return
+	}
+
+	if u.TOTPEnabled != nil {
+		NewErrorResponse(w, r, http.StatusConflict, errors.New("two factor authentication already enabled"))
+		return
+	}
+
+	secret, err := totp.NewSecret()
+	if err != nil {
+		NewErrorResponse(w, r, http.StatusInternalServerError, err)
+		return
+	}
+
+	if err := u.EncryptTOTP(svc.cfg.EmailKey, secret); err != nil {
+		NewErrorResponse(w, r, http.StatusInternalServerError, err)
+		return
+	}
+
+	if err := svc.users.SetTOTP(ctx, u.Username, u.TOTPSecret, nil, nil); err != nil {
+		NewErrorResponse(w, r, http.StatusInternalServerError, err)
+		return
+	}
+
+	if err := svc.MarshalJSONResponse(w, http.StatusOK, &TOTPEnrollment{
+		Secret: secret,
+		URI:    totp.URI(svc.cfg.TOTPIssuer, *u.Username, secret),
+	}); err != nil {
+		NewErrorResponse(w, r, http.StatusInternalServerError, err)
+		return
+	}
+}
+
+// TOTPConfirmHandler enables two factor authentication with a code for the enrolled secret,
+// every other session of the user is signed out since it was signed in without it
+func (svc *Service) TOTPConfirmHandler(w http.ResponseWriter, r *http.Request) {
+
+	m := new(TOTPInput)
+	ctx := r.Context()
+
+	claims, err := ClaimsFromContext(ctx)
+	if err != nil {
+		NewErrorResponse(w, r, http.StatusUnauthorized, err)
+		return
+	}
+
+	if err := svc.UnmarshalJSONRequest(w, r, &m); err != nil {
+		NewErrorResponse(w, r, http.StatusBadRequest, err)
+		return
+	}
+
+	if m.Code == nil {
+		NewErrorResponse(w, r, http.StatusBadRequest, errors.New("no code provided"))
+		return
+	}
+
+	u, err := svc.users.GetByUsername(ctx, claims.Username)
+	if err != nil {
+		NewErrorResponse(w, r, http.StatusInternalServerError, err)
+		return
+	}
+
+	if u.TOTPEnabled != nil {
+		NewErrorResponse(w, r, http.StatusConflict, errors.New("two factor authentication already enabled"))
+		return
+	}
+
+	if u.TOTPSecret == nil {
+		NewErrorResponse(w, r, http.StatusConflict, errors.New("no two factor enrollment to confirm"))
+		return
+	}
+
+	if status, err := svc.secondFactor(ctx, u, &TOTPInput{Code: m.Code}); err != nil {
+		NewErrorResponse(w, r, status, err)
+		return
+	}
+
+	codes, err := totp.NewRecoveryCodes(numRecoveryCodes)
+	if err != nil {
+		NewErrorResponse(w, r, http.StatusInternalServerError, err)
+		return
+	}
+
+	hashes := make([]string, len(codes))
+	for i, code := range codes {
+		hashes[i] = totp.HashRecoveryCode(code)
+	}
+
+	now := time.Now().UTC()
+
+	err = svc.tx.Do(ctx, func(ctx context.Context) error {
+
+		if err := svc.users.SetTOTP(ctx, u.Username, u.TOTPSecret, &now, hashes); err != nil {
+			return fmt.Errorf("failed to enable totp: %w", err)
+		}
+
+		if err := svc.tokens.RevokeUser(ctx, u.Username, now); err != nil {
+			return fmt.Errorf("failed to revoke refresh tokens: %w", err)
+		}
+
+		return nil
+	})
+	if err != nil {
+		NewErrorResponse(w, r, http.StatusInternalServerError, err)
+		return
+	}
+
+	if err := svc.denyUser(ctx, u.Username, now); err != nil {
+		NewErrorResponse(w, r, http.StatusInternalServerError, err)
+		return
+	}
+
+	u.TOTPEnabled = &now
+
+	tokens, err := svc.issueTokens(ctx, u, "")
+	if err != nil {
+		NewErrorResponse(w, r, http.StatusInternalServerError, err)
+		return
+	}
+
+	if err := svc.MarshalJSONResponse(w, http.StatusOK, &TOTPEnabledResponse{
+		RecoveryCodes: codes,
+		TokenResponse: tokens,
+	}); err != nil {
+		NewErrorResponse(w, r, http.StatusInternalServerError, err)
+		return
+	}
+}
+
+// TOTPDisableHandler turns two factor authentication off with a code or recovery code, admins
+// cannot turn it off when REQUIRE_ADMIN_TOTP is set
+func (svc *Service) TOTPDisableHandler(w http.ResponseWriter, r *http.Request) {
+
+	m := new(TOTPInput)
+	ctx := r.Context()
+
+	claims, err := ClaimsFromContext(ctx)
+	if err != nil {
+		NewErrorResponse(w, r, http.StatusUnauthorized, err)
+		return
+	}
+
+	if err := svc.UnmarshalJSONRequest(w, r, &m); err != nil {
+		NewErrorResponse(w, r, http.StatusBadRequest, err)
+		return
+	}
+
+	u, err := svc.users.GetByUsername(ctx, claims.Username)
+	if err != nil {
+		NewErrorResponse(w, r, http.StatusInternalServerError, err)
+		return
+	}
+
+	if u.TOTPEnabled == nil {
+		NewErrorResponse(w, r, http.StatusConflict, errors.New("two factor authentication not enabled"))
+		return
+	}
+
+	if svc.cfg.RequireAdminTOTP && ptrconv.StringPtrString(u.Role) == user.RoleAdmin {
+		NewErrorResponse(w, r, http.StatusForbidden, ErrTOTPRequired)
+		return
+	}
+
+	if status, err := svc.secondFactor(ctx, u, m); err != nil {
+		NewErrorResponse(w, r, status, err)
+		return
+	}
+
+	if err := svc.users.SetTOTP(ctx, u.Username, nil, nil, nil); err != nil {
+		NewErrorResponse(w, r, http.StatusInternalServerError, err)
+		return
+	}
+
+	if err := svc.NoContentResponse(w, http.StatusAccepted); err != nil {
+		NewErrorResponse(w, r, http.StatusInternalServerError, err)
+		return
+	}
+}
+
+// TOTPVerifyHandler finishes a sign in with the challenge token and a code or recovery code,
+// the challenge token is used up by the first attempt whether the code matches or not
+func (svc *Service) TOTPVerifyHandler(w http.ResponseWriter, r *http.Request) {
+
+	m := new(TOTPInput)
+	ctx := r.Context()
+
+	if err := svc.UnmarshalJSONRequest(w, r, &m); err != nil {
+		NewErrorResponse(w, r, http.StatusBadRequest, err)
+		return
+	}
+
+	if m.ChallengeToken == nil || *m.ChallengeToken == "" {
+		NewErrorResponse(w, r, http.StatusBadRequest, errors.New("no challenge_token provided"))
+		return
+	}
+
+	if m.Code == nil && m.RecoveryCode == nil {
+		NewErrorResponse(w, r, http.StatusBadRequest, errors.New("no code or recovery_code provided"))
+		return
+	}
+
+	t, err := svc.tokens.UseTicket(ctx, token.Hash(*m.ChallengeToken), token.PurposeChallenge, time.Now().UTC())
+	if err != nil {
+		switch err {
+		case token.ErrNotFound:
+			NewErrorResponse(w, r, http.StatusUnauthorized, errors.New("challenge token invalid, used or expired"))
+		default:
+			NewErrorResponse(w, r, http.StatusInternalServerError, err)
+		}
+		return
+	}
+
+	u, err := svc.users.GetByUsername(ctx, t.Username)
+	if err != nil {
+		switch err {
+		case user.ErrNotFound:
+			NewErrorResponse(w, r, http.StatusUnauthorized, err)
+		default:
+			NewErrorResponse(w, r, http.StatusInternalServerError, err)
+		}
+		return
+	}
+
+	if u.IsDeactivated() {
+		NewErrorResponse(w, r, http.StatusUnauthorized, user.ErrDeactivated)
+		return
+	}
+
+	if u.TOTPEnabled == nil {
+		NewErrorResponse(w, r, http.StatusUnauthorized, errors.New("two factor authentication not enabled"))
+		return
+	}
+
+	// failed codes count against the username and client ip like failed passwords do
+	keys := svc.signInKeys(r, *u.Username)
+
+	locked, err := svc.attemptLockout(ctx, keys)
+	if err != nil {
+		NewErrorResponse(w, r, http.StatusInternalServerError, err)
+		return
+	}
+
+	if locked > 0 {
+		w.Header().Set("Retry-After", ceilSeconds(locked))
+		NewErrorResponse(w, r, http.StatusTooManyRequests, ErrLockedOut)
+		return
+	}
+
+	if status, err := svc.secondFactor(ctx, u, m); err != nil {
+		if status == http.StatusUnauthorized {
+			if err := svc.failLockout(ctx, keys); err != nil {
+				NewErrorResponse(w, r, http.StatusInternalServerError, err)
+				return
+			}
+		}
+		NewErrorResponse(w, r, status, err)
+		return
+	}
+
+	if err := svc.resetLockout(ctx, keys); err != nil {
+		NewErrorResponse(w, r, http.StatusInternalServerError, err)
+		return
+	}
+
+	bn, err := svc.siteBan(ctx, u.Username)
+	if err != nil {
+		NewErrorResponse(w, r, http.StatusInternalServerError, err)
+		return
+	}
+
+	if bn != nil {
+		NewErrorResponse(w, r, http.StatusForbidden, bn.Err())
+		return
+	}
+
+	tokens, err := svc.issueTokens(ctx, u, "")
+	if err != nil {
+		NewErrorResponse(w, r, http.StatusInternalServerError, err)
+		return
+	}
+
+	if err := svc.MarshalJSONResponse(w, http.StatusOK, tokens); err != nil {
+		NewErrorResponse(w, r, http.StatusInternalServerError, err)
+		return
+	}
+}
@@ -2,8 +2,8 @@ package api
 
 import (
 	"net/http"
-	"strconv"
 
+	"github.com/rgynn/klottr/pkg/user"
 	"github.com/rgynn/ptrconv"
 )
 
@@ -11,33 +11,28 @@ func (svc *Service) SearchUsersHandler(w http.ResponseWriter, r *http.Request) {
 
 	ctx := r.Context()
 
-	claims, err := ClaimsFromContext(ctx)
-	if err != nil {
-		NewErrorResponse(w, r, http.StatusUnauthorized, err)
-		return
-	}
-
 	var username *string
 	if uname := r.URL.Query().Get("username"); uname != "" {
 		username = &uname
 	}
 
-	from, err := strconv.ParseInt(r.URL.Query().Get("from"), 10, 64)
+	page, err := svc.PaginationFromRequest(w, r)
 	if err != nil {
-		from = 0
+		NewErrorResponse(w, r, http.StatusBadRequest, err)
+		return
 	}
 
-	size, err := strconv.ParseInt(r.URL.Query().Get("size"), 10, 64)
-	if err != nil || size < 1 {
-		size = 100
+	list, err := svc.users.Search(ctx, username, ptrconv.StringPtr(user.RoleUser), page.After, page.From, page.Size)
+	if err != nil {
+		NewErrorResponse(w, r, http.StatusInternalServerError, err)
+		return
 	}
 
-	if claims.IsUser() {
-		NewErrorResponse(w, r, http.StatusUnauthorized, err)
-		return
+	for _, m := range list {
+		m.PasswordHash = nil
 	}
 
-	result, err := svc.users.Search(ctx, username, ptrconv.StringPtr("user"), from, size)
+	result, err := svc.NewListResponse(list, user.NextCursor(list, page.Size))
 	if err != nil {
 		NewErrorResponse(w, r, http.StatusInternalServerError, err)
 		return
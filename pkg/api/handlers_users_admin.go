@@ -1,8 +1,11 @@
 package api
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"net/http"
-	"strconv"
+	"time"
 
 	"github.com/gorilla/mux"
 	"github.com/rgynn/klottr/pkg/thread"
@@ -15,31 +18,21 @@ func (svc *Service) CreateAdminUserHandler(w http.ResponseWriter, r *http.Reques
 	m := new(user.Model)
 	ctx := r.Context()
 
-	claims, err := ClaimsFromContext(ctx)
-	if err != nil {
-		NewErrorResponse(w, r, http.StatusUnauthorized, err)
-		return
-	}
-
-	if claims.IsUser() {
-		NewErrorResponse(w, r, http.StatusUnauthorized, err)
-		return
-	}
-
 	if err := svc.UnmarshalJSONRequest(w, r, &m); err != nil {
 		NewErrorResponse(w, r, http.StatusBadRequest, err)
 		return
 	}
 
-	m.Role = ptrconv.StringPtr("admin")
+	m.Role = ptrconv.StringPtr(user.RoleAdmin)
+	m.Identities = nil
 
 	if err := m.HashPassword(); err != nil {
 		NewErrorResponse(w, r, http.StatusBadRequest, err)
 		return
 	}
 
-	if err := m.HashEmail(); err != nil {
-		NewErrorResponse(w, r, http.StatusBadRequest, err)
+	if status, err := svc.storeEmail(m); err != nil {
+		NewErrorResponse(w, r, status, err)
 		return
 	}
 
@@ -63,35 +56,30 @@ func (svc *Service) SearchAdminUsersHandler(w http.ResponseWriter, r *http.Reque
 
 	ctx := r.Context()
 
-	claims, err := ClaimsFromContext(ctx)
-	if err != nil {
-		NewErrorResponse(w, r, http.StatusUnauthorized, err)
-		return
-	}
-
 	var username *string
 	if uname := r.URL.Query().Get("username"); uname != "" {
 		username = &uname
 	}
 
-	from, err := strconv.ParseInt(r.URL.Query().Get("from"), 10, 64)
+	page, err := svc.PaginationFromRequest(w, r)
 	if err != nil {
-		from = 0
+		NewErrorResponse(w, r, http.StatusBadRequest, err)
+		return
 	}
 
-	size, err := strconv.ParseInt(r.URL.Query().Get("size"), 10, 64)
-	if err != nil || size < 1 {
-		size = 100
+	list, err := svc.users.Search(ctx, username, ptrconv.StringPtr(user.RoleAdmin), page.After, page.From, page.Size)
+	if err != nil {
+		NewErrorResponse(w, r, http.StatusInternalServerError, err)
+		return
 	}
 
-	if claims.IsUser() {
-		NewErrorResponse(w, r, http.StatusUnauthorized, err)
-		return
+	for _, m := range list {
+		m.PasswordHash = nil
 	}
 
-	result, err := svc.users.Search(ctx, username, ptrconv.StringPtr("admin"), from, size)
+	result, err := svc.NewListResponse(list, user.NextCursor(list, page.Size))
 	if err != nil {
-		NewErrorResponse(w, r, http.StatusInternalServerError, thread.ErrCategoryNotFound)
+		NewErrorResponse(w, r, http.StatusInternalServerError, err)
 		return
 	}
 
@@ -112,13 +100,8 @@ func (svc *Service) GetAdminUserHandler(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	if claims.IsUser() {
-		NewErrorResponse(w, r, http.StatusUnauthorized, err)
-		return
-	}
-
 	if username != ptrconv.StringPtrString(claims.Username) {
-		NewErrorResponse(w, r, http.StatusUnauthorized, err)
+		NewErrorResponse(w, r, http.StatusForbidden, errors.New("admins can only get their own user"))
 		return
 	}
 
@@ -128,6 +111,8 @@ func (svc *Service) GetAdminUserHandler(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
+	result.PasswordHash = nil
+
 	if err := svc.MarshalJSONResponse(w, http.StatusOK, result); err != nil {
 		NewErrorResponse(w, r, http.StatusInternalServerError, err)
 		return
@@ -145,17 +130,35 @@ func (svc *Service) DeleteAdminUserHandler(w http.ResponseWriter, r *http.Reques
 		return
 	}
 
-	if claims.IsUser() {
-		NewErrorResponse(w, r, http.StatusUnauthorized, err)
+	if username != ptrconv.StringPtrString(claims.Username) {
+		NewErrorResponse(w, r, http.StatusForbidden, errors.New("admins can only delete their own user"))
 		return
 	}
 
-	if username != ptrconv.StringPtrString(claims.Username) {
-		NewErrorResponse(w, r, http.StatusUnauthorized, err)
+	now := time.Now().UTC()
+
+	err = svc.tx.Do(ctx, func(ctx context.Context) error {
+
+		if err := svc.users.Delete(ctx, &username, ptrconv.StringPtr(user.RoleAdmin)); err != nil {
+			return fmt.Errorf("failed to delete user: %w", err)
+		}
+
+		if err := svc.tokens.RevokeUser(ctx, &username, now); err != nil {
+			return fmt.Errorf("failed to revoke refresh tokens: %w", err)
+		}
+
+		if err := svc.tokens.DeleteUserPersonal(ctx, &username); err != nil {
+			return fmt.Errorf("failed to delete personal access tokens: %w", err)
+		}
+
+		return nil
+	})
+	if err != nil {
+		NewErrorResponse(w, r, http.StatusInternalServerError, err)
 		return
 	}
 
-	if err := svc.users.Delete(ctx, &username, ptrconv.StringPtr("admin")); err != nil {
+	if err := svc.denyUser(ctx, &username, now); err != nil {
 		NewErrorResponse(w, r, http.StatusInternalServerError, err)
 		return
 	}
@@ -165,3 +168,151 @@ func (svc *Service) DeleteAdminUserHandler(w http.ResponseWriter, r *http.Reques
 		return
 	}
 }
+
+func (svc *Service) SearchModeratorUsersHandler(w http.ResponseWriter, r *http.Request) {
+
+	ctx := r.Context()
+
+	var username *string
+	if uname := r.URL.Query().Get("username"); uname != "" {
+		username = &uname
+	}
+
+	page, err := svc.PaginationFromRequest(w, r)
+	if err != nil {
+		NewErrorResponse(w, r, http.StatusBadRequest, err)
+		return
+	}
+
+	list, err := svc.users.Search(ctx, username, ptrconv.StringPtr(user.RoleModerator), page.After, page.From, page.Size)
+	if err != nil {
+		NewErrorResponse(w, r, http.StatusInternalServerError, err)
+		return
+	}
+
+	for _, m := range list {
+		m.PasswordHash = nil
+	}
+
+	result, err := svc.NewListResponse(list, user.NextCursor(list, page.Size))
+	if err != nil {
+		NewErrorResponse(w, r, http.StatusInternalServerError, err)
+		return
+	}
+
+	if err := svc.MarshalJSONResponse(w, http.StatusOK, result); err != nil {
+		NewErrorResponse(w, r, http.StatusInternalServerError, err)
+		return
+	}
+}
+
+// ModeratorRequest lists the categories a moderator is given moderation powers in
+type ModeratorRequest struct {
+	Categories []string `json:"categories"`
+}
+
+// SetModeratorHandler makes a user moderator of the categories in the request, replacing the
+// categories it moderated before, the user has to sign in again for the new claims
+func (svc *Service) SetModeratorHandler(w http.ResponseWriter, r *http.Request) {
+
+	username := mux.Vars(r)["username"]
+	ctx := r.Context()
+
+	m := new(ModeratorRequest)
+
+	if err := svc.UnmarshalJSONRequest(w, r, &m); err != nil {
+		NewErrorResponse(w, r, http.StatusBadRequest, err)
+		return
+	}
+
+	moderates := []string{}
+	for _, category := range m.Categories {
+		if contains(moderates, category) {
+			continue
+		}
+		if _, _, err := svc.threadCategory(ctx, category); err != nil {
+			switch err {
+			case thread.ErrCategoryNotFound:
+				NewErrorResponse(w, r, http.StatusBadRequest, fmt.Errorf("%w: %s", err, category))
+			default:
+				NewErrorResponse(w, r, http.StatusInternalServerError, err)
+			}
+			return
+		}
+		moderates = append(moderates, category)
+	}
+
+	if err := user.ValidRole(user.RoleModerator, moderates); err != nil {
+		NewErrorResponse(w, r, http.StatusBadRequest, err)
+		return
+	}
+
+	if status, err := svc.changeableRole(ctx, &username); err != nil {
+		NewErrorResponse(w, r, status, err)
+		return
+	}
+
+	if err := svc.users.SetRole(ctx, &username, ptrconv.StringPtr(user.RoleModerator), moderates); err != nil {
+		NewErrorResponse(w, r, http.StatusInternalServerError, err)
+		return
+	}
+
+	// the role is carried in the claims, access tokens issued before the change are refused
+	// so the next refresh picks up the new role
+	if err := svc.denyUser(ctx, &username, time.Now().UTC()); err != nil {
+		NewErrorResponse(w, r, http.StatusInternalServerError, err)
+		return
+	}
+
+	if err := svc.NoContentResponse(w, http.StatusAccepted); err != nil {
+		NewErrorResponse(w, r, http.StatusInternalServerError, err)
+		return
+	}
+}
+
+// DeleteModeratorHandler takes the moderation powers of a user away, making it a user again
+func (svc *Service) DeleteModeratorHandler(w http.ResponseWriter, r *http.Request) {
+
+	username := mux.Vars(r)["username"]
+	ctx := r.Context()
+
+	if status, err := svc.changeableRole(ctx, &username); err != nil {
+		NewErrorResponse(w, r, status, err)
+		return
+	}
+
+	if err := svc.users.SetRole(ctx, &username, ptrconv.StringPtr(user.RoleUser), nil); err != nil {
+		NewErrorResponse(w, r, http.StatusInternalServerError, err)
+		return
+	}
+
+	if err := svc.denyUser(ctx, &username, time.Now().UTC()); err != nil {
+		NewErrorResponse(w, r, http.StatusInternalServerError, err)
+		return
+	}
+
+	if err := svc.NoContentResponse(w, http.StatusAccepted); err != nil {
+		NewErrorResponse(w, r, http.StatusInternalServerError, err)
+		return
+	}
+}
+
+// changeableRole checks that username exists and is not an admin, admins keep their role
+func (svc *Service) changeableRole(ctx context.Context, username *string) (int, error) {
+
+	u, err := svc.users.GetByUsername(ctx, username)
+	if err != nil {
+		switch err {
+		case user.ErrNotFound:
+			return http.StatusNotFound, err
+		default:
+			return http.StatusInternalServerError, err
+		}
+	}
+
+	if ptrconv.StringPtrString(u.Role) == user.RoleAdmin {
+		return http.StatusForbidden, errors.New("cannot change the role of an admin")
+	}
+
+	return http.StatusOK, nil
+}
@@ -0,0 +1,43 @@
+package api
+
+import (
+	"context"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// startJob runs fn every interval in the background until the service is closed
+func (svc *Service) startJob(name string, interval time.Duration, fn func(ctx context.Context) error) {
+	svc.jobs.Add(1)
+	go func() {
+		defer svc.jobs.Done()
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-svc.stop:
+				return
+			case <-ticker.C:
+				ctx, cancel := context.WithTimeout(context.Background(), svc.cfg.RequestTimeout)
+				if err := fn(ctx); err != nil {
+					logrus.Errorf("background job %s failed: %s", name, err.Error())
+				}
+				cancel()
+			}
+		}
+	}()
+}
+
+// startTask runs fn once in the background, the service waits for it when it is closed
+func (svc *Service) startTask(name string, fn func(ctx context.Context) error) {
+	svc.jobs.Add(1)
+	go func() {
+		defer svc.jobs.Done()
+		ctx, cancel := context.WithTimeout(context.Background(), svc.cfg.RequestTimeout)
+		defer cancel()
+		if err := fn(ctx); err != nil {
+			logrus.Errorf("background task %s failed: %s", name, err.Error())
+		}
+	}()
+}
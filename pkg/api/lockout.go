@@ -0,0 +1,169 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/rgynn/klottr/pkg/lockout"
+
+	memorylockout "github.com/rgynn/klottr/pkg/lockout/memory"
+)
+
+var ErrLockedOut = errors.New("too many failed sign in attempts, try again later")
+
+func (svc *Service) setupLockout() error {
+
+	var err error
+
+	switch svc.cfg.LockoutStore {
+	case "memory":
+		svc.lockout, err = memorylockout.NewStore(svc.cfg)
+	case "none":
+		return nil
+	default:
+		return fmt.Errorf("unsupported lockout store: %s", svc.cfg.LockoutStore)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to initialize %s lockout store: %w", svc.cfg.LockoutStore, err)
+	}
+
+	svc.startJob("delete expired lockouts", time.Minute, svc.deleteExpiredLockouts)
+
+	return nil
+}
+
+// lockoutKey is a key failed sign in attempts are tracked by, kind labels its metrics and
+// the failed attempts of keys to forget are forgotten after a successful sign in
+type lockoutKey struct {
+	kind   string
+	key    string
+	policy *lockout.Policy
+	forget bool
+}
+
+// signInKeys returns the keys failed sign in attempts of r for username are tracked by, the
+// username and the client ip each have their own threshold
+func (svc *Service) signInKeys(r *http.Request, username string) []*lockoutKey {
+
+	policy := func(threshold int) *lockout.Policy {
+		return &lockout.Policy{
+			Threshold: threshold,
+			Base:      svc.cfg.LockoutBase,
+			Max:       svc.cfg.LockoutMax,
+			Window:    svc.cfg.LockoutWindow,
+		}
+	}
+
+	return []*lockoutKey{
+		{kind: "username", key: "signin:username:" + strings.ToLower(username), policy: policy(svc.cfg.LockoutUserThreshold), forget: true},
+		{kind: "ip", key: "signin:ip:" + svc.clientIP(r), policy: policy(svc.cfg.LockoutIPThreshold)},
+	}
+}
+
+// attemptLockout counts an attempt for every one of keys as failed until it is released or
+// reset, and returns how long the longest lockout of keys lasts when one is locked out. The
+// attempt is then not counted for any of keys
+func (svc *Service) attemptLockout(ctx context.Context, keys []*lockoutKey) (time.Duration, error) {
+
+	if svc.lockout == nil {
+		return 0, nil
+	}
+
+	var longest time.Duration
+
+	now := time.Now().UTC()
+	counted := []*lockoutKey{}
+
+	for _, k := range keys {
+		d, err := svc.lockout.Attempt(ctx, k.key, k.policy, now)
+		if err != nil {
+			return 0, err
+		}
+		if d > 0 {
+			metricBlockedSignIns.WithLabelValues(k.kind).Inc()
+		} else {
+			counted = append(counted, k)
+		}
+		if d > longest {
+			longest = d
+		}
+	}
+
+	if longest > 0 {
+		if err := svc.releaseLockout(ctx, counted); err != nil {
+			return 0, err
+		}
+	}
+
+	return longest, nil
+}
+
+// failLockout keeps the attempt counted by attemptLockout as failed, counting the keys it
+// locked out
+func (svc *Service) failLockout(ctx context.Context, keys []*lockoutKey) error {
+
+	if svc.lockout == nil {
+		return nil
+	}
+
+	now := time.Now().UTC()
+
+	for _, k := range keys {
+		d, err := svc.lockout.Locked(ctx, k.key, now)
+		if err != nil {
+			return err
+		}
+		if d > 0 {
+			metricLockouts.WithLabelValues(k.kind).Inc()
+		}
+	}
+
+	return nil
+}
+
+// releaseLockout takes back the attempt counted by attemptLockout for every one of keys
+func (svc *Service) releaseLockout(ctx context.Context, keys []*lockoutKey) error {
+
+	if svc.lockout == nil {
+		return nil
+	}
+
+	for _, k := range keys {
+		if err := svc.lockout.Release(ctx, k.key, k.policy); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// resetLockout forgets the failed attempts of the username after a successful sign in, the
+// attempt counted for the client ip is taken back
+func (svc *Service) resetLockout(ctx context.Context, keys []*lockoutKey) error {
+
+	if svc.lockout == nil {
+		return nil
+	}
+
+	for _, k := range keys {
+		var err error
+		if k.forget {
+			err = svc.lockout.Reset(ctx, k.key)
+		} else {
+			err = svc.lockout.Release(ctx, k.key, k.policy)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (svc *Service) deleteExpiredLockouts(ctx context.Context) error {
+	return svc.lockout.DeleteExpired(ctx, time.Now().UTC())
+}
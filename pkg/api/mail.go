@@ -0,0 +1,125 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/rgynn/klottr/pkg/mail"
+	"github.com/rgynn/klottr/pkg/token"
+	"github.com/rgynn/klottr/pkg/user"
+
+	filemail "github.com/rgynn/klottr/pkg/mail/file"
+	loggermail "github.com/rgynn/klottr/pkg/mail/logger"
+	smtpmail "github.com/rgynn/klottr/pkg/mail/smtp"
+)
+
+func (svc *Service) setupMailer() error {
+
+	var err error
+
+	switch svc.cfg.Mailer {
+	case "smtp":
+		svc.mailer, err = smtpmail.NewMailer(svc.cfg)
+	case "file":
+		svc.mailer, err = filemail.NewMailer(svc.cfg)
+	case "log":
+		svc.mailer, err = loggermail.NewMailer(svc.cfg)
+	case "none":
+		return nil
+	default:
+		return fmt.Errorf("unsupported mailer: %s", svc.cfg.Mailer)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to initialize %s mailer: %w", svc.cfg.Mailer, err)
+	}
+
+	return nil
+}
+
+// storeEmail replaces the email address of m with its hash, and its encrypted form when mail
+// can be sent, before m is created
+func (svc *Service) storeEmail(m *user.Model) (int, error) {
+
+	if err := m.NormalizeEmail(); err != nil {
+		return http.StatusBadRequest, err
+	}
+
+	if svc.mailer != nil {
+		if err := m.EncryptEmail(svc.cfg.EmailKey); err != nil {
+			return http.StatusInternalServerError, err
+		}
+	}
+
+	if err := m.HashEmail(); err != nil {
+		return http.StatusBadRequest, err
+	}
+
+	return http.StatusOK, nil
+}
+
+// sendTicket stores a single use ticket for u and mails a link with it to the email address
+// of u, the link is target with the ticket in the token parameter
+func (svc *Service) sendTicket(ctx context.Context, u *user.Model, purpose, target string, ttl time.Duration, subject, body string) error {
+
+	if svc.mailer == nil || u.EmailEncrypted == nil {
+		return nil
+	}
+
+	email, err := u.DecryptEmail(svc.cfg.EmailKey)
+	if err != nil {
+		return err
+	}
+
+	s, hash, err := token.New()
+	if err != nil {
+		return err
+	}
+
+	now := time.Now().UTC()
+
+	if err := svc.tokens.CreateTicket(ctx, &token.Ticket{
+		Hash:     hash,
+		Purpose:  purpose,
+		Username: u.Username,
+		Created:  now,
+		Expires:  now.Add(ttl),
+	}); err != nil {
+		return err
+	}
+
+	link := fmt.Sprintf("%s?%s", target, url.Values{"token": {s}}.Encode())
+
+	return svc.mailer.Send(ctx, &mail.Message{
+		From:    svc.cfg.MailFrom,
+		To:      email,
+		Subject: subject,
+		Body:    fmt.Sprintf(body, *u.Username, link, ttl),
+	})
+}
+
+// sendVerification mails u a link validating its email address
+func (svc *Service) sendVerification(ctx context.Context, u *user.Model) error {
+	return svc.sendTicket(ctx, u, token.PurposeVerify, svc.cfg.PublicURL+"/api/1.0/auth/verify", svc.cfg.VerifyTokenTTL,
+		"Verify your klottr email address",
+		"Hi %s,\n\nopen the link below to verify your email address:\n\n%s\n\nThe link is valid for %s.\n",
+	)
+}
+
+// sendReset mails u a link to the page at RESET_URL setting a new password
+func (svc *Service) sendReset(ctx context.Context, u *user.Model) error {
+	return svc.sendTicket(ctx, u, token.PurposeReset, svc.cfg.ResetURL, svc.cfg.ResetTokenTTL,
+		"Reset your klottr password",
+		"Hi %s,\n\nsomeone asked to reset the password of your account. Open the link below to choose a new one:\n\n%s\n\nThe link is valid for %s, ignore this mail if it was not you.\n",
+	)
+}
+
+// checkValidated refuses posts by users without a validated email address when REQUIRE_VALIDATED is set
+func (svc *Service) checkValidated(claims *JWTClaims) error {
+	if svc.cfg.RequireValidated && !claims.Validated {
+		return user.ErrNotValidated
+	}
+	return nil
+}
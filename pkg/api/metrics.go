@@ -19,9 +19,33 @@ var (
 			Buckets: prometheus.DefBuckets,
 		},
 		[]string{"path", "method", "code"})
+	metricRateLimited = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "http_requests_rate_limited_total",
+			Help: "How many HTTP requests were refused by a rate limit, partitioned by path and HTTP method.",
+		},
+		[]string{"path", "method"},
+	)
+	metricBlockedSignIns = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "signin_blocked_total",
+			Help: "How many sign in attempts were refused because of a lockout, partitioned by the key locked out.",
+		},
+		[]string{"key"},
+	)
+	metricLockouts = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "signin_lockouts_total",
+			Help: "How many failed sign in attempts started or extended a lockout, partitioned by the key locked out.",
+		},
+		[]string{"key"},
+	)
 )
 
 func setupMetrics() {
 	prometheus.MustRegister(metricServedRequests)
 	prometheus.MustRegister(metricDurationSeconds)
+	prometheus.MustRegister(metricRateLimited)
+	prometheus.MustRegister(metricBlockedSignIns)
+	prometheus.MustRegister(metricLockouts)
 }
@@ -5,6 +5,7 @@ import (
 	"errors"
 	"fmt"
 	"log"
+	"net"
 	"net/http"
 	"strings"
 	"time"
@@ -12,6 +13,7 @@ import (
 	"github.com/golang-jwt/jwt"
 	"github.com/gorilla/mux"
 	"github.com/rgynn/klottr/pkg/helper"
+	"github.com/rgynn/klottr/pkg/token"
 	"github.com/rgynn/klottr/pkg/user"
 	"github.com/rgynn/ptrconv"
 	"github.com/sirupsen/logrus"
@@ -108,13 +110,24 @@ func (svc *Service) JWTMiddleware(h http.Handler) http.Handler {
 			return
 		}
 
-		claims := new(JWTClaims)
+		if strings.HasPrefix(tokenString, token.PersonalPrefix) {
+			claims, status, err := svc.personalClaims(r, tokenString)
+			if err != nil {
+				NewErrorResponse(w, r, status, err)
+				return
+			}
+			h.ServeHTTP(w, r.WithContext(ClaimsContext(r.Context(), claims)))
+			return
+		}
 
-		_, err := jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
-			return []byte(svc.cfg.JWTSecret), nil
-		})
+		claims, err := svc.parseJWT(tokenString)
 		if err != nil {
-			NewErrorResponse(w, r, http.StatusBadRequest, errors.New("no valid jwt provided"))
+			switch err {
+			case errInvalidJWT:
+				NewErrorResponse(w, r, http.StatusBadRequest, err)
+			default:
+				NewErrorResponse(w, r, http.StatusUnauthorized, err)
+			}
 			return
 		}
 
@@ -127,17 +140,23 @@ func (svc *Service) RequiredJWTMiddleware(h http.Handler) http.Handler {
 
 		tokenString := strings.Replace(r.Header.Get("Authorization"), "Bearer ", "", 1)
 		if tokenString == "" {
-			NewErrorResponse(w, r, http.StatusUnauthorized, errors.New("no valid jwt provided"))
+			NewErrorResponse(w, r, http.StatusUnauthorized, errInvalidJWT)
 			return
 		}
 
-		claims := new(JWTClaims)
+		if strings.HasPrefix(tokenString, token.PersonalPrefix) {
+			claims, status, err := svc.personalClaims(r, tokenString)
+			if err != nil {
+				NewErrorResponse(w, r, status, err)
+				return
+			}
+			h.ServeHTTP(w, r.WithContext(ClaimsContext(r.Context(), claims)))
+			return
+		}
 
-		_, err := jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
-			return []byte(svc.cfg.JWTSecret), nil
-		})
+		claims, err := svc.parseJWT(tokenString)
 		if err != nil {
-			NewErrorResponse(w, r, http.StatusUnauthorized, errors.New("no valid jwt provided"))
+			NewErrorResponse(w, r, http.StatusUnauthorized, err)
 			return
 		}
 
@@ -145,21 +164,102 @@ func (svc *Service) RequiredJWTMiddleware(h http.Handler) http.Handler {
 	})
 }
 
+// BanMiddleware refuses writes made with the jwt of a user banned site wide, so a ban takes
+// effect right away instead of when the jwt expires
+func (svc *Service) BanMiddleware(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+
+		switch r.Method {
+		case http.MethodGet, http.MethodHead, http.MethodOptions:
+			h.ServeHTTP(w, r)
+			return
+		}
+
+		claims, err := ClaimsFromContext(r.Context())
+		if err != nil {
+			h.ServeHTTP(w, r)
+			return
+		}
+
+		m, err := svc.siteBan(r.Context(), claims.Username)
+		if err != nil {
+			NewErrorResponse(w, r, http.StatusInternalServerError, err)
+			return
+		}
+
+		if m != nil {
+			NewErrorResponse(w, r, http.StatusForbidden, m.Err())
+			return
+		}
+
+		h.ServeHTTP(w, r)
+	})
+}
+
 type JWTClaims struct {
-	Username  *string       `json:"username"`
-	UserID    *string       `json:"userID"`
-	Role      *string       `json:"role"`
+	Username *string `json:"username"`
+	UserID   *string `json:"userID"`
+	Role     *string `json:"role"`
+	// Moderates are the categories a moderator has moderation powers in
+	Moderates []string      `json:"moderates,omitempty"`
 	Validated bool          `json:"validated"`
 	Counters  user.Counters `json:"counters"`
+	// SessionID is the family of refresh tokens the access token was issued with
+	SessionID string `json:"sid,omitempty"`
+	// TOTP is set when the user has two factor authentication enabled, so signed in with a second factor
+	TOTP bool `json:"totp,omitempty"`
+	// TOTPRequired is set on admins without TOTP when REQUIRE_ADMIN_TOTP is set, they have no
+	// admin powers until they enable two factor authentication
+	TOTPRequired bool `json:"-"`
+	// Scopes limit the routes a personal access token is accepted on, empty for jwts
+	Scopes []string `json:"-"`
 	jwt.StandardClaims
 }
 
+// HasScope checks that the claims of a personal access token were given scope, claims of a jwt
+// have every scope
+func (claims *JWTClaims) HasScope(scope string) bool {
+
+	return claims.Scopes == nil || contains(claims.Scopes, scope)
+}
+
 func (claims *JWTClaims) IsAdmin() bool {
-	return ptrconv.StringPtrString(claims.Role) == "admin"
+	return ptrconv.StringPtrString(claims.Role) == user.RoleAdmin && !claims.TOTPRequired
+}
+
+func (claims *JWTClaims) IsModerator() bool {
+	return ptrconv.StringPtrString(claims.Role) == user.RoleModerator
+}
+
+// CanModerate reports whether the claims carry moderation powers in category,
+// admins moderate every category and moderators the ones they were assigned
+func (claims *JWTClaims) CanModerate(category string) bool {
+	if claims.IsAdmin() {
+		return true
+	}
+	if !claims.IsModerator() {
+		return false
+	}
+	for _, c := range claims.Moderates {
+		if c == category {
+			return true
+		}
+	}
+	return false
 }
 
 func (claims *JWTClaims) IsUser() bool {
-	return ptrconv.StringPtrString(claims.Role) == "user"
+	return ptrconv.StringPtrString(claims.Role) == user.RoleUser
+}
+
+// HasRole reports whether the claims carry one of roles
+func (claims *JWTClaims) HasRole(roles ...string) bool {
+	for _, role := range roles {
+		if ptrconv.StringPtrString(claims.Role) == role {
+			return true
+		}
+	}
+	return false
 }
 
 // Response Recorder middleware
@@ -205,3 +305,24 @@ func (svc *Service) RequestMetricsMiddleware(h http.Handler) http.Handler {
 		).Observe(float64(time.Since(rec.Started).Seconds()))
 	})
 }
+
+// clientIP returns the ip address r was sent from, the last address of X-Forwarded-For when
+// TRUST_PROXY is set since that is the one the proxy in front of the service saw
+func (svc *Service) clientIP(r *http.Request) string {
+
+	if svc.cfg.TrustProxy {
+		if forwarded := r.Header.Get("X-Forwarded-For"); forwarded != "" {
+			addrs := strings.Split(forwarded, ",")
+			if ip := net.ParseIP(strings.TrimSpace(addrs[len(addrs)-1])); ip != nil {
+				return ip.String()
+			}
+		}
+	}
+
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+
+	return host
+}
@@ -0,0 +1,231 @@
+package api
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/rgynn/klottr/pkg/helper"
+	"github.com/rgynn/klottr/pkg/oidc"
+	"github.com/rgynn/klottr/pkg/token"
+	"github.com/rgynn/klottr/pkg/user"
+	"github.com/rgynn/ptrconv"
+
+	discoveryoidc "github.com/rgynn/klottr/pkg/oidc/discovery"
+)
+
+// maxUsernameLength of usernames provisioned for identities
+const maxUsernameLength = 32
+
+// oidcStateCookie holds the hash of the state of the sign in started by the browser, so the
+// callback of a sign in started elsewhere is refused
+const oidcStateCookie = "klottr_oidc_state"
+
+// oidcCookiePath limits the state cookie to the single sign on routes
+const oidcCookiePath = "/api/1.0/auth/oidc/"
+
+func (svc *Service) setupOIDC() error {
+
+	svc.oidc = map[string]oidc.Provider{}
+
+	for _, p := range svc.cfg.OIDCProviders {
+
+		provider, err := discoveryoidc.NewProvider(svc.cfg, p, svc.oidcRedirect(p.Name))
+		if err != nil {
+			return fmt.Errorf("failed to initialize %s identity provider: %w", p.Name, err)
+		}
+
+		svc.oidc[p.Name] = provider
+	}
+
+	return nil
+}
+
+// oidcRedirect is the callback url of provider, it has to be registered at the provider
+func (svc *Service) oidcRedirect(provider string) string {
+	return svc.cfg.PublicURL + "/api/1.0/auth/oidc/" + provider + "/callback"
+}
+
+// oidcState kept with the state ticket of a sign in until the user comes back from the provider
+type oidcState struct {
+	Provider string `json:"provider"`
+	Verifier string `json:"verifier"`
+	Nonce    string `json:"nonce"`
+}
+
+// startOIDC stores the state of a new sign in with provider, ties it to the browser with the
+// state cookie set on w and returns the url the user is sent to, with username the identity is
+// linked to that user instead of signing in
+func (svc *Service) startOIDC(ctx context.Context, w http.ResponseWriter, provider oidc.Provider, username *string) (string, error) {
+
+	verifier, err := oidc.NewVerifier()
+	if err != nil {
+		return "", err
+	}
+
+	state := &oidcState{
+		Provider: provider.Name(),
+		Verifier: verifier,
+		Nonce:    token.NewID(),
+	}
+
+	data, err := json.Marshal(state)
+	if err != nil {
+		return "", err
+	}
+
+	s, hash, err := token.New()
+	if err != nil {
+		return "", err
+	}
+
+	if username == nil {
+		username = ptrconv.StringPtr("")
+	}
+
+	now := time.Now().UTC()
+
+	if err := svc.tokens.CreateTicket(ctx, &token.Ticket{
+		Hash:     hash,
+		Purpose:  token.PurposeOIDC,
+		Username: username,
+		Created:  now,
+		Expires:  now.Add(svc.cfg.OIDCStateTTL),
+		Data:     string(data),
+	}); err != nil {
+		return "", err
+	}
+
+	target, err := provider.AuthURL(ctx, s, state.Nonce, oidc.Challenge(verifier))
+	if err != nil {
+		return "", err
+	}
+
+	svc.setOIDCCookie(w, hash, int(svc.cfg.OIDCStateTTL/time.Second))
+
+	return target, nil
+}
+
+// setOIDCCookie sets the state cookie to value for maxAge seconds, a negative maxAge removes it
+func (svc *Service) setOIDCCookie(w http.ResponseWriter, value string, maxAge int) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     oidcStateCookie,
+		Value:    value,
+		Path:     oidcCookiePath,
+		MaxAge:   maxAge,
+		HttpOnly: true,
+		Secure:   strings.HasPrefix(svc.cfg.PublicURL, "https://"),
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+// validOIDCCookie reports whether the state cookie of r holds the hash of state
+func validOIDCCookie(r *http.Request, state string) bool {
+
+	cookie, err := r.Cookie(oidcStateCookie)
+	if err != nil {
+		return false
+	}
+
+	return subtle.ConstantTimeCompare([]byte(cookie.Value), []byte(token.Hash(state))) == 1
+}
+
+// provisionUser creates a user for an identity signing in for the first time, with a username
+// taken from its claims and a random password that can be replaced through a password reset
+func (svc *Service) provisionUser(ctx context.Context, identity *oidc.Identity) (*user.Model, error) {
+
+	base := provisionUsername(identity)
+
+	for attempt := 0; attempt < 5; attempt++ {
+
+		username := base
+		if attempt > 0 {
+			username = fmt.Sprintf("%s-%s", base, strings.ToLower(helper.RandomString(4)))
+		}
+
+		password, _, err := token.New()
+		if err != nil {
+			return nil, err
+		}
+
+		m := &user.Model{
+			Role:       ptrconv.StringPtr(user.RoleUser),
+			Username:   &username,
+			Password:   &password,
+			Identities: []user.Identity{{Issuer: identity.Issuer, Subject: identity.Subject}},
+			Created:    ptrconv.TimePtr(time.Now().UTC()),
+		}
+
+		if err := m.HashPassword(); err != nil {
+			return nil, err
+		}
+
+		if identity.Email != "" {
+			m.Email = ptrconv.StringPtr(identity.Email)
+			m.Validated = identity.EmailVerified
+			// an address the service cannot use is left out rather than failing the sign in
+			if _, err := svc.storeEmail(m); err != nil {
+				m.Email = nil
+				m.EmailEncrypted = nil
+				m.Validated = false
+			}
+		}
+
+		if err := m.ValidForSave(); err != nil {
+			return nil, err
+		}
+
+		err = svc.users.Create(ctx, m)
+		switch err {
+		case nil:
+			return svc.users.GetByUsername(ctx, m.Username)
+		case user.ErrAlreadyExists:
+			// the first sign in of the same identity may have been made at the same time
+			if u, err := svc.users.GetByIdentity(ctx, identity.Issuer, identity.Subject); err == nil {
+				return u, nil
+			}
+		default:
+			return nil, err
+		}
+	}
+
+	return nil, errors.New("failed to find a free username for identity")
+}
+
+// provisionUsername returns the preferred username of identity, or the local part of its email
+// address, keeping lowercase letters, digits, dots, dashes and underscores
+func provisionUsername(identity *oidc.Identity) string {
+
+	candidates := []string{identity.PreferredUsername}
+	if i := strings.Index(identity.Email, "@"); i > 0 {
+		candidates = append(candidates, identity.Email[:i])
+	}
+	candidates = append(candidates, identity.Name)
+
+	for _, candidate := range candidates {
+
+		var b strings.Builder
+		for _, r := range strings.ToLower(candidate) {
+			switch {
+			case r >= 'a' && r <= 'z', r >= '0' && r <= '9', r == '.', r == '-', r == '_':
+				b.WriteRune(r)
+			case r == ' ':
+				b.WriteRune('.')
+			}
+			if b.Len() >= maxUsernameLength {
+				break
+			}
+		}
+
+		if username := strings.Trim(b.String(), ".-_"); username != "" {
+			return username
+		}
+	}
+
+	return "user"
+}
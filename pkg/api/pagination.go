@@ -0,0 +1,71 @@
+package api
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/rgynn/klottr/pkg/cursor"
+)
+
+// ListResponse envelope for list endpoints, pass next_cursor as the cursor query parameter
+// to get the next page, it is left out on the last page
+type ListResponse struct {
+	Results    interface{} `json:"results"`
+	NextCursor string      `json:"next_cursor,omitempty"`
+}
+
+// Pagination of a list request
+type Pagination struct {
+	After *cursor.Cursor
+	From  int64
+	Size  int64
+}
+
+// PaginationFromRequest reads the cursor and size query parameters, size is capped at
+// PAGE_SIZE_MAX. from is still accepted when no cursor is given but marked as deprecated
+// in the response headers
+func (svc *Service) PaginationFromRequest(w http.ResponseWriter, r *http.Request) (*Pagination, error) {
+
+	query := r.URL.Query()
+
+	size, err := strconv.ParseInt(query.Get("size"), 10, 64)
+	if err != nil || size < 1 || size > svc.cfg.PageSizeMax {
+		size = svc.cfg.PageSizeMax
+	}
+
+	result := &Pagination{Size: size}
+
+	if token := query.Get("cursor"); token != "" {
+		if result.After, err = cursor.Decode([]byte(svc.cfg.CursorSecret), token); err != nil {
+			return nil, err
+		}
+		return result, nil
+	}
+
+	if query.Get("from") != "" {
+		w.Header().Set("Deprecation", "true")
+		from, err := strconv.ParseInt(query.Get("from"), 10, 64)
+		if err != nil || from < 0 {
+			from = 0
+		}
+		result.From = from
+	}
+
+	return result, nil
+}
+
+// NewListResponse wraps results in a ListResponse with next signed as the next cursor
+func (svc *Service) NewListResponse(results interface{}, next *cursor.Cursor) (*ListResponse, error) {
+
+	result := &ListResponse{Results: results}
+
+	if next != nil {
+		token, err := cursor.Encode([]byte(svc.cfg.CursorSecret), next)
+		if err != nil {
+			return nil, err
+		}
+		result.NextCursor = token
+	}
+
+	return result, nil
+}
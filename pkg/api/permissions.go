@@ -0,0 +1,116 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"github.com/rgynn/klottr/pkg/token"
+	"github.com/rgynn/klottr/pkg/user"
+)
+
+var ErrForbidden = errors.New("role not allowed to access route")
+
+var ErrNotModerator = errors.New("not a moderator of category")
+
+var ErrTOTPRequired = errors.New("two factor authentication required for admins")
+
+var ErrScope = errors.New("personal access token not given the scope of route")
+
+// Route of a permission table, Roles are the roles allowed to call it
+type Route struct {
+	Method  string
+	Path    string
+	Roles   []string
+	Handler http.HandlerFunc
+}
+
+// AdminRoutes is the permission table of the routes below /api/1.0/admin
+func (svc *Service) AdminRoutes() []*Route {
+
+	admins := []string{user.RoleAdmin}
+
+	return []*Route{
+		{Method: http.MethodPost, Path: "/c", Roles: admins, Handler: svc.CreateCategoryHandler},
+		{Method: http.MethodPost, Path: "/c/{category}/archive", Roles: admins, Handler: svc.ArchiveCategoryHandler},
+		{Method: http.MethodGet, Path: "/users", Roles: admins, Handler: svc.SearchUsersHandler},
+		{Method: http.MethodGet, Path: "/admins", Roles: admins, Handler: svc.SearchAdminUsersHandler},
+		{Method: http.MethodPost, Path: "/admins", Roles: admins, Handler: svc.CreateAdminUserHandler},
+		{Method: http.MethodGet, Path: "/admins/{username}", Roles: admins, Handler: svc.GetAdminUserHandler},
+		{Method: http.MethodDelete, Path: "/admins/{username}", Roles: admins, Handler: svc.DeleteAdminUserHandler},
+		{Method: http.MethodGet, Path: "/moderators", Roles: admins, Handler: svc.SearchModeratorUsersHandler},
+		{Method: http.MethodPut, Path: "/moderators/{username}", Roles: admins, Handler: svc.SetModeratorHandler},
+		{Method: http.MethodDelete, Path: "/moderators/{username}", Roles: admins, Handler: svc.DeleteModeratorHandler},
+		{Method: http.MethodGet, Path: "/bans", Roles: admins, Handler: svc.ListSiteBansHandler},
+		{Method: http.MethodPost, Path: "/bans", Roles: admins, Handler: svc.CreateSiteBanHandler},
+		{Method: http.MethodDelete, Path: "/bans/{username}", Roles: admins, Handler: svc.DeleteSiteBanHandler},
+	}
+}
+
+// ModeratorRoutes is the permission table of the moderation routes of a category, moderators
+// are only let through to the categories they moderate
+func (svc *Service) ModeratorRoutes() []*Route {
+
+	moderators := []string{user.RoleAdmin, user.RoleModerator}
+
+	return []*Route{
+		{Method: http.MethodPost, Path: "/c/{category}/t/{slug_id}/{slug_title}/{action:lock|unlock|pin|unpin}", Roles: moderators, Handler: svc.ModerateThreadHandler},
+		{Method: http.MethodGet, Path: "/c/{category}/bans", Roles: moderators, Handler: svc.ListBansHandler},
+		{Method: http.MethodPost, Path: "/c/{category}/bans", Roles: moderators, Handler: svc.CreateBanHandler},
+		{Method: http.MethodDelete, Path: "/c/{category}/bans/{username}", Roles: moderators, Handler: svc.DeleteBanHandler},
+		{Method: http.MethodGet, Path: "/reports", Roles: moderators, Handler: svc.ListReportsHandler},
+		{Method: http.MethodPost, Path: "/c/{category}/reports/{parent_id}", Roles: moderators, Handler: svc.ResolveReportsHandler},
+	}
+}
+
+// HandleRoutes registers routes on r and guards every one of them with RoleMiddleware
+func (svc *Service) HandleRoutes(r *mux.Router, routes []*Route) {
+
+	permissions := map[*mux.Route][]string{}
+
+	for _, route := range routes {
+		permissions[r.HandleFunc(route.Path, route.Handler).Methods(route.Method)] = route.Roles
+	}
+
+	r.Use(svc.RoleMiddleware(permissions))
+}
+
+// RoleMiddleware requires a valid jwt with one of the roles permissions gives the matched route,
+// routes missing from permissions are refused, moderators are only let through to routes of a
+// {category} they moderate, admins only with two factor authentication when REQUIRE_ADMIN_TOTP is set
+// and personal access tokens only with the admin scope
+func (svc *Service) RoleMiddleware(permissions map[*mux.Route][]string) mux.MiddlewareFunc {
+	return func(h http.Handler) http.Handler {
+		return svc.RequiredJWTMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+
+			claims, err := ClaimsFromContext(r.Context())
+			if err != nil {
+				NewErrorResponse(w, r, http.StatusUnauthorized, err)
+				return
+			}
+
+			if claims.TOTPRequired {
+				NewErrorResponse(w, r, http.StatusForbidden, ErrTOTPRequired)
+				return
+			}
+
+			if !claims.HasScope(token.ScopeAdmin) {
+				NewErrorResponse(w, r, http.StatusForbidden, ErrScope)
+				return
+			}
+
+			roles, ok := permissions[mux.CurrentRoute(r)]
+			if !ok || !claims.HasRole(roles...) {
+				NewErrorResponse(w, r, http.StatusForbidden, ErrForbidden)
+				return
+			}
+
+			if category, ok := mux.Vars(r)["category"]; ok && claims.IsModerator() && !claims.CanModerate(category) {
+				NewErrorResponse(w, r, http.StatusForbidden, ErrNotModerator)
+				return
+			}
+
+			h.ServeHTTP(w, r)
+		}))
+	}
+}
@@ -0,0 +1,162 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/rgynn/klottr/pkg/comment"
+	"github.com/rgynn/klottr/pkg/thread"
+	"github.com/rgynn/klottr/pkg/user"
+	"github.com/rgynn/ptrconv"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// purgeBatchSize is the number of deleted threads or comments read at a time by the purge
+const purgeBatchSize = 500
+
+// purgeDeleted removes the threads and comments that were deleted longer ago than the retention period
+func (svc *Service) purgeDeleted(ctx context.Context) error {
+
+	before := time.Now().UTC().Add(-svc.cfg.DeletedRetention)
+
+	svc.registryMu.RLock()
+	entries := make([]*threadCategory, 0, len(svc.registry))
+	for _, entry := range svc.registry {
+		entries = append(entries, entry)
+	}
+	svc.registryMu.RUnlock()
+
+	for _, entry := range entries {
+		if err := svc.purgeThreads(ctx, entry.threads, before); err != nil {
+			return fmt.Errorf("failed to purge %s threads: %w", *entry.model.Name, err)
+		}
+	}
+
+	if err := svc.purgeComments(ctx, before); err != nil {
+		return fmt.Errorf("failed to purge comments: %w", err)
+	}
+
+	return nil
+}
+
+// purgeThreads removes the threads deleted before together with their comments and the revisions
+// of those, comments that were not deleted themselves are taken off the counters of their authors
+func (svc *Service) purgeThreads(ctx context.Context, threads thread.Repository, before time.Time) error {
+
+	for {
+
+		list, err := threads.ListDeleted(ctx, before, 0, purgeBatchSize)
+		if err != nil {
+			return err
+		}
+
+		for _, thrd := range list {
+			if err := svc.purgeThread(ctx, threads, thrd); err != nil {
+				return err
+			}
+		}
+
+		if len(list) < purgeBatchSize {
+			return nil
+		}
+	}
+}
+
+func (svc *Service) purgeThread(ctx context.Context, threads thread.Repository, thrd *thread.Model) error {
+
+	return svc.tx.Do(ctx, func(ctx context.Context) error {
+
+		cmnts, err := svc.comments.ListByThreadID(ctx, thrd.ID, nil, 0, 0)
+		if err != nil {
+			return fmt.Errorf("failed to list thread comments: %w", err)
+		}
+
+		if err := threads.Delete(ctx, thrd.SlugID, thrd.SlugTitle); err != nil {
+			return fmt.Errorf("failed to delete thread: %w", err)
+		}
+
+		if _, err := svc.comments.DeleteByThreadID(ctx, thrd.ID); err != nil {
+			return fmt.Errorf("failed to delete thread comments: %w", err)
+		}
+
+		parentIDs := []primitive.ObjectID{}
+		numComments := map[string]int64{}
+		for _, cmnt := range cmnts {
+			if cmnt.ID != nil {
+				parentIDs = append(parentIDs, *cmnt.ID)
+			}
+			if cmnt.Username != nil && !cmnt.IsDeleted() {
+				numComments[*cmnt.Username]++
+			}
+		}
+
+		if err := svc.revisions.DeleteByParentIDs(ctx, parentIDs); err != nil {
+			return fmt.Errorf("failed to delete thread comment revisions: %w", err)
+		}
+
+		for username, n := range numComments {
+			username := username
+			if err := svc.users.IncCounter(ctx, &username, ptrconv.StringPtr("counters.num.comments"), -n); err != nil && err != user.ErrNotFound {
+				return fmt.Errorf("failed to decrement user num comments: %w", err)
+			}
+		}
+
+		return nil
+	})
+}
+
+// purgeComments removes the comments deleted before that have no replies, deleted comments with
+// replies are kept so the tree of their thread keeps its shape
+func (svc *Service) purgeComments(ctx context.Context, before time.Time) error {
+
+	var kept int64
+
+	for {
+
+		list, err := svc.comments.ListDeleted(ctx, before, kept, purgeBatchSize)
+		if err != nil {
+			return err
+		}
+
+		byThread := map[primitive.ObjectID][]*comment.Model{}
+		for _, m := range list {
+			if m.ThreadID != nil && m.ID != nil {
+				byThread[*m.ThreadID] = append(byThread[*m.ThreadID], m)
+			} else {
+				kept++
+			}
+		}
+
+		for threadID, deleted := range byThread {
+
+			threadID := threadID
+
+			cmnts, err := svc.comments.ListByThreadID(ctx, &threadID, nil, 0, 0)
+			if err != nil {
+				return err
+			}
+
+			replied := map[primitive.ObjectID]bool{}
+			for _, m := range cmnts {
+				if m.ReplyToID != nil {
+					replied[*m.ReplyToID] = true
+				}
+			}
+
+			for _, m := range deleted {
+				if replied[*m.ID] {
+					kept++
+					continue
+				}
+				if err := svc.comments.Delete(ctx, m.SlugID); err != nil && err != comment.ErrNotFound {
+					return err
+				}
+			}
+		}
+
+		if len(list) < purgeBatchSize {
+			return nil
+		}
+	}
+}
@@ -0,0 +1,119 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/rgynn/klottr/pkg/config"
+	"github.com/rgynn/klottr/pkg/ratelimit"
+
+	memoryratelimit "github.com/rgynn/klottr/pkg/ratelimit/memory"
+)
+
+var ErrRateLimited = errors.New("too many requests, try again later")
+
+func (svc *Service) setupRateLimit() error {
+
+	var err error
+
+	switch svc.cfg.RateLimitStore {
+	case "memory":
+		svc.limiter, err = memoryratelimit.NewStore(svc.cfg)
+	case "none":
+		return nil
+	default:
+		return fmt.Errorf("unsupported rate limit store: %s", svc.cfg.RateLimitStore)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to initialize %s rate limit store: %w", svc.cfg.RateLimitStore, err)
+	}
+
+	svc.rateLimits = map[string]*config.RateLimit{}
+	for _, limit := range svc.cfg.RateLimits {
+		svc.rateLimits[limit.Method+" "+limit.Path] = limit
+	}
+
+	svc.startJob("delete expired rate limits", time.Minute, svc.deleteExpiredRateLimits)
+
+	return nil
+}
+
+// rateLimit returns the rate limit of the route with method and path template and the name of
+// its buckets, routes without one of their own share the buckets of the default rate limit
+func (svc *Service) rateLimit(method, path string) (*config.RateLimit, string) {
+
+	if limit, ok := svc.rateLimits[method+" "+path]; ok {
+		return limit, method + " " + path
+	}
+
+	if limit, ok := svc.rateLimits[" "+path]; ok {
+		return limit, "* " + path
+	}
+
+	return svc.cfg.RateLimitDefault, "default"
+}
+
+// RateLimitMiddleware limits the requests to the matched route with a token bucket per user,
+// or per client ip for requests without a jwt, by the rate limit of the route template
+func (svc *Service) RateLimitMiddleware(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+
+		route := mux.CurrentRoute(r)
+		if svc.limiter == nil || route == nil || r.Method == http.MethodOptions {
+			h.ServeHTTP(w, r)
+			return
+		}
+
+		tmp, err := route.GetPathTemplate()
+		if err != nil {
+			h.ServeHTTP(w, r)
+			return
+		}
+
+		limit, bucket := svc.rateLimit(r.Method, tmp)
+		if limit == nil {
+			h.ServeHTTP(w, r)
+			return
+		}
+
+		key := bucket + " ip:" + svc.clientIP(r)
+		if claims, err := ClaimsFromContext(r.Context()); err == nil && claims.Username != nil {
+			key = bucket + " user:" + *claims.Username
+		}
+
+		result, err := svc.limiter.Take(r.Context(), key, &ratelimit.Limit{Burst: limit.Burst, Period: limit.Period}, time.Now().UTC())
+		if err != nil {
+			NewErrorResponse(w, r, http.StatusInternalServerError, err)
+			return
+		}
+
+		w.Header().Set("RateLimit-Limit", strconv.Itoa(limit.Burst))
+		w.Header().Set("RateLimit-Remaining", strconv.Itoa(result.Remaining))
+		w.Header().Set("RateLimit-Reset", ceilSeconds(result.Reset))
+		w.Header().Set("RateLimit-Policy", fmt.Sprintf("%d;w=%s", limit.Burst, ceilSeconds(limit.Period)))
+
+		if !result.Allowed {
+			metricRateLimited.WithLabelValues(tmp, r.Method).Inc()
+			w.Header().Set("Retry-After", ceilSeconds(result.RetryAfter))
+			NewErrorResponse(w, r, http.StatusTooManyRequests, ErrRateLimited)
+			return
+		}
+
+		h.ServeHTTP(w, r)
+	})
+}
+
+func (svc *Service) deleteExpiredRateLimits(ctx context.Context) error {
+	return svc.limiter.DeleteExpired(ctx, time.Now().UTC())
+}
+
+// ceilSeconds formats d as whole seconds rounded up
+func ceilSeconds(d time.Duration) string {
+	return strconv.FormatInt(int64(math.Ceil(d.Seconds())), 10)
+}
@@ -0,0 +1,99 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/rgynn/klottr/pkg/thread"
+	"github.com/rgynn/klottr/pkg/user"
+	"github.com/rgynn/ptrconv"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// threadIncludes that can be asked for with the include query parameter of a thread
+var threadIncludes = []string{"comments"}
+
+// ThreadResponse is a thread with the resources asked for with the include query parameter
+type ThreadResponse struct {
+	*thread.Model
+	Comments *ListResponse `json:"comments,omitempty"`
+}
+
+// ThreadIncludesFromRequest reads the comma separated include query parameter
+func ThreadIncludesFromRequest(r *http.Request) (map[string]bool, error) {
+
+	result := map[string]bool{}
+
+	for _, include := range strings.Split(r.URL.Query().Get("include"), ",") {
+		if include = strings.TrimSpace(include); include == "" {
+			continue
+		}
+		if !contains(threadIncludes, include) {
+			return nil, fmt.Errorf("invalid include: %s, must be one of: %s", include, strings.Join(threadIncludes, ", "))
+		}
+		result[include] = true
+	}
+
+	return result, nil
+}
+
+// withPinned takes the pinned threads out of the page list and puts all of them in front of
+// the first page, so they are listed once on top whatever the order
+func (svc *Service) withPinned(ctx context.Context, threads thread.Repository, opts *thread.ListOptions, list []*thread.Model) ([]*thread.Model, error) {
+
+	result := []*thread.Model{}
+
+	if opts.After == nil && opts.From == 0 {
+		pinned, err := threads.ListPinned(ctx)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, pinned...)
+	}
+
+	for _, m := range list {
+		if !m.Pinned {
+			result = append(result, m)
+		}
+	}
+
+	return result, nil
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// removeThread marks thrd as deleted by username, removes its revisions and takes it off the
+// counters of its author
+func (svc *Service) removeThread(ctx context.Context, category string, threads thread.Repository, thrd *thread.Model, username *string) error {
+
+	now := time.Now().UTC()
+	m := &thread.Model{Deleted: &now, DeletedBy: username}
+
+	return svc.tx.Do(ctx, func(ctx context.Context) error {
+
+		if err := threads.SoftDelete(ctx, thrd.SlugID, thrd.SlugTitle, m); err != nil {
+			return fmt.Errorf("failed to delete %s thread: %w", category, err)
+		}
+
+		if err := svc.revisions.DeleteByParentIDs(ctx, []primitive.ObjectID{*thrd.ID}); err != nil {
+			return fmt.Errorf("failed to delete %s thread revisions: %w", category, err)
+		}
+
+		// authors whose accounts are gone have no counters left to decrement
+		if err := svc.users.IncCounter(ctx, thrd.Username, ptrconv.StringPtr("counters.num.threads"), -1); err != nil && err != user.ErrNotFound {
+			return fmt.Errorf("failed to decrement user num threads: %w", err)
+		}
+
+		return nil
+	})
+}
@@ -0,0 +1,248 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+	"github.com/rgynn/klottr/pkg/keys"
+	"github.com/rgynn/klottr/pkg/token"
+	"github.com/rgynn/klottr/pkg/user"
+	"github.com/rgynn/ptrconv"
+)
+
+var errInvalidJWT = errors.New("no valid jwt provided")
+
+var errExpiredJWT = errors.New("jwt expired")
+
+// TokenResponse returned by sign in and refresh, token is the access token passed as bearer
+// and refresh_token is exchanged once on /auth/refresh for a new pair before it expires
+type TokenResponse struct {
+	Token        string `json:"token"`
+	RefreshToken string `json:"refresh_token"`
+	ExpiresIn    int64  `json:"expires_in"`
+}
+
+// issueTokens signs an access token for u and stores a new refresh token in family, an empty
+// family starts a new one
+func (svc *Service) issueTokens(ctx context.Context, u *user.Model, family string) (*TokenResponse, error) {
+
+	now := time.Now().UTC()
+
+	if family == "" {
+		family = token.NewID()
+	}
+
+	claims := &JWTClaims{
+		Username:  u.Username,
+		UserID:    ptrconv.StringPtr(u.ID.String()),
+		Validated: u.Validated,
+		Counters:  u.Counters,
+		Role:      u.Role,
+		Moderates: u.Moderates,
+		SessionID: family,
+		TOTP:      u.TOTPEnabled != nil,
+		StandardClaims: jwt.StandardClaims{
+			Id:        token.NewID(),
+			IssuedAt:  now.Unix(),
+			ExpiresAt: now.Add(svc.cfg.AccessTokenTTL).Unix(),
+		},
+	}
+
+	access, err := svc.signingKeys().Sign(claims)
+	if err != nil {
+		return nil, err
+	}
+
+	refresh, hash, err := token.New()
+	if err != nil {
+		return nil, err
+	}
+
+	if err := svc.tokens.CreateRefresh(ctx, &token.Refresh{
+		Hash:     hash,
+		Family:   family,
+		Username: u.Username,
+		Created:  now,
+		Expires:  now.Add(svc.cfg.RefreshTokenTTL),
+	}); err != nil {
+		return nil, err
+	}
+
+	return &TokenResponse{
+		Token:        access,
+		RefreshToken: refresh,
+		ExpiresIn:    int64(svc.cfg.AccessTokenTTL / time.Second),
+	}, nil
+}
+
+// parseJWT parses and verifies an access token, refusing expired ones and the ones on the denylist
+func (svc *Service) parseJWT(tokenString string) (*JWTClaims, error) {
+
+	claims := new(JWTClaims)
+
+	if _, err := jwt.ParseWithClaims(tokenString, claims, svc.signingKeys().Keyfunc); err != nil {
+		var verr *jwt.ValidationError
+		if errors.As(err, &verr) && verr.Errors == jwt.ValidationErrorExpired {
+			return nil, errExpiredJWT
+		}
+		return nil, errInvalidJWT
+	}
+
+	if svc.isDenied(claims) {
+		return nil, token.ErrRevoked
+	}
+
+	claims.TOTPRequired = svc.cfg.RequireAdminTOTP && ptrconv.StringPtrString(claims.Role) == user.RoleAdmin && !claims.TOTP
+
+	return claims, nil
+}
+
+// deny revokes the access token of claims until it expires, the local cache is updated right
+// away while other instances pick it up on their next load of the denylist
+func (svc *Service) deny(ctx context.Context, claims *JWTClaims) error {
+
+	if claims.Id == "" {
+		return nil
+	}
+
+	m := &token.Denied{
+		JTI:      claims.Id,
+		Username: claims.Username,
+		Expires:  time.Unix(claims.ExpiresAt, 0).UTC(),
+	}
+
+	if err := svc.tokens.Deny(ctx, m); err != nil {
+		return err
+	}
+
+	svc.deniedMu.Lock()
+	svc.denied[m.JTI] = m.Expires
+	svc.deniedMu.Unlock()
+
+	return nil
+}
+
+// denyUser revokes every access token of username issued up to now, like deny it is picked up
+// by other instances on their next load of the denylist
+func (svc *Service) denyUser(ctx context.Context, username *string, now time.Time) error {
+
+	if username == nil {
+		return errors.New("no username provided")
+	}
+
+	m := &token.Denied{
+		JTI:          token.NewID(),
+		Username:     username,
+		Expires:      now.Add(svc.cfg.AccessTokenTTL),
+		IssuedBefore: &now,
+	}
+
+	if err := svc.tokens.Deny(ctx, m); err != nil {
+		return err
+	}
+
+	svc.deniedMu.Lock()
+	if before, ok := svc.deniedUsers[*username]; !ok || now.After(before) {
+		svc.deniedUsers[*username] = now
+	}
+	svc.deniedMu.Unlock()
+
+	return nil
+}
+
+// revokeSession denies the access token of claims and revokes the refresh tokens of its session
+func (svc *Service) revokeSession(ctx context.Context, claims *JWTClaims) error {
+
+	if err := svc.deny(ctx, claims); err != nil {
+		return err
+	}
+
+	if claims.SessionID == "" {
+		return nil
+	}
+
+	return svc.tokens.RevokeFamily(ctx, claims.SessionID, time.Now().UTC())
+}
+
+// isDenied reports whether the access token of claims is on the denylist, or was issued to a
+// user whose tokens were all revoked after. Issued at only has seconds, the tokens issued in
+// the second the user was denied in are let through so a sign in right after works
+func (svc *Service) isDenied(claims *JWTClaims) bool {
+
+	svc.deniedMu.RLock()
+	defer svc.deniedMu.RUnlock()
+
+	if claims.Username != nil {
+		if before, ok := svc.deniedUsers[*claims.Username]; ok && claims.IssuedAt < before.Unix() {
+			return true
+		}
+	}
+
+	if claims.Id == "" {
+		return false
+	}
+
+	expires, ok := svc.denied[claims.Id]
+
+	return ok && expires.After(time.Now())
+}
+
+// loadDenied replaces the cached denylist with the denied access tokens not yet expired
+func (svc *Service) loadDenied(ctx context.Context) error {
+
+	list, err := svc.tokens.ListDenied(ctx, time.Now().UTC())
+	if err != nil {
+		return err
+	}
+
+	denied := make(map[string]time.Time, len(list))
+	users := map[string]time.Time{}
+	for _, m := range list {
+		if m.IssuedBefore == nil {
+			denied[m.JTI] = m.Expires
+			continue
+		}
+		if m.Username == nil {
+			continue
+		}
+		if before, ok := users[*m.Username]; !ok || m.IssuedBefore.After(before) {
+			users[*m.Username] = *m.IssuedBefore
+		}
+	}
+
+	svc.deniedMu.Lock()
+	svc.denied = denied
+	svc.deniedUsers = users
+	svc.deniedMu.Unlock()
+
+	return nil
+}
+
+func (svc *Service) signingKeys() *keys.Set {
+	svc.keysMu.RLock()
+	defer svc.keysMu.RUnlock()
+	return svc.keys
+}
+
+// loadKeys loads the jwt keys from JWT_KEYS again, a key added or retired there is picked up
+// without a restart
+func (svc *Service) loadKeys(ctx context.Context) error {
+
+	set, err := keys.Load(svc.cfg.JWTKeys, svc.cfg.JWTSigningKeyID, svc.cfg.JWTSecret)
+	if err != nil {
+		return err
+	}
+
+	svc.keysMu.Lock()
+	svc.keys = set
+	svc.keysMu.Unlock()
+
+	return nil
+}
+
+// deleteExpiredTokens removes refresh tokens and denied access tokens that expired
+func (svc *Service) deleteExpiredTokens(ctx context.Context) error {
+	return svc.tokens.DeleteExpired(ctx, time.Now().UTC())
+}
@@ -0,0 +1,124 @@
+package ban
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/rgynn/klottr/pkg/cursor"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+var ErrNotFound = errors.New("ban not found")
+
+var ErrBanned = errors.New("user is banned")
+
+var ErrAlreadyBanned = errors.New("user is already banned")
+
+type Repository interface {
+	Create(ctx context.Context, m *Model) error
+	// ListActive lists the bans of username that have not expired at now, in every category and site wide
+	ListActive(ctx context.Context, username *string, now time.Time) ([]*Model, error)
+	// List lists the bans in category that have not expired at now, newest first, a nil category lists the site wide bans
+	List(ctx context.Context, category *string, now time.Time, after *cursor.Cursor, from, size int64) ([]*Model, error)
+	// Delete lifts the bans of username in category, a nil category lifts the site wide bans
+	Delete(ctx context.Context, username, category *string) error
+}
+
+// Model of a ban keeping a user from writing
+type Model struct {
+	ID       *primitive.ObjectID `json:"id,omitempty" bson:"_id,omitempty"`
+	Username *string             `json:"username"  bson:"username"`
+	// Category the ban applies to, bans without a category apply to the whole site
+	Category  *string   `json:"category,omitempty"  bson:"category,omitempty"`
+	Reason    string    `json:"reason"  bson:"reason"`
+	CreatedBy *string   `json:"created_by"  bson:"created_by"`
+	Created   time.Time `json:"created"  bson:"created"`
+	// Expires is when the ban is lifted, bans without expiry stand until they are lifted by hand
+	Expires *time.Time `json:"expires,omitempty"  bson:"expires,omitempty"`
+}
+
+func (m *Model) ValidForSave() error {
+
+	if m == nil {
+		return errors.New("no m *ban.Model provided")
+	}
+
+	if m.ID != nil {
+		return errors.New("cannot provide m.ID for new ban")
+	}
+
+	if m.Username == nil || *m.Username == "" {
+		return errors.New("no m.Username provided")
+	}
+
+	if m.Category != nil && *m.Category == "" {
+		return errors.New("m.Category cannot be empty")
+	}
+
+	if m.Reason == "" {
+		return errors.New("no m.Reason provided")
+	}
+
+	if len(m.Reason) > 512 {
+		return errors.New("m.Reason cannot be longer than 512 characters")
+	}
+
+	if m.Created.IsZero() {
+		return errors.New("no m.Created provided")
+	}
+
+	if m.Expires != nil && !m.Expires.After(m.Created) {
+		return errors.New("m.Expires must be after m.Created")
+	}
+
+	return nil
+}
+
+// Active reports whether the ban has not expired at now
+func (m *Model) Active(now time.Time) bool {
+	return m.Expires == nil || m.Expires.After(now)
+}
+
+// Applies reports whether the ban keeps its user from writing in category, site wide bans apply everywhere
+func (m *Model) Applies(category string) bool {
+	return m.Category == nil || *m.Category == category
+}
+
+// Err describes the ban to its user, wrapping ErrBanned
+func (m *Model) Err() error {
+	if m.Expires == nil {
+		return fmt.Errorf("%w: %s", ErrBanned, m.Reason)
+	}
+	return fmt.Errorf("%w until %s: %s", ErrBanned, m.Expires.Format(time.RFC3339), m.Reason)
+}
+
+// Key of m, used as the cursor to continue listing bans after m
+func Key(m *Model) *cursor.Cursor {
+	created := m.Created
+	c := &cursor.Cursor{Time: &created}
+	if m.ID != nil {
+		c.ID = m.ID.Hex()
+	}
+	return c
+}
+
+// After reports whether m is listed after the ban at c
+func After(m *Model, c *cursor.Cursor) bool {
+	if c == nil {
+		return true
+	}
+	if c.Time != nil && !m.Created.Equal(*c.Time) {
+		return m.Created.Before(*c.Time)
+	}
+	return m.ID != nil && m.ID.Hex() < c.ID
+}
+
+// NextCursor returns the cursor continuing after the page list, or nil when it was the last page
+func NextCursor(list []*Model, size int64) *cursor.Cursor {
+	if len(list) == 0 || size <= 0 || int64(len(list)) < size {
+		return nil
+	}
+	return Key(list[len(list)-1])
+}
@@ -0,0 +1,144 @@
+package memory
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/rgynn/klottr/pkg/ban"
+	"github.com/rgynn/klottr/pkg/config"
+	"github.com/rgynn/klottr/pkg/cursor"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Repository for bans kept in memory
+type Repository struct {
+	mu   sync.RWMutex
+	cfg  *config.Config
+	bans []*ban.Model
+}
+
+func NewRepository(cfg *config.Config) (ban.Repository, error) {
+
+	if cfg == nil {
+		return nil, errors.New("no cfg *config.Config provided")
+	}
+
+	return &Repository{
+		cfg:  cfg,
+		bans: []*ban.Model{},
+	}, nil
+}
+
+func (repo *Repository) Create(ctx context.Context, m *ban.Model) error {
+
+	if m == nil {
+		return errors.New("no m *ban.Model provided")
+	}
+
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	stored := clone(m)
+	if stored.ID == nil {
+		id := primitive.NewObjectID()
+		stored.ID = &id
+	}
+
+	repo.bans = append(repo.bans, stored)
+
+	return nil
+}
+
+func (repo *Repository) ListActive(ctx context.Context, username *string, now time.Time) ([]*ban.Model, error) {
+
+	if username == nil {
+		return nil, errors.New("no username provided")
+	}
+
+	repo.mu.RLock()
+	defer repo.mu.RUnlock()
+
+	result := []*ban.Model{}
+	for _, m := range repo.bans {
+		if equalString(m.Username, username) && m.Active(now) {
+			result = append(result, clone(m))
+		}
+	}
+
+	return result, nil
+}
+
+func (repo *Repository) List(ctx context.Context, category *string, now time.Time, after *cursor.Cursor, from, size int64) ([]*ban.Model, error) {
+
+	if after != nil {
+		from = 0
+	}
+
+	repo.mu.RLock()
+	defer repo.mu.RUnlock()
+
+	matches := []*ban.Model{}
+	for _, m := range repo.bans {
+		if equalString(m.Category, category) && m.Active(now) && ban.After(m, after) {
+			matches = append(matches, m)
+		}
+	}
+
+	sort.SliceStable(matches, func(i, j int) bool {
+		return ban.After(matches[j], ban.Key(matches[i]))
+	})
+
+	result := []*ban.Model{}
+	for i, m := range matches {
+		if int64(i) < from {
+			continue
+		}
+		if size > 0 && int64(len(result)) >= size {
+			break
+		}
+		result = append(result, clone(m))
+	}
+
+	return result, nil
+}
+
+func (repo *Repository) Delete(ctx context.Context, username, category *string) error {
+
+	if username == nil {
+		return errors.New("no username provided")
+	}
+
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	kept := repo.bans[:0]
+	for _, m := range repo.bans {
+		if equalString(m.Username, username) && equalString(m.Category, category) {
+			continue
+		}
+		kept = append(kept, m)
+	}
+
+	if len(kept) == len(repo.bans) {
+		return ban.ErrNotFound
+	}
+
+	repo.bans = kept
+
+	return nil
+}
+
+func clone(m *ban.Model) *ban.Model {
+	c := *m
+	return &c
+}
+
+func equalString(a, b *string) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return *a == *b
+}
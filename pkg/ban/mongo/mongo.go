@@ -0,0 +1,154 @@
+package mongo
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/rgynn/klottr/pkg/ban"
+	"github.com/rgynn/klottr/pkg/config"
+	"github.com/rgynn/klottr/pkg/cursor"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type Repository struct {
+	database   string
+	collection string
+	cfg        *config.Config
+	client     *mongo.Client
+}
+
+func NewRepository(cfg *config.Config, client *mongo.Client) (ban.Repository, error) {
+
+	if cfg == nil {
+		return nil, errors.New("no cfg *config.Config provided")
+	}
+
+	if client == nil {
+		return nil, errors.New("no client *mongo.Client provided")
+	}
+
+	return &Repository{
+		database:   cfg.DatabaseName,
+		collection: "bans",
+		cfg:        cfg,
+		client:     client,
+	}, nil
+}
+
+func (repo *Repository) Create(ctx context.Context, m *ban.Model) error {
+
+	if m == nil {
+		return errors.New("no m *ban.Model provided")
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, repo.cfg.RequestTimeout)
+	defer cancel()
+
+	_, err := repo.client.Database(repo.database).Collection(repo.collection).InsertOne(ctx, m)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (repo *Repository) ListActive(ctx context.Context, username *string, now time.Time) ([]*ban.Model, error) {
+
+	if username == nil {
+		return nil, errors.New("no username provided")
+	}
+
+	return repo.find(ctx, bson.D{
+		primitive.E{Key: "username", Value: *username},
+		active(now),
+	}, 0, 0)
+}
+
+func (repo *Repository) List(ctx context.Context, category *string, now time.Time, after *cursor.Cursor, from, size int64) ([]*ban.Model, error) {
+
+	filter := bson.D{
+		filterCategory(category),
+		active(now),
+	}
+
+	if after != nil && after.Time != nil {
+		id, err := primitive.ObjectIDFromHex(after.ID)
+		if err != nil {
+			return nil, err
+		}
+		filter = append(filter, primitive.E{Key: "$and", Value: bson.A{
+			bson.M{"$or": bson.A{
+				bson.M{"created": bson.M{"$lt": *after.Time}},
+				bson.M{"created": *after.Time, "_id": bson.M{"$lt": id}},
+			}},
+		}})
+		from = 0
+	}
+
+	return repo.find(ctx, filter, from, size)
+}
+
+func (repo *Repository) Delete(ctx context.Context, username, category *string) error {
+
+	if username == nil {
+		return errors.New("no username provided")
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, repo.cfg.RequestTimeout)
+	defer cancel()
+
+	res, err := repo.client.Database(repo.database).Collection(repo.collection).DeleteMany(ctx, bson.D{
+		primitive.E{Key: "username", Value: *username},
+		filterCategory(category),
+	})
+	if err != nil {
+		return err
+	}
+
+	if res.DeletedCount == 0 {
+		return ban.ErrNotFound
+	}
+
+	return nil
+}
+
+func (repo *Repository) find(ctx context.Context, filter bson.D, from, size int64) ([]*ban.Model, error) {
+
+	ctx, cancel := context.WithTimeout(ctx, repo.cfg.RequestTimeout)
+	defer cancel()
+
+	cursor, err := repo.client.Database(repo.database).Collection(repo.collection).Find(ctx, filter, options.Find().SetSort(bson.D{
+		primitive.E{Key: "created", Value: -1},
+		primitive.E{Key: "_id", Value: -1},
+	}).SetSkip(from).SetLimit(size))
+	if err != nil {
+		return nil, err
+	}
+
+	result := []*ban.Model{}
+	if err := cursor.All(ctx, &result); err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+// active matches the bans that have not expired at now
+func active(now time.Time) primitive.E {
+	return primitive.E{Key: "$or", Value: bson.A{
+		bson.M{"expires": bson.M{"$exists": false}},
+		bson.M{"expires": bson.M{"$gt": now}},
+	}}
+}
+
+// filterCategory matches the bans in category, or the site wide bans when category is nil
+func filterCategory(category *string) primitive.E {
+	if category == nil {
+		return primitive.E{Key: "category", Value: bson.M{"$exists": false}}
+	}
+	return primitive.E{Key: "category", Value: *category}
+}
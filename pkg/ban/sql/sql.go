@@ -0,0 +1,180 @@
+package sql
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/rgynn/klottr/pkg/ban"
+	"github.com/rgynn/klottr/pkg/config"
+	"github.com/rgynn/klottr/pkg/cursor"
+	"github.com/rgynn/klottr/pkg/sqldb"
+)
+
+const columns = `id, username, category, reason, created_by, created, expires`
+
+// Repository for bans in a sql database
+type Repository struct {
+	cfg *config.Config
+	db  *sqldb.DB
+}
+
+func NewRepository(cfg *config.Config, db *sqldb.DB) (ban.Repository, error) {
+
+	if cfg == nil {
+		return nil, errors.New("no cfg *config.Config provided")
+	}
+
+	if db == nil {
+		return nil, errors.New("no db *sqldb.DB provided")
+	}
+
+	return &Repository{
+		cfg: cfg,
+		db:  db,
+	}, nil
+}
+
+func (repo *Repository) Create(ctx context.Context, m *ban.Model) error {
+
+	if m == nil {
+		return errors.New("no m *ban.Model provided")
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, repo.cfg.RequestTimeout)
+	defer cancel()
+
+	id := m.ID
+	if id == nil {
+		id = sqldb.NewID()
+	}
+
+	_, err := repo.db.Exec(ctx, `INSERT INTO bans (`+columns+`) VALUES (?, ?, ?, ?, ?, ?, ?)`,
+		id.Hex(),
+		m.Username,
+		m.Category,
+		m.Reason,
+		m.CreatedBy,
+		m.Created,
+		m.Expires,
+	)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (repo *Repository) ListActive(ctx context.Context, username *string, now time.Time) ([]*ban.Model, error) {
+
+	if username == nil {
+		return nil, errors.New("no username provided")
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, repo.cfg.RequestTimeout)
+	defer cancel()
+
+	return repo.query(ctx, `SELECT `+columns+` FROM bans WHERE username = ? AND (expires IS NULL OR expires > ?) ORDER BY created DESC, id DESC`, *username, now)
+}
+
+func (repo *Repository) List(ctx context.Context, category *string, now time.Time, after *cursor.Cursor, from, size int64) ([]*ban.Model, error) {
+
+	where, args := filterCategory(category)
+	where += ` AND (expires IS NULL OR expires > ?)`
+	args = append(args, now)
+
+	if after != nil && after.Time != nil {
+		where += ` AND (created < ? OR (created = ? AND id < ?))`
+		args = append(args, *after.Time, *after.Time, after.ID)
+		from = 0
+	}
+
+	limit, limitArgs := repo.db.LimitOffset(from, size)
+
+	ctx, cancel := context.WithTimeout(ctx, repo.cfg.RequestTimeout)
+	defer cancel()
+
+	return repo.query(ctx, `SELECT `+columns+` FROM bans WHERE `+where+` ORDER BY created DESC, id DESC`+limit, append(args, limitArgs...)...)
+}
+
+func (repo *Repository) Delete(ctx context.Context, username, category *string) error {
+
+	if username == nil {
+		return errors.New("no username provided")
+	}
+
+	where, args := filterCategory(category)
+
+	ctx, cancel := context.WithTimeout(ctx, repo.cfg.RequestTimeout)
+	defer cancel()
+
+	res, err := repo.db.Exec(ctx, `DELETE FROM bans WHERE username = ? AND `+where, append([]interface{}{*username}, args...)...)
+	if err != nil {
+		return err
+	}
+
+	if n, err := res.RowsAffected(); err != nil || n == 0 {
+		return ban.ErrNotFound
+	}
+
+	return nil
+}
+
+// filterCategory matches the bans in category, or the site wide bans when category is nil
+func filterCategory(category *string) (string, []interface{}) {
+	if category == nil {
+		return `category IS NULL`, []interface{}{}
+	}
+	return `category = ?`, []interface{}{*category}
+}
+
+func (repo *Repository) query(ctx context.Context, query string, args ...interface{}) ([]*ban.Model, error) {
+
+	rows, err := repo.db.Query(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	result := []*ban.Model{}
+	for rows.Next() {
+		m, err := scan(rows)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, m)
+	}
+
+	return result, rows.Err()
+}
+
+type scanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scan(row scanner) (*ban.Model, error) {
+
+	m := new(ban.Model)
+	var id sql.NullString
+
+	if err := row.Scan(
+		&id,
+		&m.Username,
+		&m.Category,
+		&m.Reason,
+		&m.CreatedBy,
+		&m.Created,
+		&m.Expires,
+	); err != nil {
+		return nil, err
+	}
+
+	var err error
+
+	if m.ID, err = sqldb.ParseID(id); err != nil {
+		return nil, err
+	}
+
+	return m, nil
+}
@@ -0,0 +1,144 @@
+package category
+
+import (
+	"context"
+	"errors"
+	"regexp"
+	"time"
+	"unicode/utf8"
+
+	"github.com/rgynn/ptrconv"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+var ErrNotFound = errors.New("category not found")
+
+var ErrAlreadyExists = errors.New("category already exists")
+
+var ErrArchived = errors.New("category archived")
+
+var validName = regexp.MustCompile(`^[a-z0-9_]{1,32}$`)
+
+type Repository interface {
+	Create(ctx context.Context, m *Model) error
+	Get(ctx context.Context, name *string) (*Model, error)
+	List(ctx context.Context, archived bool) ([]*Model, error)
+	Archive(ctx context.Context, name *string) error
+}
+
+// Rules for posting new threads in a category
+type Rules struct {
+	AdminOnly        bool `json:"admin_only"  bson:"admin_only"`
+	RequireURL       bool `json:"require_url"  bson:"require_url"`
+	DisallowURL      bool `json:"disallow_url"  bson:"disallow_url"`
+	MaxContentLength int  `json:"max_content_length,omitempty"  bson:"max_content_length,omitempty"`
+}
+
+type Model struct {
+	ID          *primitive.ObjectID `json:"id,omitempty" bson:"_id,omitempty"`
+	Name        *string             `json:"name"  bson:"name"`
+	Description string              `json:"description"  bson:"description"`
+	TTLSeconds  int32               `json:"ttl_seconds,omitempty"  bson:"ttl_seconds,omitempty"`
+	Rules       Rules               `json:"rules"  bson:"rules"`
+	Created     *time.Time          `json:"created"  bson:"created"`
+	Archived    *time.Time          `json:"archived,omitempty"  bson:"archived,omitempty"`
+}
+
+func (m *Model) IsArchived() bool {
+	return m.Archived != nil
+}
+
+// TTL returns the number of seconds threads in the category live, falling back to defaultSeconds
+func (m *Model) TTL(defaultSeconds int32) int32 {
+	if m.TTLSeconds > 0 {
+		return m.TTLSeconds
+	}
+	return defaultSeconds
+}
+
+func (m *Model) ValidForSave() error {
+
+	if m == nil {
+		return errors.New("no m *category.Model provided")
+	}
+
+	if m.ID != nil {
+		return errors.New("cannot provide m.ID for new category")
+	}
+
+	if m.Name == nil {
+		return errors.New("no m.Name provided")
+	}
+
+	if !validName.MatchString(*m.Name) {
+		return errors.New("m.Name must be 1-32 characters of a-z, 0-9 or _")
+	}
+
+	if utf8.RuneCountInString(m.Description) > 500 {
+		return errors.New("m.Description too long")
+	}
+
+	if m.TTLSeconds < 0 {
+		return errors.New("m.TTLSeconds cannot be negative")
+	}
+
+	if m.Rules.RequireURL && m.Rules.DisallowURL {
+		return errors.New("m.Rules cannot both require and disallow urls")
+	}
+
+	if m.Rules.MaxContentLength < 0 || m.Rules.MaxContentLength > 3000 {
+		return errors.New("m.Rules.MaxContentLength must be between 0 and 3000")
+	}
+
+	if m.Created == nil || m.Created.IsZero() {
+		return errors.New("no m.Created provided")
+	}
+
+	if m.Archived != nil {
+		return errors.New("cannot provide m.Archived for new category")
+	}
+
+	return nil
+}
+
+// ValidForPost checks a new thread against the posting rules of the category
+func (m *Model) ValidForPost(admin bool, url *string, content string) error {
+
+	if m == nil {
+		return errors.New("no m *category.Model provided")
+	}
+
+	if m.IsArchived() {
+		return ErrArchived
+	}
+
+	if m.Rules.AdminOnly && !admin {
+		return errors.New("only admins can post in this category")
+	}
+
+	if m.Rules.RequireURL && (url == nil || *url == "") {
+		return errors.New("threads in this category require an url")
+	}
+
+	if m.Rules.DisallowURL && url != nil && *url != "" {
+		return errors.New("threads in this category cannot have an url")
+	}
+
+	if m.Rules.MaxContentLength > 0 && utf8.RuneCountInString(content) > m.Rules.MaxContentLength {
+		return errors.New("content too long for this category")
+	}
+
+	return nil
+}
+
+// Defaults returns the categories created when none exist yet
+func Defaults() []*Model {
+	now := time.Now().UTC()
+	return []*Model{
+		{
+			Name:        ptrconv.StringPtr("misc"),
+			Description: "Miscellaneous",
+			Created:     &now,
+		},
+	}
+}
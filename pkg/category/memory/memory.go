@@ -0,0 +1,122 @@
+package memory
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/rgynn/klottr/pkg/category"
+	"github.com/rgynn/klottr/pkg/config"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Repository for categories kept in memory
+type Repository struct {
+	mu         sync.RWMutex
+	cfg        *config.Config
+	categories map[string]*category.Model
+}
+
+func NewRepository(cfg *config.Config) (category.Repository, error) {
+
+	if cfg == nil {
+		return nil, errors.New("no cfg *config.Config provided")
+	}
+
+	return &Repository{
+		cfg:        cfg,
+		categories: map[string]*category.Model{},
+	}, nil
+}
+
+func (repo *Repository) Create(ctx context.Context, m *category.Model) error {
+
+	if m == nil {
+		return errors.New("no m *category.Model provided")
+	}
+
+	if m.Name == nil {
+		return errors.New("no m.Name provided")
+	}
+
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	if _, ok := repo.categories[*m.Name]; ok {
+		return category.ErrAlreadyExists
+	}
+
+	stored := clone(m)
+	if stored.ID == nil {
+		id := primitive.NewObjectID()
+		stored.ID = &id
+	}
+
+	repo.categories[*m.Name] = stored
+
+	return nil
+}
+
+func (repo *Repository) Get(ctx context.Context, name *string) (*category.Model, error) {
+
+	if name == nil {
+		return nil, errors.New("no name provided")
+	}
+
+	repo.mu.RLock()
+	defer repo.mu.RUnlock()
+
+	m, ok := repo.categories[*name]
+	if !ok {
+		return nil, category.ErrNotFound
+	}
+
+	return clone(m), nil
+}
+
+func (repo *Repository) List(ctx context.Context, archived bool) ([]*category.Model, error) {
+
+	repo.mu.RLock()
+	defer repo.mu.RUnlock()
+
+	result := []*category.Model{}
+	for _, m := range repo.categories {
+		if m.IsArchived() && !archived {
+			continue
+		}
+		result = append(result, clone(m))
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		return *result[i].Name < *result[j].Name
+	})
+
+	return result, nil
+}
+
+func (repo *Repository) Archive(ctx context.Context, name *string) error {
+
+	if name == nil {
+		return errors.New("no name provided")
+	}
+
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	m, ok := repo.categories[*name]
+	if !ok {
+		return category.ErrNotFound
+	}
+
+	now := time.Now().UTC()
+	m.Archived = &now
+
+	return nil
+}
+
+func clone(m *category.Model) *category.Model {
+	c := *m
+	return &c
+}
@@ -0,0 +1,141 @@
+package mongo
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/rgynn/klottr/pkg/category"
+	"github.com/rgynn/klottr/pkg/config"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Repository for categories in mongo cluster
+type Repository struct {
+	database   string
+	collection string
+	cfg        *config.Config
+	client     *mongo.Client
+}
+
+func NewRepository(cfg *config.Config, client *mongo.Client) (category.Repository, error) {
+
+	if cfg == nil {
+		return nil, errors.New("no cfg *config.Config provided")
+	}
+
+	if client == nil {
+		return nil, errors.New("no client *mongo.Client provided")
+	}
+
+	return &Repository{
+		database:   cfg.DatabaseName,
+		collection: "categories",
+		cfg:        cfg,
+		client:     client,
+	}, nil
+}
+
+func (repo *Repository) Create(ctx context.Context, m *category.Model) error {
+
+	if m == nil {
+		return errors.New("no m *category.Model provided")
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, repo.cfg.RequestTimeout)
+	defer cancel()
+
+	_, err := repo.client.Database(repo.database).Collection(repo.collection).InsertOne(ctx, m)
+	if err != nil {
+		if mongo.IsDuplicateKeyError(err) {
+			return category.ErrAlreadyExists
+		}
+		return err
+	}
+
+	return nil
+}
+
+func (repo *Repository) Get(ctx context.Context, name *string) (*category.Model, error) {
+
+	if name == nil {
+		return nil, errors.New("no name provided")
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, repo.cfg.RequestTimeout)
+	defer cancel()
+
+	var result *category.Model
+	if err := repo.client.Database(repo.database).Collection(repo.collection).FindOne(ctx, bson.D{
+		primitive.E{Key: "name", Value: *name},
+	}).Decode(&result); err != nil {
+		switch err {
+		case mongo.ErrNoDocuments:
+			return nil, category.ErrNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return result, nil
+}
+
+func (repo *Repository) List(ctx context.Context, archived bool) ([]*category.Model, error) {
+
+	filter := bson.D{}
+	if !archived {
+		filter = append(filter, primitive.E{Key: "archived", Value: bson.D{
+			primitive.E{Key: "$exists", Value: false},
+		}})
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, repo.cfg.RequestTimeout)
+	defer cancel()
+
+	cursor, err := repo.client.Database(repo.database).Collection(repo.collection).Find(ctx, filter, options.Find().SetSort(bson.D{
+		primitive.E{Key: "name", Value: 1},
+	}))
+	if err != nil {
+		return nil, err
+	}
+
+	result := []*category.Model{}
+	if err := cursor.All(ctx, &result); err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+func (repo *Repository) Archive(ctx context.Context, name *string) error {
+
+	if name == nil {
+		return errors.New("no name provided")
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, repo.cfg.RequestTimeout)
+	defer cancel()
+
+	res, err := repo.client.Database(repo.database).Collection(repo.collection).UpdateOne(ctx,
+		bson.D{primitive.E{Key: "name", Value: *name}},
+		bson.D{primitive.E{
+			Key: "$set",
+			Value: bson.D{primitive.E{
+				Key:   "archived",
+				Value: time.Now().UTC(),
+			}},
+		}},
+	)
+	if err != nil {
+		return err
+	}
+
+	if res.MatchedCount != 1 {
+		return category.ErrNotFound
+	}
+
+	return nil
+}
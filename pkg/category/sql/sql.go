@@ -0,0 +1,176 @@
+package sql
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/rgynn/klottr/pkg/category"
+	"github.com/rgynn/klottr/pkg/config"
+	"github.com/rgynn/klottr/pkg/sqldb"
+)
+
+const columns = `id, name, description, ttl_seconds, rules_admin_only, rules_require_url, rules_disallow_url, rules_max_content_length, created, archived`
+
+// Repository for categories in a sql database
+type Repository struct {
+	cfg *config.Config
+	db  *sqldb.DB
+}
+
+func NewRepository(cfg *config.Config, db *sqldb.DB) (category.Repository, error) {
+
+	if cfg == nil {
+		return nil, errors.New("no cfg *config.Config provided")
+	}
+
+	if db == nil {
+		return nil, errors.New("no db *sqldb.DB provided")
+	}
+
+	return &Repository{
+		cfg: cfg,
+		db:  db,
+	}, nil
+}
+
+func (repo *Repository) Create(ctx context.Context, m *category.Model) error {
+
+	if m == nil {
+		return errors.New("no m *category.Model provided")
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, repo.cfg.RequestTimeout)
+	defer cancel()
+
+	id := m.ID
+	if id == nil {
+		id = sqldb.NewID()
+	}
+
+	_, err := repo.db.Exec(ctx, `INSERT INTO categories (`+columns+`) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		id.Hex(),
+		m.Name,
+		m.Description,
+		m.TTLSeconds,
+		m.Rules.AdminOnly,
+		m.Rules.RequireURL,
+		m.Rules.DisallowURL,
+		m.Rules.MaxContentLength,
+		m.Created,
+		m.Archived,
+	)
+	if err != nil {
+		if sqldb.IsUniqueViolation(err) {
+			return category.ErrAlreadyExists
+		}
+		return err
+	}
+
+	return nil
+}
+
+func (repo *Repository) Get(ctx context.Context, name *string) (*category.Model, error) {
+
+	if name == nil {
+		return nil, errors.New("no name provided")
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, repo.cfg.RequestTimeout)
+	defer cancel()
+
+	result, err := scan(repo.db.QueryRow(ctx, `SELECT `+columns+` FROM categories WHERE name = ?`, *name))
+	if err != nil {
+		switch err {
+		case sql.ErrNoRows:
+			return nil, category.ErrNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return result, nil
+}
+
+func (repo *Repository) List(ctx context.Context, archived bool) ([]*category.Model, error) {
+
+	query := `SELECT ` + columns + ` FROM categories`
+	if !archived {
+		query += ` WHERE archived IS NULL`
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, repo.cfg.RequestTimeout)
+	defer cancel()
+
+	rows, err := repo.db.Query(ctx, query+` ORDER BY name`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	result := []*category.Model{}
+	for rows.Next() {
+		m, err := scan(rows)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, m)
+	}
+
+	return result, rows.Err()
+}
+
+func (repo *Repository) Archive(ctx context.Context, name *string) error {
+
+	if name == nil {
+		return errors.New("no name provided")
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, repo.cfg.RequestTimeout)
+	defer cancel()
+
+	res, err := repo.db.Exec(ctx, `UPDATE categories SET archived = ? WHERE name = ?`, time.Now().UTC(), *name)
+	if err != nil {
+		return err
+	}
+
+	if n, err := res.RowsAffected(); err != nil || n != 1 {
+		return category.ErrNotFound
+	}
+
+	return nil
+}
+
+type scanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scan(row scanner) (*category.Model, error) {
+
+	m := new(category.Model)
+	var id sql.NullString
+
+	if err := row.Scan(
+		&id,
+		&m.Name,
+		&m.Description,
+		&m.TTLSeconds,
+		&m.Rules.AdminOnly,
+		&m.Rules.RequireURL,
+		&m.Rules.DisallowURL,
+		&m.Rules.MaxContentLength,
+		&m.Created,
+		&m.Archived,
+	); err != nil {
+		return nil, err
+	}
+
+	var err error
+
+	if m.ID, err = sqldb.ParseID(id); err != nil {
+		return nil, err
+	}
+
+	return m, nil
+}
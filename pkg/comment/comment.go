@@ -6,6 +6,7 @@ import (
 	"time"
 	"unicode/utf8"
 
+	"github.com/rgynn/klottr/pkg/cursor"
 	"github.com/rgynn/klottr/pkg/helper"
 	"github.com/rgynn/ptrconv"
 	"go.mongodb.org/mongo-driver/bson/primitive"
@@ -13,12 +14,27 @@ import (
 
 var ErrNotFound = errors.New("comment not found")
 
+var ErrDeleted = errors.New("comment deleted")
+
+// Tombstone replaces the content of deleted comments in responses
+const Tombstone = "[deleted]"
+
 type Repository interface {
 	Create(ctx context.Context, m *Model) error
 	Get(ctx context.Context, slugID *string) (*Model, error)
-	ListByThreadID(ctx context.Context, threadID *primitive.ObjectID, from, size int64) ([]*Model, error)
+	ListByThreadID(ctx context.Context, threadID *primitive.ObjectID, after *cursor.Cursor, from, size int64) ([]*Model, error)
 	ListByUsername(ctx context.Context, username *string, from, size int64) ([]*Model, error)
+	// Delete removes the comment, comments deleted by users are marked with SoftDelete and
+	// removed once the retention period has passed
 	Delete(ctx context.Context, slugID *string) error
+	// SoftDelete marks the comment as deleted at m.Deleted by m.DeletedBy
+	SoftDelete(ctx context.Context, slugID *string, m *Model) error
+	// ListDeleted returns the comments deleted before, in the same order on every call
+	ListDeleted(ctx context.Context, before time.Time, from, size int64) ([]*Model, error)
+	// DeleteByThreadID deletes every comment of a thread and returns the number deleted
+	DeleteByThreadID(ctx context.Context, threadID *primitive.ObjectID) (int64, error)
+	// Edit replaces the content of the comment and marks it as edited at m.Updated
+	Edit(ctx context.Context, slugID *string, m *Model) error
 
 	IncVotes(ctx context.Context, slugID *string, value int8) error
 }
@@ -27,12 +43,37 @@ type Model struct {
 	ID        *primitive.ObjectID `json:"id,omitempty" bson:"_id,omitempty"`
 	ThreadID  *primitive.ObjectID `json:"thread_id,omitempty" bson:"thread_id,omitempty"`
 	ReplyToID *primitive.ObjectID `json:"reply_to_id,omitempty"  bson:"reply_to_id,omitempty"`
+	Depth     int32               `json:"depth"  bson:"depth"`
 	SlugID    *string             `json:"slug_id,omitempty"  bson:"slug_id,omitempty"`
 	Username  *string             `json:"username,omitempty"  bson:"username,omitempty"`
 	Content   string              `json:"content"  bson:"content"`
 	Votes     int64               `json:"votes"  bson:"votes"`
 	Updated   *time.Time          `json:"updated,omitempty"  bson:"updated,omitempty"`
+	Edited    bool                `json:"edited"  bson:"edited"`
 	Created   time.Time           `json:"created"  bson:"created"`
+	Deleted   *time.Time          `json:"deleted,omitempty"  bson:"deleted,omitempty"`
+	DeletedBy *string             `json:"deleted_by,omitempty"  bson:"deleted_by,omitempty"`
+}
+
+// IsDeleted reports whether the comment has been soft deleted
+func (m *Model) IsDeleted() bool {
+	return m.Deleted != nil
+}
+
+// Redacted returns m as shown to users, deleted comments keep their place in the tree
+// but have their author and content replaced
+func (m *Model) Redacted() *Model {
+
+	if !m.IsDeleted() {
+		return m
+	}
+
+	c := *m
+	c.Username = nil
+	c.Content = Tombstone
+	c.DeletedBy = nil
+
+	return &c
 }
 
 func (m *Model) ValidForSave() error {
@@ -53,6 +94,14 @@ func (m *Model) ValidForSave() error {
 		return errors.New("no m.Username provided for new thread")
 	}
 
+	if m.Depth < 0 || m.Depth > MaxDepth {
+		return ErrMaxDepth
+	}
+
+	if (m.ReplyToID == nil) != (m.Depth == 0) {
+		return errors.New("m.ReplyToID must be provided for replies only")
+	}
+
 	if m.Content == "" {
 		return errors.New("no m.Content provided")
 	}
@@ -74,6 +123,28 @@ func (m *Model) ValidForSave() error {
 	return nil
 }
 
+// ValidForEdit runs the ValidForSave rules on an edited comment
+func (m *Model) ValidForEdit() error {
+
+	if m == nil {
+		return errors.New("no m *comment.Model provided")
+	}
+
+	if m.ID == nil {
+		return errors.New("no m.ID provided for edited comment")
+	}
+
+	if m.Updated == nil || m.Updated.IsZero() {
+		return errors.New("no m.Updated provided for edited comment")
+	}
+
+	c := *m
+	c.ID = nil
+	c.Votes = 0
+
+	return c.ValidForSave()
+}
+
 func (m *Model) GenerateSlugs() error {
 
 	if m == nil {
@@ -84,3 +155,32 @@ func (m *Model) GenerateSlugs() error {
 
 	return nil
 }
+
+// Key of m, used as the cursor to continue listing comments oldest first after m
+func Key(m *Model) *cursor.Cursor {
+	created := m.Created
+	c := &cursor.Cursor{Time: &created}
+	if m.ID != nil {
+		c.ID = m.ID.Hex()
+	}
+	return c
+}
+
+// After reports whether m is listed after the comment at c
+func After(m *Model, c *cursor.Cursor) bool {
+	if c == nil {
+		return true
+	}
+	if c.Time != nil && !m.Created.Equal(*c.Time) {
+		return m.Created.After(*c.Time)
+	}
+	return m.ID != nil && m.ID.Hex() > c.ID
+}
+
+// NextCursor returns the cursor continuing after the page list, or nil when it was the last page
+func NextCursor(list []*Model, size int64) *cursor.Cursor {
+	if len(list) == 0 || size <= 0 || int64(len(list)) < size {
+		return nil
+	}
+	return Key(list[len(list)-1])
+}
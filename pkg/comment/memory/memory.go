@@ -0,0 +1,288 @@
+package memory
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/rgynn/klottr/pkg/comment"
+	"github.com/rgynn/klottr/pkg/config"
+	"github.com/rgynn/klottr/pkg/cursor"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Repository for comments kept in memory
+type Repository struct {
+	mu       sync.RWMutex
+	cfg      *config.Config
+	comments []*comment.Model
+}
+
+func NewRepository(cfg *config.Config) (comment.Repository, error) {
+
+	if cfg == nil {
+		return nil, errors.New("no cfg *config.Config provided")
+	}
+
+	return &Repository{
+		cfg:      cfg,
+		comments: []*comment.Model{},
+	}, nil
+}
+
+func (repo *Repository) Create(ctx context.Context, m *comment.Model) error {
+
+	if m == nil {
+		return errors.New("no m *thread.Model provided")
+	}
+
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	repo.purge()
+
+	for _, existing := range repo.comments {
+		if equalString(existing.SlugID, m.SlugID) {
+			return errors.New("comment with slug_id already exists")
+		}
+	}
+
+	stored := clone(m)
+	if stored.ID == nil {
+		id := primitive.NewObjectID()
+		stored.ID = &id
+	}
+
+	repo.comments = append(repo.comments, stored)
+
+	return nil
+}
+
+func (repo *Repository) Get(ctx context.Context, slugID *string) (*comment.Model, error) {
+
+	if slugID == nil {
+		return nil, errors.New("no slugID provided")
+	}
+
+	repo.mu.RLock()
+	defer repo.mu.RUnlock()
+
+	i := repo.find(slugID)
+	if i < 0 {
+		return nil, comment.ErrNotFound
+	}
+
+	return clone(repo.comments[i]), nil
+}
+
+func (repo *Repository) ListByThreadID(ctx context.Context, threadID *primitive.ObjectID, after *cursor.Cursor, from, size int64) ([]*comment.Model, error) {
+
+	if threadID == nil {
+		return nil, errors.New("no theadID provided")
+	}
+
+	if after != nil {
+		from = 0
+	}
+
+	return repo.list(func(m *comment.Model) bool {
+		return m.ThreadID != nil && *m.ThreadID == *threadID && comment.After(m, after)
+	}, from, size), nil
+}
+
+func (repo *Repository) ListByUsername(ctx context.Context, username *string, from, size int64) ([]*comment.Model, error) {
+
+	if username == nil {
+		return nil, errors.New("no username provided")
+	}
+
+	return repo.list(func(m *comment.Model) bool {
+		return equalString(m.Username, username)
+	}, from, size), nil
+}
+
+func (repo *Repository) Delete(ctx context.Context, slugID *string) error {
+
+	if slugID == nil {
+		return errors.New("no slugID provided")
+	}
+
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	i := repo.find(slugID)
+	if i < 0 {
+		return comment.ErrNotFound
+	}
+
+	repo.comments = append(repo.comments[:i], repo.comments[i+1:]...)
+
+	return nil
+}
+
+func (repo *Repository) DeleteByThreadID(ctx context.Context, threadID *primitive.ObjectID) (int64, error) {
+
+	if threadID == nil {
+		return 0, errors.New("no threadID provided")
+	}
+
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	var deleted int64
+	live := repo.comments[:0]
+	for _, m := range repo.comments {
+		if m.ThreadID != nil && *m.ThreadID == *threadID {
+			deleted++
+			continue
+		}
+		live = append(live, m)
+	}
+	repo.comments = live
+
+	return deleted, nil
+}
+
+func (repo *Repository) SoftDelete(ctx context.Context, slugID *string, m *comment.Model) error {
+
+	if slugID == nil {
+		return errors.New("no slugID provided")
+	}
+
+	if m == nil || m.Deleted == nil {
+		return errors.New("no m.Deleted provided")
+	}
+
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	i := repo.find(slugID)
+	if i < 0 {
+		return comment.ErrNotFound
+	}
+
+	stored := repo.comments[i]
+	stored.Deleted = m.Deleted
+	stored.DeletedBy = m.DeletedBy
+
+	return nil
+}
+
+func (repo *Repository) ListDeleted(ctx context.Context, before time.Time, from, size int64) ([]*comment.Model, error) {
+	return repo.list(func(m *comment.Model) bool {
+		return m.IsDeleted() && m.Deleted.Before(before)
+	}, from, size), nil
+}
+
+func (repo *Repository) Edit(ctx context.Context, slugID *string, m *comment.Model) error {
+
+	if slugID == nil {
+		return errors.New("no slugID provided")
+	}
+
+	if m == nil {
+		return errors.New("no m *comment.Model provided")
+	}
+
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	i := repo.find(slugID)
+	if i < 0 {
+		return comment.ErrNotFound
+	}
+
+	stored := repo.comments[i]
+	stored.Content = m.Content
+	stored.Updated = m.Updated
+	stored.Edited = true
+
+	return nil
+}
+
+func (repo *Repository) IncVotes(ctx context.Context, slugID *string, value int8) error {
+
+	if slugID == nil {
+		return errors.New("no slugID provided")
+	}
+
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	i := repo.find(slugID)
+	if i < 0 {
+		return comment.ErrNotFound
+	}
+
+	repo.comments[i].Votes += int64(value)
+
+	return nil
+}
+
+// list returns the comments matching filter with skip/limit applied in insertion order
+func (repo *Repository) list(filter func(m *comment.Model) bool, from, size int64) []*comment.Model {
+
+	repo.mu.RLock()
+	defer repo.mu.RUnlock()
+
+	result := []*comment.Model{}
+
+	var skipped int64
+	for _, m := range repo.comments {
+		if repo.expired(m) || !filter(m) {
+			continue
+		}
+		if skipped < from {
+			skipped++
+			continue
+		}
+		if size > 0 && int64(len(result)) >= size {
+			break
+		}
+		result = append(result, clone(m))
+	}
+
+	return result
+}
+
+// find returns the index of the comment with slugID, or -1, callers must hold the lock
+func (repo *Repository) find(slugID *string) int {
+	for i, m := range repo.comments {
+		if !repo.expired(m) && equalString(m.SlugID, slugID) {
+			return i
+		}
+	}
+	return -1
+}
+
+// expired mirrors the expireAfterSeconds index on created in mongo
+func (repo *Repository) expired(m *comment.Model) bool {
+	if repo.cfg.PostTTLSeconds <= 0 {
+		return false
+	}
+	return time.Since(m.Created) > time.Duration(repo.cfg.PostTTLSeconds)*time.Second
+}
+
+// purge removes expired comments, callers must hold the write lock
+func (repo *Repository) purge() {
+	live := repo.comments[:0]
+	for _, m := range repo.comments {
+		if !repo.expired(m) {
+			live = append(live, m)
+		}
+	}
+	repo.comments = live
+}
+
+func clone(m *comment.Model) *comment.Model {
+	c := *m
+	return &c
+}
+
+func equalString(a, b *string) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return *a == *b
+}
@@ -3,9 +3,11 @@ package mongo
 import (
 	"context"
 	"errors"
+	"time"
 
 	"github.com/rgynn/klottr/pkg/comment"
 	"github.com/rgynn/klottr/pkg/config"
+	"github.com/rgynn/klottr/pkg/cursor"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
@@ -67,24 +69,46 @@ func (repo *Repository) Get(ctx context.Context, slugID *string) (*comment.Model
 	if err := repo.client.Database(repo.database).Collection(repo.collection).FindOne(ctx, bson.D{
 		primitive.E{Key: "slug_id", Value: *slugID},
 	}).Decode(&result); err != nil {
-		return nil, err
+		switch err {
+		case mongo.ErrNoDocuments:
+			return nil, comment.ErrNotFound
+		default:
+			return nil, err
+		}
 	}
 
 	return result, nil
 }
 
-func (repo *Repository) ListByThreadID(ctx context.Context, threadID *primitive.ObjectID, from, size int64) ([]*comment.Model, error) {
+func (repo *Repository) ListByThreadID(ctx context.Context, threadID *primitive.ObjectID, after *cursor.Cursor, from, size int64) ([]*comment.Model, error) {
 
 	if threadID == nil {
 		return nil, errors.New("no theadID provided")
 	}
 
+	filter := bson.D{
+		primitive.E{Key: "thread_id", Value: *threadID},
+	}
+
+	if after != nil && after.Time != nil {
+		id, err := primitive.ObjectIDFromHex(after.ID)
+		if err != nil {
+			return nil, err
+		}
+		filter = append(filter, primitive.E{Key: "$or", Value: bson.A{
+			bson.M{"created": bson.M{"$gt": *after.Time}},
+			bson.M{"created": *after.Time, "_id": bson.M{"$gt": id}},
+		}})
+		from = 0
+	}
+
 	ctx, cancel := context.WithTimeout(ctx, repo.cfg.RequestTimeout)
 	defer cancel()
 
-	cursor, err := repo.client.Database(repo.database).Collection(repo.collection).Find(ctx, bson.D{
-		primitive.E{Key: "thread_id", Value: *threadID},
-	}, options.Find().SetSkip(from).SetLimit(size))
+	cursor, err := repo.client.Database(repo.database).Collection(repo.collection).Find(ctx, filter, options.Find().SetSort(bson.D{
+		primitive.E{Key: "created", Value: 1},
+		primitive.E{Key: "_id", Value: 1},
+	}).SetSkip(from).SetLimit(size))
 	if err != nil {
 		return nil, err
 	}
@@ -144,6 +168,117 @@ func (repo *Repository) Delete(ctx context.Context, slugID *string) error {
 	return nil
 }
 
+func (repo *Repository) DeleteByThreadID(ctx context.Context, threadID *primitive.ObjectID) (int64, error) {
+
+	if threadID == nil {
+		return 0, errors.New("no threadID provided")
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, repo.cfg.RequestTimeout)
+	defer cancel()
+
+	res, err := repo.client.Database(repo.database).Collection(repo.collection).DeleteMany(ctx, bson.D{
+		primitive.E{Key: "thread_id", Value: *threadID},
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return res.DeletedCount, nil
+}
+
+func (repo *Repository) SoftDelete(ctx context.Context, slugID *string, m *comment.Model) error {
+
+	if slugID == nil {
+		return errors.New("no slugID provided")
+	}
+
+	if m == nil || m.Deleted == nil {
+		return errors.New("no m.Deleted provided")
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, repo.cfg.RequestTimeout)
+	defer cancel()
+
+	res, err := repo.client.Database(repo.database).Collection(repo.collection).UpdateOne(ctx, bson.D{
+		primitive.E{Key: "slug_id", Value: *slugID},
+	}, bson.D{
+		primitive.E{
+			Key: "$set",
+			Value: bson.D{
+				primitive.E{Key: "deleted", Value: m.Deleted},
+				primitive.E{Key: "deleted_by", Value: m.DeletedBy},
+			},
+		}})
+	if err != nil {
+		return err
+	}
+
+	if res.MatchedCount != 1 {
+		return comment.ErrNotFound
+	}
+
+	return nil
+}
+
+func (repo *Repository) ListDeleted(ctx context.Context, before time.Time, from, size int64) ([]*comment.Model, error) {
+
+	ctx, cancel := context.WithTimeout(ctx, repo.cfg.RequestTimeout)
+	defer cancel()
+
+	cursor, err := repo.client.Database(repo.database).Collection(repo.collection).Find(ctx, bson.D{
+		primitive.E{Key: "deleted", Value: bson.M{"$lt": before}},
+	}, options.Find().SetSort(bson.D{
+		primitive.E{Key: "deleted", Value: 1},
+		primitive.E{Key: "_id", Value: 1},
+	}).SetSkip(from).SetLimit(size))
+	if err != nil {
+		return nil, err
+	}
+
+	result := []*comment.Model{}
+	if err := cursor.All(ctx, &result); err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+func (repo *Repository) Edit(ctx context.Context, slugID *string, m *comment.Model) error {
+
+	if slugID == nil {
+		return errors.New("no slugID provided")
+	}
+
+	if m == nil {
+		return errors.New("no m *comment.Model provided")
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, repo.cfg.RequestTimeout)
+	defer cancel()
+
+	res, err := repo.client.Database(repo.database).Collection(repo.collection).UpdateOne(ctx, bson.D{
+		primitive.E{Key: "slug_id", Value: *slugID},
+	}, bson.D{
+		primitive.E{
+			Key: "$set",
+			Value: bson.D{
+				primitive.E{Key: "content", Value: m.Content},
+				primitive.E{Key: "updated", Value: m.Updated},
+				primitive.E{Key: "edited", Value: true},
+			},
+		}})
+	if err != nil {
+		return err
+	}
+
+	if res.MatchedCount != 1 {
+		return comment.ErrNotFound
+	}
+
+	return nil
+}
+
 func (repo *Repository) IncVotes(ctx context.Context, slugID *string, value int8) error {
 
 	if slugID == nil {
@@ -0,0 +1,305 @@
+package sql
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/rgynn/klottr/pkg/comment"
+	"github.com/rgynn/klottr/pkg/config"
+	"github.com/rgynn/klottr/pkg/cursor"
+	"github.com/rgynn/klottr/pkg/sqldb"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const columns = `id, thread_id, reply_to_id, depth, slug_id, username, content, votes, created, updated, edited, deleted, deleted_by`
+
+// Repository for comments in a sql database
+type Repository struct {
+	cfg *config.Config
+	db  *sqldb.DB
+}
+
+func NewRepository(cfg *config.Config, db *sqldb.DB) (comment.Repository, error) {
+
+	if cfg == nil {
+		return nil, errors.New("no cfg *config.Config provided")
+	}
+
+	if db == nil {
+		return nil, errors.New("no db *sqldb.DB provided")
+	}
+
+	return &Repository{
+		cfg: cfg,
+		db:  db,
+	}, nil
+}
+
+func (repo *Repository) Create(ctx context.Context, m *comment.Model) error {
+
+	if m == nil {
+		return errors.New("no m *thread.Model provided")
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, repo.cfg.RequestTimeout)
+	defer cancel()
+
+	id := m.ID
+	if id == nil {
+		id = sqldb.NewID()
+	}
+
+	_, err := repo.db.Exec(ctx, `INSERT INTO comments (`+columns+`) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		id.Hex(),
+		sqldb.FormatID(m.ThreadID),
+		sqldb.FormatID(m.ReplyToID),
+		m.Depth,
+		m.SlugID,
+		m.Username,
+		m.Content,
+		m.Votes,
+		m.Created,
+		m.Updated,
+		m.Edited,
+		m.Deleted,
+		m.DeletedBy,
+	)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (repo *Repository) Get(ctx context.Context, slugID *string) (*comment.Model, error) {
+
+	if slugID == nil {
+		return nil, errors.New("no slugID provided")
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, repo.cfg.RequestTimeout)
+	defer cancel()
+
+	result, err := scan(repo.db.QueryRow(ctx, `SELECT `+columns+` FROM comments WHERE slug_id = ?`, *slugID))
+	if err != nil {
+		switch err {
+		case sql.ErrNoRows:
+			return nil, comment.ErrNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return result, nil
+}
+
+func (repo *Repository) ListByThreadID(ctx context.Context, threadID *primitive.ObjectID, after *cursor.Cursor, from, size int64) ([]*comment.Model, error) {
+
+	if threadID == nil {
+		return nil, errors.New("no theadID provided")
+	}
+
+	where := `thread_id = ?`
+	args := []interface{}{threadID.Hex()}
+
+	if after != nil && after.Time != nil {
+		where += ` AND (created > ? OR (created = ? AND id > ?))`
+		args = append(args, *after.Time, *after.Time, after.ID)
+		from = 0
+	}
+
+	return repo.list(ctx, where, args, from, size)
+}
+
+func (repo *Repository) ListByUsername(ctx context.Context, username *string, from, size int64) ([]*comment.Model, error) {
+
+	if username == nil {
+		return nil, errors.New("no username provided")
+	}
+
+	return repo.list(ctx, `username = ?`, []interface{}{*username}, from, size)
+}
+
+func (repo *Repository) Delete(ctx context.Context, slugID *string) error {
+
+	if slugID == nil {
+		return errors.New("no slugID provided")
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, repo.cfg.RequestTimeout)
+	defer cancel()
+
+	res, err := repo.db.Exec(ctx, `DELETE FROM comments WHERE slug_id = ?`, *slugID)
+	if err != nil {
+		return err
+	}
+
+	if n, err := res.RowsAffected(); err != nil || n != 1 {
+		return comment.ErrNotFound
+	}
+
+	return nil
+}
+
+func (repo *Repository) DeleteByThreadID(ctx context.Context, threadID *primitive.ObjectID) (int64, error) {
+
+	if threadID == nil {
+		return 0, errors.New("no threadID provided")
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, repo.cfg.RequestTimeout)
+	defer cancel()
+
+	res, err := repo.db.Exec(ctx, `DELETE FROM comments WHERE thread_id = ?`, threadID.Hex())
+	if err != nil {
+		return 0, err
+	}
+
+	return res.RowsAffected()
+}
+
+func (repo *Repository) SoftDelete(ctx context.Context, slugID *string, m *comment.Model) error {
+
+	if slugID == nil {
+		return errors.New("no slugID provided")
+	}
+
+	if m == nil || m.Deleted == nil {
+		return errors.New("no m.Deleted provided")
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, repo.cfg.RequestTimeout)
+	defer cancel()
+
+	res, err := repo.db.Exec(ctx, `UPDATE comments SET deleted = ?, deleted_by = ? WHERE slug_id = ?`, *m.Deleted, m.DeletedBy, *slugID)
+	if err != nil {
+		return err
+	}
+
+	if n, err := res.RowsAffected(); err != nil || n != 1 {
+		return comment.ErrNotFound
+	}
+
+	return nil
+}
+
+func (repo *Repository) ListDeleted(ctx context.Context, before time.Time, from, size int64) ([]*comment.Model, error) {
+	return repo.list(ctx, `deleted < ?`, []interface{}{before}, from, size)
+}
+
+func (repo *Repository) Edit(ctx context.Context, slugID *string, m *comment.Model) error {
+
+	if slugID == nil {
+		return errors.New("no slugID provided")
+	}
+
+	if m == nil {
+		return errors.New("no m *comment.Model provided")
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, repo.cfg.RequestTimeout)
+	defer cancel()
+
+	res, err := repo.db.Exec(ctx, `UPDATE comments SET content = ?, updated = ?, edited = ? WHERE slug_id = ?`, m.Content, m.Updated, true, *slugID)
+	if err != nil {
+		return err
+	}
+
+	if n, err := res.RowsAffected(); err != nil || n != 1 {
+		return comment.ErrNotFound
+	}
+
+	return nil
+}
+
+func (repo *Repository) IncVotes(ctx context.Context, slugID *string, value int8) error {
+
+	if slugID == nil {
+		return errors.New("no slugID provided")
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, repo.cfg.RequestTimeout)
+	defer cancel()
+
+	res, err := repo.db.Exec(ctx, `UPDATE comments SET votes = votes + ? WHERE slug_id = ?`, value, *slugID)
+	if err != nil {
+		return err
+	}
+
+	if n, err := res.RowsAffected(); err != nil || n != 1 {
+		return comment.ErrNotFound
+	}
+
+	return nil
+}
+
+func (repo *Repository) list(ctx context.Context, where string, args []interface{}, from, size int64) ([]*comment.Model, error) {
+
+	ctx, cancel := context.WithTimeout(ctx, repo.cfg.RequestTimeout)
+	defer cancel()
+
+	limit, limitArgs := repo.db.LimitOffset(from, size)
+
+	rows, err := repo.db.Query(ctx, `SELECT `+columns+` FROM comments WHERE `+where+` ORDER BY created, id`+limit, append(args, limitArgs...)...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	result := []*comment.Model{}
+	for rows.Next() {
+		m, err := scan(rows)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, m)
+	}
+
+	return result, rows.Err()
+}
+
+type scanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scan(row scanner) (*comment.Model, error) {
+
+	m := new(comment.Model)
+	var id, threadID, replyToID sql.NullString
+
+	if err := row.Scan(
+		&id,
+		&threadID,
+		&replyToID,
+		&m.Depth,
+		&m.SlugID,
+		&m.Username,
+		&m.Content,
+		&m.Votes,
+		&m.Created,
+		&m.Updated,
+		&m.Edited,
+		&m.Deleted,
+		&m.DeletedBy,
+	); err != nil {
+		return nil, err
+	}
+
+	var err error
+
+	if m.ID, err = sqldb.ParseID(id); err != nil {
+		return nil, err
+	}
+
+	if m.ThreadID, err = sqldb.ParseID(threadID); err != nil {
+		return nil, err
+	}
+
+	if m.ReplyToID, err = sqldb.ParseID(replyToID); err != nil {
+		return nil, err
+	}
+
+	return m, nil
+}
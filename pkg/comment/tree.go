@@ -0,0 +1,217 @@
+package comment
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+
+	"github.com/rgynn/klottr/pkg/cursor"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	SortVotes = "votes"
+	SortNew   = "new"
+	SortOld   = "old"
+)
+
+const (
+	// MaxDepth of a reply, top level comments are at depth 0 and replies to comments
+	// at MaxDepth are refused
+	MaxDepth = 16
+	// DefaultDepth is the number of levels returned in a tree when no depth is given
+	DefaultDepth = 5
+	// DefaultReplies is the number of replies returned per comment when none is given
+	DefaultReplies = 10
+	// MaxTreeComments is the number of oldest comments of a thread a tree is built from
+	MaxTreeComments = 5000
+)
+
+var (
+	ErrInvalidSort = errors.New("invalid sort, must be one of: votes, new, old")
+	ErrMaxDepth    = fmt.Errorf("replies cannot be nested deeper than %d levels", MaxDepth)
+)
+
+// TreeOptions for listing the comments of a thread as a tree
+type TreeOptions struct {
+	Sort string
+	// Parent lists the replies to a comment instead of the top level comments
+	Parent *primitive.ObjectID
+	// After continues the top level listing after a comment returned earlier
+	After *cursor.Cursor
+	// Size is the number of top level comments
+	Size int64
+	// Depth is the number of levels returned, replies below it are left for a later request
+	Depth int
+	// Replies is the number of replies returned per comment below the top level
+	Replies int
+}
+
+// NewTreeOptions returns the options for listing a tree in order sort with depth levels
+// and replies per comment, empty strings give the defaults
+func NewTreeOptions(order, depth, replies string, parent *primitive.ObjectID, after *cursor.Cursor, size int64) (*TreeOptions, error) {
+
+	if order == "" {
+		order = SortVotes
+	}
+
+	switch order {
+	case SortVotes, SortNew, SortOld:
+		break
+	default:
+		return nil, ErrInvalidSort
+	}
+
+	if after != nil && after.Time == nil {
+		return nil, cursor.ErrInvalid
+	}
+
+	opts := &TreeOptions{
+		Sort:    order,
+		Parent:  parent,
+		After:   after,
+		Size:    size,
+		Depth:   DefaultDepth,
+		Replies: DefaultReplies,
+	}
+
+	if depth != "" {
+		n, err := strconv.Atoi(depth)
+		if err != nil || n < 1 || n > MaxDepth+1 {
+			return nil, fmt.Errorf("invalid depth: %s, must be between 1 and %d", depth, MaxDepth+1)
+		}
+		opts.Depth = n
+	}
+
+	if replies != "" {
+		n, err := strconv.Atoi(replies)
+		if err != nil || n < 1 {
+			return nil, fmt.Errorf("invalid replies: %s, must be 1 or more", replies)
+		}
+		opts.Replies = n
+	}
+
+	return opts, nil
+}
+
+// Node of a comment tree with the first page of its direct replies
+type Node struct {
+	*Model
+	NumReplies int     `json:"num_replies"`
+	Replies    []*Node `json:"replies,omitempty"`
+	// NextCursor continues the replies to the comment, passed as the cursor query parameter
+	// together with the comment as parent
+	NextCursor string `json:"next_cursor,omitempty"`
+	// More is the unsigned cursor behind NextCursor
+	More *cursor.Cursor `json:"-"`
+}
+
+// Tree builds the page of the tree described by opts from the comments of a thread listed
+// oldest first, and returns the cursor continuing the top level or nil on the last page.
+// Replies to comments that are not in list are listed at the top level.
+func Tree(list []*Model, opts *TreeOptions) ([]*Node, *cursor.Cursor) {
+
+	known := map[primitive.ObjectID]bool{}
+	for _, m := range list {
+		if m.ID != nil {
+			known[*m.ID] = true
+		}
+	}
+
+	children := map[primitive.ObjectID][]*Model{}
+	var roots []*Model
+	for _, m := range list {
+		switch {
+		case m.ReplyToID != nil && known[*m.ReplyToID]:
+			children[*m.ReplyToID] = append(children[*m.ReplyToID], m)
+		case opts.Parent == nil:
+			roots = append(roots, m)
+		}
+	}
+
+	if opts.Parent != nil {
+		roots = children[*opts.Parent]
+	}
+
+	page, next := level(roots, opts.Sort, opts.After, int(opts.Size))
+
+	return nodes(page, children, opts, 1), next
+}
+
+// nodes of page with their replies down to opts.Depth
+func nodes(page []*Model, children map[primitive.ObjectID][]*Model, opts *TreeOptions, depth int) []*Node {
+
+	result := make([]*Node, 0, len(page))
+
+	for _, m := range page {
+
+		n := &Node{Model: m}
+
+		if m.ID != nil {
+			replies := children[*m.ID]
+			n.NumReplies = len(replies)
+			if depth < opts.Depth {
+				var next []*Model
+				next, n.More = level(replies, opts.Sort, nil, opts.Replies)
+				n.Replies = nodes(next, children, opts, depth+1)
+			}
+		}
+
+		result = append(result, n)
+	}
+
+	return result
+}
+
+// level sorts the comments of one level in order and returns the page of size comments
+// after the cursor, and the cursor continuing it when there are more
+func level(list []*Model, order string, after *cursor.Cursor, size int) ([]*Model, *cursor.Cursor) {
+
+	sorted := make([]*Model, 0, len(list))
+	for _, m := range list {
+		if after == nil || less(order, after, TreeKey(order, m)) {
+			sorted = append(sorted, m)
+		}
+	}
+
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return less(order, TreeKey(order, sorted[i]), TreeKey(order, sorted[j]))
+	})
+
+	if size <= 0 || len(sorted) <= size {
+		return sorted, nil
+	}
+
+	return sorted[:size], TreeKey(order, sorted[size-1])
+}
+
+// TreeKey of m in order, used as the cursor to continue a level of a tree after m
+func TreeKey(order string, m *Model) *cursor.Cursor {
+	c := Key(m)
+	if order == SortVotes {
+		c.Score = float64(m.Votes)
+	}
+	return c
+}
+
+// less reports whether the comment at a is listed before the comment at b in order
+func less(order string, a, b *cursor.Cursor) bool {
+
+	if order == SortVotes && a.Score != b.Score {
+		return a.Score > b.Score
+	}
+
+	if !a.Time.Equal(*b.Time) {
+		if order == SortOld {
+			return a.Time.Before(*b.Time)
+		}
+		return a.Time.After(*b.Time)
+	}
+
+	if order == SortOld {
+		return a.ID < b.ID
+	}
+
+	return a.ID > b.ID
+}
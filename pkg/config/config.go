@@ -1,9 +1,12 @@
 package config
 
 import (
+	"encoding/hex"
 	"errors"
 	"flag"
 	"fmt"
+	"net/mail"
+	"net/url"
 	"os"
 	"strconv"
 	"strings"
@@ -26,14 +29,77 @@ type Config struct {
 	ReadTimeout           time.Duration
 	WriteTimeout          time.Duration
 	PostTTLSeconds        int32
+	EditWindow            time.Duration
+	DeletedRetention      time.Duration
 	CORSAllowOrigins      []string
+	DatabaseDriver        string
 	DatabaseName          string
 	DatabaseURL           string
 	JWTSecret             string
+	JWTKeys               string
+	JWTSigningKeyID       string
+	AccessTokenTTL        time.Duration
+	RefreshTokenTTL       time.Duration
+	CursorSecret          string
+	PageSizeMax           int64
+	PublicURL             string
+	ResetURL              string
+	Mailer                string
+	MailFrom              string
+	MailDir               string
+	SMTPAddr              string
+	SMTPUsername          string
+	SMTPPassword          string
+	EmailKey              []byte
+	VerifyTokenTTL        time.Duration
+	ResetTokenTTL         time.Duration
+	RequireValidated      bool
+	TOTPIssuer            string
+	ChallengeTokenTTL     time.Duration
+	RequireAdminTOTP      bool
+	OIDCProviders         []*OIDCProvider
+	OIDCStateTTL          time.Duration
+	TrustProxy            bool
+	LockoutStore          string
+	LockoutUserThreshold  int
+	LockoutIPThreshold    int
+	LockoutBase           time.Duration
+	LockoutMax            time.Duration
+	LockoutWindow         time.Duration
+	RateLimitStore        string
+	RateLimitDefault      *RateLimit
+	RateLimits            []*RateLimit
 	Version               string
 	BuildDate             string
 }
 
+// OIDCProvider is an external OpenID Connect identity provider users can sign in with
+type OIDCProvider struct {
+	// Name of the provider in the /auth/oidc/{provider} routes
+	Name         string
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	Scopes       []string
+}
+
+// RateLimit of the requests a user, or an ip without a user, makes to the routes with Method and
+// Path template, Burst requests at once refilled at Burst requests per Period. An empty Method
+// matches every method, the default rate limit has no Method and Path
+type RateLimit struct {
+	Method string
+	Path   string
+	Burst  int
+	Period time.Duration
+}
+
+// defaultRateLimits limit creating posts and voting unless RATELIMITS is set
+const defaultRateLimits = `POST /api/1.0/c/{category}=5/1m;` +
+	`POST /api/1.0/c/{category}/t/{slug_id}/{slug_title}/comments=20/1m;` +
+	`POST /api/1.0/c/{category}/t/{slug_id}/{slug_title}/comments/{comment_slug_id}/replies=20/1m;` +
+	`POST /api/1.0/c/{category}/t/{slug_id}/{slug_title}/vote=60/1m;` +
+	`POST /api/1.0/c/{category}/t/{slug_id}/{slug_title}/comments/{comment_slug_id}/vote=60/1m`
+
 func NewFromEnv(filenames ...string) (*Config, error) {
 
 	if len(filenames) > 0 {
@@ -87,21 +153,343 @@ func NewFromEnv(filenames ...string) (*Config, error) {
 		return nil, fmt.Errorf("failed to parse POST_TTL_SECONDS env variable to int32: %w", err)
 	}
 
+	editWindow := 2 * time.Hour
+	if v := os.Getenv("EDIT_WINDOW"); v != "" {
+		if editWindow, err = time.ParseDuration(v); err != nil {
+			return nil, fmt.Errorf("failed to parse EDIT_WINDOW env variable to time.Duration: %w", err)
+		}
+	}
+
+	deletedRetention := 30 * 24 * time.Hour
+	if v := os.Getenv("DELETED_RETENTION"); v != "" {
+		if deletedRetention, err = time.ParseDuration(v); err != nil {
+			return nil, fmt.Errorf("failed to parse DELETED_RETENTION env variable to time.Duration: %w", err)
+		}
+		if deletedRetention < 0 {
+			return nil, errors.New("DELETED_RETENTION env variable cannot be negative")
+		}
+	}
+
 	corsAllowOrigins := strings.Split(os.Getenv("CORS_ALLOW_ORIGINS"), ",")
 
-	dbName := os.Getenv("DATABASE_NAME")
-	if dbName == "" {
-		return nil, errors.New("no DATABASE_NAME env variable set")
+	dbDriver := os.Getenv("DATABASE_DRIVER")
+	if dbDriver == "" {
+		dbDriver = "mongo"
 	}
 
+	dbName := os.Getenv("DATABASE_NAME")
 	dbURL := os.Getenv("DATABASE_URL")
-	if dbURL == "" {
-		return nil, errors.New("no DATABASE_URL env variable set")
+
+	switch dbDriver {
+	case "mongo":
+		if dbName == "" {
+			return nil, errors.New("no DATABASE_NAME env variable set")
+		}
+		if dbURL == "" {
+			return nil, errors.New("no DATABASE_URL env variable set")
+		}
+	case "sqlite3", "postgres":
+		if dbURL == "" {
+			return nil, errors.New("no DATABASE_URL env variable set")
+		}
+	case "memory":
+		break
+	default:
+		return nil, fmt.Errorf("invalid DATABASE_DRIVER env variable set: %s", dbDriver)
 	}
 
+	jwtKeys := os.Getenv("JWT_KEYS")
+	jwtSigningKeyID := os.Getenv("JWT_SIGNING_KEY_ID")
+
 	jwtSecret := os.Getenv("JWT_SECRET")
-	if jwtSecret == "" {
-		return nil, errors.New("no JWT_SECRET env variable set")
+	if jwtSecret == "" && jwtKeys == "" {
+		return nil, errors.New("no JWT_SECRET or JWT_KEYS env variable set")
+	}
+
+	accessTokenTTL := 15 * time.Minute
+	if v := os.Getenv("ACCESS_TOKEN_TTL"); v != "" {
+		if accessTokenTTL, err = time.ParseDuration(v); err != nil {
+			return nil, fmt.Errorf("failed to parse ACCESS_TOKEN_TTL env variable to time.Duration: %w", err)
+		}
+		if accessTokenTTL <= 0 {
+			return nil, errors.New("ACCESS_TOKEN_TTL env variable must be positive")
+		}
+	}
+
+	refreshTokenTTL := 30 * 24 * time.Hour
+	if v := os.Getenv("REFRESH_TOKEN_TTL"); v != "" {
+		if refreshTokenTTL, err = time.ParseDuration(v); err != nil {
+			return nil, fmt.Errorf("failed to parse REFRESH_TOKEN_TTL env variable to time.Duration: %w", err)
+		}
+		if refreshTokenTTL <= 0 {
+			return nil, errors.New("REFRESH_TOKEN_TTL env variable must be positive")
+		}
+	}
+
+	cursorSecret := os.Getenv("CURSOR_SECRET")
+	if cursorSecret == "" {
+		cursorSecret = jwtSecret
+	}
+	if cursorSecret == "" {
+		return nil, errors.New("no CURSOR_SECRET env variable set, it is required when JWT_SECRET is not")
+	}
+
+	pageSizeMax := int64(100)
+	if v := os.Getenv("PAGE_SIZE_MAX"); v != "" {
+		if pageSizeMax, err = strconv.ParseInt(v, 10, 64); err != nil {
+			return nil, fmt.Errorf("failed to parse PAGE_SIZE_MAX env variable to int64: %w", err)
+		}
+		if pageSizeMax < 1 {
+			return nil, errors.New("PAGE_SIZE_MAX env variable must be positive")
+		}
+	}
+
+	publicURL := strings.TrimSuffix(os.Getenv("PUBLIC_URL"), "/")
+	if publicURL == "" {
+		publicURL = fmt.Sprintf("http://%s:%s", host, port)
+	}
+	if u, err := url.Parse(publicURL); err != nil || u.Scheme == "" || u.Host == "" {
+		return nil, fmt.Errorf("invalid PUBLIC_URL env variable set: %s", publicURL)
+	}
+
+	resetURL := os.Getenv("RESET_URL")
+	if resetURL == "" {
+		resetURL = publicURL + "/reset-password"
+	}
+	if u, err := url.Parse(resetURL); err != nil || u.Scheme == "" || u.Host == "" || u.RawQuery != "" {
+		return nil, fmt.Errorf("invalid RESET_URL env variable set: %s", resetURL)
+	}
+
+	mailer := os.Getenv("MAILER")
+	if mailer == "" {
+		mailer = "log"
+	}
+
+	mailFrom := os.Getenv("MAIL_FROM")
+	mailDir := os.Getenv("MAIL_DIR")
+	smtpAddr := os.Getenv("SMTP_ADDR")
+
+	switch mailer {
+	case "smtp":
+		if smtpAddr == "" {
+			return nil, errors.New("no SMTP_ADDR env variable set")
+		}
+		if mailFrom == "" {
+			return nil, errors.New("no MAIL_FROM env variable set")
+		}
+	case "file":
+		if mailDir == "" {
+			return nil, errors.New("no MAIL_DIR env variable set")
+		}
+	case "log", "none":
+		break
+	default:
+		return nil, fmt.Errorf("invalid MAILER env variable set: %s", mailer)
+	}
+
+	if mailFrom == "" {
+		mailFrom = "klottr <noreply@localhost>"
+	}
+
+	if _, err := mail.ParseAddress(mailFrom); err != nil {
+		return nil, fmt.Errorf("invalid MAIL_FROM env variable set: %w", err)
+	}
+
+	var emailKey []byte
+	if v := os.Getenv("EMAIL_KEY"); v != "" {
+		if emailKey, err = hex.DecodeString(v); err != nil || len(emailKey) != 32 {
+			return nil, errors.New("EMAIL_KEY env variable must be 32 bytes hex encoded")
+		}
+	} else if mailer != "none" {
+		return nil, errors.New("no EMAIL_KEY env variable set, it is required unless MAILER is none")
+	}
+
+	verifyTokenTTL := 24 * time.Hour
+	if v := os.Getenv("VERIFY_TOKEN_TTL"); v != "" {
+		if verifyTokenTTL, err = time.ParseDuration(v); err != nil {
+			return nil, fmt.Errorf("failed to parse VERIFY_TOKEN_TTL env variable to time.Duration: %w", err)
+		}
+		if verifyTokenTTL <= 0 {
+			return nil, errors.New("VERIFY_TOKEN_TTL env variable must be positive")
+		}
+	}
+
+	resetTokenTTL := time.Hour
+	if v := os.Getenv("RESET_TOKEN_TTL"); v != "" {
+		if resetTokenTTL, err = time.ParseDuration(v); err != nil {
+			return nil, fmt.Errorf("failed to parse RESET_TOKEN_TTL env variable to time.Duration: %w", err)
+		}
+		if resetTokenTTL <= 0 {
+			return nil, errors.New("RESET_TOKEN_TTL env variable must be positive")
+		}
+	}
+
+	requireValidated := false
+	if v := os.Getenv("REQUIRE_VALIDATED"); v != "" {
+		if requireValidated, err = strconv.ParseBool(v); err != nil {
+			return nil, fmt.Errorf("failed to parse REQUIRE_VALIDATED env variable to bool: %w", err)
+		}
+		if requireValidated && mailer == "none" {
+			return nil, errors.New("REQUIRE_VALIDATED env variable cannot be set when MAILER is none")
+		}
+	}
+
+	totpIssuer := os.Getenv("TOTP_ISSUER")
+	if totpIssuer == "" {
+		totpIssuer = "klottr"
+	}
+
+	challengeTokenTTL := 5 * time.Minute
+	if v := os.Getenv("CHALLENGE_TOKEN_TTL"); v != "" {
+		if challengeTokenTTL, err = time.ParseDuration(v); err != nil {
+			return nil, fmt.Errorf("failed to parse CHALLENGE_TOKEN_TTL env variable to time.Duration: %w", err)
+		}
+		if challengeTokenTTL <= 0 {
+			return nil, errors.New("CHALLENGE_TOKEN_TTL env variable must be positive")
+		}
+	}
+
+	requireAdminTOTP := false
+	if v := os.Getenv("REQUIRE_ADMIN_TOTP"); v != "" {
+		if requireAdminTOTP, err = strconv.ParseBool(v); err != nil {
+			return nil, fmt.Errorf("failed to parse REQUIRE_ADMIN_TOTP env variable to bool: %w", err)
+		}
+		if requireAdminTOTP && emailKey == nil {
+			return nil, errors.New("REQUIRE_ADMIN_TOTP env variable cannot be set without EMAIL_KEY")
+		}
+	}
+
+	var oidcProviders []*OIDCProvider
+	if v := os.Getenv("OIDC_PROVIDERS"); v != "" {
+		for _, name := range strings.Split(v, ",") {
+			p, err := oidcProviderFromEnv(strings.ToLower(strings.TrimSpace(name)))
+			if err != nil {
+				return nil, err
+			}
+			oidcProviders = append(oidcProviders, p)
+		}
+	}
+
+	oidcStateTTL := 10 * time.Minute
+	if v := os.Getenv("OIDC_STATE_TTL"); v != "" {
+		if oidcStateTTL, err = time.ParseDuration(v); err != nil {
+			return nil, fmt.Errorf("failed to parse OIDC_STATE_TTL env variable to time.Duration: %w", err)
+		}
+		if oidcStateTTL <= 0 {
+			return nil, errors.New("OIDC_STATE_TTL env variable must be positive")
+		}
+	}
+
+	trustProxy := false
+	if v := os.Getenv("TRUST_PROXY"); v != "" {
+		if trustProxy, err = strconv.ParseBool(v); err != nil {
+			return nil, fmt.Errorf("failed to parse TRUST_PROXY env variable to bool: %w", err)
+		}
+	}
+
+	lockoutStore := os.Getenv("LOCKOUT_STORE")
+	if lockoutStore == "" {
+		lockoutStore = "memory"
+	}
+
+	switch lockoutStore {
+	case "memory", "none":
+		break
+	default:
+		return nil, fmt.Errorf("invalid LOCKOUT_STORE env variable set: %s", lockoutStore)
+	}
+
+	lockoutUserThreshold := 5
+	if v := os.Getenv("LOCKOUT_USER_THRESHOLD"); v != "" {
+		if lockoutUserThreshold, err = strconv.Atoi(v); err != nil {
+			return nil, fmt.Errorf("failed to parse LOCKOUT_USER_THRESHOLD env variable to int: %w", err)
+		}
+	}
+
+	lockoutIPThreshold := 20
+	if v := os.Getenv("LOCKOUT_IP_THRESHOLD"); v != "" {
+		if lockoutIPThreshold, err = strconv.Atoi(v); err != nil {
+			return nil, fmt.Errorf("failed to parse LOCKOUT_IP_THRESHOLD env variable to int: %w", err)
+		}
+	}
+
+	lockoutBase := time.Second
+	if v := os.Getenv("LOCKOUT_BASE"); v != "" {
+		if lockoutBase, err = time.ParseDuration(v); err != nil {
+			return nil, fmt.Errorf("failed to parse LOCKOUT_BASE env variable to time.Duration: %w", err)
+		}
+		if lockoutBase <= 0 {
+			return nil, errors.New("LOCKOUT_BASE env variable must be positive")
+		}
+	}
+
+	lockoutMax := 15 * time.Minute
+	if v := os.Getenv("LOCKOUT_MAX"); v != "" {
+		if lockoutMax, err = time.ParseDuration(v); err != nil {
+			return nil, fmt.Errorf("failed to parse LOCKOUT_MAX env variable to time.Duration: %w", err)
+		}
+		if lockoutMax < lockoutBase {
+			return nil, errors.New("LOCKOUT_MAX env variable cannot be less than LOCKOUT_BASE")
+		}
+	}
+
+	lockoutWindow := 15 * time.Minute
+	if v := os.Getenv("LOCKOUT_WINDOW"); v != "" {
+		if lockoutWindow, err = time.ParseDuration(v); err != nil {
+			return nil, fmt.Errorf("failed to parse LOCKOUT_WINDOW env variable to time.Duration: %w", err)
+		}
+		if lockoutWindow <= 0 {
+			return nil, errors.New("LOCKOUT_WINDOW env variable must be positive")
+		}
+	}
+
+	rateLimitStore := os.Getenv("RATELIMIT_STORE")
+	if rateLimitStore == "" {
+		rateLimitStore = "memory"
+	}
+
+	switch rateLimitStore {
+	case "memory", "none":
+		break
+	default:
+		return nil, fmt.Errorf("invalid RATELIMIT_STORE env variable set: %s", rateLimitStore)
+	}
+
+	var rateLimitDefault *RateLimit
+	if v := os.Getenv("RATELIMIT_DEFAULT"); v != "" {
+		if rateLimitDefault, err = parseRateLimit(v); err != nil {
+			return nil, fmt.Errorf("invalid RATELIMIT_DEFAULT env variable set: %w", err)
+		}
+	}
+
+	rateLimitsEnv, ok := os.LookupEnv("RATELIMITS")
+	if !ok {
+		rateLimitsEnv = defaultRateLimits
+	}
+
+	var rateLimits []*RateLimit
+	for _, v := range strings.Split(rateLimitsEnv, ";") {
+		if v = strings.TrimSpace(v); v == "" {
+			continue
+		}
+		i := strings.LastIndex(v, "=")
+		if i < 0 {
+			return nil, fmt.Errorf("invalid RATELIMITS env variable set, expected METHOD /path=burst/period: %s", v)
+		}
+		fields := strings.Fields(v[:i])
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("invalid RATELIMITS env variable set, expected METHOD /path=burst/period: %s", v)
+		}
+		limit, err := parseRateLimit(v[i+1:])
+		if err != nil {
+			return nil, fmt.Errorf("invalid RATELIMITS env variable set: %w", err)
+		}
+		limit.Method = strings.ToUpper(fields[0])
+		limit.Path = fields[1]
+		if limit.Method == "*" {
+			limit.Method = ""
+		}
+		rateLimits = append(rateLimits, limit)
 	}
 
 	if VERSION == "" {
@@ -122,14 +510,123 @@ func NewFromEnv(filenames ...string) (*Config, error) {
 		WriteTimeout:          writeTimeout,
 		CORSAllowOrigins:      corsAllowOrigins,
 		PostTTLSeconds:        int32(postTTLSeconds),
+		EditWindow:            editWindow,
+		DeletedRetention:      deletedRetention,
+		DatabaseDriver:        dbDriver,
 		DatabaseName:          dbName,
 		DatabaseURL:           dbURL,
 		JWTSecret:             jwtSecret,
+		JWTKeys:               jwtKeys,
+		JWTSigningKeyID:       jwtSigningKeyID,
+		AccessTokenTTL:        accessTokenTTL,
+		RefreshTokenTTL:       refreshTokenTTL,
+		CursorSecret:          cursorSecret,
+		PageSizeMax:           pageSizeMax,
+		PublicURL:             publicURL,
+		ResetURL:              resetURL,
+		Mailer:                mailer,
+		MailFrom:              mailFrom,
+		MailDir:               mailDir,
+		SMTPAddr:              smtpAddr,
+		SMTPUsername:          os.Getenv("SMTP_USERNAME"),
+		SMTPPassword:          os.Getenv("SMTP_PASSWORD"),
+		EmailKey:              emailKey,
+		VerifyTokenTTL:        verifyTokenTTL,
+		ResetTokenTTL:         resetTokenTTL,
+		RequireValidated:      requireValidated,
+		TOTPIssuer:            totpIssuer,
+		ChallengeTokenTTL:     challengeTokenTTL,
+		RequireAdminTOTP:      requireAdminTOTP,
+		OIDCProviders:         oidcProviders,
+		OIDCStateTTL:          oidcStateTTL,
+		TrustProxy:            trustProxy,
+		LockoutStore:          lockoutStore,
+		LockoutUserThreshold:  lockoutUserThreshold,
+		LockoutIPThreshold:    lockoutIPThreshold,
+		LockoutBase:           lockoutBase,
+		LockoutMax:            lockoutMax,
+		LockoutWindow:         lockoutWindow,
+		RateLimitStore:        rateLimitStore,
+		RateLimitDefault:      rateLimitDefault,
+		RateLimits:            rateLimits,
 		Version:               VERSION,
 		BuildDate:             BUILDDATE,
 	}, nil
 }
 
+// oidcProviderFromEnv reads the OIDC_<NAME>_* env variables of the provider name
+func oidcProviderFromEnv(name string) (*OIDCProvider, error) {
+
+	if name == "" {
+		return nil, errors.New("invalid OIDC_PROVIDERS env variable set, empty provider name")
+	}
+
+	for _, r := range name {
+		if (r < 'a' || r > 'z') && (r < '0' || r > '9') {
+			return nil, fmt.Errorf("invalid OIDC_PROVIDERS env variable set, provider names are letters and digits: %s", name)
+		}
+	}
+
+	prefix := "OIDC_" + strings.ToUpper(name) + "_"
+
+	issuer := strings.TrimSuffix(os.Getenv(prefix+"ISSUER"), "/")
+	if u, err := url.Parse(issuer); err != nil || u.Scheme == "" || u.Host == "" {
+		return nil, fmt.Errorf("invalid %sISSUER env variable set: %s", prefix, issuer)
+	}
+
+	clientID := os.Getenv(prefix + "CLIENT_ID")
+	if clientID == "" {
+		return nil, fmt.Errorf("no %sCLIENT_ID env variable set", prefix)
+	}
+
+	scopes := []string{"openid", "email", "profile"}
+	if v := os.Getenv(prefix + "SCOPES"); v != "" {
+		scopes = strings.Fields(strings.ReplaceAll(v, ",", " "))
+	}
+
+	if !contains(scopes, "openid") {
+		scopes = append([]string{"openid"}, scopes...)
+	}
+
+	return &OIDCProvider{
+		Name:         name,
+		Issuer:       issuer,
+		ClientID:     clientID,
+		ClientSecret: os.Getenv(prefix + "CLIENT_SECRET"),
+		Scopes:       scopes,
+	}, nil
+}
+
+// parseRateLimit parses burst/period, like 10/1m for 10 requests a minute
+func parseRateLimit(s string) (*RateLimit, error) {
+
+	i := strings.Index(s, "/")
+	if i < 0 {
+		return nil, fmt.Errorf("expected burst/period, got: %s", s)
+	}
+
+	burst, err := strconv.Atoi(strings.TrimSpace(s[:i]))
+	if err != nil || burst <= 0 {
+		return nil, fmt.Errorf("burst must be a positive int, got: %s", s)
+	}
+
+	period, err := time.ParseDuration(strings.TrimSpace(s[i+1:]))
+	if err != nil || period <= 0 {
+		return nil, fmt.Errorf("period must be a positive duration, got: %s", s)
+	}
+
+	return &RateLimit{Burst: burst, Period: period}, nil
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
 type Flags struct {
 	EnvFiles []string
 }
@@ -0,0 +1,76 @@
+package cursor
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+)
+
+var ErrInvalid = errors.New("invalid cursor")
+
+// Cursor is the position of the last item of a page in a sorted listing, the next page
+// starts with the items sorted after it. Listings sorted by a score depending on the current
+// time carry the Sort and the Now the scores were calculated at, so every page of the listing
+// is ranked the same
+type Cursor struct {
+	Score float64    `json:"s,omitempty"`
+	Time  *time.Time `json:"t,omitempty"`
+	ID    string     `json:"id"`
+	Sort  string     `json:"o,omitempty"`
+	Now   *time.Time `json:"n,omitempty"`
+}
+
+// Encode c into an opaque token signed with secret
+func Encode(secret []byte, c *Cursor) (string, error) {
+
+	if c == nil {
+		return "", errors.New("no c *cursor.Cursor provided")
+	}
+
+	payload, err := json.Marshal(c)
+	if err != nil {
+		return "", err
+	}
+
+	return base64.RawURLEncoding.EncodeToString(payload) + "." + base64.RawURLEncoding.EncodeToString(sign(secret, payload)), nil
+}
+
+// Decode a token created by Encode with the same secret
+func Decode(secret []byte, token string) (*Cursor, error) {
+
+	parts := strings.Split(token, ".")
+	if len(parts) != 2 {
+		return nil, ErrInvalid
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return nil, ErrInvalid
+	}
+
+	signature, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return nil, ErrInvalid
+	}
+
+	if !hmac.Equal(signature, sign(secret, payload)) {
+		return nil, ErrInvalid
+	}
+
+	var result *Cursor
+	if err := json.Unmarshal(payload, &result); err != nil || result == nil || result.ID == "" {
+		return nil, ErrInvalid
+	}
+
+	return result, nil
+}
+
+func sign(secret, payload []byte) []byte {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write(payload) //nolint:errcheck
+	return mac.Sum(nil)
+}
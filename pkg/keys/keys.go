@@ -0,0 +1,330 @@
+package keys
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/golang-jwt/jwt"
+)
+
+var ErrUnknownKey = errors.New("unknown jwt signing key")
+
+// Key used to sign and verify jwts, keys loaded from a public key only verify
+type Key struct {
+	ID      string
+	Method  jwt.SigningMethod
+	Private crypto.PrivateKey
+	Public  crypto.PublicKey
+}
+
+// Set of keys, the signing key signs new jwts while every key in the set verifies them so a
+// new signing key can be rolled out without invalidating the jwts signed with the old one.
+// A set without keys signs and verifies with HS256 and the shared secret instead
+type Set struct {
+	signing *Key
+	keys    map[string]*Key
+	secret  []byte
+}
+
+// Load the keys in path, a PEM file or a directory of them, each key is identified by the kid
+// of its file name without extension. Private RSA keys sign with RS256 and Ed25519 keys with
+// EdDSA. signingID selects the signing key, defaulting to the last private key by name.
+// secret, when not empty, keeps verifying HS256 jwts so the switch from the shared secret
+// does not sign anyone out. Without a path the set uses HS256 and secret only
+func Load(path, signingID, secret string) (*Set, error) {
+
+	set := &Set{
+		keys:   map[string]*Key{},
+		secret: []byte(secret),
+	}
+
+	if path == "" {
+		if secret == "" {
+			return nil, errors.New("no jwt keys or secret provided")
+		}
+		return set, nil
+	}
+
+	files, err := keyFiles(path)
+	if err != nil {
+		return nil, err
+	}
+
+	signable := []string{}
+
+	for _, file := range files {
+
+		b, err := ioutil.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+
+		id := strings.TrimSuffix(filepath.Base(file), filepath.Ext(file))
+
+		key, err := parse(id, b)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse jwt key %s: %w", file, err)
+		}
+
+		set.keys[id] = key
+
+		if key.Private != nil {
+			signable = append(signable, id)
+		}
+	}
+
+	if signingID == "" {
+		if len(signable) == 0 {
+			return nil, fmt.Errorf("no private jwt key found in %s", path)
+		}
+		sort.Strings(signable)
+		signingID = signable[len(signable)-1]
+	}
+
+	key, ok := set.keys[signingID]
+	if !ok || key.Private == nil {
+		return nil, fmt.Errorf("no private jwt key with kid %s found in %s", signingID, path)
+	}
+
+	set.signing = key
+
+	return set, nil
+}
+
+// keyFiles returns path, or the .pem files in it when it is a directory
+func keyFiles(path string) ([]string, error) {
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+
+	if !info.IsDir() {
+		return []string{path}, nil
+	}
+
+	files, err := filepath.Glob(filepath.Join(path, "*.pem"))
+	if err != nil {
+		return nil, err
+	}
+
+	if len(files) == 0 {
+		return nil, fmt.Errorf("no .pem jwt keys found in %s", path)
+	}
+
+	return files, nil
+}
+
+func parse(id string, b []byte) (*Key, error) {
+
+	block, _ := pem.Decode(b)
+	if block == nil {
+		return nil, jwt.ErrKeyMustBePEMEncoded
+	}
+
+	var parsed interface{}
+	var err error
+
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		parsed, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "PRIVATE KEY":
+		parsed, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	case "PUBLIC KEY":
+		parsed, err = x509.ParsePKIXPublicKey(block.Bytes)
+	default:
+		return nil, fmt.Errorf("unsupported PEM block type: %s", block.Type)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	switch k := parsed.(type) {
+	case *rsa.PrivateKey:
+		return &Key{ID: id, Method: jwt.SigningMethodRS256, Private: k, Public: &k.PublicKey}, nil
+	case *rsa.PublicKey:
+		return &Key{ID: id, Method: jwt.SigningMethodRS256, Public: k}, nil
+	case ed25519.PrivateKey:
+		return &Key{ID: id, Method: jwt.SigningMethodEdDSA, Private: k, Public: k.Public()}, nil
+	case ed25519.PublicKey:
+		return &Key{ID: id, Method: jwt.SigningMethodEdDSA, Public: k}, nil
+	default:
+		return nil, fmt.Errorf("unsupported key type: %T", parsed)
+	}
+}
+
+// Sign claims with the signing key of the set
+func (set *Set) Sign(claims jwt.Claims) (string, error) {
+
+	if set.signing == nil {
+		return jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(set.secret)
+	}
+
+	t := jwt.NewWithClaims(set.signing.Method, claims)
+	t.Header["kid"] = set.signing.ID
+
+	return t.SignedString(set.signing.Private)
+}
+
+// Keyfunc returns the key verifying t, refusing jwts whose algorithm does not match their key
+func (set *Set) Keyfunc(t *jwt.Token) (interface{}, error) {
+
+	kid, _ := t.Header["kid"].(string)
+
+	if kid == "" {
+		if _, ok := t.Method.(*jwt.SigningMethodHMAC); !ok || len(set.secret) == 0 {
+			return nil, ErrUnknownKey
+		}
+		return set.secret, nil
+	}
+
+	key, ok := set.keys[kid]
+	if !ok || key.Method.Alg() != t.Method.Alg() {
+		return nil, ErrUnknownKey
+	}
+
+	return key.Public, nil
+}
+
+// JWK is a public key in the JSON Web Key format of RFC 7517
+type JWK struct {
+	KeyType string `json:"kty"`
+	ID      string `json:"kid"`
+	Use     string `json:"use"`
+	Alg     string `json:"alg"`
+	N       string `json:"n,omitempty"`
+	E       string `json:"e,omitempty"`
+	Curve   string `json:"crv,omitempty"`
+	X       string `json:"x,omitempty"`
+	Y       string `json:"y,omitempty"`
+}
+
+// Key returns the verifying key of a JWK published by someone else, RSA keys verify RS256,
+// RS384 or RS512 as their alg says, P-256 keys ES256 and Ed25519 keys EdDSA
+func (jwk *JWK) Key() (*Key, error) {
+
+	switch jwk.KeyType {
+	case "RSA":
+		n, err := base64.RawURLEncoding.DecodeString(jwk.N)
+		if err != nil {
+			return nil, fmt.Errorf("invalid n of jwk %s: %w", jwk.ID, err)
+		}
+		e, err := base64.RawURLEncoding.DecodeString(jwk.E)
+		if err != nil || len(e) == 0 || len(e) > 4 {
+			return nil, fmt.Errorf("invalid e of jwk %s", jwk.ID)
+		}
+		var method jwt.SigningMethod = jwt.SigningMethodRS256
+		switch jwk.Alg {
+		case "", "RS256":
+			break
+		case "RS384":
+			method = jwt.SigningMethodRS384
+		case "RS512":
+			method = jwt.SigningMethodRS512
+		default:
+			return nil, fmt.Errorf("unsupported alg of jwk %s: %s", jwk.ID, jwk.Alg)
+		}
+		return &Key{ID: jwk.ID, Method: method, Public: &rsa.PublicKey{
+			N: new(big.Int).SetBytes(n),
+			E: int(new(big.Int).SetBytes(e).Int64()),
+		}}, nil
+	case "EC":
+		if jwk.Curve != "P-256" {
+			return nil, fmt.Errorf("unsupported crv of jwk %s: %s", jwk.ID, jwk.Curve)
+		}
+		x, err := base64.RawURLEncoding.DecodeString(jwk.X)
+		if err != nil {
+			return nil, fmt.Errorf("invalid x of jwk %s: %w", jwk.ID, err)
+		}
+		y, err := base64.RawURLEncoding.DecodeString(jwk.Y)
+		if err != nil {
+			return nil, fmt.Errorf("invalid y of jwk %s: %w", jwk.ID, err)
+		}
+		k := &ecdsa.PublicKey{Curve: elliptic.P256(), X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+		if !k.Curve.IsOnCurve(k.X, k.Y) {
+			return nil, fmt.Errorf("invalid point of jwk %s", jwk.ID)
+		}
+		return &Key{ID: jwk.ID, Method: jwt.SigningMethodES256, Public: k}, nil
+	case "OKP":
+		if jwk.Curve != "Ed25519" {
+			return nil, fmt.Errorf("unsupported crv of jwk %s: %s", jwk.ID, jwk.Curve)
+		}
+		x, err := base64.RawURLEncoding.DecodeString(jwk.X)
+		if err != nil || len(x) != ed25519.PublicKeySize {
+			return nil, fmt.Errorf("invalid x of jwk %s", jwk.ID)
+		}
+		return &Key{ID: jwk.ID, Method: jwt.SigningMethodEdDSA, Public: ed25519.PublicKey(x)}, nil
+	default:
+		return nil, fmt.Errorf("unsupported kty of jwk %s: %s", jwk.ID, jwk.KeyType)
+	}
+}
+
+// JWKS lists the public keys of the set, shared secrets are never listed
+type JWKS struct {
+	Keys []*JWK `json:"keys"`
+}
+
+// NewSet returns a set verifying with the keys of jwks, keys it cannot use are skipped
+func NewSet(jwks *JWKS) *Set {
+
+	set := &Set{keys: map[string]*Key{}}
+
+	for _, jwk := range jwks.Keys {
+		if jwk.Use != "" && jwk.Use != "sig" {
+			continue
+		}
+		if key, err := jwk.Key(); err == nil {
+			set.keys[key.ID] = key
+		}
+	}
+
+	return set
+}
+
+// JWKS returns the public keys of the set sorted by kid
+func (set *Set) JWKS() *JWKS {
+
+	result := &JWKS{Keys: []*JWK{}}
+
+	for _, key := range set.keys {
+
+		jwk := &JWK{
+			ID:  key.ID,
+			Use: "sig",
+			Alg: key.Method.Alg(),
+		}
+
+		switch k := key.Public.(type) {
+		case *rsa.PublicKey:
+			jwk.KeyType = "RSA"
+			jwk.N = base64.RawURLEncoding.EncodeToString(k.N.Bytes())
+			jwk.E = base64.RawURLEncoding.EncodeToString(big.NewInt(int64(k.E)).Bytes())
+		case ed25519.PublicKey:
+			jwk.KeyType = "OKP"
+			jwk.Curve = "Ed25519"
+			jwk.X = base64.RawURLEncoding.EncodeToString(k)
+		}
+
+		result.Keys = append(result.Keys, jwk)
+	}
+
+	sort.Slice(result.Keys, func(i, j int) bool {
+		return result.Keys[i].ID < result.Keys[j].ID
+	})
+
+	return result
+}
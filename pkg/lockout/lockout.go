@@ -0,0 +1,53 @@
+package lockout
+
+import (
+	"context"
+	"time"
+)
+
+// Store tracks failed attempts by key, a shared store locks a key out on every instance
+type Store interface {
+	// Locked returns how long key stays locked out after now, zero when it is not
+	Locked(ctx context.Context, key string, now time.Time) (time.Duration, error)
+	// Attempt checks and counts an attempt for key at now at once, so concurrent attempts cannot
+	// all get past the check. The attempt is counted as failed up front, a lockout it brings
+	// the key to lasts as long as the policy says. When key already is locked out the attempt
+	// is refused without being counted and how long the lockout lasts is returned
+	Attempt(ctx context.Context, key string, policy *Policy, now time.Time) (time.Duration, error)
+	// Release takes back an attempt counted by Attempt that did not fail
+	Release(ctx context.Context, key string, policy *Policy) error
+	// Reset forgets the failed attempts of key
+	Reset(ctx context.Context, key string) error
+	// DeleteExpired forgets the keys that are not locked out and have not failed within
+	// their window at now
+	DeleteExpired(ctx context.Context, now time.Time) error
+}
+
+// Policy of a kind of key, every failed attempt from the Threshold on locks the key out for
+// twice as long as the one before, starting at Base and capped at Max. Failed attempts are
+// forgotten after Window without any
+type Policy struct {
+	Threshold int
+	Base      time.Duration
+	Max       time.Duration
+	Window    time.Duration
+}
+
+// Lockout returns how long a key with failures failed attempts within the window is locked out
+func (p *Policy) Lockout(failures int) time.Duration {
+
+	if p.Threshold <= 0 || failures < p.Threshold {
+		return 0
+	}
+
+	d := p.Base
+	for i := p.Threshold; i < failures && d < p.Max; i++ {
+		d *= 2
+	}
+
+	if d > p.Max {
+		d = p.Max
+	}
+
+	return d
+}
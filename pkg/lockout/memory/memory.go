@@ -0,0 +1,132 @@
+package memory
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/rgynn/klottr/pkg/config"
+	"github.com/rgynn/klottr/pkg/lockout"
+)
+
+// Store keeping failed attempts in memory, each instance of the service locks out on its own
+type Store struct {
+	mu       sync.Mutex
+	cfg      *config.Config
+	attempts map[string]*attempts
+}
+
+type attempts struct {
+	failures int
+	last     time.Time
+	until    time.Time
+	window   time.Duration
+}
+
+func NewStore(cfg *config.Config) (lockout.Store, error) {
+
+	if cfg == nil {
+		return nil, errors.New("no cfg *config.Config provided")
+	}
+
+	return &Store{
+		cfg:      cfg,
+		attempts: map[string]*attempts{},
+	}, nil
+}
+
+func (store *Store) Locked(ctx context.Context, key string, now time.Time) (time.Duration, error) {
+
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	a, ok := store.attempts[key]
+	if !ok || !a.until.After(now) {
+		return 0, nil
+	}
+
+	return a.until.Sub(now), nil
+}
+
+func (store *Store) Attempt(ctx context.Context, key string, policy *lockout.Policy, now time.Time) (time.Duration, error) {
+
+	if policy == nil {
+		return 0, errors.New("no policy *lockout.Policy provided")
+	}
+
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	a, ok := store.attempts[key]
+	if ok && a.until.After(now) {
+		return a.until.Sub(now), nil
+	}
+
+	if !ok || now.Sub(a.last) > policy.Window {
+		a = &attempts{}
+		store.attempts[key] = a
+	}
+
+	a.failures++
+	a.last = now
+	a.window = policy.Window
+
+	if d := policy.Lockout(a.failures); d > 0 {
+		a.until = now.Add(d)
+	}
+
+	return 0, nil
+}
+
+func (store *Store) Release(ctx context.Context, key string, policy *lockout.Policy) error {
+
+	if policy == nil {
+		return errors.New("no policy *lockout.Policy provided")
+	}
+
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	a, ok := store.attempts[key]
+	if !ok {
+		return nil
+	}
+
+	a.failures--
+
+	if a.failures <= 0 {
+		delete(store.attempts, key)
+		return nil
+	}
+
+	if policy.Lockout(a.failures) == 0 {
+		a.until = time.Time{}
+	}
+
+	return nil
+}
+
+func (store *Store) Reset(ctx context.Context, key string) error {
+
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	delete(store.attempts, key)
+
+	return nil
+}
+
+func (store *Store) DeleteExpired(ctx context.Context, now time.Time) error {
+
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	for key, a := range store.attempts {
+		if !a.until.After(now) && now.Sub(a.last) > a.window {
+			delete(store.attempts, key)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,46 @@
+package file
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/rgynn/klottr/pkg/config"
+	"github.com/rgynn/klottr/pkg/helper"
+	"github.com/rgynn/klottr/pkg/mail"
+)
+
+// Mailer writing every message to a .eml file in a directory, for local development and tests
+type Mailer struct {
+	cfg *config.Config
+	dir string
+}
+
+func NewMailer(cfg *config.Config) (mail.Mailer, error) {
+
+	if cfg == nil {
+		return nil, errors.New("no cfg *config.Config provided")
+	}
+
+	if err := os.MkdirAll(cfg.MailDir, 0o700); err != nil {
+		return nil, err
+	}
+
+	return &Mailer{cfg: cfg, dir: cfg.MailDir}, nil
+}
+
+func (mailer *Mailer) Send(ctx context.Context, m *mail.Message) error {
+
+	if err := m.Valid(); err != nil {
+		return err
+	}
+
+	now := time.Now().UTC()
+	name := fmt.Sprintf("%s-%s.eml", now.Format("20060102T150405.000000000"), helper.RandomString(8))
+
+	return ioutil.WriteFile(filepath.Join(mailer.dir, name), m.Bytes(now), 0o600)
+}
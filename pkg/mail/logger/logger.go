@@ -0,0 +1,38 @@
+package logger
+
+import (
+	"context"
+	"errors"
+
+	"github.com/rgynn/klottr/pkg/config"
+	"github.com/rgynn/klottr/pkg/mail"
+	"github.com/sirupsen/logrus"
+)
+
+// Mailer logging messages instead of sending them, for local development
+type Mailer struct {
+	cfg *config.Config
+}
+
+func NewMailer(cfg *config.Config) (mail.Mailer, error) {
+
+	if cfg == nil {
+		return nil, errors.New("no cfg *config.Config provided")
+	}
+
+	return &Mailer{cfg: cfg}, nil
+}
+
+func (mailer *Mailer) Send(ctx context.Context, m *mail.Message) error {
+
+	if err := m.Valid(); err != nil {
+		return err
+	}
+
+	logrus.WithFields(logrus.Fields{
+		"to":      m.To,
+		"subject": m.Subject,
+	}).Infof("Mail not sent, MAILER is log:\n%s", m.Body)
+
+	return nil
+}
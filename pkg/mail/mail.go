@@ -0,0 +1,61 @@
+package mail
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"mime"
+	"net/mail"
+	"time"
+)
+
+// Mailer sends messages to users
+type Mailer interface {
+	Send(ctx context.Context, m *Message) error
+}
+
+// Message sent as plain text
+type Message struct {
+	From    string
+	To      string
+	Subject string
+	Body    string
+}
+
+func (m *Message) Valid() error {
+
+	if m == nil {
+		return errors.New("no m *mail.Message provided")
+	}
+
+	if _, err := mail.ParseAddress(m.From); err != nil {
+		return fmt.Errorf("invalid m.From provided: %w", err)
+	}
+
+	if _, err := mail.ParseAddress(m.To); err != nil {
+		return fmt.Errorf("invalid m.To provided: %w", err)
+	}
+
+	if m.Subject == "" {
+		return errors.New("no m.Subject provided")
+	}
+
+	return nil
+}
+
+// Bytes returns m formatted as an RFC 5322 message
+func (m *Message) Bytes(now time.Time) []byte {
+
+	b := new(bytes.Buffer)
+
+	fmt.Fprintf(b, "From: %s\r\n", m.From)
+	fmt.Fprintf(b, "To: %s\r\n", m.To)
+	fmt.Fprintf(b, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", m.Subject))
+	fmt.Fprintf(b, "Date: %s\r\n", now.Format(time.RFC1123Z))
+	fmt.Fprintf(b, "MIME-Version: 1.0\r\n")
+	fmt.Fprintf(b, "Content-Type: text/plain; charset=utf-8\r\n")
+	fmt.Fprintf(b, "\r\n%s\r\n", m.Body)
+
+	return b.Bytes()
+}
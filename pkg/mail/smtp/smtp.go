@@ -0,0 +1,119 @@
+package smtp
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"time"
+
+	"github.com/rgynn/klottr/pkg/config"
+	klottrmail "github.com/rgynn/klottr/pkg/mail"
+)
+
+// Mailer sending messages through an SMTP server, using STARTTLS when the server offers it
+type Mailer struct {
+	cfg  *config.Config
+	addr string
+	host string
+	auth smtp.Auth
+}
+
+func NewMailer(cfg *config.Config) (klottrmail.Mailer, error) {
+
+	if cfg == nil {
+		return nil, errors.New("no cfg *config.Config provided")
+	}
+
+	host, _, err := net.SplitHostPort(cfg.SMTPAddr)
+	if err != nil {
+		return nil, err
+	}
+
+	mailer := &Mailer{
+		cfg:  cfg,
+		addr: cfg.SMTPAddr,
+		host: host,
+	}
+
+	if cfg.SMTPUsername != "" {
+		mailer.auth = smtp.PlainAuth("", cfg.SMTPUsername, cfg.SMTPPassword, host)
+	}
+
+	return mailer, nil
+}
+
+func (mailer *Mailer) Send(ctx context.Context, m *klottrmail.Message) error {
+
+	if err := m.Valid(); err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, mailer.cfg.RequestTimeout)
+	defer cancel()
+
+	conn, err := new(net.Dialer).DialContext(ctx, "tcp", mailer.addr)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	if deadline, ok := ctx.Deadline(); ok {
+		if err := conn.SetDeadline(deadline); err != nil {
+			return err
+		}
+	}
+
+	client, err := smtp.NewClient(conn, mailer.host)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	if ok, _ := client.Extension("STARTTLS"); ok {
+		if err := client.StartTLS(&tls.Config{ServerName: mailer.host}); err != nil {
+			return err
+		}
+	}
+
+	if mailer.auth != nil {
+		if err := client.Auth(mailer.auth); err != nil {
+			return err
+		}
+	}
+
+	from, err := mail.ParseAddress(m.From)
+	if err != nil {
+		return err
+	}
+
+	to, err := mail.ParseAddress(m.To)
+	if err != nil {
+		return err
+	}
+
+	if err := client.Mail(from.Address); err != nil {
+		return err
+	}
+
+	if err := client.Rcpt(to.Address); err != nil {
+		return err
+	}
+
+	w, err := client.Data()
+	if err != nil {
+		return err
+	}
+
+	if _, err := w.Write(m.Bytes(time.Now())); err != nil {
+		return err
+	}
+
+	if err := w.Close(); err != nil {
+		return err
+	}
+
+	return client.Quit()
+}
@@ -0,0 +1,336 @@
+package discovery
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+	"github.com/rgynn/klottr/pkg/config"
+	"github.com/rgynn/klottr/pkg/keys"
+	"github.com/rgynn/klottr/pkg/oidc"
+)
+
+// maxResponseBytes read from a provider response
+const maxResponseBytes = 1 << 20
+
+// refreshInterval after which the metadata and keys of the provider are fetched again, an id
+// token signed with an unknown key fetches them at most once per minRefreshInterval
+const (
+	refreshInterval    = time.Hour
+	minRefreshInterval = time.Minute
+)
+
+// leeway allowed between the clocks of the provider and the service
+const leeway = time.Minute
+
+// Provider configured through the OpenID Connect discovery document of its issuer
+type Provider struct {
+	cfg      *config.Config
+	provider *config.OIDCProvider
+	redirect string
+	client   *http.Client
+	mu       sync.Mutex
+	metadata *metadata
+	keys     *keys.Set
+	fetched  time.Time
+}
+
+type metadata struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+// NewProvider for provider, redirect is the callback url registered at the provider. The
+// discovery document is fetched on first use so the service starts while the provider is down
+func NewProvider(cfg *config.Config, provider *config.OIDCProvider, redirect string) (oidc.Provider, error) {
+
+	if cfg == nil {
+		return nil, errors.New("no cfg *config.Config provided")
+	}
+
+	if provider == nil {
+		return nil, errors.New("no provider *config.OIDCProvider provided")
+	}
+
+	return &Provider{
+		cfg:      cfg,
+		provider: provider,
+		redirect: redirect,
+		client:   &http.Client{Timeout: cfg.RequestTimeout},
+	}, nil
+}
+
+func (p *Provider) Name() string {
+	return p.provider.Name
+}
+
+func (p *Provider) AuthURL(ctx context.Context, state, nonce, challenge string) (string, error) {
+
+	md, _, err := p.load(ctx, false)
+	if err != nil {
+		return "", err
+	}
+
+	u, err := url.Parse(md.AuthorizationEndpoint)
+	if err != nil {
+		return "", fmt.Errorf("invalid authorization_endpoint of %s: %w", p.provider.Name, err)
+	}
+
+	q := u.Query()
+	q.Set("response_type", "code")
+	q.Set("client_id", p.provider.ClientID)
+	q.Set("redirect_uri", p.redirect)
+	q.Set("scope", strings.Join(p.provider.Scopes, " "))
+	q.Set("state", state)
+	q.Set("nonce", nonce)
+	q.Set("code_challenge", challenge)
+	q.Set("code_challenge_method", "S256")
+	u.RawQuery = q.Encode()
+
+	return u.String(), nil
+}
+
+type tokenResponse struct {
+	IDToken          string `json:"id_token"`
+	Error            string `json:"error"`
+	ErrorDescription string `json:"error_description"`
+}
+
+func (p *Provider) Exchange(ctx context.Context, code, verifier, nonce string) (*oidc.Identity, error) {
+
+	md, _, err := p.load(ctx, false)
+	if err != nil {
+		return nil, err
+	}
+
+	form := url.Values{}
+	form.Set("grant_type", "authorization_code")
+	form.Set("code", code)
+	form.Set("redirect_uri", p.redirect)
+	form.Set("code_verifier", verifier)
+	form.Set("client_id", p.provider.ClientID)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, md.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+
+	if p.provider.ClientSecret != "" {
+		req.SetBasicAuth(url.QueryEscape(p.provider.ClientID), url.QueryEscape(p.provider.ClientSecret))
+	}
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	result := new(tokenResponse)
+	if err := json.NewDecoder(io.LimitReader(resp.Body, maxResponseBytes)).Decode(result); err != nil {
+		return nil, fmt.Errorf("failed to decode token response of %s: %w", p.provider.Name, err)
+	}
+
+	if resp.StatusCode != http.StatusOK || result.Error != "" {
+		return nil, fmt.Errorf("token request to %s failed: %d %s %s", p.provider.Name, resp.StatusCode, result.Error, result.ErrorDescription)
+	}
+
+	if result.IDToken == "" {
+		return nil, fmt.Errorf("no id_token in token response of %s", p.provider.Name)
+	}
+
+	return p.verify(ctx, result.IDToken, nonce)
+}
+
+// audience of an id token is a single string or a list of them
+type audience []string
+
+func (a *audience) UnmarshalJSON(b []byte) error {
+
+	var s string
+	if err := json.Unmarshal(b, &s); err == nil {
+		*a = audience{s}
+		return nil
+	}
+
+	var list []string
+	if err := json.Unmarshal(b, &list); err != nil {
+		return err
+	}
+
+	*a = list
+
+	return nil
+}
+
+type idClaims struct {
+	Issuer            string   `json:"iss"`
+	Subject           string   `json:"sub"`
+	Audience          audience `json:"aud"`
+	AuthorizedParty   string   `json:"azp"`
+	ExpiresAt         int64    `json:"exp"`
+	IssuedAt          int64    `json:"iat"`
+	Nonce             string   `json:"nonce"`
+	Email             string   `json:"email"`
+	EmailVerified     bool     `json:"email_verified"`
+	PreferredUsername string   `json:"preferred_username"`
+	Name              string   `json:"name"`
+}
+
+func (c *idClaims) Valid() error {
+
+	now := time.Now()
+
+	if c.ExpiresAt == 0 || now.After(time.Unix(c.ExpiresAt, 0).Add(leeway)) {
+		return errors.New("id token expired")
+	}
+
+	if c.IssuedAt != 0 && now.Add(leeway).Before(time.Unix(c.IssuedAt, 0)) {
+		return errors.New("id token issued in the future")
+	}
+
+	return nil
+}
+
+// verify the signature and claims of an id token, a key the provider rotated in since the keys
+// were fetched is picked up by fetching them again
+func (p *Provider) verify(ctx context.Context, idToken, nonce string) (*oidc.Identity, error) {
+
+	md, set, err := p.load(ctx, false)
+	if err != nil {
+		return nil, err
+	}
+
+	claims := new(idClaims)
+
+	_, err = jwt.ParseWithClaims(idToken, claims, set.Keyfunc)
+	if err != nil {
+		var verr *jwt.ValidationError
+		if !errors.As(err, &verr) || !errors.Is(verr.Inner, keys.ErrUnknownKey) {
+			return nil, fmt.Errorf("invalid id token from %s: %w", p.provider.Name, err)
+		}
+		if md, set, err = p.load(ctx, true); err != nil {
+			return nil, err
+		}
+		if _, err := jwt.ParseWithClaims(idToken, claims, set.Keyfunc); err != nil {
+			return nil, fmt.Errorf("invalid id token from %s: %w", p.provider.Name, err)
+		}
+	}
+
+	if claims.Issuer != md.Issuer {
+		return nil, fmt.Errorf("id token from %s has issuer %s", p.provider.Name, claims.Issuer)
+	}
+
+	if !claims.Audience.contains(p.provider.ClientID) {
+		return nil, fmt.Errorf("id token from %s is not for client %s", p.provider.Name, p.provider.ClientID)
+	}
+
+	if len(claims.Audience) > 1 && claims.AuthorizedParty != p.provider.ClientID {
+		return nil, fmt.Errorf("id token from %s is authorized for %s", p.provider.Name, claims.AuthorizedParty)
+	}
+
+	if subtle.ConstantTimeCompare([]byte(claims.Nonce), []byte(nonce)) != 1 {
+		return nil, fmt.Errorf("id token from %s has the wrong nonce", p.provider.Name)
+	}
+
+	if claims.Subject == "" {
+		return nil, fmt.Errorf("id token from %s has no subject", p.provider.Name)
+	}
+
+	return &oidc.Identity{
+		Issuer:            claims.Issuer,
+		Subject:           claims.Subject,
+		Email:             claims.Email,
+		EmailVerified:     claims.EmailVerified,
+		PreferredUsername: claims.PreferredUsername,
+		Name:              claims.Name,
+	}, nil
+}
+
+func (a audience) contains(s string) bool {
+	for _, v := range a {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// load returns the metadata and keys of the provider, fetching them when they are missing,
+// older than refreshInterval or when force is set and they were not just fetched
+func (p *Provider) load(ctx context.Context, force bool) (*metadata, *keys.Set, error) {
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	age := time.Since(p.fetched)
+
+	if p.metadata != nil && age < refreshInterval && (!force || age < minRefreshInterval) {
+		return p.metadata, p.keys, nil
+	}
+
+	md := new(metadata)
+	if err := p.get(ctx, p.provider.Issuer+"/.well-known/openid-configuration", md); err != nil {
+		return nil, nil, err
+	}
+
+	if strings.TrimSuffix(md.Issuer, "/") != p.provider.Issuer {
+		return nil, nil, fmt.Errorf("discovery document of %s has issuer %s", p.provider.Name, md.Issuer)
+	}
+
+	if md.AuthorizationEndpoint == "" || md.TokenEndpoint == "" || md.JWKSURI == "" {
+		return nil, nil, fmt.Errorf("discovery document of %s misses endpoints", p.provider.Name)
+	}
+
+	jwks := new(keys.JWKS)
+	if err := p.get(ctx, md.JWKSURI, jwks); err != nil {
+		return nil, nil, err
+	}
+
+	p.metadata = md
+	p.keys = keys.NewSet(jwks)
+	p.fetched = time.Now()
+
+	return p.metadata, p.keys, nil
+}
+
+func (p *Provider) get(ctx context.Context, target string, v interface{}) error {
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, target, nil)
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to fetch %s: %w", target, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		io.Copy(ioutil.Discard, io.LimitReader(resp.Body, maxResponseBytes)) //nolint:errcheck
+		return fmt.Errorf("failed to fetch %s: %d", target, resp.StatusCode)
+	}
+
+	if err := json.NewDecoder(io.LimitReader(resp.Body, maxResponseBytes)).Decode(v); err != nil {
+		return fmt.Errorf("failed to decode %s: %w", target, err)
+	}
+
+	return nil
+}
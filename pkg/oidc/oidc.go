@@ -0,0 +1,52 @@
+package oidc
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+)
+
+var ErrUnknownProvider = errors.New("unknown identity provider")
+
+// Provider is an external identity provider users sign in with through the authorization code
+// flow with PKCE
+type Provider interface {
+	// Name of the provider in the /auth/oidc/{provider} routes
+	Name() string
+	// AuthURL returns the url of the authorization endpoint the user is sent to, nonce ends up
+	// in the id token and challenge is the PKCE code challenge of the verifier passed to Exchange
+	AuthURL(ctx context.Context, state, nonce, challenge string) (string, error)
+	// Exchange trades the code the user came back with for an id token, verifies it against
+	// nonce and returns the identity it is about
+	Exchange(ctx context.Context, code, verifier, nonce string) (*Identity, error)
+}
+
+// Identity of a user at a provider, Issuer and Subject identify it for good while the other
+// claims can change
+type Identity struct {
+	Issuer            string
+	Subject           string
+	Email             string
+	EmailVerified     bool
+	PreferredUsername string
+	Name              string
+}
+
+// NewVerifier returns a random PKCE code verifier as described in RFC 7636
+func NewVerifier() (string, error) {
+
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// Challenge returns the S256 code challenge of verifier
+func Challenge(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
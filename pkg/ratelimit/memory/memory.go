@@ -0,0 +1,95 @@
+package memory
+
+import (
+	"context"
+	"errors"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/rgynn/klottr/pkg/config"
+	"github.com/rgynn/klottr/pkg/ratelimit"
+)
+
+// Store keeping token buckets in memory, each instance of the service limits on its own
+type Store struct {
+	mu      sync.Mutex
+	cfg     *config.Config
+	buckets map[string]*bucket
+}
+
+type bucket struct {
+	tokens  float64
+	updated time.Time
+	full    time.Time
+}
+
+func NewStore(cfg *config.Config) (ratelimit.Store, error) {
+
+	if cfg == nil {
+		return nil, errors.New("no cfg *config.Config provided")
+	}
+
+	return &Store{
+		cfg:     cfg,
+		buckets: map[string]*bucket{},
+	}, nil
+}
+
+func (store *Store) Take(ctx context.Context, key string, limit *ratelimit.Limit, now time.Time) (*ratelimit.Result, error) {
+
+	if limit == nil || limit.Burst <= 0 || limit.Period <= 0 {
+		return nil, errors.New("no valid limit *ratelimit.Limit provided")
+	}
+
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	rate := limit.Rate()
+	burst := float64(limit.Burst)
+
+	b, ok := store.buckets[key]
+	if !ok {
+		b = &bucket{tokens: burst, updated: now}
+		store.buckets[key] = b
+	}
+
+	if elapsed := now.Sub(b.updated).Seconds(); elapsed > 0 {
+		b.tokens = math.Min(burst, b.tokens+elapsed*rate)
+		b.updated = now
+	}
+
+	result := &ratelimit.Result{}
+
+	if b.tokens >= 1 {
+		b.tokens--
+		result.Allowed = true
+	} else {
+		result.RetryAfter = seconds((1 - b.tokens) / rate)
+	}
+
+	b.full = now.Add(seconds((burst - b.tokens) / rate))
+
+	result.Remaining = int(b.tokens)
+	result.Reset = b.full.Sub(now)
+
+	return result, nil
+}
+
+func (store *Store) DeleteExpired(ctx context.Context, now time.Time) error {
+
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	for key, b := range store.buckets {
+		if !b.full.After(now) {
+			delete(store.buckets, key)
+		}
+	}
+
+	return nil
+}
+
+func seconds(s float64) time.Duration {
+	return time.Duration(s * float64(time.Second))
+}
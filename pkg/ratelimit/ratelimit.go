@@ -0,0 +1,35 @@
+package ratelimit
+
+import (
+	"context"
+	"time"
+)
+
+// Store keeps a token bucket per key, a shared store limits a key across every instance
+type Store interface {
+	// Take takes a token from the bucket of key at now, a new bucket starts out full
+	Take(ctx context.Context, key string, limit *Limit, now time.Time) (*Result, error)
+	// DeleteExpired forgets the buckets that are full again at now
+	DeleteExpired(ctx context.Context, now time.Time) error
+}
+
+// Limit of a token bucket holding Burst tokens, refilled at Burst tokens per Period
+type Limit struct {
+	Burst  int
+	Period time.Duration
+}
+
+// Rate returns how many tokens are refilled per second
+func (l *Limit) Rate() float64 {
+	return float64(l.Burst) / l.Period.Seconds()
+}
+
+// Result of taking a token
+type Result struct {
+	Allowed   bool
+	Remaining int
+	// Reset is how long until the bucket is full again
+	Reset time.Duration
+	// RetryAfter is how long until a token is available when none was, zero when one was taken
+	RetryAfter time.Duration
+}
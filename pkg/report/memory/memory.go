@@ -0,0 +1,160 @@
+package memory
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"sync"
+
+	"github.com/rgynn/klottr/pkg/config"
+	"github.com/rgynn/klottr/pkg/report"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Repository for reports kept in memory
+type Repository struct {
+	mu      sync.RWMutex
+	cfg     *config.Config
+	reports []*report.Model
+}
+
+func NewRepository(cfg *config.Config) (report.Repository, error) {
+
+	if cfg == nil {
+		return nil, errors.New("no cfg *config.Config provided")
+	}
+
+	return &Repository{
+		cfg:     cfg,
+		reports: []*report.Model{},
+	}, nil
+}
+
+func (repo *Repository) Create(ctx context.Context, m *report.Model) error {
+
+	if m == nil || m.ParentID == nil || m.Username == nil {
+		return errors.New("no m *report.Model provided")
+	}
+
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	for _, existing := range repo.reports {
+		if *existing.ParentID == *m.ParentID && *existing.Username == *m.Username {
+			return report.ErrAlreadyReported
+		}
+	}
+
+	stored := *m
+	if stored.ID == nil {
+		id := primitive.NewObjectID()
+		stored.ID = &id
+	}
+
+	repo.reports = append(repo.reports, &stored)
+
+	return nil
+}
+
+func (repo *Repository) ListQueue(ctx context.Context, opts *report.QueueOptions) ([]*report.Item, error) {
+
+	if opts == nil {
+		return nil, errors.New("no opts *report.QueueOptions provided")
+	}
+
+	from := opts.From
+	if opts.After != nil {
+		from = 0
+	}
+
+	repo.mu.RLock()
+	defer repo.mu.RUnlock()
+
+	items := map[primitive.ObjectID]*report.Item{}
+	for _, m := range repo.reports {
+		if m.Resolution != nil {
+			continue
+		}
+		if opts.ParentID != nil && *m.ParentID != *opts.ParentID {
+			continue
+		}
+		if opts.Categories != nil && !contains(opts.Categories, *m.Category) {
+			continue
+		}
+		item, ok := items[*m.ParentID]
+		if !ok {
+			item = &report.Item{
+				ParentID:     m.ParentID,
+				SlugType:     m.SlugType,
+				SlugID:       m.SlugID,
+				Category:     m.Category,
+				ThreadSlugID: m.ThreadSlugID,
+				Author:       m.Author,
+			}
+			items[*m.ParentID] = item
+		}
+		item.Reports++
+	}
+
+	matches := []*report.Item{}
+	for _, item := range items {
+		if report.After(item, opts.After) {
+			matches = append(matches, item)
+		}
+	}
+
+	sort.Slice(matches, func(i, j int) bool {
+		return report.After(matches[j], report.Key(matches[i]))
+	})
+
+	result := []*report.Item{}
+	for i, item := range matches {
+		if int64(i) < from {
+			continue
+		}
+		if opts.Size > 0 && int64(len(result)) >= opts.Size {
+			break
+		}
+		result = append(result, item)
+	}
+
+	return result, nil
+}
+
+func (repo *Repository) Resolve(ctx context.Context, parentID *primitive.ObjectID, resolution *report.Resolution) error {
+
+	if parentID == nil {
+		return errors.New("no parentID provided")
+	}
+
+	if resolution == nil {
+		return errors.New("no resolution *report.Resolution provided")
+	}
+
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	var n int
+	for _, m := range repo.reports {
+		if *m.ParentID == *parentID && m.Resolution == nil {
+			r := *resolution
+			m.Resolution = &r
+			n++
+		}
+	}
+
+	if n == 0 {
+		return report.ErrNotFound
+	}
+
+	return nil
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
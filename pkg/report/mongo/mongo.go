@@ -0,0 +1,162 @@
+package mongo
+
+import (
+	"context"
+	"errors"
+
+	"github.com/rgynn/klottr/pkg/config"
+	"github.com/rgynn/klottr/pkg/report"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+type Repository struct {
+	database   string
+	collection string
+	cfg        *config.Config
+	client     *mongo.Client
+}
+
+func NewRepository(cfg *config.Config, client *mongo.Client) (report.Repository, error) {
+
+	if cfg == nil {
+		return nil, errors.New("no cfg *config.Config provided")
+	}
+
+	if client == nil {
+		return nil, errors.New("no client *mongo.Client provided")
+	}
+
+	return &Repository{
+		database:   cfg.DatabaseName,
+		collection: "reports",
+		cfg:        cfg,
+		client:     client,
+	}, nil
+}
+
+func (repo *Repository) Create(ctx context.Context, m *report.Model) error {
+
+	if m == nil {
+		return errors.New("no m *report.Model provided")
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, repo.cfg.RequestTimeout)
+	defer cancel()
+
+	_, err := repo.client.Database(repo.database).Collection(repo.collection).InsertOne(ctx, m)
+	if err != nil {
+		if mongo.IsDuplicateKeyError(err) {
+			return report.ErrAlreadyReported
+		}
+		return err
+	}
+
+	return nil
+}
+
+func (repo *Repository) ListQueue(ctx context.Context, opts *report.QueueOptions) ([]*report.Item, error) {
+
+	if opts == nil {
+		return nil, errors.New("no opts *report.QueueOptions provided")
+	}
+
+	match := bson.D{
+		primitive.E{Key: "resolution", Value: bson.M{"$exists": false}},
+	}
+
+	if opts.ParentID != nil {
+		match = append(match, primitive.E{Key: "parent_id", Value: *opts.ParentID})
+	}
+
+	if opts.Categories != nil {
+		match = append(match, primitive.E{Key: "category", Value: bson.M{"$in": opts.Categories}})
+	}
+
+	pipeline := mongo.Pipeline{
+		bson.D{primitive.E{Key: "$match", Value: match}},
+		bson.D{primitive.E{Key: "$group", Value: bson.D{
+			primitive.E{Key: "_id", Value: "$parent_id"},
+			primitive.E{Key: "slug_type", Value: bson.M{"$first": "$slug_type"}},
+			primitive.E{Key: "slug_id", Value: bson.M{"$first": "$slug_id"}},
+			primitive.E{Key: "category", Value: bson.M{"$first": "$category"}},
+			primitive.E{Key: "thread_slug_id", Value: bson.M{"$first": "$thread_slug_id"}},
+			primitive.E{Key: "author", Value: bson.M{"$first": "$author"}},
+			primitive.E{Key: "reports", Value: bson.M{"$sum": 1}},
+		}}},
+	}
+
+	from := opts.From
+	if opts.After != nil {
+		id, err := primitive.ObjectIDFromHex(opts.After.ID)
+		if err != nil {
+			return nil, err
+		}
+		reports := int64(opts.After.Score)
+		pipeline = append(pipeline, bson.D{primitive.E{Key: "$match", Value: bson.M{"$or": bson.A{
+			bson.M{"reports": bson.M{"$lt": reports}},
+			bson.M{"reports": reports, "_id": bson.M{"$lt": id}},
+		}}}})
+		from = 0
+	}
+
+	pipeline = append(pipeline, bson.D{primitive.E{Key: "$sort", Value: bson.D{
+		primitive.E{Key: "reports", Value: -1},
+		primitive.E{Key: "_id", Value: -1},
+	}}})
+
+	if from > 0 {
+		pipeline = append(pipeline, bson.D{primitive.E{Key: "$skip", Value: from}})
+	}
+
+	if opts.Size > 0 {
+		pipeline = append(pipeline, bson.D{primitive.E{Key: "$limit", Value: opts.Size}})
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, repo.cfg.RequestTimeout)
+	defer cancel()
+
+	cursor, err := repo.client.Database(repo.database).Collection(repo.collection).Aggregate(ctx, pipeline)
+	if err != nil {
+		return nil, err
+	}
+
+	result := []*report.Item{}
+	if err := cursor.All(ctx, &result); err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+func (repo *Repository) Resolve(ctx context.Context, parentID *primitive.ObjectID, resolution *report.Resolution) error {
+
+	if parentID == nil {
+		return errors.New("no parentID provided")
+	}
+
+	if resolution == nil {
+		return errors.New("no resolution *report.Resolution provided")
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, repo.cfg.RequestTimeout)
+	defer cancel()
+
+	res, err := repo.client.Database(repo.database).Collection(repo.collection).UpdateMany(ctx, bson.D{
+		primitive.E{Key: "parent_id", Value: *parentID},
+		primitive.E{Key: "resolution", Value: bson.M{"$exists": false}},
+	}, bson.D{primitive.E{
+		Key:   "$set",
+		Value: bson.D{primitive.E{Key: "resolution", Value: resolution}},
+	}})
+	if err != nil {
+		return err
+	}
+
+	if res.MatchedCount == 0 {
+		return report.ErrNotFound
+	}
+
+	return nil
+}
@@ -0,0 +1,207 @@
+package report
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/rgynn/klottr/pkg/cursor"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	SlugTypeThreads  = "threads"
+	SlugTypeComments = "comments"
+)
+
+const (
+	ReasonSpam     = "spam"
+	ReasonAbuse    = "abuse"
+	ReasonOffTopic = "off_topic"
+	ReasonIllegal  = "illegal"
+	ReasonOther    = "other"
+)
+
+// Reasons a thread or comment can be reported for
+var Reasons = []string{ReasonSpam, ReasonAbuse, ReasonOffTopic, ReasonIllegal, ReasonOther}
+
+const (
+	// ActionDismiss closes the reports and leaves the content in place
+	ActionDismiss = "dismiss"
+	// ActionRemove deletes the reported content
+	ActionRemove = "remove"
+	// ActionWarn adds a warning to the counters of the author
+	ActionWarn = "warn"
+	// ActionBan bans the author from the category of the content
+	ActionBan = "ban"
+)
+
+// Actions a moderator can resolve reports with
+var Actions = []string{ActionDismiss, ActionRemove, ActionWarn, ActionBan}
+
+var ErrNotFound = errors.New("report not found")
+
+var ErrAlreadyReported = errors.New("already reported")
+
+type Repository interface {
+	// Create stores a report, returning ErrAlreadyReported when the user reported the same thread or comment before
+	Create(ctx context.Context, m *Model) error
+	// ListQueue lists the threads and comments with open reports, most reported first
+	ListQueue(ctx context.Context, opts *QueueOptions) ([]*Item, error)
+	// Resolve closes the open reports of the thread or comment with parentID, returning ErrNotFound when there are none
+	Resolve(ctx context.Context, parentID *primitive.ObjectID, resolution *Resolution) error
+}
+
+// Model of a report of a thread or comment by a user
+type Model struct {
+	ID *primitive.ObjectID `json:"id,omitempty" bson:"_id,omitempty"`
+	// ParentID is the id of the reported thread or comment
+	ParentID     *primitive.ObjectID `json:"parent_id"  bson:"parent_id"`
+	SlugType     *string             `json:"slug_type"  bson:"slug_type"`
+	SlugID       *string             `json:"slug_id"  bson:"slug_id"`
+	Category     *string             `json:"category"  bson:"category"`
+	ThreadSlugID *string             `json:"thread_slug_id"  bson:"thread_slug_id"`
+	// Author of the reported thread or comment
+	Author *string `json:"author"  bson:"author"`
+	// Username of the user who reported it
+	Username *string   `json:"username"  bson:"username"`
+	Reason   *string   `json:"reason"  bson:"reason"`
+	Comment  string    `json:"comment,omitempty"  bson:"comment,omitempty"`
+	Created  time.Time `json:"created"  bson:"created"`
+	// Resolution is set once a moderator acted on the report
+	Resolution *Resolution `json:"resolution,omitempty"  bson:"resolution,omitempty"`
+}
+
+// Resolution of a report, recorded with the username of the moderator
+type Resolution struct {
+	Action   *string   `json:"action"  bson:"action"`
+	Username *string   `json:"username"  bson:"username"`
+	Created  time.Time `json:"created"  bson:"created"`
+}
+
+// Item of the moderation queue, a reported thread or comment with the number of open reports
+type Item struct {
+	ParentID     *primitive.ObjectID `json:"parent_id"  bson:"_id"`
+	SlugType     *string             `json:"slug_type"  bson:"slug_type"`
+	SlugID       *string             `json:"slug_id"  bson:"slug_id"`
+	Category     *string             `json:"category"  bson:"category"`
+	ThreadSlugID *string             `json:"thread_slug_id"  bson:"thread_slug_id"`
+	Author       *string             `json:"author"  bson:"author"`
+	Reports      int64               `json:"reports"  bson:"reports"`
+}
+
+// QueueOptions for listing the moderation queue
+type QueueOptions struct {
+	// Categories limits the queue to reports in these categories, nil lists every category
+	Categories []string
+	// ParentID limits the queue to the thread or comment with this id
+	ParentID *primitive.ObjectID
+	After    *cursor.Cursor
+	From     int64
+	Size     int64
+}
+
+func (m *Model) ValidForSave() error {
+
+	if m == nil {
+		return errors.New("no m *report.Model provided")
+	}
+
+	if m.ID != nil {
+		return errors.New("cannot provide m.ID for new report")
+	}
+
+	if m.ParentID == nil {
+		return errors.New("no m.ParentID provided")
+	}
+
+	if m.SlugType == nil || (*m.SlugType != SlugTypeThreads && *m.SlugType != SlugTypeComments) {
+		return errors.New("m.SlugType must be one of: threads, comments")
+	}
+
+	if m.SlugID == nil || m.Category == nil || m.ThreadSlugID == nil {
+		return errors.New("no m.SlugID, m.Category or m.ThreadSlugID provided")
+	}
+
+	if m.Username == nil {
+		return errors.New("no m.Username provided")
+	}
+
+	if m.Reason == nil || !contains(Reasons, *m.Reason) {
+		return fmt.Errorf("m.Reason must be one of: %s", strings.Join(Reasons, ", "))
+	}
+
+	if len(m.Comment) > 512 {
+		return errors.New("m.Comment cannot be longer than 512 characters")
+	}
+
+	if m.Created.IsZero() {
+		return errors.New("no m.Created provided")
+	}
+
+	if m.Resolution != nil {
+		return errors.New("cannot provide m.Resolution for new report")
+	}
+
+	return nil
+}
+
+func (r *Resolution) ValidForSave() error {
+
+	if r == nil {
+		return errors.New("no r *report.Resolution provided")
+	}
+
+	if r.Action == nil || !contains(Actions, *r.Action) {
+		return fmt.Errorf("r.Action must be one of: %s", strings.Join(Actions, ", "))
+	}
+
+	if r.Username == nil {
+		return errors.New("no r.Username provided")
+	}
+
+	if r.Created.IsZero() {
+		return errors.New("no r.Created provided")
+	}
+
+	return nil
+}
+
+// Key of item, used as the cursor to continue listing the queue after item
+func Key(item *Item) *cursor.Cursor {
+	c := &cursor.Cursor{Score: float64(item.Reports)}
+	if item.ParentID != nil {
+		c.ID = item.ParentID.Hex()
+	}
+	return c
+}
+
+// After reports whether item is listed after the item at c
+func After(item *Item, c *cursor.Cursor) bool {
+	if c == nil {
+		return true
+	}
+	if float64(item.Reports) != c.Score {
+		return float64(item.Reports) < c.Score
+	}
+	return item.ParentID != nil && item.ParentID.Hex() < c.ID
+}
+
+// NextCursor returns the cursor continuing after the page list, or nil when it was the last page
+func NextCursor(list []*Item, size int64) *cursor.Cursor {
+	if len(list) == 0 || size <= 0 || int64(len(list)) < size {
+		return nil
+	}
+	return Key(list[len(list)-1])
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,176 @@
+package sql
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"strings"
+
+	"github.com/rgynn/klottr/pkg/config"
+	"github.com/rgynn/klottr/pkg/report"
+	"github.com/rgynn/klottr/pkg/sqldb"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Repository for reports in a sql database
+type Repository struct {
+	cfg *config.Config
+	db  *sqldb.DB
+}
+
+func NewRepository(cfg *config.Config, db *sqldb.DB) (report.Repository, error) {
+
+	if cfg == nil {
+		return nil, errors.New("no cfg *config.Config provided")
+	}
+
+	if db == nil {
+		return nil, errors.New("no db *sqldb.DB provided")
+	}
+
+	return &Repository{
+		cfg: cfg,
+		db:  db,
+	}, nil
+}
+
+func (repo *Repository) Create(ctx context.Context, m *report.Model) error {
+
+	if m == nil {
+		return errors.New("no m *report.Model provided")
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, repo.cfg.RequestTimeout)
+	defer cancel()
+
+	id := m.ID
+	if id == nil {
+		id = sqldb.NewID()
+	}
+
+	_, err := repo.db.Exec(ctx, `INSERT INTO reports (id, parent_id, slug_type, slug_id, category, thread_slug_id, author, username, reason, comment, created)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		id.Hex(),
+		sqldb.FormatID(m.ParentID),
+		m.SlugType,
+		m.SlugID,
+		m.Category,
+		m.ThreadSlugID,
+		m.Author,
+		m.Username,
+		m.Reason,
+		m.Comment,
+		m.Created,
+	)
+	if err != nil {
+		if sqldb.IsUniqueViolation(err) {
+			return report.ErrAlreadyReported
+		}
+		return err
+	}
+
+	return nil
+}
+
+func (repo *Repository) ListQueue(ctx context.Context, opts *report.QueueOptions) ([]*report.Item, error) {
+
+	if opts == nil {
+		return nil, errors.New("no opts *report.QueueOptions provided")
+	}
+
+	if opts.Categories != nil && len(opts.Categories) == 0 {
+		return []*report.Item{}, nil
+	}
+
+	where := `resolved IS NULL`
+	args := []interface{}{}
+
+	if opts.ParentID != nil {
+		where += ` AND parent_id = ?`
+		args = append(args, opts.ParentID.Hex())
+	}
+
+	if opts.Categories != nil {
+		where += ` AND category IN (?` + strings.Repeat(`, ?`, len(opts.Categories)-1) + `)`
+		for _, category := range opts.Categories {
+			args = append(args, category)
+		}
+	}
+
+	having := ``
+	from := opts.From
+	if opts.After != nil {
+		having = ` HAVING COUNT(*) < ? OR (COUNT(*) = ? AND parent_id < ?)`
+		args = append(args, int64(opts.After.Score), int64(opts.After.Score), opts.After.ID)
+		from = 0
+	}
+
+	limit, limitArgs := repo.db.LimitOffset(from, opts.Size)
+
+	ctx, cancel := context.WithTimeout(ctx, repo.cfg.RequestTimeout)
+	defer cancel()
+
+	rows, err := repo.db.Query(ctx, `SELECT parent_id, slug_type, slug_id, category, thread_slug_id, author, COUNT(*) FROM reports WHERE `+where+`
+		GROUP BY parent_id, slug_type, slug_id, category, thread_slug_id, author`+having+` ORDER BY COUNT(*) DESC, parent_id DESC`+limit, append(args, limitArgs...)...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	result := []*report.Item{}
+	for rows.Next() {
+
+		item := new(report.Item)
+		var parentID sql.NullString
+
+		if err := rows.Scan(
+			&parentID,
+			&item.SlugType,
+			&item.SlugID,
+			&item.Category,
+			&item.ThreadSlugID,
+			&item.Author,
+			&item.Reports,
+		); err != nil {
+			return nil, err
+		}
+
+		if item.ParentID, err = sqldb.ParseID(parentID); err != nil {
+			return nil, err
+		}
+
+		result = append(result, item)
+	}
+
+	return result, rows.Err()
+}
+
+func (repo *Repository) Resolve(ctx context.Context, parentID *primitive.ObjectID, resolution *report.Resolution) error {
+
+	if parentID == nil {
+		return errors.New("no parentID provided")
+	}
+
+	if resolution == nil {
+		return errors.New("no resolution *report.Resolution provided")
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, repo.cfg.RequestTimeout)
+	defer cancel()
+
+	res, err := repo.db.Exec(ctx, `UPDATE reports SET resolved_action = ?, resolved_by = ?, resolved = ? WHERE parent_id = ? AND resolved IS NULL`,
+		resolution.Action,
+		resolution.Username,
+		resolution.Created,
+		parentID.Hex(),
+	)
+	if err != nil {
+		return err
+	}
+
+	if n, err := res.RowsAffected(); err != nil || n == 0 {
+		return report.ErrNotFound
+	}
+
+	return nil
+}
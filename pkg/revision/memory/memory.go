@@ -0,0 +1,135 @@
+package memory
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/rgynn/klottr/pkg/config"
+	"github.com/rgynn/klottr/pkg/cursor"
+	"github.com/rgynn/klottr/pkg/revision"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Repository for revisions kept in memory
+type Repository struct {
+	mu        sync.RWMutex
+	cfg       *config.Config
+	revisions []*revision.Model
+}
+
+func NewRepository(cfg *config.Config) (revision.Repository, error) {
+
+	if cfg == nil {
+		return nil, errors.New("no cfg *config.Config provided")
+	}
+
+	return &Repository{
+		cfg:       cfg,
+		revisions: []*revision.Model{},
+	}, nil
+}
+
+func (repo *Repository) Create(ctx context.Context, m *revision.Model) error {
+
+	if m == nil {
+		return errors.New("no m *revision.Model provided")
+	}
+
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	repo.purge()
+
+	stored := *m
+	if stored.ID == nil {
+		id := primitive.NewObjectID()
+		stored.ID = &id
+	}
+
+	repo.revisions = append(repo.revisions, &stored)
+
+	return nil
+}
+
+func (repo *Repository) ListByParentID(ctx context.Context, parentID *primitive.ObjectID, after *cursor.Cursor, from, size int64) ([]*revision.Model, error) {
+
+	if parentID == nil {
+		return nil, errors.New("no parentID provided")
+	}
+
+	if after != nil {
+		from = 0
+	}
+
+	repo.mu.RLock()
+	defer repo.mu.RUnlock()
+
+	matches := []*revision.Model{}
+	for _, m := range repo.revisions {
+		if !repo.expired(m) && m.ParentID != nil && *m.ParentID == *parentID && revision.After(m, after) {
+			matches = append(matches, m)
+		}
+	}
+
+	sort.SliceStable(matches, func(i, j int) bool {
+		return revision.After(matches[j], revision.Key(matches[i]))
+	})
+
+	result := []*revision.Model{}
+	for i, m := range matches {
+		if int64(i) < from {
+			continue
+		}
+		if size > 0 && int64(len(result)) >= size {
+			break
+		}
+		c := *m
+		result = append(result, &c)
+	}
+
+	return result, nil
+}
+
+func (repo *Repository) DeleteByParentIDs(ctx context.Context, parentIDs []primitive.ObjectID) error {
+
+	deleted := map[primitive.ObjectID]bool{}
+	for _, id := range parentIDs {
+		deleted[id] = true
+	}
+
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	live := repo.revisions[:0]
+	for _, m := range repo.revisions {
+		if m.ParentID != nil && deleted[*m.ParentID] {
+			continue
+		}
+		live = append(live, m)
+	}
+	repo.revisions = live
+
+	return nil
+}
+
+// expired mirrors the expireAfterSeconds index on created in mongo
+func (repo *Repository) expired(m *revision.Model) bool {
+	if repo.cfg.PostTTLSeconds <= 0 {
+		return false
+	}
+	return time.Since(m.Created) > time.Duration(repo.cfg.PostTTLSeconds)*time.Second
+}
+
+// purge removes expired revisions, callers must hold the write lock
+func (repo *Repository) purge() {
+	live := repo.revisions[:0]
+	for _, m := range repo.revisions {
+		if !repo.expired(m) {
+			live = append(live, m)
+		}
+	}
+	repo.revisions = live
+}
@@ -0,0 +1,116 @@
+package mongo
+
+import (
+	"context"
+	"errors"
+
+	"github.com/rgynn/klottr/pkg/config"
+	"github.com/rgynn/klottr/pkg/cursor"
+	"github.com/rgynn/klottr/pkg/revision"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type Repository struct {
+	database   string
+	collection string
+	cfg        *config.Config
+	client     *mongo.Client
+}
+
+func NewRepository(cfg *config.Config, client *mongo.Client) (revision.Repository, error) {
+
+	if cfg == nil {
+		return nil, errors.New("no cfg *config.Config provided")
+	}
+
+	if client == nil {
+		return nil, errors.New("no client *mongo.Client provided")
+	}
+
+	return &Repository{
+		database:   cfg.DatabaseName,
+		collection: "revisions",
+		cfg:        cfg,
+		client:     client,
+	}, nil
+}
+
+func (repo *Repository) Create(ctx context.Context, m *revision.Model) error {
+
+	if m == nil {
+		return errors.New("no m *revision.Model provided")
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, repo.cfg.RequestTimeout)
+	defer cancel()
+
+	_, err := repo.client.Database(repo.database).Collection(repo.collection).InsertOne(ctx, m)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (repo *Repository) ListByParentID(ctx context.Context, parentID *primitive.ObjectID, after *cursor.Cursor, from, size int64) ([]*revision.Model, error) {
+
+	if parentID == nil {
+		return nil, errors.New("no parentID provided")
+	}
+
+	filter := bson.D{
+		primitive.E{Key: "parent_id", Value: *parentID},
+	}
+
+	if after != nil && after.Time != nil {
+		id, err := primitive.ObjectIDFromHex(after.ID)
+		if err != nil {
+			return nil, err
+		}
+		filter = append(filter, primitive.E{Key: "$or", Value: bson.A{
+			bson.M{"replaced": bson.M{"$lt": *after.Time}},
+			bson.M{"replaced": *after.Time, "_id": bson.M{"$lt": id}},
+		}})
+		from = 0
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, repo.cfg.RequestTimeout)
+	defer cancel()
+
+	cursor, err := repo.client.Database(repo.database).Collection(repo.collection).Find(ctx, filter, options.Find().SetSort(bson.D{
+		primitive.E{Key: "replaced", Value: -1},
+		primitive.E{Key: "_id", Value: -1},
+	}).SetSkip(from).SetLimit(size))
+	if err != nil {
+		return nil, err
+	}
+
+	result := []*revision.Model{}
+	if err := cursor.All(ctx, &result); err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+func (repo *Repository) DeleteByParentIDs(ctx context.Context, parentIDs []primitive.ObjectID) error {
+
+	if len(parentIDs) == 0 {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, repo.cfg.RequestTimeout)
+	defer cancel()
+
+	_, err := repo.client.Database(repo.database).Collection(repo.collection).DeleteMany(ctx, bson.D{
+		primitive.E{Key: "parent_id", Value: bson.M{"$in": parentIDs}},
+	})
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
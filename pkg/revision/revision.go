@@ -0,0 +1,107 @@
+package revision
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/rgynn/klottr/pkg/cursor"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	SlugTypeThreads  = "threads"
+	SlugTypeComments = "comments"
+)
+
+var ErrEditWindow = errors.New("edit window has passed")
+
+type Repository interface {
+	Create(ctx context.Context, m *Model) error
+	// ListByParentID lists the revisions of the thread or comment with parentID, most recently replaced first
+	ListByParentID(ctx context.Context, parentID *primitive.ObjectID, after *cursor.Cursor, from, size int64) ([]*Model, error)
+	// DeleteByParentIDs deletes the revisions of the threads and comments with parentIDs
+	DeleteByParentIDs(ctx context.Context, parentIDs []primitive.ObjectID) error
+}
+
+// Model of a prior version of an edited thread or comment
+type Model struct {
+	ID       *primitive.ObjectID `json:"id,omitempty" bson:"_id,omitempty"`
+	ParentID *primitive.ObjectID `json:"parent_id,omitempty"  bson:"parent_id,omitempty"`
+	SlugType *string             `json:"slug_type"  bson:"slug_type"`
+	SlugID   *string             `json:"slug_id"  bson:"slug_id"`
+	Title    *string             `json:"title,omitempty"  bson:"title,omitempty"`
+	Content  string              `json:"content"  bson:"content"`
+	// Created is when this version was written
+	Created time.Time `json:"created"  bson:"created"`
+	// Replaced is when an edit replaced this version
+	Replaced time.Time `json:"replaced"  bson:"replaced"`
+}
+
+func (m *Model) ValidForSave() error {
+
+	if m == nil {
+		return errors.New("no m *revision.Model provided")
+	}
+
+	if m.ID != nil {
+		return errors.New("cannot provide m.ID for new revision")
+	}
+
+	if m.ParentID == nil {
+		return errors.New("no m.ParentID provided")
+	}
+
+	if m.SlugType == nil || (*m.SlugType != SlugTypeThreads && *m.SlugType != SlugTypeComments) {
+		return errors.New("m.SlugType must be one of: threads, comments")
+	}
+
+	if m.SlugID == nil {
+		return errors.New("no m.SlugID provided")
+	}
+
+	if m.Created.IsZero() {
+		return errors.New("no m.Created provided")
+	}
+
+	if m.Replaced.IsZero() {
+		return errors.New("no m.Replaced provided")
+	}
+
+	return nil
+}
+
+// Editable reports whether a thread or comment created at created can still be edited at now,
+// a window of 0 does not limit edits
+func Editable(created, now time.Time, window time.Duration) bool {
+	return window <= 0 || now.Sub(created) <= window
+}
+
+// Key of m, used as the cursor to continue listing revisions after m
+func Key(m *Model) *cursor.Cursor {
+	replaced := m.Replaced
+	c := &cursor.Cursor{Time: &replaced}
+	if m.ID != nil {
+		c.ID = m.ID.Hex()
+	}
+	return c
+}
+
+// After reports whether m is listed after the revision at c
+func After(m *Model, c *cursor.Cursor) bool {
+	if c == nil {
+		return true
+	}
+	if c.Time != nil && !m.Replaced.Equal(*c.Time) {
+		return m.Replaced.Before(*c.Time)
+	}
+	return m.ID != nil && m.ID.Hex() < c.ID
+}
+
+// NextCursor returns the cursor continuing after the page list, or nil when it was the last page
+func NextCursor(list []*Model, size int64) *cursor.Cursor {
+	if len(list) == 0 || size <= 0 || int64(len(list)) < size {
+		return nil
+	}
+	return Key(list[len(list)-1])
+}
@@ -0,0 +1,170 @@
+package sql
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"strings"
+
+	"github.com/rgynn/klottr/pkg/config"
+	"github.com/rgynn/klottr/pkg/cursor"
+	"github.com/rgynn/klottr/pkg/revision"
+	"github.com/rgynn/klottr/pkg/sqldb"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const columns = `id, parent_id, slug_type, slug_id, title, content, created, replaced`
+
+// deleteBatchSize is the number of parent ids bound per delete statement
+const deleteBatchSize = 500
+
+// Repository for revisions in a sql database
+type Repository struct {
+	cfg *config.Config
+	db  *sqldb.DB
+}
+
+func NewRepository(cfg *config.Config, db *sqldb.DB) (revision.Repository, error) {
+
+	if cfg == nil {
+		return nil, errors.New("no cfg *config.Config provided")
+	}
+
+	if db == nil {
+		return nil, errors.New("no db *sqldb.DB provided")
+	}
+
+	return &Repository{
+		cfg: cfg,
+		db:  db,
+	}, nil
+}
+
+func (repo *Repository) Create(ctx context.Context, m *revision.Model) error {
+
+	if m == nil {
+		return errors.New("no m *revision.Model provided")
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, repo.cfg.RequestTimeout)
+	defer cancel()
+
+	id := m.ID
+	if id == nil {
+		id = sqldb.NewID()
+	}
+
+	_, err := repo.db.Exec(ctx, `INSERT INTO revisions (`+columns+`) VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+		id.Hex(),
+		sqldb.FormatID(m.ParentID),
+		m.SlugType,
+		m.SlugID,
+		m.Title,
+		m.Content,
+		m.Created,
+		m.Replaced,
+	)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (repo *Repository) ListByParentID(ctx context.Context, parentID *primitive.ObjectID, after *cursor.Cursor, from, size int64) ([]*revision.Model, error) {
+
+	if parentID == nil {
+		return nil, errors.New("no parentID provided")
+	}
+
+	where := `parent_id = ?`
+	args := []interface{}{parentID.Hex()}
+
+	if after != nil && after.Time != nil {
+		where += ` AND (replaced < ? OR (replaced = ? AND id < ?))`
+		args = append(args, *after.Time, *after.Time, after.ID)
+		from = 0
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, repo.cfg.RequestTimeout)
+	defer cancel()
+
+	limit, limitArgs := repo.db.LimitOffset(from, size)
+
+	rows, err := repo.db.Query(ctx, `SELECT `+columns+` FROM revisions WHERE `+where+` ORDER BY replaced DESC, id DESC`+limit, append(args, limitArgs...)...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	result := []*revision.Model{}
+	for rows.Next() {
+		m, err := scan(rows)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, m)
+	}
+
+	return result, rows.Err()
+}
+
+func (repo *Repository) DeleteByParentIDs(ctx context.Context, parentIDs []primitive.ObjectID) error {
+
+	ctx, cancel := context.WithTimeout(ctx, repo.cfg.RequestTimeout)
+	defer cancel()
+
+	for start := 0; start < len(parentIDs); start += deleteBatchSize {
+
+		end := start + deleteBatchSize
+		if end > len(parentIDs) {
+			end = len(parentIDs)
+		}
+
+		args := make([]interface{}, 0, end-start)
+		for _, id := range parentIDs[start:end] {
+			args = append(args, id.Hex())
+		}
+
+		if _, err := repo.db.Exec(ctx, `DELETE FROM revisions WHERE parent_id IN (?`+strings.Repeat(`, ?`, len(args)-1)+`)`, args...); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+type scanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scan(row scanner) (*revision.Model, error) {
+
+	m := new(revision.Model)
+	var id, parentID sql.NullString
+
+	if err := row.Scan(
+		&id,
+		&parentID,
+		&m.SlugType,
+		&m.SlugID,
+		&m.Title,
+		&m.Content,
+		&m.Created,
+		&m.Replaced,
+	); err != nil {
+		return nil, err
+	}
+
+	var err error
+
+	if m.ID, err = sqldb.ParseID(id); err != nil {
+		return nil, err
+	}
+
+	if m.ParentID, err = sqldb.ParseID(parentID); err != nil {
+		return nil, err
+	}
+
+	return m, nil
+}
@@ -0,0 +1,298 @@
+package sqldb
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// migrations are applied in order and recorded in schema_migrations, never edit an
+// existing entry, append a new one instead
+var migrations = [][]string{
+	{
+		`CREATE TABLE threads (
+			id TEXT PRIMARY KEY,
+			category TEXT NOT NULL,
+			username TEXT,
+			slug_id TEXT NOT NULL,
+			slug_title TEXT NOT NULL,
+			title TEXT,
+			url TEXT,
+			content TEXT NOT NULL,
+			counters_votes BIGINT NOT NULL DEFAULT 0,
+			counters_comments BIGINT NOT NULL DEFAULT 0,
+			created TIMESTAMP NOT NULL,
+			updated TIMESTAMP,
+			UNIQUE (category, slug_id)
+		)`,
+		`CREATE INDEX threads_category_slug_idx ON threads (category, slug_id, slug_title)`,
+		`CREATE INDEX threads_username_idx ON threads (username)`,
+		`CREATE INDEX threads_created_idx ON threads (created)`,
+		`CREATE TABLE comments (
+			id TEXT PRIMARY KEY,
+			thread_id TEXT NOT NULL,
+			reply_to_id TEXT,
+			slug_id TEXT NOT NULL UNIQUE,
+			username TEXT,
+			content TEXT NOT NULL,
+			votes BIGINT NOT NULL DEFAULT 0,
+			created TIMESTAMP NOT NULL,
+			updated TIMESTAMP
+		)`,
+		`CREATE INDEX comments_thread_id_username_idx ON comments (thread_id, username)`,
+		`CREATE INDEX comments_username_idx ON comments (username)`,
+		`CREATE INDEX comments_created_idx ON comments (created)`,
+		`CREATE TABLE users (
+			id TEXT PRIMARY KEY,
+			role TEXT NOT NULL,
+			validated BOOLEAN NOT NULL DEFAULT FALSE,
+			username TEXT NOT NULL UNIQUE,
+			password_hash TEXT,
+			email_hash TEXT,
+			counters_num_threads BIGINT NOT NULL DEFAULT 0,
+			counters_num_comments BIGINT NOT NULL DEFAULT 0,
+			counters_votes_threads BIGINT NOT NULL DEFAULT 0,
+			counters_votes_comments BIGINT NOT NULL DEFAULT 0,
+			created TIMESTAMP,
+			updated TIMESTAMP,
+			deactivated TIMESTAMP
+		)`,
+		`CREATE INDEX users_username_role_idx ON users (username, role)`,
+		`CREATE TABLE user_votes (
+			username TEXT NOT NULL,
+			slug_type TEXT NOT NULL,
+			slug_id TEXT NOT NULL,
+			value SMALLINT NOT NULL,
+			PRIMARY KEY (username, slug_type, slug_id)
+		)`,
+	},
+	{
+		`CREATE TABLE categories (
+			id TEXT PRIMARY KEY,
+			name TEXT NOT NULL UNIQUE,
+			description TEXT NOT NULL DEFAULT '',
+			ttl_seconds INTEGER NOT NULL DEFAULT 0,
+			rules_admin_only BOOLEAN NOT NULL DEFAULT FALSE,
+			rules_require_url BOOLEAN NOT NULL DEFAULT FALSE,
+			rules_disallow_url BOOLEAN NOT NULL DEFAULT FALSE,
+			rules_max_content_length INTEGER NOT NULL DEFAULT 0,
+			created TIMESTAMP NOT NULL,
+			archived TIMESTAMP
+		)`,
+	},
+	{
+		`ALTER TABLE threads ADD COLUMN counters_ups BIGINT NOT NULL DEFAULT 0`,
+		`ALTER TABLE threads ADD COLUMN counters_downs BIGINT NOT NULL DEFAULT 0`,
+		`CREATE INDEX threads_category_created_idx ON threads (category, created, id)`,
+		`CREATE INDEX threads_category_votes_idx ON threads (category, counters_votes, created, id)`,
+	},
+	{
+		`CREATE INDEX comments_thread_id_created_idx ON comments (thread_id, created, id)`,
+	},
+	{
+		`ALTER TABLE comments ADD COLUMN depth INTEGER NOT NULL DEFAULT 0`,
+	},
+	{
+		`ALTER TABLE threads ADD COLUMN edited BOOLEAN NOT NULL DEFAULT FALSE`,
+		`ALTER TABLE comments ADD COLUMN edited BOOLEAN NOT NULL DEFAULT FALSE`,
+		`CREATE TABLE revisions (
+			id TEXT PRIMARY KEY,
+			parent_id TEXT NOT NULL,
+			slug_type TEXT NOT NULL,
+			slug_id TEXT NOT NULL,
+			title TEXT,
+			content TEXT NOT NULL,
+			created TIMESTAMP NOT NULL,
+			replaced TIMESTAMP NOT NULL
+		)`,
+		`CREATE INDEX revisions_parent_id_replaced_idx ON revisions (parent_id, replaced, id)`,
+	},
+	{
+		`ALTER TABLE threads ADD COLUMN deleted TIMESTAMP`,
+		`ALTER TABLE threads ADD COLUMN deleted_by TEXT`,
+		`ALTER TABLE comments ADD COLUMN deleted TIMESTAMP`,
+		`ALTER TABLE comments ADD COLUMN deleted_by TEXT`,
+		`CREATE INDEX threads_category_deleted_idx ON threads (category, deleted)`,
+		`CREATE INDEX comments_deleted_idx ON comments (deleted)`,
+	},
+	{
+		`CREATE TABLE moderators (
+			username TEXT NOT NULL,
+			category TEXT NOT NULL,
+			PRIMARY KEY (username, category)
+		)`,
+		`ALTER TABLE threads ADD COLUMN locked BOOLEAN NOT NULL DEFAULT FALSE`,
+		`ALTER TABLE threads ADD COLUMN pinned BOOLEAN NOT NULL DEFAULT FALSE`,
+		`CREATE TABLE bans (
+			id TEXT PRIMARY KEY,
+			username TEXT NOT NULL,
+			category TEXT,
+			reason TEXT NOT NULL,
+			created_by TEXT,
+			created TIMESTAMP NOT NULL,
+			expires TIMESTAMP
+		)`,
+		`CREATE INDEX bans_username_idx ON bans (username, category)`,
+		`CREATE INDEX bans_category_created_idx ON bans (category, created, id)`,
+	},
+	{
+		`CREATE TABLE reports (
+			id TEXT PRIMARY KEY,
+			parent_id TEXT NOT NULL,
+			slug_type TEXT NOT NULL,
+			slug_id TEXT NOT NULL,
+			category TEXT NOT NULL,
+			thread_slug_id TEXT NOT NULL,
+			author TEXT,
+			username TEXT NOT NULL,
+			reason TEXT NOT NULL,
+			comment TEXT NOT NULL DEFAULT '',
+			created TIMESTAMP NOT NULL,
+			resolved_action TEXT,
+			resolved_by TEXT,
+			resolved TIMESTAMP,
+			UNIQUE (parent_id, username)
+		)`,
+		`CREATE INDEX reports_resolved_category_idx ON reports (resolved, category)`,
+		`ALTER TABLE users ADD COLUMN counters_warnings BIGINT NOT NULL DEFAULT 0`,
+	},
+	{
+		`CREATE TABLE refresh_tokens (
+			hash TEXT PRIMARY KEY,
+			family TEXT NOT NULL,
+			username TEXT NOT NULL,
+			created TIMESTAMP NOT NULL,
+			expires TIMESTAMP NOT NULL,
+			used TIMESTAMP,
+			revoked TIMESTAMP
+		)`,
+		`CREATE INDEX refresh_tokens_family_idx ON refresh_tokens (family)`,
+		`CREATE INDEX refresh_tokens_username_idx ON refresh_tokens (username)`,
+		`CREATE TABLE denied_tokens (
+			jti TEXT PRIMARY KEY,
+			username TEXT NOT NULL,
+			expires TIMESTAMP NOT NULL,
+			issued_before TIMESTAMP
+		)`,
+	},
+	{
+		`ALTER TABLE users ADD COLUMN email_encrypted TEXT`,
+		`CREATE TABLE tickets (
+			hash TEXT PRIMARY KEY,
+			purpose TEXT NOT NULL,
+			username TEXT NOT NULL,
+			created TIMESTAMP NOT NULL,
+			expires TIMESTAMP NOT NULL,
+			used TIMESTAMP
+		)`,
+		`CREATE INDEX tickets_username_idx ON tickets (username, purpose)`,
+	},
+	{
+		`ALTER TABLE users ADD COLUMN totp_secret TEXT`,
+		`ALTER TABLE users ADD COLUMN totp_enabled TIMESTAMP`,
+		`ALTER TABLE users ADD COLUMN recovery_codes TEXT`,
+		`ALTER TABLE users ADD COLUMN totp_step BIGINT NOT NULL DEFAULT 0`,
+	},
+	{
+		`ALTER TABLE tickets ADD COLUMN data TEXT`,
+		`CREATE TABLE user_identities (
+			issuer TEXT NOT NULL,
+			subject TEXT NOT NULL,
+			username TEXT NOT NULL,
+			PRIMARY KEY (issuer, subject)
+		)`,
+		`CREATE INDEX user_identities_username_idx ON user_identities (username)`,
+	},
+	{
+		`CREATE TABLE personal_tokens (
+			hash TEXT PRIMARY KEY,
+			id TEXT NOT NULL UNIQUE,
+			username TEXT NOT NULL,
+			name TEXT NOT NULL,
+			scopes TEXT NOT NULL,
+			created TIMESTAMP NOT NULL,
+			expires TIMESTAMP,
+			last_used TIMESTAMP
+		)`,
+		`CREATE INDEX personal_tokens_username_idx ON personal_tokens (username)`,
+	},
+}
+
+// tables created by migrations, in the order they can be dropped
+var tables = []string{
+	"personal_tokens",
+	"user_identities",
+	"tickets",
+	"denied_tokens",
+	"refresh_tokens",
+	"reports",
+	"bans",
+	"moderators",
+	"revisions",
+	"categories",
+	"user_votes",
+	"users",
+	"comments",
+	"threads",
+}
+
+// Migrate applies all migrations not yet recorded in schema_migrations
+func (db *DB) Migrate(ctx context.Context) error {
+
+	if _, err := db.Exec(ctx, `CREATE TABLE IF NOT EXISTS schema_migrations (
+		version INTEGER PRIMARY KEY,
+		applied TIMESTAMP NOT NULL
+	)`); err != nil {
+		return err
+	}
+
+	var current int
+	if err := db.QueryRow(ctx, `SELECT COALESCE(MAX(version), 0) FROM schema_migrations`).Scan(&current); err != nil {
+		return err
+	}
+
+	for i := current; i < len(migrations); i++ {
+		if err := db.migrate(ctx, i+1, migrations[i]); err != nil {
+			return fmt.Errorf("failed to apply migration %d: %w", i+1, err)
+		}
+		logrus.Infof("Applied database migration: %d", i+1)
+	}
+
+	return nil
+}
+
+func (db *DB) migrate(ctx context.Context, version int, statements []string) error {
+
+	tx, err := db.conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback() //nolint:errcheck
+
+	for _, stmt := range statements {
+		if _, err := tx.ExecContext(ctx, stmt); err != nil {
+			return err
+		}
+	}
+
+	if _, err := tx.ExecContext(ctx, db.Rebind(`INSERT INTO schema_migrations (version, applied) VALUES (?, ?)`), version, time.Now().UTC()); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// Reset drops every table and applies all migrations again
+func (db *DB) Reset(ctx context.Context) error {
+
+	for _, table := range append(tables, "schema_migrations") {
+		logrus.Infof("Dropping table: %s", table)
+		if _, err := db.Exec(ctx, fmt.Sprintf("DROP TABLE IF EXISTS %s", table)); err != nil {
+			return err
+		}
+	}
+
+	return db.Migrate(ctx)
+}
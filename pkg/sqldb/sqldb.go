@@ -0,0 +1,295 @@
+package sqldb
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/mattn/go-sqlite3"
+	"github.com/rgynn/klottr/pkg/config"
+	"github.com/sirupsen/logrus"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	DriverSQLite   = "sqlite3"
+	DriverPostgres = "postgres"
+)
+
+// DB is a database/sql connection pool aware of the SQL dialect it talks to
+type DB struct {
+	driver  string
+	conn    *sql.DB
+	cfg     *config.Config
+	mu      sync.Mutex
+	expiry  []expiry
+	stop    chan struct{}
+	stopped chan struct{}
+}
+
+type expiry struct {
+	table string
+	ttl   time.Duration
+	where string
+	args  []interface{}
+}
+
+func Open(cfg *config.Config) (*DB, error) {
+
+	if cfg == nil {
+		return nil, errors.New("no cfg *config.Config provided")
+	}
+
+	switch cfg.DatabaseDriver {
+	case DriverSQLite, DriverPostgres:
+		break
+	default:
+		return nil, fmt.Errorf("unsupported sql driver: %s", cfg.DatabaseDriver)
+	}
+
+	conn, err := sql.Open(cfg.DatabaseDriver, cfg.DatabaseURL)
+	if err != nil {
+		return nil, err
+	}
+
+	// sqlite only allows a single writer, serialize access instead of failing with database is locked
+	if cfg.DatabaseDriver == DriverSQLite {
+		conn.SetMaxOpenConns(1)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.RequestTimeout)
+	defer cancel()
+
+	if err := conn.PingContext(ctx); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	return &DB{
+		driver: cfg.DatabaseDriver,
+		conn:   conn,
+		cfg:    cfg,
+	}, nil
+}
+
+func (db *DB) Driver() string {
+	return db.driver
+}
+
+func (db *DB) Ping(ctx context.Context) error {
+	return db.conn.PingContext(ctx)
+}
+
+func (db *DB) Close() error {
+	db.mu.Lock()
+	stop, stopped := db.stop, db.stopped
+	db.stop = nil
+	db.mu.Unlock()
+	if stop != nil {
+		close(stop)
+		<-stopped
+	}
+	return db.conn.Close()
+}
+
+func (db *DB) Exec(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	if tx := txFromContext(ctx); tx != nil {
+		return tx.ExecContext(ctx, db.Rebind(query), args...)
+	}
+	return db.conn.ExecContext(ctx, db.Rebind(query), args...)
+}
+
+func (db *DB) Query(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error) {
+	if tx := txFromContext(ctx); tx != nil {
+		return tx.QueryContext(ctx, db.Rebind(query), args...)
+	}
+	return db.conn.QueryContext(ctx, db.Rebind(query), args...)
+}
+
+func (db *DB) QueryRow(ctx context.Context, query string, args ...interface{}) *sql.Row {
+	if tx := txFromContext(ctx); tx != nil {
+		return tx.QueryRowContext(ctx, db.Rebind(query), args...)
+	}
+	return db.conn.QueryRowContext(ctx, db.Rebind(query), args...)
+}
+
+type txKey struct{}
+
+// Do runs fn in a transaction committed when fn returns nil and rolled back otherwise, queries
+// made with the ctx passed to fn are part of it. Calls to Do inside fn join the same transaction.
+func (db *DB) Do(ctx context.Context, fn func(ctx context.Context) error) error {
+
+	if txFromContext(ctx) != nil {
+		return fn(ctx)
+	}
+
+	tx, err := db.conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback() //nolint:errcheck
+
+	if err := fn(context.WithValue(ctx, txKey{}, tx)); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func txFromContext(ctx context.Context) *sql.Tx {
+	tx, _ := ctx.Value(txKey{}).(*sql.Tx)
+	return tx
+}
+
+// ForUpdate returns the clause locking selected rows until the end of the transaction, sqlite
+// has no row locks but only allows a single writer which serializes transactions already
+func (db *DB) ForUpdate() string {
+	if db.driver == DriverPostgres {
+		return " FOR UPDATE"
+	}
+	return ""
+}
+
+// Rebind rewrites ? placeholders into the positional $n form postgres expects
+func (db *DB) Rebind(query string) string {
+
+	if db.driver != DriverPostgres {
+		return query
+	}
+
+	var b strings.Builder
+	n := 0
+	for _, r := range query {
+		if r == '?' {
+			n++
+			b.WriteString("$" + strconv.Itoa(n))
+			continue
+		}
+		b.WriteRune(r)
+	}
+
+	return b.String()
+}
+
+// LimitOffset returns a LIMIT/OFFSET clause for from/size pagination, size < 1 means no limit
+func (db *DB) LimitOffset(from, size int64) (string, []interface{}) {
+	if size > 0 {
+		return " LIMIT ? OFFSET ?", []interface{}{size, from}
+	}
+	if db.driver == DriverSQLite {
+		return " LIMIT -1 OFFSET ?", []interface{}{from}
+	}
+	return " OFFSET ?", []interface{}{from}
+}
+
+// ExpireAfter registers table for removal of rows whose created column is older than ttl,
+// the sql counterpart of the expireAfterSeconds index on created in mongo. An optional where
+// clause with ? placeholders limits which rows the ttl applies to.
+func (db *DB) ExpireAfter(table string, ttl time.Duration, where string, args ...interface{}) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	db.expiry = append(db.expiry, expiry{table: table, ttl: ttl, where: where, args: args})
+}
+
+// StartExpiry removes expired rows every interval until the database is closed
+func (db *DB) StartExpiry(interval time.Duration) {
+
+	db.mu.Lock()
+	if db.stop != nil {
+		db.mu.Unlock()
+		return
+	}
+	db.stop = make(chan struct{})
+	db.stopped = make(chan struct{})
+	stop, stopped := db.stop, db.stopped
+	db.mu.Unlock()
+
+	go func() {
+		defer close(stopped)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			db.expire()
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+func (db *DB) expire() {
+
+	db.mu.Lock()
+	expiries := append([]expiry{}, db.expiry...)
+	db.mu.Unlock()
+
+	for _, e := range expiries {
+		if e.ttl <= 0 {
+			continue
+		}
+		query := fmt.Sprintf("DELETE FROM %s WHERE created < ?", e.table)
+		if e.where != "" {
+			query += " AND " + e.where
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), db.cfg.RequestTimeout)
+		res, err := db.Exec(ctx, query, append([]interface{}{time.Now().UTC().Add(-e.ttl)}, e.args...)...)
+		cancel()
+		if err != nil {
+			logrus.Errorf("failed to remove expired rows from %s: %s", e.table, err.Error())
+			continue
+		}
+		if n, err := res.RowsAffected(); err == nil && n > 0 {
+			logrus.Debugf("removed %d expired rows from %s", n, e.table)
+		}
+	}
+}
+
+// IsUniqueViolation reports whether err was caused by a unique constraint
+func IsUniqueViolation(err error) bool {
+
+	var sqliteErr sqlite3.Error
+	if errors.As(err, &sqliteErr) {
+		return sqliteErr.ExtendedCode == sqlite3.ErrConstraintUnique || sqliteErr.ExtendedCode == sqlite3.ErrConstraintPrimaryKey
+	}
+
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) {
+		return pqErr.Code == "23505"
+	}
+
+	return false
+}
+
+// NewID returns a new id in the same format as the mongo object ids used by the models
+func NewID() *primitive.ObjectID {
+	id := primitive.NewObjectID()
+	return &id
+}
+
+// ParseID parses an id stored by NewID, empty ids are returned as nil
+func ParseID(s sql.NullString) (*primitive.ObjectID, error) {
+	if !s.Valid || s.String == "" {
+		return nil, nil
+	}
+	id, err := primitive.ObjectIDFromHex(s.String)
+	if err != nil {
+		return nil, err
+	}
+	return &id, nil
+}
+
+// FormatID returns the stored form of id, nil ids are stored as NULL
+func FormatID(id *primitive.ObjectID) interface{} {
+	if id == nil {
+		return nil
+	}
+	return id.Hex()
+}
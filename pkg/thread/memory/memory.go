@@ -0,0 +1,334 @@
+package memory
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/rgynn/klottr/pkg/config"
+	"github.com/rgynn/klottr/pkg/thread"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Repository for threads kept in memory
+type Repository struct {
+	mu       sync.RWMutex
+	category string
+	ttl      time.Duration
+	cfg      *config.Config
+	threads  []*thread.Model
+}
+
+func NewRepository(cfg *config.Config, category string, ttlSeconds int32) (thread.Repository, error) {
+
+	if cfg == nil {
+		return nil, errors.New("no cfg *config.Config provided")
+	}
+
+	if category == "" {
+		return nil, errors.New("must supply a category for thread repisotory")
+	}
+
+	return &Repository{
+		category: category,
+		ttl:      time.Duration(ttlSeconds) * time.Second,
+		cfg:      cfg,
+		threads:  []*thread.Model{},
+	}, nil
+}
+
+func (repo *Repository) List(ctx context.Context, opts *thread.ListOptions) ([]*thread.Model, error) {
+
+	if opts == nil {
+		return nil, errors.New("no opts *thread.ListOptions provided")
+	}
+
+	repo.mu.RLock()
+	defer repo.mu.RUnlock()
+
+	result := []*thread.Model{}
+
+	for _, m := range repo.threads {
+		if repo.expired(m) || m.IsDeleted() {
+			continue
+		}
+		if opts.Since != nil && m.Created != nil && m.Created.Before(*opts.Since) {
+			continue
+		}
+		result = append(result, clone(m))
+	}
+
+	thread.Sort(result, opts)
+
+	return thread.Page(result, opts), nil
+}
+
+func (repo *Repository) Create(ctx context.Context, m *thread.Model) error {
+
+	if m == nil {
+		return errors.New("no m *thread.Model provided")
+	}
+
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	repo.purge()
+
+	for _, existing := range repo.threads {
+		if equalString(existing.SlugID, m.SlugID) {
+			return errors.New("thread with slug_id already exists")
+		}
+	}
+
+	stored := clone(m)
+	if stored.ID == nil {
+		id := primitive.NewObjectID()
+		stored.ID = &id
+	}
+
+	repo.threads = append(repo.threads, stored)
+
+	return nil
+}
+
+func (repo *Repository) Get(ctx context.Context, slugID, slugTitle *string) (*thread.Model, error) {
+
+	if slugID == nil {
+		return nil, errors.New("no slugID provided")
+	}
+
+	repo.mu.RLock()
+	defer repo.mu.RUnlock()
+
+	i := repo.find(slugID, slugTitle)
+	if i < 0 {
+		return nil, thread.ErrNotFound
+	}
+
+	return clone(repo.threads[i]), nil
+}
+
+func (repo *Repository) Delete(ctx context.Context, slugID, slugTitle *string) error {
+
+	if slugID == nil {
+		return errors.New("no slugID provided")
+	}
+
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	i := repo.find(slugID, slugTitle)
+	if i < 0 {
+		return thread.ErrNotFound
+	}
+
+	repo.threads = append(repo.threads[:i], repo.threads[i+1:]...)
+
+	return nil
+}
+
+func (repo *Repository) SoftDelete(ctx context.Context, slugID, slugTitle *string, m *thread.Model) error {
+
+	if slugID == nil {
+		return errors.New("no slugID provided")
+	}
+
+	if m == nil || m.Deleted == nil {
+		return errors.New("no m.Deleted provided")
+	}
+
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	i := repo.find(slugID, slugTitle)
+	if i < 0 {
+		return thread.ErrNotFound
+	}
+
+	stored := repo.threads[i]
+	stored.Deleted = m.Deleted
+	stored.DeletedBy = m.DeletedBy
+
+	return nil
+}
+
+func (repo *Repository) ListDeleted(ctx context.Context, before time.Time, from, size int64) ([]*thread.Model, error) {
+
+	repo.mu.RLock()
+	defer repo.mu.RUnlock()
+
+	result := []*thread.Model{}
+
+	var skipped int64
+	for _, m := range repo.threads {
+		if repo.expired(m) || !m.IsDeleted() || !m.Deleted.Before(before) {
+			continue
+		}
+		if skipped < from {
+			skipped++
+			continue
+		}
+		if size > 0 && int64(len(result)) >= size {
+			break
+		}
+		result = append(result, clone(m))
+	}
+
+	return result, nil
+}
+
+func (repo *Repository) Edit(ctx context.Context, slugID, slugTitle *string, m *thread.Model) error {
+
+	if slugID == nil {
+		return errors.New("no slugID provided")
+	}
+
+	if m == nil {
+		return errors.New("no m *thread.Model provided")
+	}
+
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	i := repo.find(slugID, slugTitle)
+	if i < 0 {
+		return thread.ErrNotFound
+	}
+
+	stored := repo.threads[i]
+	stored.Title = m.Title
+	stored.Content = m.Content
+	stored.Updated = m.Updated
+	stored.Edited = true
+
+	return nil
+}
+
+func (repo *Repository) Moderate(ctx context.Context, slugID, slugTitle *string, m *thread.Model) error {
+
+	if slugID == nil {
+		return errors.New("no slugID provided")
+	}
+
+	if m == nil {
+		return errors.New("no m *thread.Model provided")
+	}
+
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	i := repo.find(slugID, slugTitle)
+	if i < 0 {
+		return thread.ErrNotFound
+	}
+
+	stored := repo.threads[i]
+	stored.Locked = m.Locked
+	stored.Pinned = m.Pinned
+
+	return nil
+}
+
+func (repo *Repository) ListPinned(ctx context.Context) ([]*thread.Model, error) {
+
+	repo.mu.RLock()
+	defer repo.mu.RUnlock()
+
+	result := []*thread.Model{}
+
+	for _, m := range repo.threads {
+		if repo.expired(m) || m.IsDeleted() || !m.Pinned {
+			continue
+		}
+		result = append(result, clone(m))
+	}
+
+	thread.Sort(result, &thread.ListOptions{Sort: thread.SortNew})
+
+	return result, nil
+}
+
+func (repo *Repository) IncCounter(ctx context.Context, slugID, slugTitle, field *string, value int8) error {
+
+	if slugID == nil {
+		return errors.New("no slugID provided")
+	}
+
+	if field == nil {
+		return errors.New("no field provided")
+	}
+
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	i := repo.find(slugID, slugTitle)
+	if i < 0 {
+		return thread.ErrNotFound
+	}
+
+	m := repo.threads[i]
+
+	switch *field {
+	case "counters.votes":
+		m.Counters.Votes += int64(value)
+	case "counters.ups":
+		m.Counters.Ups = uint32(int64(m.Counters.Ups) + int64(value))
+	case "counters.downs":
+		m.Counters.Downs = uint32(int64(m.Counters.Downs) + int64(value))
+	case "counters.comments":
+		m.Counters.Comments = uint32(int64(m.Counters.Comments) + int64(value))
+	default:
+		return errors.New("invalid counter field provided: " + *field)
+	}
+
+	return nil
+}
+
+// find returns the index of the thread matching slugID and optionally slugTitle, or -1, callers must hold the lock
+func (repo *Repository) find(slugID, slugTitle *string) int {
+	for i, m := range repo.threads {
+		if repo.expired(m) {
+			continue
+		}
+		if !equalString(m.SlugID, slugID) {
+			continue
+		}
+		if slugTitle != nil && !equalString(m.SlugTitle, slugTitle) {
+			continue
+		}
+		return i
+	}
+	return -1
+}
+
+// expired mirrors the expireAfterSeconds index on created in mongo
+func (repo *Repository) expired(m *thread.Model) bool {
+	if repo.ttl <= 0 || m.Created == nil {
+		return false
+	}
+	return time.Since(*m.Created) > repo.ttl
+}
+
+// purge removes expired threads, callers must hold the write lock
+func (repo *Repository) purge() {
+	live := repo.threads[:0]
+	for _, m := range repo.threads {
+		if !repo.expired(m) {
+			live = append(live, m)
+		}
+	}
+	repo.threads = live
+}
+
+func clone(m *thread.Model) *thread.Model {
+	c := *m
+	return &c
+}
+
+func equalString(a, b *string) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return *a == *b
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"time"
 
 	"github.com/rgynn/klottr/pkg/config"
 	"github.com/rgynn/klottr/pkg/thread"
@@ -43,12 +44,145 @@ func NewRepository(cfg *config.Config, client *mongo.Client, category string) (t
 	}, nil
 }
 
-func (repo *Repository) List(ctx context.Context, from, size int64) ([]*thread.Model, error) {
+// CreateIndexes creates the collection indexes for threads in category, including the ttl index on created
+func CreateIndexes(ctx context.Context, cfg *config.Config, client *mongo.Client, category string, ttlSeconds int32) ([]string, error) {
+
+	if cfg == nil {
+		return nil, errors.New("no cfg *config.Config provided")
+	}
+
+	if client == nil {
+		return nil, errors.New("no client *mongo.Client provided")
+	}
+
+	if category == "" {
+		return nil, errors.New("must supply a category for thread repisotory")
+	}
+
+	return client.Database(cfg.DatabaseName).Collection(fmt.Sprintf("threads_%s", category)).Indexes().CreateMany(ctx,
+		[]mongo.IndexModel{
+			{
+				Keys: bson.D{
+					primitive.E{Key: "_id", Value: 1},
+				},
+			},
+			{
+				Keys: bson.D{
+					primitive.E{Key: "slug_id", Value: 1},
+				},
+				Options: options.Index().SetUnique(true),
+			},
+			{
+				Keys: bson.D{
+					primitive.E{Key: "category", Value: 1},
+					primitive.E{Key: "slug_id", Value: 1},
+					primitive.E{Key: "slug_title", Value: 1},
+				},
+			},
+			{
+				Keys: bson.D{
+					primitive.E{Key: "username", Value: 1},
+				},
+			},
+			{
+				Keys: bson.D{
+					primitive.E{Key: "created", Value: 1},
+				},
+				Options: options.Index().SetExpireAfterSeconds(ttlSeconds),
+			},
+			{
+				Keys: bson.D{
+					primitive.E{Key: "created", Value: -1},
+					primitive.E{Key: "_id", Value: -1},
+				},
+			},
+			{
+				Keys: bson.D{
+					primitive.E{Key: "counters.votes", Value: -1},
+					primitive.E{Key: "created", Value: -1},
+					primitive.E{Key: "_id", Value: -1},
+				},
+			},
+			{
+				Keys: bson.D{
+					primitive.E{Key: "deleted", Value: 1},
+				},
+				Options: options.Index().SetSparse(true),
+			},
+			{
+				Keys: bson.D{
+					primitive.E{Key: "pinned", Value: 1},
+				},
+			},
+		},
+	)
+}
+
+func (repo *Repository) List(ctx context.Context, opts *thread.ListOptions) ([]*thread.Model, error) {
+
+	if opts == nil {
+		return nil, errors.New("no opts *thread.ListOptions provided")
+	}
+
+	filter := bson.D{
+		primitive.E{Key: "deleted", Value: bson.M{"$exists": false}},
+	}
+	if opts.Since != nil {
+		filter = append(filter, primitive.E{Key: "created", Value: bson.M{"$gte": *opts.Since}})
+	}
+
+	var after *primitive.ObjectID
+	if opts.After != nil {
+		id, err := primitive.ObjectIDFromHex(opts.After.ID)
+		if err != nil {
+			return nil, err
+		}
+		after = &id
+	}
+
+	from := opts.From
+	if opts.After != nil {
+		from = 0
+	}
+
+	findOpts := options.Find()
+
+	switch {
+	case opts.Ranked():
+		findOpts.SetSort(bson.D{
+			primitive.E{Key: "created", Value: -1},
+		}).SetLimit(thread.MaxCandidates)
+	case opts.Sort == thread.SortTop:
+		if after != nil {
+			votes := int64(opts.After.Score)
+			filter = append(filter, primitive.E{Key: "$or", Value: bson.A{
+				bson.M{"counters.votes": bson.M{"$lt": votes}},
+				bson.M{"counters.votes": votes, "created": bson.M{"$lt": *opts.After.Time}},
+				bson.M{"counters.votes": votes, "created": *opts.After.Time, "_id": bson.M{"$lt": *after}},
+			}})
+		}
+		findOpts.SetSort(bson.D{
+			primitive.E{Key: "counters.votes", Value: -1},
+			primitive.E{Key: "created", Value: -1},
+			primitive.E{Key: "_id", Value: -1},
+		}).SetSkip(from).SetLimit(opts.Size)
+	default:
+		if after != nil {
+			filter = append(filter, primitive.E{Key: "$or", Value: bson.A{
+				bson.M{"created": bson.M{"$lt": *opts.After.Time}},
+				bson.M{"created": *opts.After.Time, "_id": bson.M{"$lt": *after}},
+			}})
+		}
+		findOpts.SetSort(bson.D{
+			primitive.E{Key: "created", Value: -1},
+			primitive.E{Key: "_id", Value: -1},
+		}).SetSkip(from).SetLimit(opts.Size)
+	}
 
 	ctx, cancel := context.WithTimeout(ctx, repo.cfg.RequestTimeout)
 	defer cancel()
 
-	cursor, err := repo.client.Database(repo.database).Collection(repo.collection).Find(ctx, bson.D{}, options.Find().SetSkip(from).SetLimit(size))
+	cursor, err := repo.client.Database(repo.database).Collection(repo.collection).Find(ctx, filter, findOpts)
 	if err != nil {
 		return nil, err
 	}
@@ -58,6 +192,11 @@ func (repo *Repository) List(ctx context.Context, from, size int64) ([]*thread.M
 		return nil, err
 	}
 
+	if opts.Ranked() {
+		thread.Sort(result, opts)
+		result = thread.Page(result, opts)
+	}
+
 	return result, nil
 }
 
@@ -97,7 +236,12 @@ func (repo *Repository) Get(ctx context.Context, slugID, slugTitle *string) (*th
 
 	var result *thread.Model
 	if err := repo.client.Database(repo.database).Collection(repo.collection).FindOne(ctx, filter).Decode(&result); err != nil {
-		return nil, err
+		switch err {
+		case mongo.ErrNoDocuments:
+			return nil, thread.ErrNotFound
+		default:
+			return nil, err
+		}
 	}
 
 	return result, nil
@@ -132,6 +276,175 @@ func (repo *Repository) Delete(ctx context.Context, slugID, slugTitle *string) e
 	return nil
 }
 
+func (repo *Repository) SoftDelete(ctx context.Context, slugID, slugTitle *string, m *thread.Model) error {
+
+	if slugID == nil {
+		return errors.New("no slugID provided")
+	}
+
+	if m == nil || m.Deleted == nil {
+		return errors.New("no m.Deleted provided")
+	}
+
+	filter := bson.D{
+		primitive.E{Key: "slug_id", Value: *slugID},
+	}
+
+	if slugTitle != nil {
+		filter = append(filter, primitive.E{Key: "slug_title", Value: *slugTitle})
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, repo.cfg.RequestTimeout)
+	defer cancel()
+
+	res, err := repo.client.Database(repo.database).Collection(repo.collection).UpdateOne(ctx, filter,
+		bson.D{primitive.E{
+			Key: "$set",
+			Value: bson.D{
+				primitive.E{Key: "deleted", Value: m.Deleted},
+				primitive.E{Key: "deleted_by", Value: m.DeletedBy},
+			},
+		}})
+	if err != nil {
+		return err
+	}
+
+	if res.MatchedCount != 1 {
+		return thread.ErrNotFound
+	}
+
+	return nil
+}
+
+func (repo *Repository) ListDeleted(ctx context.Context, before time.Time, from, size int64) ([]*thread.Model, error) {
+
+	ctx, cancel := context.WithTimeout(ctx, repo.cfg.RequestTimeout)
+	defer cancel()
+
+	cursor, err := repo.client.Database(repo.database).Collection(repo.collection).Find(ctx, bson.D{
+		primitive.E{Key: "deleted", Value: bson.M{"$lt": before}},
+	}, options.Find().SetSort(bson.D{
+		primitive.E{Key: "deleted", Value: 1},
+		primitive.E{Key: "_id", Value: 1},
+	}).SetSkip(from).SetLimit(size))
+	if err != nil {
+		return nil, err
+	}
+
+	result := []*thread.Model{}
+	if err := cursor.All(ctx, &result); err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+func (repo *Repository) Edit(ctx context.Context, slugID, slugTitle *string, m *thread.Model) error {
+
+	if slugID == nil {
+		return errors.New("no slugID provided")
+	}
+
+	if m == nil {
+		return errors.New("no m *thread.Model provided")
+	}
+
+	filter := bson.D{
+		primitive.E{Key: "slug_id", Value: *slugID},
+	}
+
+	if slugTitle != nil {
+		filter = append(filter, primitive.E{Key: "slug_title", Value: *slugTitle})
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, repo.cfg.RequestTimeout)
+	defer cancel()
+
+	res, err := repo.client.Database(repo.database).Collection(repo.collection).UpdateOne(ctx, filter,
+		bson.D{primitive.E{
+			Key: "$set",
+			Value: bson.D{
+				primitive.E{Key: "title", Value: m.Title},
+				primitive.E{Key: "content", Value: m.Content},
+				primitive.E{Key: "updated", Value: m.Updated},
+				primitive.E{Key: "edited", Value: true},
+			},
+		}})
+	if err != nil {
+		return err
+	}
+
+	if res.MatchedCount != 1 {
+		return thread.ErrNotFound
+	}
+
+	return nil
+}
+
+func (repo *Repository) Moderate(ctx context.Context, slugID, slugTitle *string, m *thread.Model) error {
+
+	if slugID == nil {
+		return errors.New("no slugID provided")
+	}
+
+	if m == nil {
+		return errors.New("no m *thread.Model provided")
+	}
+
+	filter := bson.D{
+		primitive.E{Key: "slug_id", Value: *slugID},
+	}
+
+	if slugTitle != nil {
+		filter = append(filter, primitive.E{Key: "slug_title", Value: *slugTitle})
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, repo.cfg.RequestTimeout)
+	defer cancel()
+
+	res, err := repo.client.Database(repo.database).Collection(repo.collection).UpdateOne(ctx, filter,
+		bson.D{primitive.E{
+			Key: "$set",
+			Value: bson.D{
+				primitive.E{Key: "locked", Value: m.Locked},
+				primitive.E{Key: "pinned", Value: m.Pinned},
+			},
+		}})
+	if err != nil {
+		return err
+	}
+
+	if res.MatchedCount != 1 {
+		return thread.ErrNotFound
+	}
+
+	return nil
+}
+
+func (repo *Repository) ListPinned(ctx context.Context) ([]*thread.Model, error) {
+
+	ctx, cancel := context.WithTimeout(ctx, repo.cfg.RequestTimeout)
+	defer cancel()
+
+	cursor, err := repo.client.Database(repo.database).Collection(repo.collection).Find(ctx, bson.D{
+		primitive.E{Key: "pinned", Value: true},
+		primitive.E{Key: "deleted", Value: bson.M{"$exists": false}},
+	}, options.Find().SetSort(bson.D{
+		primitive.E{Key: "created", Value: -1},
+		primitive.E{Key: "_id", Value: -1},
+	}))
+	if err != nil {
+		return nil, err
+	}
+
+	result := []*thread.Model{}
+	if err := cursor.All(ctx, &result); err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
 func (repo *Repository) IncCounter(ctx context.Context, slugID, slugTitle, field *string, value int8) error {
 
 	if slugID == nil {
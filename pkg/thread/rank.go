@@ -0,0 +1,224 @@
+package thread
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"time"
+
+	"github.com/rgynn/klottr/pkg/cursor"
+)
+
+const (
+	SortNew           = "new"
+	SortTop           = "top"
+	SortHot           = "hot"
+	SortRising        = "rising"
+	SortControversial = "controversial"
+)
+
+// MaxCandidates is the number of most recent threads ranked by the hot, rising and
+// controversial orders, which depend on the current time and are scored by the service
+const MaxCandidates = 1000
+
+// gravity of the hot order, the same as hackernews
+const gravity = 1.8
+
+const (
+	hotWindow    = 7 * 24 * time.Hour
+	risingWindow = 24 * time.Hour
+)
+
+// windows for the top and controversial orders
+var windows = map[string]time.Duration{
+	"hour":  time.Hour,
+	"day":   24 * time.Hour,
+	"week":  7 * 24 * time.Hour,
+	"month": 30 * 24 * time.Hour,
+	"year":  365 * 24 * time.Hour,
+	"all":   0,
+}
+
+var ErrInvalidSort = errors.New("invalid sort, must be one of: new, top, hot, rising, controversial")
+
+// ListOptions for listing threads in a category
+type ListOptions struct {
+	Sort  string
+	Since *time.Time
+	// After continues the listing after a thread returned earlier, replacing From
+	After *cursor.Cursor
+	// From is the number of threads to skip, deprecated in favor of After
+	From int64
+	Size int64
+	// Now is the time scores depending on the age of threads are calculated at
+	Now time.Time
+}
+
+// NewListOptions returns the options for listing threads in order sort, window limits the
+// top and controversial orders to threads created within the last hour, day, week, month or year
+func NewListOptions(order, window string, after *cursor.Cursor, from, size int64) (*ListOptions, error) {
+
+	if order == "" {
+		order = SortNew
+	}
+
+	if window == "" {
+		window = "day"
+	}
+
+	now := time.Now().UTC()
+
+	// a cursor continues the listing it was returned for, ranked at the same time
+	if after != nil {
+		if after.Time == nil || after.Now == nil || after.Sort != order {
+			return nil, cursor.ErrInvalid
+		}
+		now = after.Now.UTC()
+	}
+
+	opts := &ListOptions{
+		Sort:  order,
+		After: after,
+		From:  from,
+		Size:  size,
+		Now:   now,
+	}
+
+	switch order {
+	case SortNew:
+		break
+	case SortHot:
+		opts.Since = timePtr(now.Add(-hotWindow))
+	case SortRising:
+		opts.Since = timePtr(now.Add(-risingWindow))
+	case SortTop, SortControversial:
+		d, ok := windows[window]
+		if !ok {
+			return nil, fmt.Errorf("invalid window: %s, must be one of: hour, day, week, month, year, all", window)
+		}
+		if d > 0 {
+			opts.Since = timePtr(now.Add(-d))
+		}
+	default:
+		return nil, ErrInvalidSort
+	}
+
+	return opts, nil
+}
+
+// Ranked reports whether the order is scored by the service from the most recent candidates
+// instead of sorted by the database
+func (opts *ListOptions) Ranked() bool {
+	switch opts.Sort {
+	case SortHot, SortRising, SortControversial:
+		return true
+	default:
+		return false
+	}
+}
+
+// Score of m in order at time now, higher scores are listed first and threads with the
+// same score are listed newest first
+func Score(order string, m *Model, now time.Time) float64 {
+	switch order {
+	case SortTop:
+		return float64(m.Counters.Votes)
+	case SortHot:
+		return float64(m.Counters.Votes) / math.Pow(age(m, now)+2, gravity)
+	case SortRising:
+		return float64(m.Counters.Votes+int64(m.Counters.Comments)) / (age(m, now) + 1)
+	case SortControversial:
+		ups, downs := float64(m.Counters.Ups), float64(m.Counters.Downs)
+		if ups <= 0 || downs <= 0 {
+			return 0
+		}
+		balance := downs / ups
+		if ups < downs {
+			balance = ups / downs
+		}
+		return math.Pow(ups+downs, balance)
+	default:
+		return 0
+	}
+}
+
+// Key of m in the order of opts, used as the cursor to continue the listing after m
+func Key(opts *ListOptions, m *Model) *cursor.Cursor {
+	c := &cursor.Cursor{
+		Score: Score(opts.Sort, m, opts.Now),
+		Time:  m.Created,
+	}
+	if m.ID != nil {
+		c.ID = m.ID.Hex()
+	}
+	return c
+}
+
+// Sort list in the order of opts
+func Sort(list []*Model, opts *ListOptions) {
+	sort.SliceStable(list, func(i, j int) bool {
+		return before(Key(opts, list[i]), Key(opts, list[j]))
+	})
+}
+
+// Page returns the threads of the sorted list after opts.After, or from opts.From, limited to opts.Size
+func Page(list []*Model, opts *ListOptions) []*Model {
+
+	from := opts.From
+
+	if opts.After != nil {
+		from = int64(sort.Search(len(list), func(i int) bool {
+			return before(opts.After, Key(opts, list[i]))
+		}))
+	}
+
+	if from >= int64(len(list)) {
+		return []*Model{}
+	}
+
+	list = list[from:]
+	if opts.Size > 0 && opts.Size < int64(len(list)) {
+		list = list[:opts.Size]
+	}
+
+	return list
+}
+
+// NextCursor returns the cursor continuing after the page list, or nil when it was the last page
+func NextCursor(opts *ListOptions, list []*Model) *cursor.Cursor {
+	if len(list) == 0 || opts.Size <= 0 || int64(len(list)) < opts.Size {
+		return nil
+	}
+	c := Key(opts, list[len(list)-1])
+	c.Sort = opts.Sort
+	c.Now = timePtr(opts.Now)
+	return c
+}
+
+// before reports whether the thread at a is listed before the thread at b
+func before(a, b *cursor.Cursor) bool {
+	if a.Score != b.Score {
+		return a.Score > b.Score
+	}
+	if a.Time == nil || b.Time == nil {
+		if (a.Time == nil) != (b.Time == nil) {
+			return b.Time == nil
+		}
+	} else if !a.Time.Equal(*b.Time) {
+		return a.Time.After(*b.Time)
+	}
+	return a.ID > b.ID
+}
+
+// age of m in hours
+func age(m *Model, now time.Time) float64 {
+	if m.Created == nil {
+		return 0
+	}
+	return math.Max(now.Sub(*m.Created).Hours(), 0)
+}
+
+func timePtr(t time.Time) *time.Time {
+	return &t
+}
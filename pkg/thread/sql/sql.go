@@ -0,0 +1,399 @@
+package sql
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/rgynn/klottr/pkg/config"
+	"github.com/rgynn/klottr/pkg/sqldb"
+	"github.com/rgynn/klottr/pkg/thread"
+)
+
+const columns = `id, username, slug_id, slug_title, title, url, content, counters_votes, counters_ups, counters_downs, counters_comments, created, updated, edited, locked, pinned, deleted, deleted_by`
+
+// counterColumns maps the counter fields used by the api to their columns
+var counterColumns = map[string]string{
+	"counters.votes":    "counters_votes",
+	"counters.ups":      "counters_ups",
+	"counters.downs":    "counters_downs",
+	"counters.comments": "counters_comments",
+}
+
+// Repository for threads in a sql database
+type Repository struct {
+	category string
+	cfg      *config.Config
+	db       *sqldb.DB
+}
+
+func NewRepository(cfg *config.Config, db *sqldb.DB, category string) (thread.Repository, error) {
+
+	if cfg == nil {
+		return nil, errors.New("no cfg *config.Config provided")
+	}
+
+	if db == nil {
+		return nil, errors.New("no db *sqldb.DB provided")
+	}
+
+	if category == "" {
+		return nil, errors.New("must supply a category for thread repisotory")
+	}
+
+	return &Repository{
+		category: category,
+		cfg:      cfg,
+		db:       db,
+	}, nil
+}
+
+func (repo *Repository) List(ctx context.Context, opts *thread.ListOptions) ([]*thread.Model, error) {
+
+	if opts == nil {
+		return nil, errors.New("no opts *thread.ListOptions provided")
+	}
+
+	where := `category = ? AND deleted IS NULL`
+	args := []interface{}{repo.category}
+	if opts.Since != nil {
+		where += ` AND created >= ?`
+		args = append(args, *opts.Since)
+	}
+
+	from := opts.From
+	if opts.After != nil {
+		from = 0
+	}
+
+	var order, limit string
+	var limitArgs []interface{}
+
+	switch {
+	case opts.Ranked():
+		order = `created DESC`
+		limit, limitArgs = repo.db.LimitOffset(0, thread.MaxCandidates)
+	case opts.Sort == thread.SortTop:
+		if opts.After != nil {
+			where += ` AND (counters_votes < ? OR (counters_votes = ? AND (created < ? OR (created = ? AND id < ?))))`
+			args = append(args, int64(opts.After.Score), int64(opts.After.Score), *opts.After.Time, *opts.After.Time, opts.After.ID)
+		}
+		order = `counters_votes DESC, created DESC, id DESC`
+		limit, limitArgs = repo.db.LimitOffset(from, opts.Size)
+	default:
+		if opts.After != nil {
+			where += ` AND (created < ? OR (created = ? AND id < ?))`
+			args = append(args, *opts.After.Time, *opts.After.Time, opts.After.ID)
+		}
+		order = `created DESC, id DESC`
+		limit, limitArgs = repo.db.LimitOffset(from, opts.Size)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, repo.cfg.RequestTimeout)
+	defer cancel()
+
+	result, err := repo.query(ctx, `SELECT `+columns+` FROM threads WHERE `+where+` ORDER BY `+order+limit, append(args, limitArgs...)...)
+	if err != nil {
+		return nil, err
+	}
+
+	if opts.Ranked() {
+		thread.Sort(result, opts)
+		result = thread.Page(result, opts)
+	}
+
+	return result, nil
+}
+
+func (repo *Repository) Create(ctx context.Context, m *thread.Model) error {
+
+	if m == nil {
+		return errors.New("no m *thread.Model provided")
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, repo.cfg.RequestTimeout)
+	defer cancel()
+
+	id := m.ID
+	if id == nil {
+		id = sqldb.NewID()
+	}
+
+	_, err := repo.db.Exec(ctx, `INSERT INTO threads (id, category, username, slug_id, slug_title, title, url, content, counters_votes, counters_ups, counters_downs, counters_comments, created, updated, edited, locked, pinned, deleted, deleted_by)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		id.Hex(),
+		repo.category,
+		m.Username,
+		m.SlugID,
+		m.SlugTitle,
+		m.Title,
+		m.URL,
+		m.Content,
+		m.Counters.Votes,
+		m.Counters.Ups,
+		m.Counters.Downs,
+		m.Counters.Comments,
+		m.Created,
+		m.Updated,
+		m.Edited,
+		m.Locked,
+		m.Pinned,
+		m.Deleted,
+		m.DeletedBy,
+	)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (repo *Repository) Get(ctx context.Context, slugID, slugTitle *string) (*thread.Model, error) {
+
+	if slugID == nil {
+		return nil, errors.New("no slugID provided")
+	}
+
+	where, args := repo.filter(slugID, slugTitle)
+
+	ctx, cancel := context.WithTimeout(ctx, repo.cfg.RequestTimeout)
+	defer cancel()
+
+	result, err := scan(repo.db.QueryRow(ctx, `SELECT `+columns+` FROM threads WHERE `+where, args...))
+	if err != nil {
+		switch err {
+		case sql.ErrNoRows:
+			return nil, thread.ErrNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return result, nil
+}
+
+func (repo *Repository) Delete(ctx context.Context, slugID, slugTitle *string) error {
+
+	if slugID == nil {
+		return errors.New("no slugID provided")
+	}
+
+	where, args := repo.filter(slugID, slugTitle)
+
+	ctx, cancel := context.WithTimeout(ctx, repo.cfg.RequestTimeout)
+	defer cancel()
+
+	res, err := repo.db.Exec(ctx, `DELETE FROM threads WHERE `+where, args...)
+	if err != nil {
+		return err
+	}
+
+	if n, err := res.RowsAffected(); err != nil || n != 1 {
+		return thread.ErrNotFound
+	}
+
+	return nil
+}
+
+func (repo *Repository) SoftDelete(ctx context.Context, slugID, slugTitle *string, m *thread.Model) error {
+
+	if slugID == nil {
+		return errors.New("no slugID provided")
+	}
+
+	if m == nil || m.Deleted == nil {
+		return errors.New("no m.Deleted provided")
+	}
+
+	where, args := repo.filter(slugID, slugTitle)
+
+	ctx, cancel := context.WithTimeout(ctx, repo.cfg.RequestTimeout)
+	defer cancel()
+
+	res, err := repo.db.Exec(ctx, `UPDATE threads SET deleted = ?, deleted_by = ? WHERE `+where, append([]interface{}{*m.Deleted, m.DeletedBy}, args...)...)
+	if err != nil {
+		return err
+	}
+
+	if n, err := res.RowsAffected(); err != nil || n != 1 {
+		return thread.ErrNotFound
+	}
+
+	return nil
+}
+
+func (repo *Repository) ListDeleted(ctx context.Context, before time.Time, from, size int64) ([]*thread.Model, error) {
+
+	limit, limitArgs := repo.db.LimitOffset(from, size)
+
+	ctx, cancel := context.WithTimeout(ctx, repo.cfg.RequestTimeout)
+	defer cancel()
+
+	return repo.query(ctx, `SELECT `+columns+` FROM threads WHERE category = ? AND deleted < ? ORDER BY deleted, id`+limit, append([]interface{}{repo.category, before}, limitArgs...)...)
+}
+
+func (repo *Repository) Edit(ctx context.Context, slugID, slugTitle *string, m *thread.Model) error {
+
+	if slugID == nil {
+		return errors.New("no slugID provided")
+	}
+
+	if m == nil {
+		return errors.New("no m *thread.Model provided")
+	}
+
+	where, args := repo.filter(slugID, slugTitle)
+
+	ctx, cancel := context.WithTimeout(ctx, repo.cfg.RequestTimeout)
+	defer cancel()
+
+	res, err := repo.db.Exec(ctx, `UPDATE threads SET title = ?, content = ?, updated = ?, edited = ? WHERE `+where, append([]interface{}{m.Title, m.Content, m.Updated, true}, args...)...)
+	if err != nil {
+		return err
+	}
+
+	if n, err := res.RowsAffected(); err != nil || n != 1 {
+		return thread.ErrNotFound
+	}
+
+	return nil
+}
+
+func (repo *Repository) Moderate(ctx context.Context, slugID, slugTitle *string, m *thread.Model) error {
+
+	if slugID == nil {
+		return errors.New("no slugID provided")
+	}
+
+	if m == nil {
+		return errors.New("no m *thread.Model provided")
+	}
+
+	where, args := repo.filter(slugID, slugTitle)
+
+	ctx, cancel := context.WithTimeout(ctx, repo.cfg.RequestTimeout)
+	defer cancel()
+
+	res, err := repo.db.Exec(ctx, `UPDATE threads SET locked = ?, pinned = ? WHERE `+where, append([]interface{}{m.Locked, m.Pinned}, args...)...)
+	if err != nil {
+		return err
+	}
+
+	if n, err := res.RowsAffected(); err != nil || n != 1 {
+		return thread.ErrNotFound
+	}
+
+	return nil
+}
+
+func (repo *Repository) ListPinned(ctx context.Context) ([]*thread.Model, error) {
+
+	ctx, cancel := context.WithTimeout(ctx, repo.cfg.RequestTimeout)
+	defer cancel()
+
+	return repo.query(ctx, `SELECT `+columns+` FROM threads WHERE category = ? AND pinned = ? AND deleted IS NULL ORDER BY created DESC, id DESC`, repo.category, true)
+}
+
+func (repo *Repository) IncCounter(ctx context.Context, slugID, slugTitle, field *string, value int8) error {
+
+	if slugID == nil {
+		return errors.New("no slugID provided")
+	}
+
+	if field == nil {
+		return errors.New("no field provided")
+	}
+
+	column, ok := counterColumns[*field]
+	if !ok {
+		return errors.New("invalid counter field provided: " + *field)
+	}
+
+	where, args := repo.filter(slugID, slugTitle)
+
+	ctx, cancel := context.WithTimeout(ctx, repo.cfg.RequestTimeout)
+	defer cancel()
+
+	res, err := repo.db.Exec(ctx, `UPDATE threads SET `+column+` = `+column+` + ? WHERE `+where, append([]interface{}{value}, args...)...)
+	if err != nil {
+		return err
+	}
+
+	if n, err := res.RowsAffected(); err != nil || n != 1 {
+		return thread.ErrNotFound
+	}
+
+	return nil
+}
+
+func (repo *Repository) filter(slugID, slugTitle *string) (string, []interface{}) {
+	where := `category = ? AND slug_id = ?`
+	args := []interface{}{repo.category, *slugID}
+	if slugTitle != nil {
+		where += ` AND slug_title = ?`
+		args = append(args, *slugTitle)
+	}
+	return where, args
+}
+
+func (repo *Repository) query(ctx context.Context, query string, args ...interface{}) ([]*thread.Model, error) {
+
+	rows, err := repo.db.Query(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	result := []*thread.Model{}
+	for rows.Next() {
+		m, err := scan(rows)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, m)
+	}
+
+	return result, rows.Err()
+}
+
+type scanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scan(row scanner) (*thread.Model, error) {
+
+	m := new(thread.Model)
+	var id sql.NullString
+
+	if err := row.Scan(
+		&id,
+		&m.Username,
+		&m.SlugID,
+		&m.SlugTitle,
+		&m.Title,
+		&m.URL,
+		&m.Content,
+		&m.Counters.Votes,
+		&m.Counters.Ups,
+		&m.Counters.Downs,
+		&m.Counters.Comments,
+		&m.Created,
+		&m.Updated,
+		&m.Edited,
+		&m.Locked,
+		&m.Pinned,
+		&m.Deleted,
+		&m.DeletedBy,
+	); err != nil {
+		return nil, err
+	}
+
+	var err error
+
+	if m.ID, err = sqldb.ParseID(id); err != nil {
+		return nil, err
+	}
+
+	return m, nil
+}
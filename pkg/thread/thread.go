@@ -17,16 +17,43 @@ var ErrCategoryNotFound = errors.New("thread category not found")
 
 var ErrNotFound = errors.New("thread not found")
 
+var ErrDeleted = errors.New("thread deleted")
+
+var ErrLocked = errors.New("thread locked")
+
+// MaxPinned is the number of threads that can be pinned in a category at a time
+const MaxPinned = 2
+
+var ErrMaxPinned = fmt.Errorf("no more than %d threads can be pinned in a category", MaxPinned)
+
+// Tombstone replaces the title and content of deleted threads in responses
+const Tombstone = "[deleted]"
+
 type Repository interface {
-	List(ctx context.Context, from, size int64) ([]*Model, error)
+	List(ctx context.Context, opts *ListOptions) ([]*Model, error)
 	Create(ctx context.Context, m *Model) error
 	Get(ctx context.Context, slugID, slugTitle *string) (*Model, error)
+	// Delete removes the thread, threads deleted by users are marked with SoftDelete and
+	// removed once the retention period has passed
 	Delete(ctx context.Context, slugID, slugTitle *string) error
+	// SoftDelete marks the thread as deleted at m.Deleted by m.DeletedBy, deleted threads
+	// are left out of List
+	SoftDelete(ctx context.Context, slugID, slugTitle *string, m *Model) error
+	// ListDeleted returns the threads deleted before, in the same order on every call
+	ListDeleted(ctx context.Context, before time.Time, from, size int64) ([]*Model, error)
+	// Moderate replaces the locked and pinned flags of the thread with those of m
+	Moderate(ctx context.Context, slugID, slugTitle *string, m *Model) error
+	// ListPinned returns the pinned threads that are not deleted, newest first
+	ListPinned(ctx context.Context) ([]*Model, error)
+	// Edit replaces the title and content of the thread and marks it as edited at m.Updated
+	Edit(ctx context.Context, slugID, slugTitle *string, m *Model) error
 	IncCounter(ctx context.Context, slugID, slugTitle, field *string, value int8) error
 }
 
 type Counters struct {
 	Votes    int64  `json:"votes"  bson:"votes"`
+	Ups      uint32 `json:"ups"  bson:"ups"`
+	Downs    uint32 `json:"downs"  bson:"downs"`
 	Comments uint32 `json:"comments"  bson:"comments"`
 }
 
@@ -41,6 +68,59 @@ type Model struct {
 	Counters  Counters            `json:"counters"  bson:"counters"`
 	Created   *time.Time          `json:"created"  bson:"created"`
 	Updated   *time.Time          `json:"updated"  bson:"updated"`
+	Edited    bool                `json:"edited"  bson:"edited"`
+	// Locked threads take no new comments or edits
+	Locked bool `json:"locked"  bson:"locked"`
+	// Pinned threads are listed first in their category
+	Pinned    bool       `json:"pinned"  bson:"pinned"`
+	Deleted   *time.Time `json:"deleted,omitempty"  bson:"deleted,omitempty"`
+	DeletedBy *string    `json:"deleted_by,omitempty"  bson:"deleted_by,omitempty"`
+}
+
+// IsDeleted reports whether the thread has been soft deleted
+func (m *Model) IsDeleted() bool {
+	return m.Deleted != nil
+}
+
+// Redacted returns m as shown to users, deleted threads keep their slugs and counters
+// but have their author, title, url and content replaced
+func (m *Model) Redacted() *Model {
+
+	if !m.IsDeleted() {
+		return m
+	}
+
+	c := *m
+	c.Username = nil
+	c.Title = ptrconv.StringPtr(Tombstone)
+	c.URL = nil
+	c.Content = Tombstone
+	c.DeletedBy = nil
+
+	return &c
+}
+
+// VoteCounters returns the changes to counters.ups and counters.downs when a vote changes from old to new
+func VoteCounters(old, new int8) map[string]int8 {
+	result := map[string]int8{}
+	if old > 0 {
+		result["counters.ups"]--
+	}
+	if old < 0 {
+		result["counters.downs"]--
+	}
+	if new > 0 {
+		result["counters.ups"]++
+	}
+	if new < 0 {
+		result["counters.downs"]++
+	}
+	for field, value := range result {
+		if value == 0 {
+			delete(result, field)
+		}
+	}
+	return result
 }
 
 func (m *Model) ValidForSave() error {
@@ -92,6 +172,27 @@ func (m *Model) ValidForSave() error {
 	return nil
 }
 
+// ValidForEdit runs the ValidForSave rules on an edited thread
+func (m *Model) ValidForEdit() error {
+
+	if m == nil {
+		return errors.New("no m *thread.Model provided")
+	}
+
+	if m.ID == nil {
+		return errors.New("no m.ID provided for edited thread")
+	}
+
+	if m.Updated == nil || m.Updated.IsZero() {
+		return errors.New("no m.Updated provided for edited thread")
+	}
+
+	c := *m
+	c.ID = nil
+
+	return c.ValidForSave()
+}
+
 func (m *Model) GenerateSlugs() error {
 
 	if m == nil {
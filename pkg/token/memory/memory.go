@@ -0,0 +1,336 @@
+package memory
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/rgynn/klottr/pkg/config"
+	"github.com/rgynn/klottr/pkg/token"
+)
+
+// Repository for tokens kept in memory
+type Repository struct {
+	mu       sync.RWMutex
+	cfg      *config.Config
+	refresh  map[string]*token.Refresh
+	denied   map[string]*token.Denied
+	tickets  map[string]*token.Ticket
+	personal map[string]*token.Personal
+}
+
+func NewRepository(cfg *config.Config) (token.Repository, error) {
+
+	if cfg == nil {
+		return nil, errors.New("no cfg *config.Config provided")
+	}
+
+	return &Repository{
+		cfg:      cfg,
+		refresh:  map[string]*token.Refresh{},
+		denied:   map[string]*token.Denied{},
+		tickets:  map[string]*token.Ticket{},
+		personal: map[string]*token.Personal{},
+	}, nil
+}
+
+func (repo *Repository) CreateRefresh(ctx context.Context, m *token.Refresh) error {
+
+	if m == nil {
+		return errors.New("no m *token.Refresh provided")
+	}
+
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	if _, ok := repo.refresh[m.Hash]; ok {
+		return errors.New("refresh token already exists")
+	}
+
+	stored := *m
+	repo.refresh[m.Hash] = &stored
+
+	return nil
+}
+
+func (repo *Repository) UseRefresh(ctx context.Context, hash string, now time.Time) (*token.Refresh, error) {
+
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	m, ok := repo.refresh[hash]
+	if !ok {
+		return nil, token.ErrNotFound
+	}
+
+	result := *m
+
+	if m.Used != nil {
+		return &result, token.ErrReused
+	}
+
+	m.Used = &now
+
+	return &result, nil
+}
+
+func (repo *Repository) RevokeFamily(ctx context.Context, family string, now time.Time) error {
+
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	for _, m := range repo.refresh {
+		if m.Family == family && m.Revoked == nil {
+			m.Revoked = &now
+		}
+	}
+
+	return nil
+}
+
+func (repo *Repository) RevokeUser(ctx context.Context, username *string, now time.Time) error {
+
+	if username == nil {
+		return errors.New("no username provided")
+	}
+
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	for _, m := range repo.refresh {
+		if m.Username != nil && *m.Username == *username && m.Revoked == nil {
+			m.Revoked = &now
+		}
+	}
+
+	return nil
+}
+
+func (repo *Repository) Deny(ctx context.Context, m *token.Denied) error {
+
+	if m == nil {
+		return errors.New("no m *token.Denied provided")
+	}
+
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	stored := *m
+	repo.denied[m.JTI] = &stored
+
+	return nil
+}
+
+func (repo *Repository) ListDenied(ctx context.Context, now time.Time) ([]*token.Denied, error) {
+
+	repo.mu.RLock()
+	defer repo.mu.RUnlock()
+
+	result := []*token.Denied{}
+	for _, m := range repo.denied {
+		if m.Expires.After(now) {
+			c := *m
+			result = append(result, &c)
+		}
+	}
+
+	return result, nil
+}
+
+func (repo *Repository) CreateTicket(ctx context.Context, m *token.Ticket) error {
+
+	if m == nil {
+		return errors.New("no m *token.Ticket provided")
+	}
+
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	if _, ok := repo.tickets[m.Hash]; ok {
+		return errors.New("ticket already exists")
+	}
+
+	stored := *m
+	repo.tickets[m.Hash] = &stored
+
+	return nil
+}
+
+func (repo *Repository) UseTicket(ctx context.Context, hash, purpose string, now time.Time) (*token.Ticket, error) {
+
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	m, ok := repo.tickets[hash]
+	if !ok || m.Purpose != purpose || m.Used != nil || !m.Expires.After(now) {
+		return nil, token.ErrNotFound
+	}
+
+	m.Used = &now
+	result := *m
+
+	return &result, nil
+}
+
+func (repo *Repository) RevokeTickets(ctx context.Context, username *string, purpose string, now time.Time) error {
+
+	if username == nil {
+		return errors.New("no username provided")
+	}
+
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	for _, m := range repo.tickets {
+		if m.Username != nil && *m.Username == *username && m.Purpose == purpose && m.Used == nil {
+			m.Used = &now
+		}
+	}
+
+	return nil
+}
+
+func (repo *Repository) DeleteExpired(ctx context.Context, now time.Time) error {
+
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	for hash, m := range repo.refresh {
+		if !m.Expires.After(now) {
+			delete(repo.refresh, hash)
+		}
+	}
+
+	for jti, m := range repo.denied {
+		if !m.Expires.After(now) {
+			delete(repo.denied, jti)
+		}
+	}
+
+	for hash, m := range repo.tickets {
+		if !m.Expires.After(now) {
+			delete(repo.tickets, hash)
+		}
+	}
+
+	for hash, m := range repo.personal {
+		if m.Expires != nil && !m.Expires.After(now) {
+			delete(repo.personal, hash)
+		}
+	}
+
+	return nil
+}
+
+func (repo *Repository) CreatePersonal(ctx context.Context, m *token.Personal) error {
+
+	if m == nil {
+		return errors.New("no m *token.Personal provided")
+	}
+
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	if _, ok := repo.personal[m.Hash]; ok {
+		return errors.New("personal access token already exists")
+	}
+
+	stored := *m
+	stored.Scopes = append([]string{}, m.Scopes...)
+	repo.personal[m.Hash] = &stored
+
+	return nil
+}
+
+func (repo *Repository) GetPersonal(ctx context.Context, hash string, now time.Time) (*token.Personal, error) {
+
+	repo.mu.RLock()
+	defer repo.mu.RUnlock()
+
+	m, ok := repo.personal[hash]
+	if !ok || (m.Expires != nil && !m.Expires.After(now)) {
+		return nil, token.ErrPersonalNotFound
+	}
+
+	result := *m
+
+	return &result, nil
+}
+
+func (repo *Repository) ListPersonal(ctx context.Context, username *string) ([]*token.Personal, error) {
+
+	if username == nil {
+		return nil, errors.New("no username provided")
+	}
+
+	repo.mu.RLock()
+	defer repo.mu.RUnlock()
+
+	result := []*token.Personal{}
+	for _, m := range repo.personal {
+		if m.Username != nil && *m.Username == *username {
+			c := *m
+			result = append(result, &c)
+		}
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Created.After(result[j].Created)
+	})
+
+	return result, nil
+}
+
+func (repo *Repository) TouchPersonal(ctx context.Context, hash string, now time.Time) error {
+
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	m, ok := repo.personal[hash]
+	if !ok {
+		return token.ErrPersonalNotFound
+	}
+
+	m.LastUsed = &now
+
+	return nil
+}
+
+func (repo *Repository) DeletePersonal(ctx context.Context, username *string, id string) error {
+
+	if username == nil {
+		return errors.New("no username provided")
+	}
+
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	for hash, m := range repo.personal {
+		if m.ID == id && m.Username != nil && *m.Username == *username {
+			delete(repo.personal, hash)
+			return nil
+		}
+	}
+
+	return token.ErrPersonalNotFound
+}
+
+func (repo *Repository) DeleteUserPersonal(ctx context.Context, username *string) error {
+
+	if username == nil {
+		return errors.New("no username provided")
+	}
+
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	for hash, m := range repo.personal {
+		if m.Username != nil && *m.Username == *username {
+			delete(repo.personal, hash)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,372 @@
+package mongo
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/rgynn/klottr/pkg/config"
+	"github.com/rgynn/klottr/pkg/token"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type Repository struct {
+	database string
+	refresh  string
+	denied   string
+	tickets  string
+	personal string
+	cfg      *config.Config
+	client   *mongo.Client
+}
+
+func NewRepository(cfg *config.Config, client *mongo.Client) (token.Repository, error) {
+
+	if cfg == nil {
+		return nil, errors.New("no cfg *config.Config provided")
+	}
+
+	if client == nil {
+		return nil, errors.New("no client *mongo.Client provided")
+	}
+
+	return &Repository{
+		database: cfg.DatabaseName,
+		refresh:  "refresh_tokens",
+		denied:   "denied_tokens",
+		tickets:  "tickets",
+		personal: "personal_tokens",
+		cfg:      cfg,
+		client:   client,
+	}, nil
+}
+
+func (repo *Repository) CreateRefresh(ctx context.Context, m *token.Refresh) error {
+
+	if m == nil {
+		return errors.New("no m *token.Refresh provided")
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, repo.cfg.RequestTimeout)
+	defer cancel()
+
+	_, err := repo.client.Database(repo.database).Collection(repo.refresh).InsertOne(ctx, m)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (repo *Repository) UseRefresh(ctx context.Context, hash string, now time.Time) (*token.Refresh, error) {
+
+	ctx, cancel := context.WithTimeout(ctx, repo.cfg.RequestTimeout)
+	defer cancel()
+
+	collection := repo.client.Database(repo.database).Collection(repo.refresh)
+
+	result := new(token.Refresh)
+	err := collection.FindOneAndUpdate(ctx, bson.D{
+		primitive.E{Key: "_id", Value: hash},
+		primitive.E{Key: "used", Value: bson.M{"$exists": false}},
+	}, bson.M{
+		"$set": bson.M{"used": now},
+	}).Decode(result)
+	if err == nil {
+		return result, nil
+	}
+
+	if !errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, err
+	}
+
+	if err := collection.FindOne(ctx, bson.M{"_id": hash}).Decode(result); err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, token.ErrNotFound
+		}
+		return nil, err
+	}
+
+	return result, token.ErrReused
+}
+
+func (repo *Repository) RevokeFamily(ctx context.Context, family string, now time.Time) error {
+	return repo.revoke(ctx, primitive.E{Key: "family", Value: family}, now)
+}
+
+func (repo *Repository) RevokeUser(ctx context.Context, username *string, now time.Time) error {
+
+	if username == nil {
+		return errors.New("no username provided")
+	}
+
+	return repo.revoke(ctx, primitive.E{Key: "username", Value: *username}, now)
+}
+
+func (repo *Repository) revoke(ctx context.Context, match primitive.E, now time.Time) error {
+
+	ctx, cancel := context.WithTimeout(ctx, repo.cfg.RequestTimeout)
+	defer cancel()
+
+	_, err := repo.client.Database(repo.database).Collection(repo.refresh).UpdateMany(ctx, bson.D{
+		match,
+		primitive.E{Key: "revoked", Value: bson.M{"$exists": false}},
+	}, bson.M{
+		"$set": bson.M{"revoked": now},
+	})
+
+	return err
+}
+
+func (repo *Repository) Deny(ctx context.Context, m *token.Denied) error {
+
+	if m == nil {
+		return errors.New("no m *token.Denied provided")
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, repo.cfg.RequestTimeout)
+	defer cancel()
+
+	_, err := repo.client.Database(repo.database).Collection(repo.denied).InsertOne(ctx, m)
+	if err != nil {
+		if mongo.IsDuplicateKeyError(err) {
+			return nil
+		}
+		return err
+	}
+
+	return nil
+}
+
+func (repo *Repository) ListDenied(ctx context.Context, now time.Time) ([]*token.Denied, error) {
+
+	ctx, cancel := context.WithTimeout(ctx, repo.cfg.RequestTimeout)
+	defer cancel()
+
+	cursor, err := repo.client.Database(repo.database).Collection(repo.denied).Find(ctx, bson.M{
+		"expires": bson.M{"$gt": now},
+	}, options.Find())
+	if err != nil {
+		return nil, err
+	}
+
+	result := []*token.Denied{}
+	if err := cursor.All(ctx, &result); err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+func (repo *Repository) CreateTicket(ctx context.Context, m *token.Ticket) error {
+
+	if m == nil {
+		return errors.New("no m *token.Ticket provided")
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, repo.cfg.RequestTimeout)
+	defer cancel()
+
+	_, err := repo.client.Database(repo.database).Collection(repo.tickets).InsertOne(ctx, m)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (repo *Repository) UseTicket(ctx context.Context, hash, purpose string, now time.Time) (*token.Ticket, error) {
+
+	ctx, cancel := context.WithTimeout(ctx, repo.cfg.RequestTimeout)
+	defer cancel()
+
+	result := new(token.Ticket)
+	if err := repo.client.Database(repo.database).Collection(repo.tickets).FindOneAndUpdate(ctx, bson.D{
+		primitive.E{Key: "_id", Value: hash},
+		primitive.E{Key: "purpose", Value: purpose},
+		primitive.E{Key: "used", Value: bson.M{"$exists": false}},
+		primitive.E{Key: "expires", Value: bson.M{"$gt": now}},
+	}, bson.M{
+		"$set": bson.M{"used": now},
+	}, options.FindOneAndUpdate().SetReturnDocument(options.After)).Decode(result); err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, token.ErrNotFound
+		}
+		return nil, err
+	}
+
+	return result, nil
+}
+
+func (repo *Repository) RevokeTickets(ctx context.Context, username *string, purpose string, now time.Time) error {
+
+	if username == nil {
+		return errors.New("no username provided")
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, repo.cfg.RequestTimeout)
+	defer cancel()
+
+	_, err := repo.client.Database(repo.database).Collection(repo.tickets).UpdateMany(ctx, bson.D{
+		primitive.E{Key: "username", Value: *username},
+		primitive.E{Key: "purpose", Value: purpose},
+		primitive.E{Key: "used", Value: bson.M{"$exists": false}},
+	}, bson.M{
+		"$set": bson.M{"used": now},
+	})
+
+	return err
+}
+
+// DeleteExpired removes expired tokens, the ttl indexes on expires do the same but only run
+// once a minute
+func (repo *Repository) DeleteExpired(ctx context.Context, now time.Time) error {
+
+	ctx, cancel := context.WithTimeout(ctx, repo.cfg.RequestTimeout)
+	defer cancel()
+
+	filter := bson.M{"expires": bson.M{"$lte": now}}
+
+	if _, err := repo.client.Database(repo.database).Collection(repo.refresh).DeleteMany(ctx, filter); err != nil {
+		return err
+	}
+
+	if _, err := repo.client.Database(repo.database).Collection(repo.denied).DeleteMany(ctx, filter); err != nil {
+		return err
+	}
+
+	if _, err := repo.client.Database(repo.database).Collection(repo.tickets).DeleteMany(ctx, filter); err != nil {
+		return err
+	}
+
+	if _, err := repo.client.Database(repo.database).Collection(repo.personal).DeleteMany(ctx, filter); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (repo *Repository) CreatePersonal(ctx context.Context, m *token.Personal) error {
+
+	if m == nil {
+		return errors.New("no m *token.Personal provided")
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, repo.cfg.RequestTimeout)
+	defer cancel()
+
+	_, err := repo.client.Database(repo.database).Collection(repo.personal).InsertOne(ctx, m)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (repo *Repository) GetPersonal(ctx context.Context, hash string, now time.Time) (*token.Personal, error) {
+
+	ctx, cancel := context.WithTimeout(ctx, repo.cfg.RequestTimeout)
+	defer cancel()
+
+	result := new(token.Personal)
+	if err := repo.client.Database(repo.database).Collection(repo.personal).FindOne(ctx, bson.M{
+		"_id": hash,
+		"$or": bson.A{
+			bson.M{"expires": bson.M{"$exists": false}},
+			bson.M{"expires": bson.M{"$gt": now}},
+		},
+	}).Decode(result); err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, token.ErrPersonalNotFound
+		}
+		return nil, err
+	}
+
+	return result, nil
+}
+
+func (repo *Repository) ListPersonal(ctx context.Context, username *string) ([]*token.Personal, error) {
+
+	if username == nil {
+		return nil, errors.New("no username provided")
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, repo.cfg.RequestTimeout)
+	defer cancel()
+
+	cursor, err := repo.client.Database(repo.database).Collection(repo.personal).Find(ctx, bson.M{
+		"username": *username,
+	}, options.Find().SetSort(bson.M{"created": -1}))
+	if err != nil {
+		return nil, err
+	}
+
+	result := []*token.Personal{}
+	if err := cursor.All(ctx, &result); err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+func (repo *Repository) TouchPersonal(ctx context.Context, hash string, now time.Time) error {
+
+	ctx, cancel := context.WithTimeout(ctx, repo.cfg.RequestTimeout)
+	defer cancel()
+
+	res, err := repo.client.Database(repo.database).Collection(repo.personal).UpdateOne(ctx, bson.M{
+		"_id": hash,
+	}, bson.M{
+		"$set": bson.M{"last_used": now},
+	})
+	if err != nil {
+		return err
+	}
+
+	if res.MatchedCount != 1 {
+		return token.ErrPersonalNotFound
+	}
+
+	return nil
+}
+
+func (repo *Repository) DeletePersonal(ctx context.Context, username *string, id string) error {
+
+	if username == nil {
+		return errors.New("no username provided")
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, repo.cfg.RequestTimeout)
+	defer cancel()
+
+	res, err := repo.client.Database(repo.database).Collection(repo.personal).DeleteOne(ctx, bson.D{
+		primitive.E{Key: "id", Value: id},
+		primitive.E{Key: "username", Value: *username},
+	})
+	if err != nil {
+		return err
+	}
+
+	if res.DeletedCount != 1 {
+		return token.ErrPersonalNotFound
+	}
+
+	return nil
+}
+
+func (repo *Repository) DeleteUserPersonal(ctx context.Context, username *string) error {
+
+	if username == nil {
+		return errors.New("no username provided")
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, repo.cfg.RequestTimeout)
+	defer cancel()
+
+	_, err := repo.client.Database(repo.database).Collection(repo.personal).DeleteMany(ctx, bson.M{"username": *username})
+
+	return err
+}
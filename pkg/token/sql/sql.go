@@ -0,0 +1,438 @@
+package sql
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/rgynn/klottr/pkg/config"
+	"github.com/rgynn/klottr/pkg/sqldb"
+	"github.com/rgynn/klottr/pkg/token"
+)
+
+const columns = `hash, family, username, created, expires, used, revoked`
+
+// Repository for tokens in a sql database
+type Repository struct {
+	cfg *config.Config
+	db  *sqldb.DB
+}
+
+func NewRepository(cfg *config.Config, db *sqldb.DB) (token.Repository, error) {
+
+	if cfg == nil {
+		return nil, errors.New("no cfg *config.Config provided")
+	}
+
+	if db == nil {
+		return nil, errors.New("no db *sqldb.DB provided")
+	}
+
+	return &Repository{
+		cfg: cfg,
+		db:  db,
+	}, nil
+}
+
+func (repo *Repository) CreateRefresh(ctx context.Context, m *token.Refresh) error {
+
+	if m == nil {
+		return errors.New("no m *token.Refresh provided")
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, repo.cfg.RequestTimeout)
+	defer cancel()
+
+	_, err := repo.db.Exec(ctx, `INSERT INTO refresh_tokens (`+columns+`) VALUES (?, ?, ?, ?, ?, ?, ?)`,
+		m.Hash,
+		m.Family,
+		m.Username,
+		m.Created,
+		m.Expires,
+		m.Used,
+		m.Revoked,
+	)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (repo *Repository) UseRefresh(ctx context.Context, hash string, now time.Time) (*token.Refresh, error) {
+
+	ctx, cancel := context.WithTimeout(ctx, repo.cfg.RequestTimeout)
+	defer cancel()
+
+	res, err := repo.db.Exec(ctx, `UPDATE refresh_tokens SET used = ? WHERE hash = ? AND used IS NULL`, now, hash)
+	if err != nil {
+		return nil, err
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return nil, err
+	}
+
+	m, err := scan(repo.db.QueryRow(ctx, `SELECT `+columns+` FROM refresh_tokens WHERE hash = ?`, hash))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, token.ErrNotFound
+		}
+		return nil, err
+	}
+
+	if n == 0 {
+		return m, token.ErrReused
+	}
+
+	return m, nil
+}
+
+func (repo *Repository) RevokeFamily(ctx context.Context, family string, now time.Time) error {
+
+	ctx, cancel := context.WithTimeout(ctx, repo.cfg.RequestTimeout)
+	defer cancel()
+
+	_, err := repo.db.Exec(ctx, `UPDATE refresh_tokens SET revoked = ? WHERE family = ? AND revoked IS NULL`, now, family)
+
+	return err
+}
+
+func (repo *Repository) RevokeUser(ctx context.Context, username *string, now time.Time) error {
+
+	if username == nil {
+		return errors.New("no username provided")
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, repo.cfg.RequestTimeout)
+	defer cancel()
+
+	_, err := repo.db.Exec(ctx, `UPDATE refresh_tokens SET revoked = ? WHERE username = ? AND revoked IS NULL`, now, *username)
+
+	return err
+}
+
+func (repo *Repository) Deny(ctx context.Context, m *token.Denied) error {
+
+	if m == nil {
+		return errors.New("no m *token.Denied provided")
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, repo.cfg.RequestTimeout)
+	defer cancel()
+
+	_, err := repo.db.Exec(ctx, `INSERT INTO denied_tokens (jti, username, expires, issued_before) VALUES (?, ?, ?, ?)`, m.JTI, m.Username, m.Expires, m.IssuedBefore)
+	if err != nil {
+		if sqldb.IsUniqueViolation(err) {
+			return nil
+		}
+		return err
+	}
+
+	return nil
+}
+
+func (repo *Repository) ListDenied(ctx context.Context, now time.Time) ([]*token.Denied, error) {
+
+	ctx, cancel := context.WithTimeout(ctx, repo.cfg.RequestTimeout)
+	defer cancel()
+
+	rows, err := repo.db.Query(ctx, `SELECT jti, username, expires, issued_before FROM denied_tokens WHERE expires > ?`, now)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	result := []*token.Denied{}
+	for rows.Next() {
+		m := new(token.Denied)
+		if err := rows.Scan(&m.JTI, &m.Username, &m.Expires, &m.IssuedBefore); err != nil {
+			return nil, err
+		}
+		result = append(result, m)
+	}
+
+	return result, rows.Err()
+}
+
+func (repo *Repository) CreateTicket(ctx context.Context, m *token.Ticket) error {
+
+	if m == nil {
+		return errors.New("no m *token.Ticket provided")
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, repo.cfg.RequestTimeout)
+	defer cancel()
+
+	_, err := repo.db.Exec(ctx, `INSERT INTO tickets (hash, purpose, username, created, expires, used, data) VALUES (?, ?, ?, ?, ?, ?, ?)`,
+		m.Hash,
+		m.Purpose,
+		m.Username,
+		m.Created,
+		m.Expires,
+		m.Used,
+		m.Data,
+	)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (repo *Repository) UseTicket(ctx context.Context, hash, purpose string, now time.Time) (*token.Ticket, error) {
+
+	ctx, cancel := context.WithTimeout(ctx, repo.cfg.RequestTimeout)
+	defer cancel()
+
+	var result *token.Ticket
+
+	err := repo.db.Do(ctx, func(ctx context.Context) error {
+
+		res, err := repo.db.Exec(ctx, `UPDATE tickets SET used = ? WHERE hash = ? AND purpose = ? AND used IS NULL AND expires > ?`, now, hash, purpose, now)
+		if err != nil {
+			return err
+		}
+
+		if n, err := res.RowsAffected(); err != nil || n != 1 {
+			return token.ErrNotFound
+		}
+
+		m := new(token.Ticket)
+		var data sql.NullString
+		if err := repo.db.QueryRow(ctx, `SELECT hash, purpose, username, created, expires, used, data FROM tickets WHERE hash = ?`, hash).Scan(
+			&m.Hash,
+			&m.Purpose,
+			&m.Username,
+			&m.Created,
+			&m.Expires,
+			&m.Used,
+			&data,
+		); err != nil {
+			return err
+		}
+		m.Data = data.String
+
+		result = m
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+func (repo *Repository) RevokeTickets(ctx context.Context, username *string, purpose string, now time.Time) error {
+
+	if username == nil {
+		return errors.New("no username provided")
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, repo.cfg.RequestTimeout)
+	defer cancel()
+
+	_, err := repo.db.Exec(ctx, `UPDATE tickets SET used = ? WHERE username = ? AND purpose = ? AND used IS NULL`, now, *username, purpose)
+
+	return err
+}
+
+func (repo *Repository) DeleteExpired(ctx context.Context, now time.Time) error {
+
+	ctx, cancel := context.WithTimeout(ctx, repo.cfg.RequestTimeout)
+	defer cancel()
+
+	return repo.db.Do(ctx, func(ctx context.Context) error {
+
+		if _, err := repo.db.Exec(ctx, `DELETE FROM refresh_tokens WHERE expires <= ?`, now); err != nil {
+			return err
+		}
+
+		if _, err := repo.db.Exec(ctx, `DELETE FROM denied_tokens WHERE expires <= ?`, now); err != nil {
+			return err
+		}
+
+		if _, err := repo.db.Exec(ctx, `DELETE FROM tickets WHERE expires <= ?`, now); err != nil {
+			return err
+		}
+
+		if _, err := repo.db.Exec(ctx, `DELETE FROM personal_tokens WHERE expires <= ?`, now); err != nil {
+			return err
+		}
+
+		return nil
+	})
+}
+
+const personalColumns = `hash, id, username, name, scopes, created, expires, last_used`
+
+func (repo *Repository) CreatePersonal(ctx context.Context, m *token.Personal) error {
+
+	if m == nil {
+		return errors.New("no m *token.Personal provided")
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, repo.cfg.RequestTimeout)
+	defer cancel()
+
+	_, err := repo.db.Exec(ctx, `INSERT INTO personal_tokens (`+personalColumns+`) VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+		m.Hash,
+		m.ID,
+		m.Username,
+		m.Name,
+		strings.Join(m.Scopes, " "),
+		m.Created,
+		m.Expires,
+		m.LastUsed,
+	)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (repo *Repository) GetPersonal(ctx context.Context, hash string, now time.Time) (*token.Personal, error) {
+
+	ctx, cancel := context.WithTimeout(ctx, repo.cfg.RequestTimeout)
+	defer cancel()
+
+	m, err := scanPersonal(repo.db.QueryRow(ctx, `SELECT `+personalColumns+` FROM personal_tokens WHERE hash = ? AND (expires IS NULL OR expires > ?)`, hash, now))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, token.ErrPersonalNotFound
+		}
+		return nil, err
+	}
+
+	return m, nil
+}
+
+func (repo *Repository) ListPersonal(ctx context.Context, username *string) ([]*token.Personal, error) {
+
+	if username == nil {
+		return nil, errors.New("no username provided")
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, repo.cfg.RequestTimeout)
+	defer cancel()
+
+	rows, err := repo.db.Query(ctx, `SELECT `+personalColumns+` FROM personal_tokens WHERE username = ? ORDER BY created DESC`, *username)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	result := []*token.Personal{}
+	for rows.Next() {
+		m, err := scanPersonal(rows)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, m)
+	}
+
+	return result, rows.Err()
+}
+
+func (repo *Repository) TouchPersonal(ctx context.Context, hash string, now time.Time) error {
+
+	ctx, cancel := context.WithTimeout(ctx, repo.cfg.RequestTimeout)
+	defer cancel()
+
+	res, err := repo.db.Exec(ctx, `UPDATE personal_tokens SET last_used = ? WHERE hash = ?`, now, hash)
+	if err != nil {
+		return err
+	}
+
+	if n, err := res.RowsAffected(); err != nil || n != 1 {
+		return token.ErrPersonalNotFound
+	}
+
+	return nil
+}
+
+func (repo *Repository) DeleteUserPersonal(ctx context.Context, username *string) error {
+
+	if username == nil {
+		return errors.New("no username provided")
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, repo.cfg.RequestTimeout)
+	defer cancel()
+
+	_, err := repo.db.Exec(ctx, `DELETE FROM personal_tokens WHERE username = ?`, *username)
+
+	return err
+}
+
+func (repo *Repository) DeletePersonal(ctx context.Context, username *string, id string) error {
+
+	if username == nil {
+		return errors.New("no username provided")
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, repo.cfg.RequestTimeout)
+	defer cancel()
+
+	res, err := repo.db.Exec(ctx, `DELETE FROM personal_tokens WHERE id = ? AND username = ?`, id, *username)
+	if err != nil {
+		return err
+	}
+
+	if n, err := res.RowsAffected(); err != nil || n != 1 {
+		return token.ErrPersonalNotFound
+	}
+
+	return nil
+}
+
+type scanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scan(row scanner) (*token.Refresh, error) {
+
+	m := new(token.Refresh)
+
+	if err := row.Scan(
+		&m.Hash,
+		&m.Family,
+		&m.Username,
+		&m.Created,
+		&m.Expires,
+		&m.Used,
+		&m.Revoked,
+	); err != nil {
+		return nil, err
+	}
+
+	return m, nil
+}
+
+func scanPersonal(row scanner) (*token.Personal, error) {
+
+	m := new(token.Personal)
+	var scopes string
+
+	if err := row.Scan(
+		&m.Hash,
+		&m.ID,
+		&m.Username,
+		&m.Name,
+		&scopes,
+		&m.Created,
+		&m.Expires,
+		&m.LastUsed,
+	); err != nil {
+		return nil, err
+	}
+
+	m.Scopes = strings.Fields(scopes)
+
+	return m, nil
+}
@@ -0,0 +1,195 @@
+package token
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"time"
+)
+
+var ErrNotFound = errors.New("refresh token not found")
+
+// ErrReused is returned for a refresh token that was already exchanged, which means it was
+// copied, the whole family of tokens is revoked when it happens
+var ErrReused = errors.New("refresh token reused")
+
+var ErrRevoked = errors.New("token revoked")
+
+var ErrExpired = errors.New("refresh token expired")
+
+var ErrPersonalNotFound = errors.New("personal access token not found")
+
+type Repository interface {
+	// CreateRefresh stores a refresh token by its hash
+	CreateRefresh(ctx context.Context, m *Refresh) error
+	// UseRefresh marks the refresh token with hash as used at now and returns it, returning
+	// ErrReused with the token when it was used before
+	UseRefresh(ctx context.Context, hash string, now time.Time) (*Refresh, error)
+	// RevokeFamily revokes the refresh tokens of family at now
+	RevokeFamily(ctx context.Context, family string, now time.Time) error
+	// RevokeUser revokes the refresh tokens of username at now
+	RevokeUser(ctx context.Context, username *string, now time.Time) error
+	// Deny adds the jti of an access token to the denylist until the token expires, or every
+	// access token of a user issued before a time
+	Deny(ctx context.Context, m *Denied) error
+	// ListDenied lists the denied access tokens that have not expired at now
+	ListDenied(ctx context.Context, now time.Time) ([]*Denied, error)
+	// CreateTicket stores a single use ticket by its hash
+	CreateTicket(ctx context.Context, m *Ticket) error
+	// UseTicket marks the unused ticket with hash and purpose as used at now and returns it,
+	// returning ErrNotFound when there is none or it expired
+	UseTicket(ctx context.Context, hash, purpose string, now time.Time) (*Ticket, error)
+	// RevokeTickets marks the unused tickets of username with purpose as used at now
+	RevokeTickets(ctx context.Context, username *string, purpose string, now time.Time) error
+	// CreatePersonal stores a personal access token by its hash
+	CreatePersonal(ctx context.Context, m *Personal) error
+	// GetPersonal returns the personal access token with hash, returning ErrPersonalNotFound
+	// when there is none or it expired at now
+	GetPersonal(ctx context.Context, hash string, now time.Time) (*Personal, error)
+	// ListPersonal lists the personal access tokens of username, newest first
+	ListPersonal(ctx context.Context, username *string) ([]*Personal, error)
+	// TouchPersonal sets when the personal access token with hash was last used
+	TouchPersonal(ctx context.Context, hash string, now time.Time) error
+	// DeletePersonal revokes the personal access token of username with id by removing it
+	DeletePersonal(ctx context.Context, username *string, id string) error
+	// DeleteUserPersonal revokes every personal access token of username by removing them
+	DeleteUserPersonal(ctx context.Context, username *string) error
+	// DeleteExpired removes the refresh tokens, denied jtis, tickets and personal access tokens
+	// that expired before now
+	DeleteExpired(ctx context.Context, now time.Time) error
+}
+
+// Refresh token, only the hash of the token handed out is stored, every sign in starts a
+// family of tokens each replacing the one it was exchanged for
+type Refresh struct {
+	Hash     string     `json:"hash"  bson:"_id"`
+	Family   string     `json:"family"  bson:"family"`
+	Username *string    `json:"username"  bson:"username"`
+	Created  time.Time  `json:"created"  bson:"created"`
+	Expires  time.Time  `json:"expires"  bson:"expires"`
+	Used     *time.Time `json:"used,omitempty"  bson:"used,omitempty"`
+	Revoked  *time.Time `json:"revoked,omitempty"  bson:"revoked,omitempty"`
+}
+
+// Denied jti of an access token that was revoked before it expired
+type Denied struct {
+	JTI      string    `json:"jti"  bson:"_id"`
+	Username *string   `json:"username"  bson:"username"`
+	Expires  time.Time `json:"expires"  bson:"expires"`
+	// IssuedBefore denies every access token of Username issued before it instead of the one
+	// with JTI, it is kept until the last of those tokens expires
+	IssuedBefore *time.Time `json:"issued_before,omitempty"  bson:"issued_before,omitempty"`
+}
+
+const (
+	// PurposeVerify tickets validate the email address of a user
+	PurposeVerify = "verify"
+	// PurposeReset tickets set a new password for a user
+	PurposeReset = "reset"
+	// PurposeChallenge tickets finish a sign in with a second factor after the password matched
+	PurposeChallenge = "challenge"
+	// PurposeOIDC tickets are the state of a sign in with an external identity provider
+	PurposeOIDC = "oidc"
+)
+
+// Ticket is a single use token mailed to a user, only its hash is stored
+type Ticket struct {
+	Hash    string `json:"hash"  bson:"_id"`
+	Purpose string `json:"purpose"  bson:"purpose"`
+	// Username the ticket is for, empty when it is issued before a user is known
+	Username *string    `json:"username"  bson:"username"`
+	Created  time.Time  `json:"created"  bson:"created"`
+	Expires  time.Time  `json:"expires"  bson:"expires"`
+	Used     *time.Time `json:"used,omitempty"  bson:"used,omitempty"`
+	// Data is state kept with the ticket until it is used
+	Data string `json:"-"  bson:"data,omitempty"`
+}
+
+const (
+	// ScopeRead allows the GET routes
+	ScopeRead = "read"
+	// ScopePost allows creating, editing, deleting and reporting threads and comments
+	ScopePost = "post"
+	// ScopeVote allows voting on threads and comments
+	ScopeVote = "vote"
+	// ScopeAdmin allows the admin and moderation routes the role of the user is allowed on
+	ScopeAdmin = "admin"
+)
+
+// Scopes a personal access token can be given
+var Scopes = []string{ScopeRead, ScopePost, ScopeVote, ScopeAdmin}
+
+// PersonalPrefix starts every personal access token so they are told apart from jwts
+const PersonalPrefix = "klt_pat_"
+
+// Personal access token a user creates for a bot or script, only its hash is stored
+type Personal struct {
+	Hash     string     `json:"-"  bson:"_id"`
+	ID       string     `json:"id"  bson:"id"`
+	Username *string    `json:"username"  bson:"username"`
+	Name     string     `json:"name"  bson:"name"`
+	Scopes   []string   `json:"scopes"  bson:"scopes"`
+	Created  time.Time  `json:"created"  bson:"created"`
+	Expires  *time.Time `json:"expires,omitempty"  bson:"expires,omitempty"`
+	LastUsed *time.Time `json:"last_used,omitempty"  bson:"last_used,omitempty"`
+}
+
+// Valid checks that the refresh token can be exchanged at now
+func (m *Refresh) Valid(now time.Time) error {
+
+	if m.Revoked != nil {
+		return ErrRevoked
+	}
+
+	if !m.Expires.After(now) {
+		return ErrExpired
+	}
+
+	return nil
+}
+
+// New returns a random token and its hash
+func New() (string, string, error) {
+
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", "", err
+	}
+
+	s := base64.RawURLEncoding.EncodeToString(b)
+
+	return s, Hash(s), nil
+}
+
+// NewPersonal returns a random personal access token and its hash
+func NewPersonal() (string, string, error) {
+
+	s, _, err := New()
+	if err != nil {
+		return "", "", err
+	}
+
+	s = PersonalPrefix + s
+
+	return s, Hash(s), nil
+}
+
+// NewID returns a random id for the jti of an access token or a family of refresh tokens
+func NewID() string {
+
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+
+	return hex.EncodeToString(b)
+}
+
+// Hash of a token as it is stored
+func Hash(s string) string {
+	sum := sha256.Sum256([]byte(s))
+	return hex.EncodeToString(sum[:])
+}
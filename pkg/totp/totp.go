@@ -0,0 +1,155 @@
+package totp
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	// Digits of a code
+	Digits = 6
+	// Period a code is valid for
+	Period = 30 * time.Second
+	// Skew is the number of periods before and after now a code is still accepted in, so a
+	// code typed in just as it changes or with a clock slightly off works
+	Skew = 1
+)
+
+var encoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// NewSecret returns a random base32 encoded secret of 160 bits, the size RFC 4226 recommends
+func NewSecret() (string, error) {
+
+	b := make([]byte, 20)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+
+	return encoding.EncodeToString(b), nil
+}
+
+// URI returns the otpauth uri authenticator apps import the secret of account from, usually
+// shown as a qr code
+func URI(issuer, account, secret string) string {
+
+	v := url.Values{}
+	v.Set("secret", secret)
+	v.Set("issuer", issuer)
+	v.Set("algorithm", "SHA1")
+	v.Set("digits", fmt.Sprint(Digits))
+	v.Set("period", fmt.Sprint(int(Period/time.Second)))
+
+	u := url.URL{
+		Scheme:   "otpauth",
+		Host:     "totp",
+		Path:     "/" + issuer + ":" + account,
+		RawQuery: v.Encode(),
+	}
+
+	return u.String()
+}
+
+// Code returns the code of secret at t as described in RFC 6238
+func Code(secret string, t time.Time) (string, error) {
+
+	key, err := encoding.DecodeString(strings.ToUpper(secret))
+	if err != nil {
+		return "", fmt.Errorf("invalid totp secret: %w", err)
+	}
+
+	return code(key, uint64(t.Unix()/int64(Period/time.Second))), nil
+}
+
+// Validate reports whether code is the code of secret at t, or of the periods within Skew of it,
+// and returns the time step of the latest period it matched
+func Validate(secret, code string, t time.Time) (int64, bool, error) {
+
+	code = strings.TrimSpace(code)
+	if len(code) != Digits {
+		return 0, false, nil
+	}
+
+	key, err := encoding.DecodeString(strings.ToUpper(secret))
+	if err != nil {
+		return 0, false, fmt.Errorf("invalid totp secret: %w", err)
+	}
+
+	step := t.Unix() / int64(Period/time.Second)
+
+	matched := int64(0)
+	valid := false
+	for i := -Skew; i <= Skew; i++ {
+		if subtle.ConstantTimeCompare([]byte(codeAt(key, step+int64(i))), []byte(code)) == 1 {
+			matched = step + int64(i)
+			valid = true
+		}
+	}
+
+	return matched, valid, nil
+}
+
+func codeAt(key []byte, step int64) string {
+	if step < 0 {
+		return ""
+	}
+	return code(key, uint64(step))
+}
+
+func code(key []byte, counter uint64) string {
+
+	msg := make([]byte, 8)
+	binary.BigEndian.PutUint64(msg, counter)
+
+	mac := hmac.New(sha1.New, key)
+	mac.Write(msg) //nolint:errcheck
+	sum := mac.Sum(nil)
+
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+
+	mod := uint32(1)
+	for i := 0; i < Digits; i++ {
+		mod *= 10
+	}
+
+	return fmt.Sprintf("%0*d", Digits, value%mod)
+}
+
+// NewRecoveryCodes returns n random single use codes signing in in place of a totp code, they
+// are shown to the user once and only their hashes are stored
+func NewRecoveryCodes(n int) ([]string, error) {
+
+	codes := make([]string, n)
+
+	for i := range codes {
+		b := make([]byte, 6)
+		if _, err := rand.Read(b); err != nil {
+			return nil, err
+		}
+		s := strings.ToLower(encoding.EncodeToString(b))
+		codes[i] = s[:5] + "-" + s[5:]
+	}
+
+	return codes, nil
+}
+
+// HashRecoveryCode returns the sha256 hex of a recovery code, ignoring case, spaces and dashes
+// so it can be typed in as it reads
+func HashRecoveryCode(code string) string {
+
+	code = strings.ToLower(strings.NewReplacer("-", "", " ", "").Replace(code))
+
+	sum := sha256.Sum256([]byte(code))
+
+	return hex.EncodeToString(sum[:])
+}
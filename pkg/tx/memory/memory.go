@@ -0,0 +1,30 @@
+package memory
+
+import (
+	"context"
+	"errors"
+
+	"github.com/rgynn/klottr/pkg/config"
+	"github.com/rgynn/klottr/pkg/tx"
+)
+
+// Transactor for repositories kept in memory, writes are applied as they are made and not
+// rolled back when a later write of the unit of work fails
+type Transactor struct {
+	cfg *config.Config
+}
+
+func NewTransactor(cfg *config.Config) (tx.Transactor, error) {
+
+	if cfg == nil {
+		return nil, errors.New("no cfg *config.Config provided")
+	}
+
+	return &Transactor{
+		cfg: cfg,
+	}, nil
+}
+
+func (t *Transactor) Do(ctx context.Context, fn func(ctx context.Context) error) error {
+	return fn(ctx)
+}
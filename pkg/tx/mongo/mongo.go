@@ -0,0 +1,91 @@
+package mongo
+
+import (
+	"context"
+	"errors"
+
+	"github.com/rgynn/klottr/pkg/config"
+	"github.com/rgynn/klottr/pkg/tx"
+	"github.com/sirupsen/logrus"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// Transactor for repositories in mongo cluster, using sessions with multi-document transactions
+type Transactor struct {
+	cfg          *config.Config
+	client       *mongo.Client
+	transactions bool
+}
+
+func NewTransactor(cfg *config.Config, client *mongo.Client) (tx.Transactor, error) {
+
+	if cfg == nil {
+		return nil, errors.New("no cfg *config.Config provided")
+	}
+
+	if client == nil {
+		return nil, errors.New("no client *mongo.Client provided")
+	}
+
+	transactions, err := supportsTransactions(cfg, client)
+	if err != nil {
+		return nil, err
+	}
+
+	if !transactions {
+		logrus.Warn("MongoDB deployment is not a replica set or sharded cluster, units of work run without transactions")
+	}
+
+	return &Transactor{
+		cfg:          cfg,
+		client:       client,
+		transactions: transactions,
+	}, nil
+}
+
+func (t *Transactor) Do(ctx context.Context, fn func(ctx context.Context) error) error {
+
+	if !t.transactions {
+		return fn(ctx)
+	}
+
+	// join the transaction of an enclosing unit of work
+	if mongo.SessionFromContext(ctx) != nil {
+		return fn(ctx)
+	}
+
+	session, err := t.client.StartSession()
+	if err != nil {
+		return err
+	}
+	defer session.EndSession(ctx)
+
+	_, err = session.WithTransaction(ctx, func(sc mongo.SessionContext) (interface{}, error) {
+		return nil, fn(sc)
+	})
+
+	return err
+}
+
+// supportsTransactions reports whether the deployment is a replica set or sharded cluster,
+// standalone servers do not support transactions
+func supportsTransactions(cfg *config.Config, client *mongo.Client) (bool, error) {
+
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.RequestTimeout)
+	defer cancel()
+
+	var result struct {
+		SetName string `bson:"setName"`
+		Msg     string `bson:"msg"`
+	}
+
+	if err := client.Database("admin").RunCommand(ctx, bson.D{
+		primitive.E{Key: "isMaster", Value: 1},
+	}).Decode(&result); err != nil {
+		return false, err
+	}
+
+	return result.SetName != "" || result.Msg == "isdbgrid", nil
+}
@@ -0,0 +1,36 @@
+package sql
+
+import (
+	"context"
+	"errors"
+
+	"github.com/rgynn/klottr/pkg/config"
+	"github.com/rgynn/klottr/pkg/sqldb"
+	"github.com/rgynn/klottr/pkg/tx"
+)
+
+// Transactor for repositories in a sql database
+type Transactor struct {
+	cfg *config.Config
+	db  *sqldb.DB
+}
+
+func NewTransactor(cfg *config.Config, db *sqldb.DB) (tx.Transactor, error) {
+
+	if cfg == nil {
+		return nil, errors.New("no cfg *config.Config provided")
+	}
+
+	if db == nil {
+		return nil, errors.New("no db *sqldb.DB provided")
+	}
+
+	return &Transactor{
+		cfg: cfg,
+		db:  db,
+	}, nil
+}
+
+func (t *Transactor) Do(ctx context.Context, fn func(ctx context.Context) error) error {
+	return t.db.Do(ctx, fn)
+}
@@ -0,0 +1,10 @@
+package tx
+
+import "context"
+
+// Transactor runs units of work spanning several repositories
+type Transactor interface {
+	// Do runs fn in a transaction committed when fn returns nil and rolled back otherwise,
+	// repository calls made with the ctx passed to fn are part of the transaction
+	Do(ctx context.Context, fn func(ctx context.Context) error) error
+}
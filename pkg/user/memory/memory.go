@@ -0,0 +1,510 @@
+package memory
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/rgynn/klottr/pkg/config"
+	"github.com/rgynn/klottr/pkg/cursor"
+	"github.com/rgynn/klottr/pkg/user"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Repository for users kept in memory
+type Repository struct {
+	mu    sync.RWMutex
+	cfg   *config.Config
+	users []*user.Model
+}
+
+func NewRepository(cfg *config.Config) (user.Repository, error) {
+
+	if cfg == nil {
+		return nil, errors.New("no cfg *config.Config provided")
+	}
+
+	return &Repository{
+		cfg:   cfg,
+		users: []*user.Model{},
+	}, nil
+}
+
+func (repo *Repository) Create(ctx context.Context, m *user.Model) error {
+
+	if m == nil {
+		return errors.New("no m *user.Model provided")
+	}
+
+	m.Votes = user.Votes{
+		Threads:  map[string]int8{},
+		Comments: map[string]int8{},
+	}
+
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	if repo.find(m.Username) >= 0 {
+		return user.ErrAlreadyExists
+	}
+
+	for _, identity := range m.Identities {
+		if repo.findIdentity(identity.Issuer, identity.Subject) >= 0 {
+			return user.ErrAlreadyExists
+		}
+	}
+
+	stored := clone(m)
+	if stored.ID == nil {
+		id := primitive.NewObjectID()
+		stored.ID = &id
+	}
+
+	repo.users = append(repo.users, stored)
+
+	return nil
+}
+
+func (repo *Repository) Search(ctx context.Context, username, role *string, after *cursor.Cursor, from, size int64) ([]*user.Model, error) {
+
+	if role == nil {
+		return nil, errors.New("no role provided")
+	}
+
+	if after != nil {
+		from = 0
+	}
+
+	repo.mu.RLock()
+	defer repo.mu.RUnlock()
+
+	result := []*user.Model{}
+
+	var skipped int64
+	for _, m := range repo.users {
+		if !equalString(m.Role, role) {
+			continue
+		}
+		if username != nil && !equalString(m.Username, username) {
+			continue
+		}
+		if after != nil && (m.ID == nil || m.ID.Hex() <= after.ID) {
+			continue
+		}
+		if skipped < from {
+			skipped++
+			continue
+		}
+		if size > 0 && int64(len(result)) >= size {
+			break
+		}
+		result = append(result, clone(m))
+	}
+
+	return result, nil
+}
+
+func (repo *Repository) GetByID(ctx context.Context, id *string) (*user.Model, error) {
+
+	if id == nil {
+		return nil, errors.New("no id provided")
+	}
+
+	repo.mu.RLock()
+	defer repo.mu.RUnlock()
+
+	for _, m := range repo.users {
+		if m.ID != nil && m.ID.Hex() == *id {
+			return clone(m), nil
+		}
+	}
+
+	return nil, user.ErrNotFound
+}
+
+func (repo *Repository) GetByUsername(ctx context.Context, username *string) (*user.Model, error) {
+
+	if username == nil {
+		return nil, errors.New("no username provided")
+	}
+
+	repo.mu.RLock()
+	defer repo.mu.RUnlock()
+
+	i := repo.find(username)
+	if i < 0 {
+		return nil, user.ErrNotFound
+	}
+
+	return clone(repo.users[i]), nil
+}
+
+func (repo *Repository) GetByIdentity(ctx context.Context, issuer, subject string) (*user.Model, error) {
+
+	repo.mu.RLock()
+	defer repo.mu.RUnlock()
+
+	i := repo.findIdentity(issuer, subject)
+	if i < 0 {
+		return nil, user.ErrNotFound
+	}
+
+	return clone(repo.users[i]), nil
+}
+
+func (repo *Repository) LinkIdentity(ctx context.Context, username *string, identity *user.Identity) error {
+
+	if username == nil {
+		return errors.New("no username provided")
+	}
+
+	if identity == nil {
+		return errors.New("no identity provided")
+	}
+
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	i := repo.find(username)
+	if i < 0 {
+		return user.ErrNotFound
+	}
+
+	if j := repo.findIdentity(identity.Issuer, identity.Subject); j >= 0 {
+		if j == i {
+			return nil
+		}
+		return user.ErrAlreadyExists
+	}
+
+	repo.users[i].Identities = append(repo.users[i].Identities, *identity)
+
+	return nil
+}
+
+func (repo *Repository) Deactivate(ctx context.Context, username, role *string) error {
+
+	if username == nil {
+		return errors.New("no username provided")
+	}
+
+	if role == nil {
+		return errors.New("no role provided")
+	}
+
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	i := repo.find(username)
+	if i < 0 || !equalString(repo.users[i].Role, role) {
+		return user.ErrNotFound
+	}
+
+	now := time.Now().UTC()
+	repo.users[i].Deactivated = &now
+
+	return nil
+}
+
+func (repo *Repository) SetPassword(ctx context.Context, username, passwordHash *string) error {
+
+	if username == nil {
+		return errors.New("no username provided")
+	}
+
+	if passwordHash == nil {
+		return errors.New("no passwordHash provided")
+	}
+
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	i := repo.find(username)
+	if i < 0 {
+		return user.ErrNotFound
+	}
+
+	now := time.Now().UTC()
+	hash := *passwordHash
+	repo.users[i].PasswordHash = &hash
+	repo.users[i].Updated = &now
+
+	return nil
+}
+
+func (repo *Repository) SetValidated(ctx context.Context, username *string) error {
+
+	if username == nil {
+		return errors.New("no username provided")
+	}
+
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	i := repo.find(username)
+	if i < 0 {
+		return user.ErrNotFound
+	}
+
+	repo.users[i].Validated = true
+
+	return nil
+}
+
+func (repo *Repository) SetTOTP(ctx context.Context, username, secret *string, enabled *time.Time, recoveryCodes []string) error {
+
+	if username == nil {
+		return errors.New("no username provided")
+	}
+
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	i := repo.find(username)
+	if i < 0 {
+		return user.ErrNotFound
+	}
+
+	m := repo.users[i]
+	m.TOTPSecret = nil
+	m.TOTPEnabled = nil
+
+	if secret != nil {
+		s := *secret
+		m.TOTPSecret = &s
+	}
+
+	if enabled != nil {
+		t := *enabled
+		m.TOTPEnabled = &t
+	}
+
+	m.RecoveryCodes = append([]string(nil), recoveryCodes...)
+
+	return nil
+}
+
+func (repo *Repository) UseRecoveryCode(ctx context.Context, username *string, hash string) error {
+
+	if username == nil {
+		return errors.New("no username provided")
+	}
+
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	i := repo.find(username)
+	if i < 0 {
+		return user.ErrNotFound
+	}
+
+	m := repo.users[i]
+	for j, code := range m.RecoveryCodes {
+		if code == hash {
+			m.RecoveryCodes = append(append([]string(nil), m.RecoveryCodes[:j]...), m.RecoveryCodes[j+1:]...)
+			return nil
+		}
+	}
+
+	return user.ErrInvalidCode
+}
+
+func (repo *Repository) UseTOTPStep(ctx context.Context, username *string, step int64) error {
+
+	if username == nil {
+		return errors.New("no username provided")
+	}
+
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	i := repo.find(username)
+	if i < 0 {
+		return user.ErrNotFound
+	}
+
+	if step <= repo.users[i].TOTPStep {
+		return user.ErrInvalidCode
+	}
+
+	repo.users[i].TOTPStep = step
+
+	return nil
+}
+
+func (repo *Repository) Delete(ctx context.Context, username, role *string) error {
+
+	if username == nil {
+		return errors.New("no username provided")
+	}
+
+	if role == nil {
+		return errors.New("no role provided")
+	}
+
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	i := repo.find(username)
+	if i < 0 || !equalString(repo.users[i].Role, role) {
+		return user.ErrNotFound
+	}
+
+	repo.users = append(repo.users[:i], repo.users[i+1:]...)
+
+	return nil
+}
+
+func (repo *Repository) SetRole(ctx context.Context, username, role *string, moderates []string) error {
+
+	if username == nil {
+		return errors.New("no username provided")
+	}
+
+	if role == nil {
+		return errors.New("no role provided")
+	}
+
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	i := repo.find(username)
+	if i < 0 {
+		return user.ErrNotFound
+	}
+
+	r := *role
+	repo.users[i].Role = &r
+	repo.users[i].Moderates = append([]string(nil), moderates...)
+
+	return nil
+}
+
+func (repo *Repository) IncCounter(ctx context.Context, username, field *string, value int64) error {
+
+	if username == nil {
+		return errors.New("no username provided")
+	}
+
+	if field == nil {
+		return errors.New("no field provided")
+	}
+
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	i := repo.find(username)
+	if i < 0 {
+		return user.ErrNotFound
+	}
+
+	counters := &repo.users[i].Counters
+
+	var counter *uint32
+	switch *field {
+	case "counters.num.threads":
+		counter = &counters.Num.Threads
+	case "counters.num.comments":
+		counter = &counters.Num.Comments
+	case "counters.votes.threads":
+		counter = &counters.Votes.Threads
+	case "counters.votes.comments":
+		counter = &counters.Votes.Comments
+	case "counters.warnings":
+		counter = &counters.Warnings
+	default:
+		return errors.New("invalid counter field provided: " + *field)
+	}
+
+	*counter = uint32(int64(*counter) + value)
+
+	return nil
+}
+
+func (repo *Repository) SwapVote(ctx context.Context, username *string, vote *user.Vote) (int8, error) {
+
+	if username == nil {
+		return 0, errors.New("no username provided")
+	}
+
+	if vote == nil {
+		return 0, errors.New("no vote provided")
+	}
+
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	i := repo.find(username)
+	if i < 0 {
+		return 0, user.ErrNotFound
+	}
+
+	var votes map[string]int8
+
+	switch *vote.SlugType {
+	case "threads":
+		votes = repo.users[i].Votes.Threads
+	case "comments":
+		votes = repo.users[i].Votes.Comments
+	default:
+		return 0, errors.New("invalid vote slug type provided: " + *vote.SlugType)
+	}
+
+	old := votes[*vote.SlugID]
+
+	if *vote.Value == 0 {
+		delete(votes, *vote.SlugID)
+	} else {
+		votes[*vote.SlugID] = *vote.Value
+	}
+
+	return old, nil
+}
+
+// find returns the index of the user with username, or -1, callers must hold the lock
+func (repo *Repository) findIdentity(issuer, subject string) int {
+	for i, m := range repo.users {
+		for _, identity := range m.Identities {
+			if identity.Issuer == issuer && identity.Subject == subject {
+				return i
+			}
+		}
+	}
+	return -1
+}
+
+func (repo *Repository) find(username *string) int {
+	for i, m := range repo.users {
+		if equalString(m.Username, username) {
+			return i
+		}
+	}
+	return -1
+}
+
+// clone copies m including its vote maps so callers cannot mutate stored state
+func clone(m *user.Model) *user.Model {
+	c := *m
+	c.Moderates = append([]string(nil), m.Moderates...)
+	c.RecoveryCodes = append([]string(nil), m.RecoveryCodes...)
+	c.Identities = append([]user.Identity(nil), m.Identities...)
+	c.Votes = user.Votes{
+		Threads:  make(map[string]int8, len(m.Votes.Threads)),
+		Comments: make(map[string]int8, len(m.Votes.Comments)),
+	}
+	for k, v := range m.Votes.Threads {
+		c.Votes.Threads[k] = v
+	}
+	for k, v := range m.Votes.Comments {
+		c.Votes.Comments[k] = v
+	}
+	return &c
+}
+
+func equalString(a, b *string) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return *a == *b
+}
@@ -7,6 +7,7 @@ import (
 	"time"
 
 	"github.com/rgynn/klottr/pkg/config"
+	"github.com/rgynn/klottr/pkg/cursor"
 	"github.com/rgynn/klottr/pkg/user"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
@@ -65,7 +66,7 @@ func (repo *Repository) Create(ctx context.Context, m *user.Model) error {
 	return nil
 }
 
-func (repo *Repository) Search(ctx context.Context, username, role *string, from, size int64) ([]*user.Model, error) {
+func (repo *Repository) Search(ctx context.Context, username, role *string, after *cursor.Cursor, from, size int64) ([]*user.Model, error) {
 
 	if role == nil {
 		return nil, errors.New("no role provided")
@@ -79,10 +80,21 @@ func (repo *Repository) Search(ctx context.Context, username, role *string, from
 		filter = append(filter, primitive.E{Key: "username", Value: *username})
 	}
 
+	if after != nil {
+		id, err := primitive.ObjectIDFromHex(after.ID)
+		if err != nil {
+			return nil, err
+		}
+		filter = append(filter, primitive.E{Key: "_id", Value: bson.M{"$gt": id}})
+		from = 0
+	}
+
 	ctx, cancel := context.WithTimeout(ctx, repo.cfg.RequestTimeout)
 	defer cancel()
 
-	cursor, err := repo.client.Database(repo.database).Collection(repo.collection).Find(ctx, filter, options.Find().SetSkip(from).SetLimit(size))
+	cursor, err := repo.client.Database(repo.database).Collection(repo.collection).Find(ctx, filter, options.Find().SetSort(bson.D{
+		primitive.E{Key: "_id", Value: 1},
+	}).SetSkip(from).SetLimit(size))
 	if err != nil {
 		return nil, err
 	}
@@ -128,12 +140,84 @@ func (repo *Repository) GetByUsername(ctx context.Context, username *string) (*u
 
 	var result *user.Model
 	if err := repo.client.Database(repo.database).Collection(repo.collection).FindOne(ctx, bson.D{primitive.E{Key: "username", Value: *username}}).Decode(&result); err != nil {
-		return nil, err
+		switch err {
+		case mongo.ErrNoDocuments:
+			return nil, user.ErrNotFound
+		default:
+			return nil, err
+		}
 	}
 
 	return result, nil
 }
 
+func (repo *Repository) GetByIdentity(ctx context.Context, issuer, subject string) (*user.Model, error) {
+
+	ctx, cancel := context.WithTimeout(ctx, repo.cfg.RequestTimeout)
+	defer cancel()
+
+	var result *user.Model
+	if err := repo.client.Database(repo.database).Collection(repo.collection).FindOne(ctx, bson.D{primitive.E{
+		Key: "identities",
+		Value: bson.D{primitive.E{
+			Key: "$elemMatch",
+			Value: bson.D{
+				primitive.E{Key: "issuer", Value: issuer},
+				primitive.E{Key: "subject", Value: subject},
+			},
+		}},
+	}}).Decode(&result); err != nil {
+		switch err {
+		case mongo.ErrNoDocuments:
+			return nil, user.ErrNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return result, nil
+}
+
+func (repo *Repository) LinkIdentity(ctx context.Context, username *string, identity *user.Identity) error {
+
+	if username == nil {
+		return errors.New("no username provided")
+	}
+
+	if identity == nil {
+		return errors.New("no identity provided")
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, repo.cfg.RequestTimeout)
+	defer cancel()
+
+	// the unique index on identities refuses an identity linked to another user
+	res, err := repo.client.Database(repo.database).Collection(repo.collection).UpdateOne(ctx,
+		bson.D{
+			primitive.E{Key: "username", Value: *username},
+		},
+		bson.D{primitive.E{
+			Key: "$addToSet",
+			Value: bson.D{primitive.E{
+				Key:   "identities",
+				Value: identity,
+			}},
+		}},
+	)
+	if err != nil {
+		if mongo.IsDuplicateKeyError(err) {
+			return user.ErrAlreadyExists
+		}
+		return err
+	}
+
+	if res.MatchedCount != 1 {
+		return user.ErrNotFound
+	}
+
+	return nil
+}
+
 func (repo *Repository) Deactivate(ctx context.Context, username, role *string) error {
 
 	if username == nil {
@@ -171,6 +255,198 @@ func (repo *Repository) Deactivate(ctx context.Context, username, role *string)
 	return nil
 }
 
+func (repo *Repository) SetPassword(ctx context.Context, username, passwordHash *string) error {
+
+	if username == nil {
+		return errors.New("no username provided")
+	}
+
+	if passwordHash == nil {
+		return errors.New("no passwordHash provided")
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, repo.cfg.RequestTimeout)
+	defer cancel()
+
+	res, err := repo.client.Database(repo.database).Collection(repo.collection).UpdateOne(ctx,
+		bson.D{
+			primitive.E{Key: "username", Value: *username},
+		},
+		bson.D{primitive.E{
+			Key: "$set",
+			Value: bson.D{
+				primitive.E{Key: "password_hash", Value: *passwordHash},
+				primitive.E{Key: "updated", Value: time.Now().UTC()},
+			},
+		}},
+	)
+	if err != nil {
+		return err
+	}
+
+	if res.MatchedCount != 1 {
+		return user.ErrNotFound
+	}
+
+	return nil
+}
+
+func (repo *Repository) SetValidated(ctx context.Context, username *string) error {
+
+	if username == nil {
+		return errors.New("no username provided")
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, repo.cfg.RequestTimeout)
+	defer cancel()
+
+	res, err := repo.client.Database(repo.database).Collection(repo.collection).UpdateOne(ctx,
+		bson.D{
+			primitive.E{Key: "username", Value: *username},
+		},
+		bson.D{primitive.E{
+			Key: "$set",
+			Value: bson.D{primitive.E{
+				Key:   "validated",
+				Value: true,
+			}},
+		}},
+	)
+	if err != nil {
+		return err
+	}
+
+	if res.MatchedCount != 1 {
+		return user.ErrNotFound
+	}
+
+	return nil
+}
+
+func (repo *Repository) SetTOTP(ctx context.Context, username, secret *string, enabled *time.Time, recoveryCodes []string) error {
+
+	if username == nil {
+		return errors.New("no username provided")
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, repo.cfg.RequestTimeout)
+	defer cancel()
+
+	update := bson.D{}
+	set := bson.D{}
+	unset := bson.D{}
+
+	if secret != nil {
+		set = append(set, primitive.E{Key: "totp_secret", Value: *secret})
+	} else {
+		unset = append(unset, primitive.E{Key: "totp_secret", Value: ""})
+	}
+
+	if enabled != nil {
+		set = append(set, primitive.E{Key: "totp_enabled", Value: *enabled})
+	} else {
+		unset = append(unset, primitive.E{Key: "totp_enabled", Value: ""})
+	}
+
+	if len(recoveryCodes) > 0 {
+		set = append(set, primitive.E{Key: "recovery_codes", Value: recoveryCodes})
+	} else {
+		unset = append(unset, primitive.E{Key: "recovery_codes", Value: ""})
+	}
+
+	if len(set) > 0 {
+		update = append(update, primitive.E{Key: "$set", Value: set})
+	}
+
+	if len(unset) > 0 {
+		update = append(update, primitive.E{Key: "$unset", Value: unset})
+	}
+
+	res, err := repo.client.Database(repo.database).Collection(repo.collection).UpdateOne(ctx,
+		bson.D{
+			primitive.E{Key: "username", Value: *username},
+		},
+		update,
+	)
+	if err != nil {
+		return err
+	}
+
+	if res.MatchedCount != 1 {
+		return user.ErrNotFound
+	}
+
+	return nil
+}
+
+func (repo *Repository) UseRecoveryCode(ctx context.Context, username *string, hash string) error {
+
+	if username == nil {
+		return errors.New("no username provided")
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, repo.cfg.RequestTimeout)
+	defer cancel()
+
+	// matching on the hash makes the code usable once even when it is sent twice at the same time
+	res, err := repo.client.Database(repo.database).Collection(repo.collection).UpdateOne(ctx,
+		bson.D{
+			primitive.E{Key: "username", Value: *username},
+			primitive.E{Key: "recovery_codes", Value: hash},
+		},
+		bson.D{primitive.E{
+			Key: "$pull",
+			Value: bson.D{primitive.E{
+				Key:   "recovery_codes",
+				Value: hash,
+			}},
+		}},
+	)
+	if err != nil {
+		return err
+	}
+
+	if res.MatchedCount != 1 {
+		return user.ErrInvalidCode
+	}
+
+	return nil
+}
+
+func (repo *Repository) UseTOTPStep(ctx context.Context, username *string, step int64) error {
+
+	if username == nil {
+		return errors.New("no username provided")
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, repo.cfg.RequestTimeout)
+	defer cancel()
+
+	// matching on the last step makes a code usable once even when it is sent twice at the same time
+	res, err := repo.client.Database(repo.database).Collection(repo.collection).UpdateOne(ctx,
+		bson.D{
+			primitive.E{Key: "username", Value: *username},
+			primitive.E{Key: "$or", Value: bson.A{
+				bson.M{"totp_step": bson.M{"$lt": step}},
+				bson.M{"totp_step": bson.M{"$exists": false}},
+			}},
+		},
+		bson.D{primitive.E{
+			Key:   "$set",
+			Value: bson.D{primitive.E{Key: "totp_step", Value: step}},
+		}},
+	)
+	if err != nil {
+		return err
+	}
+
+	if res.MatchedCount != 1 {
+		return user.ErrInvalidCode
+	}
+
+	return nil
+}
+
 func (repo *Repository) Delete(ctx context.Context, username, role *string) error {
 
 	if username == nil {
@@ -199,27 +475,28 @@ func (repo *Repository) Delete(ctx context.Context, username, role *string) erro
 	return nil
 }
 
-func (repo *Repository) IncCounter(ctx context.Context, username, field *string, value int8) error {
+func (repo *Repository) SetRole(ctx context.Context, username, role *string, moderates []string) error {
 
 	if username == nil {
 		return errors.New("no username provided")
 	}
 
-	if field == nil {
-		return errors.New("no field provided")
+	if role == nil {
+		return errors.New("no role provided")
 	}
 
 	ctx, cancel := context.WithTimeout(ctx, repo.cfg.RequestTimeout)
 	defer cancel()
 
 	res, err := repo.client.Database(repo.database).Collection(repo.collection).UpdateOne(ctx,
+		bson.D{
+			primitive.E{Key: "username", Value: *username},
+		},
 		bson.D{primitive.E{
-			Key: "username", Value: *username,
-		}},
-		bson.D{primitive.E{
-			Key: "$inc",
+			Key: "$set",
 			Value: bson.D{
-				primitive.E{Key: *field, Value: value},
+				primitive.E{Key: "role", Value: *role},
+				primitive.E{Key: "moderates", Value: moderates},
 			},
 		}},
 	)
@@ -234,14 +511,14 @@ func (repo *Repository) IncCounter(ctx context.Context, username, field *string,
 	return nil
 }
 
-func (repo *Repository) UpsertVote(ctx context.Context, username *string, vote *user.Vote) error {
+func (repo *Repository) IncCounter(ctx context.Context, username, field *string, value int64) error {
 
 	if username == nil {
 		return errors.New("no username provided")
 	}
 
-	if vote == nil {
-		return errors.New("no vote provided")
+	if field == nil {
+		return errors.New("no field provided")
 	}
 
 	ctx, cancel := context.WithTimeout(ctx, repo.cfg.RequestTimeout)
@@ -252,9 +529,9 @@ func (repo *Repository) UpsertVote(ctx context.Context, username *string, vote *
 			Key: "username", Value: *username,
 		}},
 		bson.D{primitive.E{
-			Key: "$set",
+			Key: "$inc",
 			Value: bson.D{
-				primitive.E{Key: fmt.Sprintf("votes.%s.%s", *vote.SlugType, *vote.SlugID), Value: *vote.Value},
+				primitive.E{Key: *field, Value: value},
 			},
 		}},
 	)
@@ -268,3 +545,62 @@ func (repo *Repository) UpsertVote(ctx context.Context, username *string, vote *
 
 	return nil
 }
+
+func (repo *Repository) SwapVote(ctx context.Context, username *string, vote *user.Vote) (int8, error) {
+
+	if username == nil {
+		return 0, errors.New("no username provided")
+	}
+
+	if vote == nil {
+		return 0, errors.New("no vote provided")
+	}
+
+	field := fmt.Sprintf("votes.%s.%s", *vote.SlugType, *vote.SlugID)
+
+	update := bson.D{primitive.E{
+		Key: "$set",
+		Value: bson.D{
+			primitive.E{Key: field, Value: *vote.Value},
+		},
+	}}
+
+	if *vote.Value == 0 {
+		update = bson.D{primitive.E{
+			Key: "$unset",
+			Value: bson.D{
+				primitive.E{Key: field, Value: ""},
+			},
+		}}
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, repo.cfg.RequestTimeout)
+	defer cancel()
+
+	var result *user.Model
+	if err := repo.client.Database(repo.database).Collection(repo.collection).FindOneAndUpdate(ctx,
+		bson.D{primitive.E{
+			Key: "username", Value: *username,
+		}},
+		update,
+		options.FindOneAndUpdate().SetReturnDocument(options.Before).SetProjection(bson.D{
+			primitive.E{Key: field, Value: 1},
+		}),
+	).Decode(&result); err != nil {
+		switch err {
+		case mongo.ErrNoDocuments:
+			return 0, user.ErrNotFound
+		default:
+			return 0, err
+		}
+	}
+
+	switch *vote.SlugType {
+	case "threads":
+		return result.Votes.Threads[*vote.SlugID], nil
+	case "comments":
+		return result.Votes.Comments[*vote.SlugID], nil
+	default:
+		return 0, nil
+	}
+}
@@ -0,0 +1,728 @@
+package sql
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/rgynn/klottr/pkg/config"
+	"github.com/rgynn/klottr/pkg/cursor"
+	"github.com/rgynn/klottr/pkg/sqldb"
+	"github.com/rgynn/klottr/pkg/user"
+)
+
+const columns = `id, role, validated, username, password_hash, email_hash, email_encrypted, totp_secret, totp_enabled, recovery_codes, totp_step, counters_num_threads, counters_num_comments, counters_votes_threads, counters_votes_comments, counters_warnings, created, updated, deactivated`
+
+// counterColumns maps the counter fields used by the api to their columns
+var counterColumns = map[string]string{
+	"counters.num.threads":    "counters_num_threads",
+	"counters.num.comments":   "counters_num_comments",
+	"counters.votes.threads":  "counters_votes_threads",
+	"counters.votes.comments": "counters_votes_comments",
+	"counters.warnings":       "counters_warnings",
+}
+
+// Repository for users in a sql database
+type Repository struct {
+	cfg *config.Config
+	db  *sqldb.DB
+}
+
+func NewRepository(cfg *config.Config, db *sqldb.DB) (user.Repository, error) {
+
+	if cfg == nil {
+		return nil, errors.New("no cfg *config.Config provided")
+	}
+
+	if db == nil {
+		return nil, errors.New("no db *sqldb.DB provided")
+	}
+
+	return &Repository{
+		cfg: cfg,
+		db:  db,
+	}, nil
+}
+
+func (repo *Repository) Create(ctx context.Context, m *user.Model) error {
+
+	if m == nil {
+		return errors.New("no m *user.Model provided")
+	}
+
+	m.Votes = user.Votes{
+		Threads:  map[string]int8{},
+		Comments: map[string]int8{},
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, repo.cfg.RequestTimeout)
+	defer cancel()
+
+	id := m.ID
+	if id == nil {
+		id = sqldb.NewID()
+	}
+
+	return repo.db.Do(ctx, func(ctx context.Context) error {
+
+		_, err := repo.db.Exec(ctx, `INSERT INTO users (`+columns+`) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+			id.Hex(),
+			m.Role,
+			m.Validated,
+			m.Username,
+			m.PasswordHash,
+			m.EmailHash,
+			m.EmailEncrypted,
+			m.TOTPSecret,
+			m.TOTPEnabled,
+			joinCodes(m.RecoveryCodes),
+			m.TOTPStep,
+			m.Counters.Num.Threads,
+			m.Counters.Num.Comments,
+			m.Counters.Votes.Threads,
+			m.Counters.Votes.Comments,
+			m.Counters.Warnings,
+			m.Created,
+			m.Updated,
+			m.Deactivated,
+		)
+		if err != nil {
+			if sqldb.IsUniqueViolation(err) {
+				return user.ErrAlreadyExists
+			}
+			return err
+		}
+
+		for _, category := range m.Moderates {
+			if _, err := repo.db.Exec(ctx, `INSERT INTO moderators (username, category) VALUES (?, ?)`, m.Username, category); err != nil {
+				return err
+			}
+		}
+
+		for _, identity := range m.Identities {
+			if _, err := repo.db.Exec(ctx, `INSERT INTO user_identities (issuer, subject, username) VALUES (?, ?, ?)`, identity.Issuer, identity.Subject, m.Username); err != nil {
+				if sqldb.IsUniqueViolation(err) {
+					return user.ErrAlreadyExists
+				}
+				return err
+			}
+		}
+
+		return nil
+	})
+}
+
+func (repo *Repository) Search(ctx context.Context, username, role *string, after *cursor.Cursor, from, size int64) ([]*user.Model, error) {
+
+	if role == nil {
+		return nil, errors.New("no role provided")
+	}
+
+	where := `role = ?`
+	args := []interface{}{*role}
+
+	if username != nil {
+		where += ` AND username = ?`
+		args = append(args, *username)
+	}
+
+	if after != nil {
+		where += ` AND id > ?`
+		args = append(args, after.ID)
+		from = 0
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, repo.cfg.RequestTimeout)
+	defer cancel()
+
+	limit, limitArgs := repo.db.LimitOffset(from, size)
+
+	rows, err := repo.db.Query(ctx, `SELECT `+columns+` FROM users WHERE `+where+` ORDER BY id`+limit, append(args, limitArgs...)...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	result := []*user.Model{}
+	for rows.Next() {
+		m, err := scan(rows)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, m)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	for _, m := range result {
+		if err := repo.loadVotes(ctx, m); err != nil {
+			return nil, err
+		}
+		if err := repo.loadModerates(ctx, m); err != nil {
+			return nil, err
+		}
+		if err := repo.loadIdentities(ctx, m); err != nil {
+			return nil, err
+		}
+	}
+
+	return result, nil
+}
+
+func (repo *Repository) GetByID(ctx context.Context, id *string) (*user.Model, error) {
+
+	if id == nil {
+		return nil, errors.New("no id provided")
+	}
+
+	return repo.get(ctx, `id = ?`, *id)
+}
+
+func (repo *Repository) GetByUsername(ctx context.Context, username *string) (*user.Model, error) {
+
+	if username == nil {
+		return nil, errors.New("no username provided")
+	}
+
+	return repo.get(ctx, `username = ?`, *username)
+}
+
+func (repo *Repository) GetByIdentity(ctx context.Context, issuer, subject string) (*user.Model, error) {
+	return repo.get(ctx, `username = (SELECT username FROM user_identities WHERE issuer = ? AND subject = ?)`, issuer, subject)
+}
+
+func (repo *Repository) LinkIdentity(ctx context.Context, username *string, identity *user.Identity) error {
+
+	if username == nil {
+		return errors.New("no username provided")
+	}
+
+	if identity == nil {
+		return errors.New("no identity provided")
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, repo.cfg.RequestTimeout)
+	defer cancel()
+
+	return repo.db.Do(ctx, func(ctx context.Context) error {
+
+		var linked string
+		err := repo.db.QueryRow(ctx, `SELECT username FROM user_identities WHERE issuer = ? AND subject = ?`, identity.Issuer, identity.Subject).Scan(&linked)
+		switch {
+		case err == nil && linked == *username:
+			return nil
+		case err == nil:
+			return user.ErrAlreadyExists
+		case err != sql.ErrNoRows:
+			return err
+		}
+
+		var exists int
+		if err := repo.db.QueryRow(ctx, `SELECT COUNT(*) FROM users WHERE username = ?`, *username).Scan(&exists); err != nil {
+			return err
+		}
+
+		if exists != 1 {
+			return user.ErrNotFound
+		}
+
+		if _, err := repo.db.Exec(ctx, `INSERT INTO user_identities (issuer, subject, username) VALUES (?, ?, ?)`, identity.Issuer, identity.Subject, *username); err != nil {
+			if sqldb.IsUniqueViolation(err) {
+				return user.ErrAlreadyExists
+			}
+			return err
+		}
+
+		return nil
+	})
+}
+
+func (repo *Repository) Deactivate(ctx context.Context, username, role *string) error {
+
+	if username == nil {
+		return errors.New("no username provided")
+	}
+
+	if role == nil {
+		return errors.New("no role provided")
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, repo.cfg.RequestTimeout)
+	defer cancel()
+
+	res, err := repo.db.Exec(ctx, `UPDATE users SET deactivated = ? WHERE role = ? AND username = ?`, time.Now().UTC(), *role, *username)
+	if err != nil {
+		return err
+	}
+
+	if n, err := res.RowsAffected(); err != nil || n != 1 {
+		return user.ErrNotFound
+	}
+
+	return nil
+}
+
+func (repo *Repository) SetPassword(ctx context.Context, username, passwordHash *string) error {
+
+	if username == nil {
+		return errors.New("no username provided")
+	}
+
+	if passwordHash == nil {
+		return errors.New("no passwordHash provided")
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, repo.cfg.RequestTimeout)
+	defer cancel()
+
+	res, err := repo.db.Exec(ctx, `UPDATE users SET password_hash = ?, updated = ? WHERE username = ?`, *passwordHash, time.Now().UTC(), *username)
+	if err != nil {
+		return err
+	}
+
+	if n, err := res.RowsAffected(); err != nil || n != 1 {
+		return user.ErrNotFound
+	}
+
+	return nil
+}
+
+func (repo *Repository) SetValidated(ctx context.Context, username *string) error {
+
+	if username == nil {
+		return errors.New("no username provided")
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, repo.cfg.RequestTimeout)
+	defer cancel()
+
+	res, err := repo.db.Exec(ctx, `UPDATE users SET validated = ? WHERE username = ?`, true, *username)
+	if err != nil {
+		return err
+	}
+
+	if n, err := res.RowsAffected(); err != nil || n != 1 {
+		return user.ErrNotFound
+	}
+
+	return nil
+}
+
+func (repo *Repository) SetTOTP(ctx context.Context, username, secret *string, enabled *time.Time, recoveryCodes []string) error {
+
+	if username == nil {
+		return errors.New("no username provided")
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, repo.cfg.RequestTimeout)
+	defer cancel()
+
+	res, err := repo.db.Exec(ctx, `UPDATE users SET totp_secret = ?, totp_enabled = ?, recovery_codes = ? WHERE username = ?`,
+		secret,
+		enabled,
+		joinCodes(recoveryCodes),
+		*username,
+	)
+	if err != nil {
+		return err
+	}
+
+	if n, err := res.RowsAffected(); err != nil || n != 1 {
+		return user.ErrNotFound
+	}
+
+	return nil
+}
+
+func (repo *Repository) UseRecoveryCode(ctx context.Context, username *string, hash string) error {
+
+	if username == nil {
+		return errors.New("no username provided")
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, repo.cfg.RequestTimeout)
+	defer cancel()
+
+	return repo.db.Do(ctx, func(ctx context.Context) error {
+
+		var stored sql.NullString
+		if err := repo.db.QueryRow(ctx, `SELECT recovery_codes FROM users WHERE username = ?`+repo.db.ForUpdate(), *username).Scan(&stored); err != nil {
+			switch err {
+			case sql.ErrNoRows:
+				return user.ErrNotFound
+			default:
+				return err
+			}
+		}
+
+		codes := splitCodes(stored)
+		for i, code := range codes {
+			if code == hash {
+				_, err := repo.db.Exec(ctx, `UPDATE users SET recovery_codes = ? WHERE username = ?`,
+					joinCodes(append(codes[:i], codes[i+1:]...)),
+					*username,
+				)
+				return err
+			}
+		}
+
+		return user.ErrInvalidCode
+	})
+}
+
+func (repo *Repository) UseTOTPStep(ctx context.Context, username *string, step int64) error {
+
+	if username == nil {
+		return errors.New("no username provided")
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, repo.cfg.RequestTimeout)
+	defer cancel()
+
+	// matching on the last step makes a code usable once even when it is sent twice at the same time
+	res, err := repo.db.Exec(ctx, `UPDATE users SET totp_step = ? WHERE username = ? AND totp_step < ?`, step, *username, step)
+	if err != nil {
+		return err
+	}
+
+	if n, err := res.RowsAffected(); err != nil || n != 1 {
+		return user.ErrInvalidCode
+	}
+
+	return nil
+}
+
+func (repo *Repository) Delete(ctx context.Context, username, role *string) error {
+
+	if username == nil {
+		return errors.New("no username provided")
+	}
+
+	if role == nil {
+		return errors.New("no role provided")
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, repo.cfg.RequestTimeout)
+	defer cancel()
+
+	res, err := repo.db.Exec(ctx, `DELETE FROM users WHERE role = ? AND username = ?`, *role, *username)
+	if err != nil {
+		return err
+	}
+
+	if n, err := res.RowsAffected(); err != nil || n != 1 {
+		return user.ErrNotFound
+	}
+
+	if _, err := repo.db.Exec(ctx, `DELETE FROM user_votes WHERE username = ?`, *username); err != nil {
+		return err
+	}
+
+	if _, err := repo.db.Exec(ctx, `DELETE FROM moderators WHERE username = ?`, *username); err != nil {
+		return err
+	}
+
+	if _, err := repo.db.Exec(ctx, `DELETE FROM user_identities WHERE username = ?`, *username); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (repo *Repository) SetRole(ctx context.Context, username, role *string, moderates []string) error {
+
+	if username == nil {
+		return errors.New("no username provided")
+	}
+
+	if role == nil {
+		return errors.New("no role provided")
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, repo.cfg.RequestTimeout)
+	defer cancel()
+
+	return repo.db.Do(ctx, func(ctx context.Context) error {
+
+		res, err := repo.db.Exec(ctx, `UPDATE users SET role = ? WHERE username = ?`, *role, *username)
+		if err != nil {
+			return err
+		}
+
+		if n, err := res.RowsAffected(); err != nil || n != 1 {
+			return user.ErrNotFound
+		}
+
+		if _, err := repo.db.Exec(ctx, `DELETE FROM moderators WHERE username = ?`, *username); err != nil {
+			return err
+		}
+
+		for _, category := range moderates {
+			if _, err := repo.db.Exec(ctx, `INSERT INTO moderators (username, category) VALUES (?, ?)`, *username, category); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
+
+func (repo *Repository) IncCounter(ctx context.Context, username, field *string, value int64) error {
+
+	if username == nil {
+		return errors.New("no username provided")
+	}
+
+	if field == nil {
+		return errors.New("no field provided")
+	}
+
+	column, ok := counterColumns[*field]
+	if !ok {
+		return errors.New("invalid counter field provided: " + *field)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, repo.cfg.RequestTimeout)
+	defer cancel()
+
+	res, err := repo.db.Exec(ctx, `UPDATE users SET `+column+` = `+column+` + ? WHERE username = ?`, value, *username)
+	if err != nil {
+		return err
+	}
+
+	if n, err := res.RowsAffected(); err != nil || n != 1 {
+		return user.ErrNotFound
+	}
+
+	return nil
+}
+
+func (repo *Repository) SwapVote(ctx context.Context, username *string, vote *user.Vote) (int8, error) {
+
+	if username == nil {
+		return 0, errors.New("no username provided")
+	}
+
+	if vote == nil {
+		return 0, errors.New("no vote provided")
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, repo.cfg.RequestTimeout)
+	defer cancel()
+
+	var old int8
+
+	err := repo.db.Do(ctx, func(ctx context.Context) error {
+
+		var exists int
+		if err := repo.db.QueryRow(ctx, `SELECT COUNT(*) FROM users WHERE username = ?`, *username).Scan(&exists); err != nil {
+			return err
+		}
+
+		if exists != 1 {
+			return user.ErrNotFound
+		}
+
+		// a placeholder row makes concurrent votes on the same slug wait for each other below
+		if _, err := repo.db.Exec(ctx, `INSERT INTO user_votes (username, slug_type, slug_id, value) VALUES (?, ?, ?, 0)
+			ON CONFLICT (username, slug_type, slug_id) DO NOTHING`,
+			*username,
+			*vote.SlugType,
+			*vote.SlugID,
+		); err != nil {
+			return err
+		}
+
+		if err := repo.db.QueryRow(ctx, `SELECT value FROM user_votes WHERE username = ? AND slug_type = ? AND slug_id = ?`+repo.db.ForUpdate(),
+			*username,
+			*vote.SlugType,
+			*vote.SlugID,
+		).Scan(&old); err != nil {
+			return err
+		}
+
+		if *vote.Value == 0 {
+			_, err := repo.db.Exec(ctx, `DELETE FROM user_votes WHERE username = ? AND slug_type = ? AND slug_id = ?`,
+				*username,
+				*vote.SlugType,
+				*vote.SlugID,
+			)
+			return err
+		}
+
+		_, err := repo.db.Exec(ctx, `UPDATE user_votes SET value = ? WHERE username = ? AND slug_type = ? AND slug_id = ?`,
+			*vote.Value,
+			*username,
+			*vote.SlugType,
+			*vote.SlugID,
+		)
+		return err
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return old, nil
+}
+
+func (repo *Repository) get(ctx context.Context, where string, args ...interface{}) (*user.Model, error) {
+
+	ctx, cancel := context.WithTimeout(ctx, repo.cfg.RequestTimeout)
+	defer cancel()
+
+	result, err := scan(repo.db.QueryRow(ctx, `SELECT `+columns+` FROM users WHERE `+where, args...))
+	if err != nil {
+		switch err {
+		case sql.ErrNoRows:
+			return nil, user.ErrNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	if err := repo.loadVotes(ctx, result); err != nil {
+		return nil, err
+	}
+
+	if err := repo.loadModerates(ctx, result); err != nil {
+		return nil, err
+	}
+
+	if err := repo.loadIdentities(ctx, result); err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+func (repo *Repository) loadVotes(ctx context.Context, m *user.Model) error {
+
+	m.Votes = user.Votes{
+		Threads:  map[string]int8{},
+		Comments: map[string]int8{},
+	}
+
+	rows, err := repo.db.Query(ctx, `SELECT slug_type, slug_id, value FROM user_votes WHERE username = ?`, m.Username)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var slugType, slugID string
+		var value int8
+		if err := rows.Scan(&slugType, &slugID, &value); err != nil {
+			return err
+		}
+		switch slugType {
+		case "threads":
+			m.Votes.Threads[slugID] = value
+		case "comments":
+			m.Votes.Comments[slugID] = value
+		}
+	}
+
+	return rows.Err()
+}
+
+func (repo *Repository) loadModerates(ctx context.Context, m *user.Model) error {
+
+	rows, err := repo.db.Query(ctx, `SELECT category FROM moderators WHERE username = ? ORDER BY category`, m.Username)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	m.Moderates = nil
+	for rows.Next() {
+		var category string
+		if err := rows.Scan(&category); err != nil {
+			return err
+		}
+		m.Moderates = append(m.Moderates, category)
+	}
+
+	return rows.Err()
+}
+
+func (repo *Repository) loadIdentities(ctx context.Context, m *user.Model) error {
+
+	rows, err := repo.db.Query(ctx, `SELECT issuer, subject FROM user_identities WHERE username = ? ORDER BY issuer, subject`, m.Username)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	m.Identities = nil
+	for rows.Next() {
+		var identity user.Identity
+		if err := rows.Scan(&identity.Issuer, &identity.Subject); err != nil {
+			return err
+		}
+		m.Identities = append(m.Identities, identity)
+	}
+
+	return rows.Err()
+}
+
+type scanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scan(row scanner) (*user.Model, error) {
+
+	m := new(user.Model)
+	var id, recoveryCodes sql.NullString
+
+	if err := row.Scan(
+		&id,
+		&m.Role,
+		&m.Validated,
+		&m.Username,
+		&m.PasswordHash,
+		&m.EmailHash,
+		&m.EmailEncrypted,
+		&m.TOTPSecret,
+		&m.TOTPEnabled,
+		&recoveryCodes,
+		&m.TOTPStep,
+		&m.Counters.Num.Threads,
+		&m.Counters.Num.Comments,
+		&m.Counters.Votes.Threads,
+		&m.Counters.Votes.Comments,
+		&m.Counters.Warnings,
+		&m.Created,
+		&m.Updated,
+		&m.Deactivated,
+	); err != nil {
+		return nil, err
+	}
+
+	var err error
+
+	if m.ID, err = sqldb.ParseID(id); err != nil {
+		return nil, err
+	}
+
+	m.RecoveryCodes = splitCodes(recoveryCodes)
+
+	return m, nil
+}
+
+// joinCodes stores recovery code hashes in one column, hex hashes never contain spaces
+func joinCodes(codes []string) interface{} {
+	if len(codes) == 0 {
+		return nil
+	}
+	return strings.Join(codes, " ")
+}
+
+func splitCodes(s sql.NullString) []string {
+	if !s.Valid || s.String == "" {
+		return nil
+	}
+	return strings.Fields(s.String)
+}
@@ -2,36 +2,77 @@ package user
 
 import (
 	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
 	"errors"
 	"fmt"
+	"net/mail"
+	"strings"
 	"time"
 	"unicode/utf8"
 
+	"github.com/rgynn/klottr/pkg/cursor"
+	"github.com/rgynn/klottr/pkg/totp"
 	"github.com/rgynn/ptrconv"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"golang.org/x/crypto/bcrypt"
 )
 
+const (
+	RoleUser  = "user"
+	RoleAdmin = "admin"
+	// RoleModerator is a user with moderation powers in the categories listed in Moderates
+	RoleModerator = "moderator"
+)
+
 var ErrDeactivated = errors.New("user account deactivated")
 
 var ErrNotFound = errors.New("user not found")
 
 var ErrAlreadyExists = errors.New("user already exists")
 
+var ErrNotValidated = errors.New("user email address not validated")
+
+var ErrInvalidCode = errors.New("invalid two factor code")
+
 type Repository interface {
 	Create(ctx context.Context, m *Model) error
-	Search(ctx context.Context, username, role *string, from, size int64) ([]*Model, error)
+	Search(ctx context.Context, username, role *string, after *cursor.Cursor, from, size int64) ([]*Model, error)
 	GetByID(ctx context.Context, id *string) (*Model, error)
 	GetByUsername(ctx context.Context, username *string) (*Model, error)
+	// GetByIdentity returns the user linked to subject at the identity provider issuer
+	GetByIdentity(ctx context.Context, issuer, subject string) (*Model, error)
+	// LinkIdentity links identity to username, ErrAlreadyExists when it is linked to a user already
+	LinkIdentity(ctx context.Context, username *string, identity *Identity) error
 	Deactivate(ctx context.Context, username, role *string) error
 	Delete(ctx context.Context, username, role *string) error
-	IncCounter(ctx context.Context, username, field *string, value int8) error
-	UpsertVote(ctx context.Context, username *string, vote *Vote) error
+	// SetRole replaces the role of username and the categories the user moderates
+	SetRole(ctx context.Context, username, role *string, moderates []string) error
+	// SetPassword replaces the password hash of username
+	SetPassword(ctx context.Context, username, passwordHash *string) error
+	// SetValidated marks the email address of username as validated
+	SetValidated(ctx context.Context, username *string) error
+	// SetTOTP replaces the encrypted totp secret of username, enabled and the recovery code
+	// hashes, all nil turns two factor authentication off
+	SetTOTP(ctx context.Context, username, secret *string, enabled *time.Time, recoveryCodes []string) error
+	// UseRecoveryCode removes the recovery code hash of username, ErrInvalidCode when it has no such code
+	UseRecoveryCode(ctx context.Context, username *string, hash string) error
+	// UseTOTPStep sets step as the last time step a totp code of username was accepted for,
+	// ErrInvalidCode when it is not after the last one so a code cannot be used twice
+	UseTOTPStep(ctx context.Context, username *string, step int64) error
+	IncCounter(ctx context.Context, username, field *string, value int64) error
+	// SwapVote stores vote for username, removing it when its value is 0, and returns the value
+	// of the vote it replaced, 0 when there was none
+	SwapVote(ctx context.Context, username *string, vote *Vote) (int8, error)
 }
 
 type Counters struct {
 	Num   Counter `json:"num"  bson:"num"`
 	Votes Counter `json:"votes"  bson:"votes"`
+	// Warnings is the number of times moderators warned the user about reported content
+	Warnings uint32 `json:"warnings"  bson:"warnings"`
 }
 
 type Counter struct {
@@ -82,20 +123,57 @@ func (v *Vote) ValidForSave() error {
 	return nil
 }
 
+// Identity of a user at an external identity provider
+type Identity struct {
+	Issuer  string `json:"issuer"  bson:"issuer"`
+	Subject string `json:"subject"  bson:"subject"`
+}
+
 type Model struct {
 	ID           *primitive.ObjectID `json:"id" bson:"_id,omitempty"`
 	Role         *string             `json:"role" bson:"role"`
+	Moderates    []string            `json:"moderates,omitempty" bson:"moderates,omitempty"`
 	Validated    bool                `json:"validated"  bson:"validated"`
 	Username     *string             `json:"username"  bson:"username"`
 	Password     *string             `json:"password,omitempty"  bson:"password,omitempty"`
 	PasswordHash *string             `json:"password_hash,omitempty"  bson:"password_hash,omitempty"`
 	Email        *string             `json:"email,omitempty"  bson:"email,omitempty"`
 	EmailHash    *string             `json:"email_hash,omitempty"  bson:"email_hash,omitempty"`
-	Counters     Counters            `json:"counters"  bson:"counters"`
-	Votes        Votes               `json:"votes" bson:"votes"`
-	Created      *time.Time          `json:"created"  bson:"created"`
-	Updated      *time.Time          `json:"updated,omitempty"  bson:"updated,omitempty"`
-	Deactivated  *time.Time          `json:"deactivated,omitempty"  bson:"deactivated,omitempty"`
+	// EmailEncrypted is the email address encrypted with EMAIL_KEY, so mail can be sent to it
+	EmailEncrypted *string `json:"-"  bson:"email_encrypted,omitempty"`
+	// TOTPSecret is the totp secret encrypted with EMAIL_KEY, it is pending until TOTPEnabled is set
+	TOTPSecret  *string    `json:"-"  bson:"totp_secret,omitempty"`
+	TOTPEnabled *time.Time `json:"totp_enabled,omitempty"  bson:"totp_enabled,omitempty"`
+	// RecoveryCodes are the hashes of the unused recovery codes
+	RecoveryCodes []string `json:"-"  bson:"recovery_codes,omitempty"`
+	// TOTPStep is the time step of the last totp code accepted, codes of it and before are refused
+	TOTPStep int64 `json:"-"  bson:"totp_step,omitempty"`
+	// Identities are the accounts at external identity providers the user signs in with
+	Identities  []Identity `json:"identities,omitempty"  bson:"identities,omitempty"`
+	Counters    Counters   `json:"counters"  bson:"counters"`
+	Votes       Votes      `json:"votes" bson:"votes"`
+	Created     *time.Time `json:"created"  bson:"created"`
+	Updated     *time.Time `json:"updated,omitempty"  bson:"updated,omitempty"`
+	Deactivated *time.Time `json:"deactivated,omitempty"  bson:"deactivated,omitempty"`
+}
+
+// ValidRole checks that role exists and that moderates lists categories for moderators only
+func ValidRole(role string, moderates []string) error {
+
+	switch role {
+	case RoleUser, RoleAdmin:
+		if len(moderates) > 0 {
+			return errors.New("only moderators can moderate categories")
+		}
+	case RoleModerator:
+		if len(moderates) == 0 {
+			return errors.New("no categories provided for moderator")
+		}
+	default:
+		return fmt.Errorf("invalid role provided: %s", role)
+	}
+
+	return nil
 }
 
 func (m *Model) IsDeactivated() bool {
@@ -123,6 +201,166 @@ func (m *Model) HashPassword() error {
 	return nil
 }
 
+// CleanEmail trims and lowercases an email address
+func CleanEmail(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
+
+// NormalizeEmail validates m.Email and trims and lowercases it, so the hash of the same
+// address always matches
+func (m *Model) NormalizeEmail() error {
+
+	if m == nil {
+		return errors.New("no m *Model provided")
+	}
+
+	if m.Email == nil {
+		return nil
+	}
+
+	email := CleanEmail(*m.Email)
+
+	addr, err := mail.ParseAddress(email)
+	if err != nil || addr.Address != email {
+		return errors.New("invalid email address provided")
+	}
+
+	m.Email = &email
+
+	return nil
+}
+
+// EncryptEmail stores m.Email encrypted with key, it has to be called before HashEmail
+func (m *Model) EncryptEmail(key []byte) error {
+
+	if m == nil {
+		return errors.New("no m *Model provided")
+	}
+
+	if m.Email == nil {
+		return nil
+	}
+
+	encrypted, err := encrypt(key, *m.Email, ptrconv.StringPtrString(m.Username))
+	if err != nil {
+		return err
+	}
+
+	m.EmailEncrypted = &encrypted
+
+	return nil
+}
+
+// DecryptEmail returns the email address stored by EncryptEmail
+func (m *Model) DecryptEmail(key []byte) (string, error) {
+
+	if m == nil {
+		return "", errors.New("no m *Model provided")
+	}
+
+	if m.EmailEncrypted == nil {
+		return "", errors.New("no m.EmailEncrypted provided")
+	}
+
+	return decrypt(key, *m.EmailEncrypted, ptrconv.StringPtrString(m.Username))
+}
+
+// EncryptTOTP stores the totp secret encrypted with key
+func (m *Model) EncryptTOTP(key []byte, secret string) error {
+
+	if m == nil {
+		return errors.New("no m *Model provided")
+	}
+
+	encrypted, err := encrypt(key, secret, ptrconv.StringPtrString(m.Username)+"/totp")
+	if err != nil {
+		return err
+	}
+
+	m.TOTPSecret = &encrypted
+
+	return nil
+}
+
+// ValidTOTP checks code against the totp secret of m at now and returns the time step it matched,
+// ErrInvalidCode when it does not match or matched a step at or before the last one accepted
+func (m *Model) ValidTOTP(key []byte, code string, now time.Time) (int64, error) {
+
+	if m == nil {
+		return 0, errors.New("no m *Model provided")
+	}
+
+	if m.TOTPSecret == nil {
+		return 0, errors.New("no m.TOTPSecret provided")
+	}
+
+	secret, err := decrypt(key, *m.TOTPSecret, ptrconv.StringPtrString(m.Username)+"/totp")
+	if err != nil {
+		return 0, err
+	}
+
+	step, valid, err := totp.Validate(secret, code, now)
+	if err != nil {
+		return 0, err
+	}
+
+	if !valid || step <= m.TOTPStep {
+		return 0, ErrInvalidCode
+	}
+
+	return step, nil
+}
+
+// encrypt seals plaintext with key bound to data, so it cannot be moved to another user or field
+func encrypt(key []byte, plaintext, data string) (string, error) {
+
+	gcm, err := newGCM(key)
+	if err != nil {
+		return "", err
+	}
+
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+
+	return base64.StdEncoding.EncodeToString(gcm.Seal(nonce, nonce, []byte(plaintext), []byte(data))), nil
+}
+
+func decrypt(key []byte, ciphertext, data string) (string, error) {
+
+	b, err := base64.StdEncoding.DecodeString(ciphertext)
+	if err != nil {
+		return "", err
+	}
+
+	gcm, err := newGCM(key)
+	if err != nil {
+		return "", err
+	}
+
+	if len(b) < gcm.NonceSize() {
+		return "", errors.New("invalid ciphertext provided")
+	}
+
+	plaintext, err := gcm.Open(nil, b[:gcm.NonceSize()], b[gcm.NonceSize():], []byte(data))
+	if err != nil {
+		return "", err
+	}
+
+	return string(plaintext), nil
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return cipher.NewGCM(block)
+}
+
 func (m *Model) HashEmail() error {
 
 	if m == nil {
@@ -158,11 +396,8 @@ func (m *Model) ValidForSave() error {
 		return errors.New("no m.Role provided")
 	}
 
-	switch *m.Role {
-	case "user", "admin":
-		break
-	default:
-		return errors.New("invalid m.Role provided")
+	if err := ValidRole(*m.Role, m.Moderates); err != nil {
+		return err
 	}
 
 	if m.Username == nil {
@@ -189,6 +424,10 @@ func (m *Model) ValidForSave() error {
 		return errors.New("cannot prvide m.Updated for new user")
 	}
 
+	if m.TOTPSecret != nil || m.TOTPEnabled != nil || len(m.RecoveryCodes) > 0 || m.TOTPStep != 0 {
+		return errors.New("cannot provide two factor authentication for new user")
+	}
+
 	return nil
 }
 
@@ -225,3 +464,16 @@ func (m *Model) ValidEmail(email *string) error {
 
 	return bcrypt.CompareHashAndPassword([]byte(*m.EmailHash), []byte(*email))
 }
+
+// NextCursor returns the cursor continuing a search ordered by id after the page list, or nil
+// when it was the last page
+func NextCursor(list []*Model, size int64) *cursor.Cursor {
+	if len(list) == 0 || size <= 0 || int64(len(list)) < size {
+		return nil
+	}
+	last := list[len(list)-1]
+	if last.ID == nil {
+		return nil
+	}
+	return &cursor.Cursor{ID: last.ID.Hex()}
+}